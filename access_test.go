@@ -0,0 +1,212 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStore_LastAccessedAt(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	accessed := time.Now().Add(-time.Minute)
+	s := &Session{
+		ID:             "accessed",
+		Values:         map[string]any{"k": "v"},
+		CreatedAt:      time.Now(),
+		ExpiresAt:      time.Now().Add(time.Hour),
+		LastAccessedAt: accessed,
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	got, err := store.Get(ctx, s.ID)
+	if err != nil || got == nil {
+		t.Fatalf("failed to get: %v, %v", got, err)
+	}
+	if !got.LastAccessedAt.Equal(accessed) {
+		t.Errorf("expected LastAccessedAt %v, got %v", accessed, got.LastAccessedAt)
+	}
+
+	// Rows without a LastAccessedAt (e.g. written by earlier versions) load as zero.
+	s.ID = "never-accessed"
+	s.LastAccessedAt = time.Time{}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if got, _ := store.Get(ctx, s.ID); got == nil || !got.LastAccessedAt.IsZero() {
+		t.Errorf("expected zero LastAccessedAt, got %v", got)
+	}
+}
+
+func TestManager_LastAccessedAt(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if s.LastAccessedAt.IsZero() {
+		t.Fatal("expected New to set LastAccessedAt")
+	}
+	cookie := saveFrom(t, mgr, s, "192.0.2.1:1234")
+	saved := s.LastAccessedAt
+
+	time.Sleep(5 * time.Millisecond)
+	got := getFrom(t, mgr, cookie, "192.0.2.1:1234")
+	if !got.LastAccessedAt.After(saved) {
+		t.Errorf("expected Get to update LastAccessedAt, got %v (saved %v)", got.LastAccessedAt, saved)
+	}
+
+	// Without TouchInterval, Get alone does not write.
+	stored, _ := store.Get(context.Background(), s.ID)
+	if !stored.LastAccessedAt.Equal(saved) {
+		t.Errorf("expected stored LastAccessedAt unchanged, got %v", stored.LastAccessedAt)
+	}
+}
+
+func TestManager_TouchInterval(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store, TouchInterval: time.Minute})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.LastAccessedAt = time.Now().Add(-2 * time.Minute)
+	cookie := saveFrom(t, mgr, s, "192.0.2.1:1234")
+
+	// The stored value is older than the interval: Get writes it back.
+	got := getFrom(t, mgr, cookie, "192.0.2.1:1234")
+	stored, _ := store.Get(ctx, s.ID)
+	if !stored.LastAccessedAt.Equal(got.LastAccessedAt) {
+		t.Errorf("expected Get to persist LastAccessedAt %v, stored %v", got.LastAccessedAt, stored.LastAccessedAt)
+	}
+
+	// Within the interval: no write.
+	touched := stored.LastAccessedAt
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.AddCookie(cookie)
+	if _, err := mgr.Get(r); err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	stored, _ = store.Get(ctx, s.ID)
+	if !stored.LastAccessedAt.Equal(touched) {
+		t.Errorf("expected no write within TouchInterval, stored %v, want %v", stored.LastAccessedAt, touched)
+	}
+}
+
+func TestManager_Peek(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	clock := newFakeClock()
+	mgr := NewManager(Config{Store: store, Clock: clock, TTL: time.Hour, TouchInterval: time.Minute})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	cookie := saveFrom(t, mgr, s, "192.0.2.1:1234")
+	saved := s.LastAccessedAt
+
+	// Past TouchInterval, Get would write the session back; Peek does not.
+	clock.Advance(2 * time.Minute)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.AddCookie(cookie)
+	got, err := mgr.Peek(r)
+	if err != nil {
+		t.Fatalf("failed to peek: %v", err)
+	}
+	if got.ID != s.ID || !got.LastAccessedAt.Equal(saved) {
+		t.Errorf("expected the session untouched, got %s accessed %v, want %v", got.ID, got.LastAccessedAt, saved)
+	}
+	if stored, _ := store.Get(ctx, s.ID); !stored.LastAccessedAt.Equal(saved) {
+		t.Errorf("expected no write, stored LastAccessedAt %v", stored.LastAccessedAt)
+	}
+
+	// Validation still applies.
+	clock.Advance(time.Hour)
+	if _, err := mgr.Peek(r); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired, got %v", err)
+	}
+}
+
+func TestManager_IdleTimeout(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	clock := newFakeClock()
+	mgr := NewManager(Config{Store: store, Clock: clock, TTL: 24 * time.Hour, IdleTimeout: 30 * time.Minute})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s, err := mgr.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	// Read-only accesses within the timeout keep the session alive, as
+	// TouchInterval defaults to a quarter of IdleTimeout.
+	for i := 0; i < 4; i++ {
+		clock.Advance(20 * time.Minute)
+		if _, err := mgr.LoadSession(ctx, s.ID); err != nil {
+			t.Fatalf("expected an active session to load, got %v", err)
+		}
+	}
+
+	clock.Advance(31 * time.Minute)
+	_, err = mgr.LoadSession(ctx, s.ID)
+	if !errors.Is(err, ErrSessionIdle) || !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected ErrSessionIdle wrapping ErrSessionExpired, got %v", err)
+	}
+	if stored, _ := store.Get(ctx, s.ID); stored != nil {
+		t.Error("expected the idle session to be deleted")
+	}
+
+	// Get returns a fresh session instead.
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Cookie", "session_id="+s.ID)
+	fresh, err := mgr.Get(r)
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if fresh.ID == s.ID {
+		t.Error("expected a new session")
+	}
+}
+
+func TestConfig_IdleTimeoutTouchInterval(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	_, err = NewManagerE(Config{Store: store, IdleTimeout: time.Minute, TouchInterval: time.Hour})
+	if err == nil {
+		t.Error("expected an error for a TouchInterval longer than IdleTimeout")
+	}
+}
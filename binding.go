@@ -0,0 +1,125 @@
+package dbsession
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+const (
+	// keyClientIP stores the client IP a session is bound to.
+	keyClientIP = reservedKeyPrefix + "ip"
+	// keyUserAgent stores the hex SHA-256 of the User-Agent a session is bound to.
+	keyUserAgent = reservedKeyPrefix + "ua"
+)
+
+// remoteAddrIP is the default client IP extractor. It returns the host part
+// of r.RemoteAddr.
+func remoteAddrIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// bindClient records the client binding data in a session that does not have
+// it yet. The caller must hold s.mu.
+func (m *Manager) bindClient(s *Session, r *http.Request) {
+	if !m.validateIP && !m.bindUserAgent {
+		return
+	}
+	if s.Values == nil {
+		s.Values = make(map[string]any)
+	}
+	if m.validateIP {
+		if _, ok := s.Values[keyClientIP]; !ok {
+			s.Values[keyClientIP] = m.clientIP(r)
+		}
+	}
+	if m.bindUserAgent {
+		if _, ok := s.Values[keyUserAgent]; !ok {
+			s.Values[keyUserAgent] = userAgentHash(r)
+		}
+	}
+}
+
+// clientMatches reports whether the request comes from the client the
+// session is bound to, according to the enabled bindings.
+func (m *Manager) clientMatches(s *Session, r *http.Request) bool {
+	if m.validateIP && !m.ipMatches(s, r) {
+		return false
+	}
+	if m.bindUserAgent && !userAgentMatches(s, r) {
+		return false
+	}
+	return true
+}
+
+// userAgentHash returns the hex SHA-256 of the request's User-Agent.
+// Hashing keeps the stored value small and avoids persisting the raw header.
+func userAgentHash(r *http.Request) string {
+	sum := sha256.Sum256([]byte(r.UserAgent()))
+	return hex.EncodeToString(sum[:])
+}
+
+// userAgentMatches reports whether the request's User-Agent hashes to the
+// value the session is bound to. Sessions without a recorded hash are
+// accepted, as for IP binding.
+func userAgentMatches(s *Session, r *http.Request) bool {
+	v, ok := s.Get(keyUserAgent)
+	if !ok {
+		return true
+	}
+	bound, ok := v.(string)
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(bound), []byte(userAgentHash(r))) == 1
+}
+
+// ipMatches reports whether the request comes from the IP (or subnet) the
+// session is bound to. Sessions without a recorded IP are accepted so that
+// enabling ValidateIP does not log out existing users.
+func (m *Manager) ipMatches(s *Session, r *http.Request) bool {
+	v, ok := s.Get(keyClientIP)
+	if !ok {
+		return true
+	}
+	bound, ok := v.(string)
+	if !ok {
+		return false
+	}
+	return m.sameNetwork(bound, m.clientIP(r))
+}
+
+// sameNetwork compares two IPs, applying the configured prefix lengths.
+// Unparseable addresses must match exactly.
+func (m *Manager) sameNetwork(a, b string) bool {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	addrA, addrB = addrA.Unmap(), addrB.Unmap()
+	if addrA.Is4() != addrB.Is4() {
+		return false
+	}
+
+	bits := m.ipv6PrefixLen
+	if addrA.Is4() {
+		bits = m.ipv4PrefixLen
+	}
+	if bits <= 0 {
+		return addrA == addrB
+	}
+
+	prefix, err := addrA.Prefix(bits)
+	if err != nil {
+		return false
+	}
+	return prefix.Contains(addrB)
+}
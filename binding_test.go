@@ -0,0 +1,187 @@
+package dbsession
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// saveFrom saves s as if the request came from remoteAddr and returns the cookie.
+func saveFrom(t *testing.T, mgr *Manager, s *Session, remoteAddr string) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = remoteAddr
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	return w.Result().Cookies()[0]
+}
+
+// getFrom loads the session for cookie as if the request came from remoteAddr.
+func getFrom(t *testing.T, mgr *Manager, cookie *http.Cookie, remoteAddr string) *Session {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = remoteAddr
+	r.AddCookie(cookie)
+	s, err := mgr.Get(r)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	return s
+}
+
+func TestManager_ValidateIP(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store, ValidateIP: true})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	cookie := saveFrom(t, mgr, s, "10.0.0.1:1234")
+
+	if got := getFrom(t, mgr, cookie, "10.0.0.1:5678"); got.ID != s.ID {
+		t.Error("expected session to be returned for the same IP")
+	}
+	if got := getFrom(t, mgr, cookie, "10.0.0.2:1234"); got.ID == s.ID {
+		t.Error("expected a new session for a different IP")
+	}
+}
+
+func TestManager_ValidateIPSubnet(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{
+		Store:         store,
+		ValidateIP:    true,
+		IPv4PrefixLen: 24,
+		IPv6PrefixLen: 64,
+	})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	cookie := saveFrom(t, mgr, s, "192.0.2.10:1234")
+
+	if got := getFrom(t, mgr, cookie, "192.0.2.200:1234"); got.ID != s.ID {
+		t.Error("expected session to be returned within the same /24")
+	}
+	if got := getFrom(t, mgr, cookie, "192.0.3.10:1234"); got.ID == s.ID {
+		t.Error("expected a new session outside the /24")
+	}
+
+	s6, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	cookie6 := saveFrom(t, mgr, s6, "[2001:db8::1]:1234")
+	if got := getFrom(t, mgr, cookie6, "[2001:db8::ffff]:1234"); got.ID != s6.ID {
+		t.Error("expected session to be returned within the same /64")
+	}
+	if got := getFrom(t, mgr, cookie6, "[2001:db8:1::1]:1234"); got.ID == s6.ID {
+		t.Error("expected a new session outside the /64")
+	}
+}
+
+func TestManager_ValidateIPCustomExtractor(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{
+		Store:      store,
+		ValidateIP: true,
+		ClientIP: func(r *http.Request) string {
+			return r.Header.Get("X-Forwarded-For")
+		},
+	})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	// Same forwarded IP through a different proxy connection.
+	r2 := httptest.NewRequest("GET", "/", nil)
+	r2.RemoteAddr = "10.9.9.9:1"
+	r2.Header.Set("X-Forwarded-For", "203.0.113.7")
+	r2.AddCookie(cookie)
+	got, err := mgr.Get(r2)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if got.ID != s.ID {
+		t.Error("expected session to be returned for the same forwarded IP")
+	}
+
+	r3 := httptest.NewRequest("GET", "/", nil)
+	r3.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r3.AddCookie(cookie)
+	got, err = mgr.Get(r3)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if got.ID == s.ID {
+		t.Error("expected a new session for a different forwarded IP")
+	}
+}
+
+func TestManager_BindUserAgent(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store, BindUserAgent: true})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("User-Agent", "Browser/1.0")
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	if v, _ := s.Get(keyUserAgent); v == "Browser/1.0" {
+		t.Error("expected the User-Agent to be stored hashed, not raw")
+	}
+
+	get := func(ua string) *Session {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("User-Agent", ua)
+		r.AddCookie(cookie)
+		got, err := mgr.Get(r)
+		if err != nil {
+			t.Fatalf("failed to get: %v", err)
+		}
+		return got
+	}
+
+	if got := get("Browser/1.0"); got.ID != s.ID {
+		t.Error("expected session to be returned for the same User-Agent")
+	}
+	if got := get("Other/2.0"); got.ID == s.ID {
+		t.Error("expected a new session for a different User-Agent")
+	}
+}
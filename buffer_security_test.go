@@ -41,3 +41,26 @@ func TestPutBufferVerifier(t *testing.T) {
 		t.Errorf("Buffer was not reset")
 	}
 }
+
+// TestPutBuffer_Oversized verifies that a buffer larger than
+// MaxPooledBufferSize is still wiped but not returned to the pool.
+func TestPutBuffer_Oversized(t *testing.T) {
+	defer func(size int) { MaxPooledBufferSize = size }(MaxPooledBufferSize)
+	MaxPooledBufferSize = 1024
+
+	buf := new(bytes.Buffer)
+	buf.Write(bytes.Repeat([]byte("s"), 4096))
+	view := buf.Bytes()
+	PutBuffer(buf)
+
+	for i, b := range view {
+		if b != 0 {
+			t.Fatalf("Byte at index %d was not zeroed! Got: %d", i, b)
+		}
+	}
+	for range 10 {
+		if got := bufferPool.Get().(*bytes.Buffer); got == buf {
+			t.Fatal("expected the oversized buffer not to be pooled")
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package dbsession
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheEntries = 1000
+	defaultCacheTTL     = 5 * time.Second
+)
+
+// CachingStore wraps a Store with an in-process LRU cache, so that repeated
+// loads of an active session are served from memory. Saves and deletes are
+// written through to the wrapped store and invalidate the cached entry.
+//
+// Changes made through other processes sharing the wrapped store are not
+// seen until the cached entry expires: a session deleted or updated
+// elsewhere can be served stale for up to CachingConfig.TTL. Use a short TTL,
+// or an InvalidationListener calling Evict, when that matters.
+type CachingStore struct {
+	store Store
+	ttl   time.Duration
+	clock Clock // Time source for entry expiry; replaceable in tests
+
+	mu    sync.Mutex
+	cache *lru[cacheEntry]
+	gen   uint64 // Incremented by every invalidation
+}
+
+// cacheEntry is a cached session and when it stops being fresh.
+type cacheEntry struct {
+	session *Session
+	expires time.Time
+}
+
+// CachingConfig holds configuration for the caching wrapper.
+type CachingConfig struct {
+	MaxEntries int           // Sessions kept in memory (default: 1000)
+	TTL        time.Duration // How long a cached session is served (default: 5s)
+}
+
+// NewCachingStore wraps store with a read cache.
+func NewCachingStore(store Store, cfg CachingConfig) *CachingStore {
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = defaultCacheEntries
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultCacheTTL
+	}
+	return &CachingStore{
+		store: store,
+		ttl:   cfg.TTL,
+		clock: systemClock{},
+		cache: newLRU[cacheEntry](cfg.MaxEntries),
+	}
+}
+
+// Get returns the cached session if it is fresh, and loads it from the
+// wrapped store otherwise. Missing sessions are not cached.
+func (c *CachingStore) Get(ctx context.Context, id string) (*Session, error) {
+	now := c.clock.Now()
+	c.mu.Lock()
+	entry, ok := c.cache.get(id)
+	if ok && now.Before(entry.expires) {
+		c.mu.Unlock()
+		// Each caller gets its own copy, so that changes to one loaded
+		// session neither leak into the cache nor into other requests.
+		return cloneSession(entry.session), nil
+	}
+	if ok {
+		c.cache.remove(id)
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	s, err := c.store.Get(ctx, id)
+	if err != nil || s == nil {
+		return s, err
+	}
+
+	c.mu.Lock()
+	// Skip caching if an invalidation happened during the load, as s may
+	// predate it.
+	if c.gen == gen {
+		expires := now.Add(c.ttl)
+		if s.ExpiresAt.Before(expires) {
+			expires = s.ExpiresAt
+		}
+		c.cache.add(id, cacheEntry{session: cloneSession(s), expires: expires})
+	}
+	c.mu.Unlock()
+	return s, nil
+}
+
+// Save writes the session to the wrapped store and invalidates its cached
+// entry. The next Get reloads it.
+func (c *CachingStore) Save(ctx context.Context, s *Session) error {
+	// Invalidated even if the save fails, as the stored state is then unknown.
+	defer c.Evict(ctx, s.ID)
+	return c.store.Save(ctx, s)
+}
+
+// Delete removes the session from the wrapped store and from the cache.
+func (c *CachingStore) Delete(ctx context.Context, id string) error {
+	defer c.Evict(ctx, id)
+	return c.store.Delete(ctx, id)
+}
+
+// Evict removes the session from the cache only, e.g. when an
+// InvalidationListener reports that another node deleted it.
+func (c *CachingStore) Evict(_ context.Context, id string) error {
+	c.mu.Lock()
+	c.cache.remove(id)
+	c.gen++
+	c.mu.Unlock()
+	return nil
+}
+
+// Flush empties the cache, e.g. from InvalidationConfig.OnReset.
+func (c *CachingStore) Flush() {
+	c.mu.Lock()
+	c.cache.clear()
+	c.gen++
+	c.mu.Unlock()
+}
+
+// SetExpiryLeeway passes d to the wrapped store, see ExpiryLeewaySetter.
+func (c *CachingStore) SetExpiryLeeway(d time.Duration) {
+	setExpiryLeeway(c.store, d)
+}
+
+// Cleanup removes expired sessions from the wrapped store. Cached sessions
+// are never served past their ExpiresAt.
+func (c *CachingStore) Cleanup(ctx context.Context) error {
+	return c.store.Cleanup(ctx)
+}
+
+// Ping checks the wrapped store.
+func (c *CachingStore) Ping(ctx context.Context) error {
+	return c.store.Ping(ctx)
+}
+
+// Close closes the wrapped store.
+func (c *CachingStore) Close() error {
+	return c.store.Close()
+}
@@ -0,0 +1,166 @@
+package dbsession
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStore counts the Get calls reaching the wrapped store.
+type countingStore struct {
+	*mapStore
+	gets atomic.Int64
+}
+
+func (c *countingStore) Get(ctx context.Context, id string) (*Session, error) {
+	c.gets.Add(1)
+	return c.mapStore.Get(ctx, id)
+}
+
+func newCachingTestStore(cfg CachingConfig) (*CachingStore, *countingStore, *fakeClock) {
+	backend := &countingStore{mapStore: newMapStore()}
+	store := NewCachingStore(backend, cfg)
+	clock := newFakeClock()
+	store.clock = clock
+	return store, backend, clock
+}
+
+func TestCachingStore_ServesFromCache(t *testing.T) {
+	store, backend, clock := newCachingTestStore(CachingConfig{TTL: time.Second})
+	ctx := context.Background()
+	s := &Session{ID: "cached", Values: map[string]any{}, ExpiresAt: clock.Now().Add(time.Hour)}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got, err := store.Get(ctx, s.ID); err != nil || got == nil {
+			t.Fatalf("failed to get: %v", err)
+		}
+	}
+	if n := backend.gets.Load(); n != 1 {
+		t.Errorf("expected 1 backend get, got %d", n)
+	}
+
+	clock.Advance(2 * time.Second)
+	store.Get(ctx, s.ID)
+	if n := backend.gets.Load(); n != 2 {
+		t.Errorf("expected the stale entry to be reloaded, got %d backend gets", n)
+	}
+}
+
+func TestCachingStore_InvalidatesOnWrite(t *testing.T) {
+	store, backend, clock := newCachingTestStore(CachingConfig{TTL: time.Minute})
+	ctx := context.Background()
+	s := &Session{ID: "written", Values: map[string]any{}, ExpiresAt: clock.Now().Add(time.Hour)}
+	store.Save(ctx, s)
+	store.Get(ctx, s.ID)
+
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	store.Get(ctx, s.ID)
+	if n := backend.gets.Load(); n != 2 {
+		t.Errorf("expected Save to invalidate the entry, got %d backend gets", n)
+	}
+
+	if err := store.Delete(ctx, s.ID); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if got, _ := store.Get(ctx, s.ID); got != nil {
+		t.Error("expected a deleted session not to be served from the cache")
+	}
+}
+
+func TestCachingStore_RespectsExpiresAt(t *testing.T) {
+	store, backend, clock := newCachingTestStore(CachingConfig{TTL: time.Hour})
+	ctx := context.Background()
+	s := &Session{ID: "expiring", Values: map[string]any{}, ExpiresAt: clock.Now().Add(time.Second)}
+	store.Save(ctx, s)
+	store.Get(ctx, s.ID)
+
+	clock.Advance(2 * time.Second)
+	store.Get(ctx, s.ID)
+	if n := backend.gets.Load(); n != 2 {
+		t.Errorf("expected an expired session not to be served from the cache, got %d backend gets", n)
+	}
+}
+
+func TestCachingStore_MaxEntries(t *testing.T) {
+	store, backend, clock := newCachingTestStore(CachingConfig{MaxEntries: 2, TTL: time.Hour})
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		s := &Session{ID: fmt.Sprintf("s%d", i), Values: map[string]any{}, ExpiresAt: clock.Now().Add(time.Hour)}
+		store.Save(ctx, s)
+		store.Get(ctx, s.ID)
+	}
+	if n := store.cache.len(); n != 2 {
+		t.Errorf("expected 2 cached entries, got %d", n)
+	}
+
+	// s0 was the least recently used and was evicted.
+	store.Get(ctx, "s0")
+	if n := backend.gets.Load(); n != 4 {
+		t.Errorf("expected s0 to be reloaded, got %d backend gets", n)
+	}
+}
+
+func TestCachingStore_GetReturnsCopies(t *testing.T) {
+	store, _, clock := newCachingTestStore(CachingConfig{TTL: time.Hour})
+	ctx := context.Background()
+	s := &Session{
+		ID:        "shared",
+		Values:    map[string]any{"cart": map[string]any{"items": []any{"apple"}}},
+		ExpiresAt: clock.Now().Add(time.Hour),
+	}
+	store.Save(ctx, s)
+	store.Get(ctx, s.ID) // Fill the cache
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got, err := store.Get(ctx, s.ID)
+			if err != nil || got == nil {
+				t.Errorf("failed to get: %v", err)
+				return
+			}
+			got.Values["owner"] = i
+			cart := got.Values["cart"].(map[string]any)
+			cart["items"] = append(cart["items"].([]any), i)
+			cart["items"].([]any)[0] = "pear"
+		}(i)
+	}
+	wg.Wait()
+
+	got, _ := store.Get(ctx, s.ID)
+	if _, ok := got.Values["owner"]; ok {
+		t.Error("expected changes to a loaded session not to reach the cache")
+	}
+	items := got.Values["cart"].(map[string]any)["items"].([]any)
+	if len(items) != 1 || items[0] != "apple" {
+		t.Errorf("expected nested values to be copied, got %v", items)
+	}
+}
+
+func TestCloneSession(t *testing.T) {
+	s := &Session{
+		ID:      "original",
+		Values:  map[string]any{"tags": []any{"a"}, "n": 1},
+		OwnerID: "alice",
+		Version: 3,
+	}
+	c := cloneSession(s)
+	c.Values["n"] = 2
+	c.Values["tags"].([]any)[0] = "b"
+	if s.Values["n"] != 1 || s.Values["tags"].([]any)[0] != "a" {
+		t.Errorf("expected the clone not to share values, got %v", s.Values)
+	}
+	if c.ID != s.ID || c.OwnerID != s.OwnerID || c.Version != s.Version {
+		t.Errorf("expected fields to be copied, got %+v", c)
+	}
+}
@@ -0,0 +1,432 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStore_BatchedCleanup(t *testing.T) {
+	dbPath := "test_cleanup.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: dbPath, CleanupBatchSize: 100})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	expired := make([]*Session, 250)
+	for i := range expired {
+		expired[i] = &Session{
+			ID:        fmt.Sprintf("expired-%d", i),
+			Values:    map[string]any{},
+			CreatedAt: time.Now().Add(-2 * time.Hour),
+			ExpiresAt: time.Now().Add(-time.Hour),
+		}
+	}
+	if err := store.SaveMulti(ctx, expired); err != nil {
+		t.Fatalf("failed to save expired sessions: %v", err)
+	}
+	live := &Session{ID: "live", Values: map[string]any{}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, live); err != nil {
+		t.Fatalf("failed to save live session: %v", err)
+	}
+
+	n, err := store.CleanupCount(ctx)
+	if err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if n != int64(len(expired)) {
+		t.Errorf("expected %d sessions removed, got %d", len(expired), n)
+	}
+
+	var remaining int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&remaining); err != nil {
+		t.Fatalf("failed to count sessions: %v", err)
+	}
+	if remaining != 1 {
+		t.Errorf("expected only the live session to remain, got %d rows", remaining)
+	}
+
+	if n, err := store.CleanupCount(ctx); err != nil || n != 0 {
+		t.Errorf("expected nothing left to clean, got %d, %v", n, err)
+	}
+}
+
+func TestSQLiteStore_CleanupCanceled(t *testing.T) {
+	dbPath := "test_cleanup_cancel.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: dbPath, CleanupBatchSize: 10})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	expired := make([]*Session, 30)
+	for i := range expired {
+		expired[i] = &Session{
+			ID:        fmt.Sprintf("expired-%d", i),
+			Values:    map[string]any{},
+			CreatedAt: time.Now().Add(-2 * time.Hour),
+			ExpiresAt: time.Now().Add(-time.Hour),
+		}
+	}
+	if err := store.SaveMulti(ctx, expired); err != nil {
+		t.Fatalf("failed to save expired sessions: %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := store.CleanupCount(canceled); err == nil {
+		t.Error("expected an error for a canceled context")
+	}
+}
+
+func TestManager_CleanupSpanReportsRemoved(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	tracer := &recordingTracer{}
+	mgr := NewManager(Config{Store: store, Tracer: tracer})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s := &Session{ID: "expired", Values: map[string]any{}, CreatedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(-time.Hour)}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	if err := mgr.storeCleanup(ctx, mgr.store); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if len(tracer.spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(tracer.spans))
+	}
+	if got := tracer.spans[0].attrs[AttrCleanupRemoved]; got != int64(1) {
+		t.Errorf("expected 1 session removed on span, got %v", got)
+	}
+}
+
+// exclusiveStore records which cleanup method the Manager used.
+type exclusiveStore struct {
+	MockStore
+	cleanups   int
+	exclusives int
+	ran        bool
+}
+
+func (s *exclusiveStore) Cleanup(ctx context.Context) error {
+	s.cleanups++
+	return nil
+}
+
+func (s *exclusiveStore) CleanupExclusive(ctx context.Context) (bool, error) {
+	s.exclusives++
+	return s.ran, nil
+}
+
+func TestManager_SingleInstanceCleanup(t *testing.T) {
+	ctx := context.Background()
+
+	store := &exclusiveStore{}
+	tracer := &recordingTracer{}
+	mgr := NewManager(Config{Store: store, Tracer: tracer, SingleInstanceCleanup: true})
+	defer mgr.Close()
+
+	if err := mgr.storeCleanup(ctx, mgr.store); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if store.exclusives != 1 || store.cleanups != 0 {
+		t.Errorf("expected exclusive cleanup, got %d exclusive and %d plain", store.exclusives, store.cleanups)
+	}
+	if got := tracer.spans[0].attrs[AttrCleanupSkipped]; got != true {
+		t.Errorf("expected skipped cleanup on span, got %v", got)
+	}
+
+	plain := &exclusiveStore{}
+	mgr2 := NewManager(Config{Store: plain})
+	defer mgr2.Close()
+	if err := mgr2.storeCleanup(ctx, mgr2.store); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if plain.exclusives != 0 || plain.cleanups != 1 {
+		t.Errorf("expected plain cleanup, got %d exclusive and %d plain", plain.exclusives, plain.cleanups)
+	}
+}
+
+func TestManager_DisabledCleanupWorker(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	before := runtime.NumGoroutine()
+	mgr := NewManager(Config{Store: store, CleanupInterval: -1})
+	if after := runtime.NumGoroutine(); after != before {
+		t.Errorf("expected no worker goroutine, goroutines went from %d to %d", before, after)
+	}
+
+	ctx := context.Background()
+	s := &Session{ID: "expired", Values: map[string]any{}, CreatedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(-time.Hour)}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if err := mgr.Cleanup(ctx); err != nil {
+		t.Fatalf("manual cleanup failed: %v", err)
+	}
+	if got, _ := store.Get(ctx, s.ID); got != nil {
+		t.Error("expected expired session to be removed by manual cleanup")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- mgr.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("close failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked without a cleanup worker")
+	}
+}
+
+func TestManager_CleanupOnDemand(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	tracer := &recordingTracer{}
+	// The worker is running but will not tick during the test.
+	mgr := NewManager(Config{Store: store, Tracer: tracer, CleanupInterval: time.Hour})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s := &Session{ID: "expired", Values: map[string]any{}, CreatedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(-time.Hour)}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	if err := mgr.Cleanup(ctx); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if got, _ := store.Get(ctx, s.ID); got != nil {
+		t.Error("expected expired session to be removed")
+	}
+	if len(tracer.spans) != 1 || tracer.spans[0].name != "dbsession.Cleanup" {
+		t.Errorf("expected a single Cleanup span, got %d spans", len(tracer.spans))
+	}
+}
+
+func TestManager_CleanupJitter(t *testing.T) {
+	interval := 10 * time.Minute
+	jitter := 2 * time.Minute
+	mgr := NewManager(Config{Store: &MockStore{}, CleanupInterval: interval, CleanupJitter: jitter})
+	defer mgr.Close()
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		d := mgr.nextCleanup()
+		if d < interval-jitter || d > interval+jitter {
+			t.Fatalf("delay %v outside %v ± %v", d, interval, jitter)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Error("expected delays to vary")
+	}
+
+	capped := NewManager(Config{Store: &MockStore{}, CleanupInterval: time.Minute, CleanupJitter: time.Hour})
+	defer capped.Close()
+	for i := 0; i < 100; i++ {
+		if d := capped.nextCleanup(); d < 30*time.Second {
+			t.Fatalf("expected jitter capped to half the interval, got delay %v", d)
+		}
+	}
+
+	plain := NewManager(Config{Store: &MockStore{}, CleanupInterval: interval})
+	defer plain.Close()
+	if d := plain.nextCleanup(); d != interval {
+		t.Errorf("expected exact interval without jitter, got %v", d)
+	}
+}
+
+// blockingCleanupStore blocks in Cleanup until its context is done and
+// records how it ended.
+type blockingCleanupStore struct {
+	MockStore
+	started   chan struct{}
+	cleanErr  chan error
+	closed    chan struct{}
+	cleanDone bool
+}
+
+func newBlockingCleanupStore() *blockingCleanupStore {
+	return &blockingCleanupStore{
+		started:  make(chan struct{}, 1),
+		cleanErr: make(chan error, 1),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (s *blockingCleanupStore) Cleanup(ctx context.Context) error {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	s.cleanDone = true
+	select {
+	case s.cleanErr <- ctx.Err():
+	default:
+	}
+	return ctx.Err()
+}
+
+func (s *blockingCleanupStore) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestManager_CloseAbortsCleanup(t *testing.T) {
+	store := newBlockingCleanupStore()
+	mgr := NewManager(Config{Store: store, CleanupInterval: time.Millisecond})
+
+	<-store.started
+	if err := mgr.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if err := <-store.cleanErr; err != context.Canceled {
+		t.Errorf("expected cleanup to be canceled, got %v", err)
+	}
+	// The worker has exited before the store was closed.
+	if !store.cleanDone {
+		t.Error("expected cleanup to have returned before Close returned")
+	}
+}
+
+func TestManager_CleanupTimeout(t *testing.T) {
+	store := newBlockingCleanupStore()
+	mgr := NewManager(Config{
+		Store:           store,
+		CleanupInterval: time.Millisecond,
+		CleanupTimeout:  10 * time.Millisecond,
+	})
+	defer mgr.Close()
+
+	select {
+	case err := <-store.cleanErr:
+		if err != context.DeadlineExceeded {
+			t.Errorf("expected cleanup to time out, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cleanup was not bounded by CleanupTimeout")
+	}
+}
+
+// stuckCleanupStore ignores cancellation: Cleanup blocks until release is
+// closed.
+type stuckCleanupStore struct {
+	MockStore
+	started  chan struct{}
+	release  chan struct{}
+	closed   bool
+	closeErr error
+}
+
+func (s *stuckCleanupStore) Cleanup(ctx context.Context) error {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return nil
+}
+
+func (s *stuckCleanupStore) Close() error {
+	s.closed = true
+	return s.closeErr
+}
+
+func TestManager_CloseContextBoundsWait(t *testing.T) {
+	store := &stuckCleanupStore{
+		started:  make(chan struct{}, 1),
+		release:  make(chan struct{}),
+		closeErr: errors.New("close failed"),
+	}
+	defer close(store.release)
+	mgr := NewManager(Config{Store: store, CleanupInterval: time.Millisecond})
+
+	<-store.started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := mgr.CloseContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait to time out, got %v", err)
+	}
+	if !errors.Is(err, store.closeErr) {
+		t.Errorf("expected the store's close error, got %v", err)
+	}
+	if !store.closed {
+		t.Error("expected the store to be closed after the wait timed out")
+	}
+}
+
+func TestManager_CloseTwice(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store})
+	if err := mgr.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if err := mgr.Close(); err != nil {
+		t.Errorf("expected second Close to succeed, got %v", err)
+	}
+}
+
+// cleanupCounter counts Cleanup calls and fails them with err.
+type cleanupCounter struct {
+	MockStore
+	calls int
+	err   error
+}
+
+func (c *cleanupCounter) Cleanup(ctx context.Context) error {
+	c.calls++
+	return c.err
+}
+
+func TestManager_CleanupStores(t *testing.T) {
+	failing := &cleanupCounter{err: errors.New("store unavailable")}
+	other := &cleanupCounter{}
+	main := &cleanupCounter{}
+	mgr := NewManager(Config{
+		Store:           main,
+		CleanupInterval: -1,
+		CleanupStores:   []Store{failing, other},
+	})
+	defer mgr.Close()
+
+	err := mgr.Cleanup(context.Background())
+	if !errors.Is(err, failing.err) {
+		t.Errorf("expected the failing store's error, got %v", err)
+	}
+	if main.calls != 1 || failing.calls != 1 || other.calls != 1 {
+		t.Errorf("expected every store cleaned once, got %d, %d and %d calls", main.calls, failing.calls, other.calls)
+	}
+
+	if _, err := NewManagerE(Config{Store: main, CleanupStores: []Store{nil}}); err == nil {
+		t.Error("expected a nil cleanup store to be rejected")
+	}
+}
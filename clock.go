@@ -0,0 +1,15 @@
+package dbsession
+
+import "time"
+
+// Clock reports the current time. The Manager uses it for every expiry
+// decision, so tests can supply a fake clock and advance it to expire
+// sessions instantly instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default Clock, backed by time.Now.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
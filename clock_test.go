@@ -0,0 +1,135 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestManager_FakeClockExpiry(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	clock := newFakeClock()
+	store.clock = clock
+	mgr := NewManager(Config{Store: store, TTL: time.Hour, Clock: clock})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if !s.CreatedAt.Equal(clock.Now()) {
+		t.Errorf("expected CreatedAt from the clock, got %v", s.CreatedAt)
+	}
+	cookie := saveFrom(t, mgr, s, "192.0.2.1:1234")
+	if !s.ExpiresAt.Equal(clock.Now().Add(time.Hour)) {
+		t.Errorf("expected ExpiresAt from the clock, got %v", s.ExpiresAt)
+	}
+
+	load := func() error {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		_, err := mgr.Load(r)
+		return err
+	}
+
+	clock.Advance(59 * time.Minute)
+	if err := load(); err != nil {
+		t.Fatalf("expected session to be live, got %v", err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if err := load(); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected ErrSessionExpired, got %v", err)
+	}
+
+	if err := mgr.Cleanup(context.Background()); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if got, _ := store.Get(context.Background(), s.ID); got != nil {
+		t.Error("expected cleanup to remove the session expired by the fake clock")
+	}
+}
+
+func TestManager_ExpiryLeeway(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	clock := newFakeClock()
+	store.clock = clock
+	// Wrapped, so that the leeway has to be passed through.
+	tiered := NewTieredStore(newMapStore(), store)
+	mgr := NewManager(Config{Store: tiered, TTL: time.Hour, Clock: clock, ExpiryLeeway: 5 * time.Second})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	cookie := saveFrom(t, mgr, s, "192.0.2.1:1234")
+	load := func() error {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		_, err := mgr.Load(r)
+		return err
+	}
+	ctx := context.Background()
+
+	// Within the leeway, the session is still valid and survives cleanup.
+	clock.Advance(time.Hour + 3*time.Second)
+	if err := load(); err != nil {
+		t.Fatalf("expected the session to be valid within the leeway, got %v", err)
+	}
+	if err := mgr.Cleanup(ctx); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if got, _ := store.Get(ctx, s.ID); got == nil {
+		t.Fatal("expected cleanup to keep the session within the leeway")
+	}
+	if n, _ := store.Count(ctx); n != 1 {
+		t.Errorf("expected the session to be counted within the leeway, got %d", n)
+	}
+
+	clock.Advance(3 * time.Second)
+	if err := load(); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected ErrSessionExpired past the leeway, got %v", err)
+	}
+	if err := mgr.Cleanup(ctx); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if got, _ := store.Get(ctx, s.ID); got != nil {
+		t.Error("expected cleanup to remove the session past the leeway")
+	}
+
+	if _, err := NewManagerE(Config{Store: store, ExpiryLeeway: -time.Second}); err == nil {
+		t.Error("expected a negative leeway to be rejected")
+	}
+}
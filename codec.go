@@ -0,0 +1,277 @@
+package dbsession
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Codec serializes session values for storage. Stores use GobCodec unless
+// configured otherwise; a store must always be read with the codec it was
+// written with.
+type Codec interface {
+	Encode(w io.Writer, values map[string]any) error
+	// Decode must read everything it needs from r before returning: r may
+	// be backed by memory that is reused once Decode returns.
+	Decode(r io.Reader) (map[string]any, error)
+}
+
+// LimitedDecoder is implemented by codecs whose Decode reads more data than
+// is stored, e.g. by decompressing it. Stores with MaxDecodedBytes set call
+// DecodeLimited instead of Decode, so that the codec can bound the expanded
+// data; other codecs read the stored data as is, which the stores check
+// against the limit themselves.
+type LimitedDecoder interface {
+	Codec
+	// DecodeLimited decodes like Decode, failing with an error wrapping
+	// ErrDecodedTooLarge if more than limit bytes are decoded. A codec
+	// typically wraps its expanded stream with LimitDecoded.
+	DecodeLimited(r io.Reader, limit int64) (map[string]any, error)
+}
+
+// LimitDecoded returns a Reader that reads from r but fails with an error
+// wrapping ErrDecodedTooLarge once more than limit bytes would be read.
+// Unlike io.LimitReader, it does not stop at the limit with io.EOF, which
+// would pass a truncated session off as valid data.
+func LimitDecoded(r io.Reader, limit int64) io.Reader {
+	return &decodedLimitReader{r: r, limit: limit, remaining: limit}
+}
+
+// decodedLimitReader is the Reader returned by LimitDecoded.
+type decodedLimitReader struct {
+	r         io.Reader
+	limit     int64
+	remaining int64 // Negative once the limit was exceeded
+}
+
+func (l *decodedLimitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, l.tooLarge()
+	}
+	// One byte past the limit is enough to tell that it is exceeded.
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = -1
+		return n, l.tooLarge()
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+func (l *decodedLimitReader) tooLarge() error {
+	return fmt.Errorf("%w: more than %d bytes", ErrDecodedTooLarge, l.limit)
+}
+
+// DecodeErrorPolicy selects how Load handles a session whose stored data
+// cannot be decoded, e.g. a truncated row or a value of a type that is no
+// longer registered with gob.
+type DecodeErrorPolicy int
+
+const (
+	// DecodeErrorPropagate returns the decode error, which wraps
+	// ErrCorruptSession. Get returns it too, so the client stays stuck with
+	// the cookie until the session expires or is deleted.
+	DecodeErrorPropagate DecodeErrorPolicy = iota
+	// DecodeErrorDiscard logs the error and treats the session as not found,
+	// so Get issues a new one. The corrupt data is left in the store for
+	// inspection and is removed by cleanup once it expires.
+	DecodeErrorDiscard
+)
+
+// GobCodec encodes values with encoding/gob. It round-trips any type
+// registered with gob.Register and is the default.
+type GobCodec struct{}
+
+func (GobCodec) Encode(w io.Writer, values map[string]any) error {
+	return gob.NewEncoder(w).Encode(values)
+}
+
+func (GobCodec) Decode(r io.Reader) (map[string]any, error) {
+	var values map[string]any
+	err := gob.NewDecoder(r).Decode(&values)
+	return values, err
+}
+
+// SortedGobCodec encodes values with encoding/gob as a list of key/value
+// pairs sorted by key, so that equal values always encode to the same bytes,
+// e.g. for snapshot tests or caches keyed on the stored data. Only the
+// session's own keys are sorted: maps nested in values are encoded by gob in
+// random order. gob numbers custom types in the order a program first
+// encodes them, so the bytes are only stable between runs that do so in the
+// same order. Its encoding differs from GobCodec's: a store must be emptied
+// or migrated when switching between them.
+type SortedGobCodec struct{}
+
+// sortedValue is a session value as encoded by SortedGobCodec.
+type sortedValue struct {
+	Key   string
+	Value any
+}
+
+func (SortedGobCodec) Encode(w io.Writer, values map[string]any) error {
+	entries := make([]sortedValue, 0, len(values))
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		entries = append(entries, sortedValue{Key: key, Value: values[key]})
+	}
+	return gob.NewEncoder(w).Encode(entries)
+}
+
+func (SortedGobCodec) Decode(r io.Reader) (map[string]any, error) {
+	var entries []sortedValue
+	if err := gob.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+	values := make(map[string]any, len(entries))
+	for _, e := range entries {
+		values[e.Key] = e.Value
+	}
+	return values, nil
+}
+
+// JSONCodec encodes values as JSON. Decoded values have JSON types
+// (numbers become float64, structs become maps).
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(w io.Writer, values map[string]any) error {
+	return json.NewEncoder(w).Encode(values)
+}
+
+func (JSONCodec) Decode(r io.Reader) (map[string]any, error) {
+	var values map[string]any
+	err := json.NewDecoder(r).Decode(&values)
+	return values, err
+}
+
+// Register records the concrete type of value with gob, as gob.Register
+// does. A value of a custom type, e.g. a struct, can only be stored in a
+// session once its type is registered, so register each such type before
+// it is first saved or loaded, typically in an init function:
+//
+//	func init() {
+//		dbsession.Register(Cart{})
+//	}
+func Register(value any) {
+	gob.Register(value)
+}
+
+// encodeError wraps an error encoding session values. gob fails on values of
+// types that were never registered, a mistake worth spelling out.
+func encodeError(err error) error {
+	if strings.Contains(err.Error(), "type not registered") {
+		return fmt.Errorf("failed to encode session data: %w (register the type with dbsession.Register)", err)
+	}
+	return fmt.Errorf("failed to encode session data: %w", err)
+}
+
+// EncodeValues encodes session values the way the stores do by default.
+// Empty values encode to nil, which the stores save as NULL.
+func EncodeValues(values map[string]any) ([]byte, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := (GobCodec{}).Encode(&buf, values); err != nil {
+		return nil, encodeError(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeValues decodes session values as stored by default, e.g. a data
+// column read directly from the database. Empty data decodes to an empty
+// map. Data written with another codec must be decoded with that codec.
+func DecodeValues(data []byte) (map[string]any, error) {
+	return decodeValues(GobCodec{}, data, 0)
+}
+
+// storeCodec returns the codec store encodes values with, see CodecStore.
+// Wrappers report the codec of the store that keeps the session durably.
+func storeCodec(store Store) Codec {
+	var codec Codec
+	switch s := store.(type) {
+	case CodecStore:
+		codec = s.Codec()
+	case *RetryStore:
+		codec = storeCodec(s.store)
+	case *CachingStore:
+		codec = storeCodec(s.store)
+	case *TieredStore:
+		codec = storeCodec(s.l2)
+	}
+	if codec == nil {
+		return GobCodec{}
+	}
+	return codec
+}
+
+// sameCodec reports whether a and b are the same codec. Codecs of a type
+// that cannot be compared are never the same.
+func sameCodec(a, b Codec) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// encodeValues encodes the session's values into buf. The encoding the
+// Manager produced on Save is reused when it was made with codec.
+func encodeValues(codec Codec, session *Session, buf *bytes.Buffer) ([]byte, error) {
+	if session.encoded != nil && sameCodec(codec, session.encodedBy) {
+		return session.encoded, nil
+	}
+	resetBuffer(buf)
+	if err := codec.Encode(buf, session.Values); err != nil {
+		return nil, encodeError(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeValues decodes stored values. Empty data (a NULL column) decodes to
+// an empty map without invoking the codec. If limit is positive, decoding
+// fails once more than limit bytes are decoded, see LimitedDecoder.
+//
+// data may be driver memory such as sql.RawBytes, valid only until the next
+// Scan or Close: it is decoded before decodeValues returns, and the pooled
+// reader is detached from it before being reused, so no reference to it
+// outlives the call.
+func decodeValues(codec Codec, data []byte, limit int64) (map[string]any, error) {
+	var values map[string]any
+	limited, expands := codec.(LimitedDecoder)
+	if limit > 0 && !expands && int64(len(data)) > limit {
+		return nil, fmt.Errorf("failed to decode session data: %w: %w: %d bytes, limit is %d",
+			ErrCorruptSession, ErrDecodedTooLarge, len(data), limit)
+	}
+	if len(data) > 0 {
+		reader := readerPool.Get().(*bytes.Reader)
+		reader.Reset(data)
+		defer func() {
+			reader.Reset(nil)
+			readerPool.Put(reader)
+		}()
+
+		var err error
+		if limit > 0 && expands {
+			values, err = limited.DecodeLimited(reader, limit)
+		} else {
+			values, err = codec.Decode(reader)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode session data: %w: %w", ErrCorruptSession, err)
+		}
+	}
+
+	if values == nil {
+		values = make(map[string]any)
+	}
+	return values, nil
+}
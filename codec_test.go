@@ -0,0 +1,455 @@
+package dbsession
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecodeValues(t *testing.T) {
+	data, err := EncodeValues(map[string]any{"user": "alice", "n": 3})
+	if err != nil {
+		t.Fatalf("failed to encode values: %v", err)
+	}
+	values, err := DecodeValues(data)
+	if err != nil {
+		t.Fatalf("failed to decode values: %v", err)
+	}
+	if values["user"] != "alice" || values["n"] != 3 {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	if data, _ := EncodeValues(nil); data != nil {
+		t.Errorf("expected empty values to encode to nil, got %v", data)
+	}
+	if values, err := DecodeValues(nil); err != nil || len(values) != 0 {
+		t.Errorf("expected empty map for empty data, got %v, %v", values, err)
+	}
+}
+
+func TestDecodeValues_StoredBlob(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	s := &Session{
+		ID:        "raw-blob",
+		Values:    map[string]any{"role": "admin"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(context.Background(), s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	var data []byte
+	if err := store.db.QueryRow("SELECT data FROM sessions WHERE id = ?", s.ID).Scan(&data); err != nil {
+		t.Fatalf("failed to read raw data: %v", err)
+	}
+	values, err := DecodeValues(data)
+	if err != nil {
+		t.Fatalf("failed to decode stored blob: %v", err)
+	}
+	if values["role"] != "admin" {
+		t.Errorf("expected admin, got %v", values["role"])
+	}
+}
+
+func TestSortedGobCodec(t *testing.T) {
+	var first []byte
+	for i := range 20 {
+		// Maps built in different orders iterate in different orders.
+		values := make(map[string]any)
+		for j := range 16 {
+			k := (i + j) % 16
+			values[fmt.Sprintf("key%02d", k)] = k
+		}
+		var buf bytes.Buffer
+		if err := (SortedGobCodec{}).Encode(&buf, values); err != nil {
+			t.Fatalf("failed to encode: %v", err)
+		}
+		if first == nil {
+			first = buf.Bytes()
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), first) {
+			t.Fatalf("encoding %d differs from the first", i)
+		}
+	}
+
+	values, err := (SortedGobCodec{}).Decode(bytes.NewReader(first))
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if len(values) != 16 || values["key03"] != 3 {
+		t.Errorf("unexpected values: %v", values)
+	}
+}
+
+func TestSQLiteStore_Codec(t *testing.T) {
+	store, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: ":memory:", Codec: JSONCodec{}})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "json",
+		Values:    map[string]any{"role": "admin"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	var data string
+	if err := store.db.QueryRow("SELECT data FROM sessions WHERE id = ?", s.ID).Scan(&data); err != nil {
+		t.Fatalf("failed to read raw data: %v", err)
+	}
+	if data != `{"role":"admin"}`+"\n" {
+		t.Errorf("expected JSON in data column, got %q", data)
+	}
+
+	got, err := store.Get(ctx, s.ID)
+	if err != nil || got == nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if got.Values["role"] != "admin" {
+		t.Errorf("expected admin, got %v", got.Values["role"])
+	}
+}
+
+// gzipCodec compresses the gob encoding, as a codec expanding stored data.
+type gzipCodec struct{}
+
+func (gzipCodec) Encode(w io.Writer, values map[string]any) error {
+	zw := gzip.NewWriter(w)
+	if err := (GobCodec{}).Encode(zw, values); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (c gzipCodec) Decode(r io.Reader) (map[string]any, error) {
+	return c.DecodeLimited(r, 0)
+}
+
+func (gzipCodec) DecodeLimited(r io.Reader, limit int64) (map[string]any, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 {
+		return (GobCodec{}).Decode(LimitDecoded(zr, limit))
+	}
+	return (GobCodec{}).Decode(zr)
+}
+
+func TestSQLiteStore_MaxDecodedBytes(t *testing.T) {
+	ctx := context.Background()
+	for name, codec := range map[string]Codec{"Limited": gzipCodec{}, "Plain": GobCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			store, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: ":memory:", Codec: codec, MaxDecodedBytes: 64 << 10})
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			defer store.Close()
+
+			small := &Session{ID: "small", Values: map[string]any{"user": "alice"}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+			// 1 MiB of zeros compresses to about a kilobyte.
+			bomb := &Session{ID: "bomb", Values: map[string]any{"pad": make([]byte, 1<<20)}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+			for _, s := range []*Session{small, bomb} {
+				if err := store.Save(ctx, s); err != nil {
+					t.Fatalf("failed to save session: %v", err)
+				}
+			}
+
+			if got, err := store.Get(ctx, "small"); err != nil || got.Values["user"] != "alice" {
+				t.Errorf("expected the small session to load, got %v, %v", got, err)
+			}
+			_, err = store.Get(ctx, "bomb")
+			if !errors.Is(err, ErrDecodedTooLarge) || !errors.Is(err, ErrCorruptSession) {
+				t.Errorf("expected ErrDecodedTooLarge wrapping ErrCorruptSession, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLimitDecoded(t *testing.T) {
+	data := []byte("0123456789")
+	if got, err := io.ReadAll(LimitDecoded(bytes.NewReader(data), 10)); err != nil || string(got) != string(data) {
+		t.Errorf("expected data at the limit to be read, got %q, %v", got, err)
+	}
+	got, err := io.ReadAll(LimitDecoded(bytes.NewReader(data), 9))
+	if !errors.Is(err, ErrDecodedTooLarge) || len(got) != 9 {
+		t.Errorf("expected ErrDecodedTooLarge after 9 bytes, got %q, %v", got, err)
+	}
+}
+
+func TestPostgreSQLStore_CodecWithJSONB(t *testing.T) {
+	_, err := NewPostgreSQLStoreWithConfig(PostgreSQLConfig{
+		DSN:    "postgres://unused",
+		Format: PostgreSQLFormatJSONB,
+		Codec:  GobCodec{},
+	})
+	if err == nil {
+		t.Fatal("expected error combining a codec with the JSONB format")
+	}
+}
+
+func TestJSONCodec_SessionTTL(t *testing.T) {
+	s := &Session{Values: map[string]any{}}
+	s.SetTTL(15 * time.Minute)
+
+	var buf bytes.Buffer
+	if err := (JSONCodec{}).Encode(&buf, s.Values); err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	values, err := (JSONCodec{}).Decode(&buf)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	decoded := &Session{Values: values}
+	if ttl := decoded.ttlLocked(); ttl != 15*time.Minute {
+		t.Errorf("expected the TTL to survive JSON, got %v", ttl)
+	}
+}
+
+func TestManager_OnDecodeError(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	ctx := context.Background()
+
+	strict := NewManager(Config{Store: store, CleanupInterval: -1})
+	lenient := NewManager(Config{Store: store, CleanupInterval: -1, OnDecodeError: DecodeErrorDiscard})
+	defer lenient.Close()
+
+	s, err := strict.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.Set("user", "alice")
+	if err := strict.PersistSession(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if _, err := store.db.Exec("UPDATE sessions SET data = ? WHERE id = ?", []byte("not gob"), s.ID); err != nil {
+		t.Fatalf("failed to corrupt session: %v", err)
+	}
+
+	if _, err := strict.LoadSession(ctx, s.ID); !errors.Is(err, ErrCorruptSession) {
+		t.Errorf("expected ErrCorruptSession by default, got %v", err)
+	}
+	if _, err := lenient.LoadSession(ctx, s.ID); err != ErrSessionNotFound {
+		t.Errorf("expected ErrSessionNotFound with DecodeErrorDiscard, got %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session_id", Value: s.ID})
+	got, err := lenient.Get(r)
+	if err != nil {
+		t.Fatalf("expected a new session, got %v", err)
+	}
+	if got.ID == s.ID {
+		t.Errorf("expected a new session to replace the corrupt one, got %+v", got)
+	}
+}
+
+// realisticValues returns session values that gob-encode to about 2KB.
+func realisticValues() map[string]any {
+	return map[string]any{
+		"user_id":     "8f14e45f-ceea-467f-a0e6-5b3c2d1e9a7b",
+		"email":       "alice@example.com",
+		"roles":       []string{"admin", "editor", "viewer"},
+		"csrf":        strings.Repeat("c", 64),
+		"preferences": strings.Repeat("p", 512),
+		"cart":        strings.Repeat("i", 1024),
+		"visits":      42,
+	}
+}
+
+func TestEncodedSizeHint(t *testing.T) {
+	defer encodedSizeHint.Store(encodedSizeHint.Load())
+	encodedSizeHint.Store(0)
+
+	recordEncodedSize(0)
+	if n := encodedSizeHint.Load(); n != 0 {
+		t.Errorf("expected empty buffers to be ignored, got %d", n)
+	}
+	recordEncodedSize(2000)
+	if n := encodedSizeHint.Load(); n != 2000 {
+		t.Errorf("expected the first size to be taken as is, got %d", n)
+	}
+	recordEncodedSize(maxEncodedSizeHint * 10)
+	if n := encodedSizeHint.Load(); n > maxEncodedSizeHint {
+		t.Errorf("expected the hint to stay below %d, got %d", maxEncodedSizeHint, n)
+	}
+
+	encodedSizeHint.Store(2000)
+	var buf bytes.Buffer
+	resetBuffer(&buf)
+	if buf.Cap() < 2000 {
+		t.Errorf("expected the buffer to be grown to the hint, got capacity %d", buf.Cap())
+	}
+}
+
+// BenchmarkEncodeValues_2KB encodes a typical session into a fresh buffer,
+// as after the pool has been emptied by a GC, with and without sizing it
+// from the encoded size hint.
+func BenchmarkEncodeValues_2KB(b *testing.B) {
+	defer encodedSizeHint.Store(encodedSizeHint.Load())
+	session := &Session{Values: realisticValues()}
+	blob, err := EncodeValues(session.Values)
+	if err != nil {
+		b.Fatalf("failed to encode values: %v", err)
+	}
+
+	for _, bench := range []struct {
+		name string
+		hint int64
+	}{
+		{"Unsized", 0},
+		{"Presized", int64(len(blob))},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			encodedSizeHint.Store(bench.hint)
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := encodeValues(GobCodec{}, session, new(bytes.Buffer)); err != nil {
+					b.Fatalf("failed to encode values: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// registeredCart is a custom value type, registered by TestRegister.
+type registeredCart struct {
+	Items []string
+	Total int
+}
+
+func TestRegister(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	mgr := NewManager(Config{Store: store, TTL: time.Hour})
+	defer mgr.Close()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	session, err := mgr.Get(r)
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	session.Set("cart", registeredCart{Items: []string{"book"}, Total: 12})
+
+	// Unregistered, the type cannot be encoded, and the error says why.
+	err = mgr.Save(httptest.NewRecorder(), r, session)
+	if err == nil || !strings.Contains(err.Error(), "dbsession.Register") {
+		t.Fatalf("expected an error pointing at Register, got %v", err)
+	}
+
+	Register(registeredCart{})
+	w := httptest.NewRecorder()
+	if err := mgr.Save(w, r, session); err != nil {
+		t.Fatalf("failed to save registered type: %v", err)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	loaded, err := mgr.Get(r)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	value, _ := loaded.Get("cart")
+	cart, ok := value.(registeredCart)
+	if !ok || cart.Total != 12 || len(cart.Items) != 1 {
+		t.Errorf("unexpected cart: %#v", value)
+	}
+}
+
+// TestManager_UnencodableValue checks that a value gob cannot encode fails
+// Save whether or not a size limit makes the Manager encode for its check,
+// even with a store that does not encode values itself.
+func TestManager_UnencodableValue(t *testing.T) {
+	for _, limit := range []int{0, 4096} {
+		mgr := NewManager(Config{Store: newMapStore(), TTL: time.Hour, MaxSessionBytes: limit})
+		session, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		session.Set("callback", func() {})
+		err = mgr.Save(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), session)
+		if err == nil || !strings.Contains(err.Error(), "failed to encode session data") {
+			t.Errorf("MaxSessionBytes %d: expected an encoding error, got %v", limit, err)
+		}
+		mgr.Close()
+	}
+}
+
+// TestManager_StoreCodec checks that the Manager encodes values with the
+// store's codec: a struct JSON can encode needs no gob registration, and
+// the store reuses the Manager's encoding instead of encoding again.
+func TestManager_StoreCodec(t *testing.T) {
+	type unregistered struct{ Name string }
+
+	for _, limit := range []int{0, 4096} {
+		codec := &countingCodec{}
+		store, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: ":memory:", Codec: codec})
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		defer store.Close()
+		mgr := NewManager(Config{Store: store, TTL: time.Hour, MaxSessionBytes: limit})
+		defer mgr.Close()
+
+		session, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		session.Set("profile", unregistered{Name: "alice"})
+		if err := mgr.Save(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), session); err != nil {
+			t.Fatalf("MaxSessionBytes %d: failed to save unregistered struct with JSONCodec: %v", limit, err)
+		}
+		if n := codec.encodes.Load(); n != 1 {
+			t.Errorf("MaxSessionBytes %d: expected values encoded once, got %d", limit, n)
+		}
+
+		got, err := store.Get(context.Background(), session.ID)
+		if err != nil || got == nil {
+			t.Fatalf("failed to get session: %v", err)
+		}
+		if profile, _ := got.Values["profile"].(map[string]any); profile["Name"] != "alice" {
+			t.Errorf("unexpected profile: %#v", got.Values["profile"])
+		}
+	}
+}
+
+// countingCodec is JSONCodec counting its Encode calls.
+type countingCodec struct {
+	JSONCodec
+	encodes atomic.Int64
+}
+
+func (c *countingCodec) Encode(w io.Writer, values map[string]any) error {
+	c.encodes.Add(1)
+	return c.JSONCodec.Encode(w, values)
+}
@@ -1,7 +1,11 @@
 package dbsession
 
 import (
+	"context"
+	"fmt"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -25,7 +29,10 @@ func TestRaceCondition(t *testing.T) {
 	req := httptest.NewRequest("GET", "/", nil)
 	w := httptest.NewRecorder()
 
-	session := mgr.New()
+	session, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
 
 	var wg sync.WaitGroup
 	start := make(chan struct{})
@@ -59,3 +66,77 @@ func TestRaceCondition(t *testing.T) {
 	close(start)
 	wg.Wait()
 }
+
+// TestSQLiteStore_ConcurrentGetRawBytes checks that sessions decoded from
+// sql.RawBytes are not corrupted when the get statement and the pooled
+// readers are reused concurrently, with saves rewriting the rows meanwhile.
+func TestSQLiteStore_ConcurrentGetRawBytes(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	// Each session holds a payload derived from its ID and generation, large
+	// enough to span several driver buffers.
+	const sessions = 8
+	payload := func(i, gen int) string {
+		return strings.Repeat(fmt.Sprintf("%d:%d;", i, gen), 512)
+	}
+	save := func(i, gen int) error {
+		return store.Save(ctx, &Session{
+			ID:        fmt.Sprintf("raw-%d", i),
+			Values:    map[string]any{"i": i, "gen": gen, "payload": payload(i, gen)},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+	}
+	for i := range sessions {
+		if err := save(i, 0); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for gen := 1; ; gen++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := save(gen%sessions, gen); err != nil {
+				t.Errorf("failed to save: %v", err)
+				return
+			}
+		}
+	}()
+
+	var readers sync.WaitGroup
+	for r := range 8 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for n := range 200 {
+				i := (r + n) % sessions
+				got, err := store.Get(ctx, fmt.Sprintf("raw-%d", i))
+				if err != nil || got == nil {
+					t.Errorf("failed to get: %v, %v", got, err)
+					return
+				}
+				gen, _ := got.Values["gen"].(int)
+				if got.Values["i"] != i || got.Values["payload"] != payload(i, gen) {
+					t.Errorf("session raw-%d decoded corrupted values", i)
+					return
+				}
+			}
+		}()
+	}
+	readers.Wait()
+	close(stop)
+	wg.Wait()
+}
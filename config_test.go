@@ -0,0 +1,66 @@
+package dbsession
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewManagerE(t *testing.T) {
+	store := &MockStore{}
+	no := false
+
+	tests := []struct {
+		name string
+		cfg  Config
+		want string // Substring of the error, empty for success
+	}{
+		{"valid", Config{Store: store}, ""},
+		{"nil store", Config{}, "Store is required"},
+		{"negative TTL", Config{Store: store, TTL: -time.Hour}, "TTL"},
+		{"SameSite None without Secure", Config{Store: store, SameSite: http.SameSiteNoneMode, Secure: &no}, "SameSite=None"},
+		{"SameSite None with default Secure", Config{Store: store, SameSite: http.SameSiteNoneMode}, ""},
+		{"negative StoreTimeout", Config{Store: store, StoreTimeout: -time.Second}, "StoreTimeout"},
+		{"negative CookieTTL", Config{Store: store, CookieTTL: -time.Hour}, "CookieTTL"},
+		{"negative MaxSessionBytes", Config{Store: store, MaxSessionBytes: -1}, "MaxSessionBytes"},
+		{"IPv4 prefix too long", Config{Store: store, IPv4PrefixLen: 33}, "IPv4PrefixLen"},
+		{"IPv6 prefix negative", Config{Store: store, IPv6PrefixLen: -1}, "IPv6PrefixLen"},
+		{"cookie name clash", Config{Store: store, CookieName: "sid", RememberCookieName: "sid"}, "must differ"},
+		{"secure prefix without Secure", Config{Store: store, UseSecurePrefix: true, Secure: &no}, "UseSecurePrefix requires"},
+		{"secure prefix on host cookie", Config{Store: store, UseSecurePrefix: true, CookieName: "__Host-sid"}, "must not already"},
+		{"secure prefix", Config{Store: store, UseSecurePrefix: true}, ""},
+		{"partitioned without SameSite None", Config{Store: store, Partitioned: true}, "Partitioned requires"},
+		{"partitioned", Config{Store: store, Partitioned: true, SameSite: http.SameSiteNoneMode}, ""},
+		{"short token key", Config{Store: store, TokenKey: []byte("short")}, "TokenKey"},
+		{"fallback is the cookie name", Config{Store: store, FallbackCookieNames: []string{"sid", "session_id"}}, "FallbackCookieNames"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr, err := NewManagerE(tt.cfg)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				mgr.Close()
+				return
+			}
+			if err == nil {
+				mgr.Close()
+				t.Fatalf("expected error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestNewManager_NilStorePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected NewManager to panic on a nil Store")
+		}
+	}()
+	NewManager(Config{})
+}
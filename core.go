@@ -0,0 +1,267 @@
+package dbsession
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// The methods in this file are the HTTP-free core of the Manager, for use
+// where there is no http.Request, e.g. in gRPC services or background
+// workers. They manage expiry and talk to the store but set no cookies and
+// do not bind sessions to a client; Get, Save and Destroy are thin HTTP
+// adapters over them.
+
+// CreateSession creates a new session and saves it to the store.
+func (m *Manager) CreateSession(ctx context.Context) (*Session, error) {
+	s, err := m.New()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.PersistSession(ctx, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadSession returns the session with the given ID. It returns
+// ErrSessionNotFound if there is no such session and ErrSessionExpired if it
+// has expired, or ErrSessionIdle if it exceeded Config.IdleTimeout.
+func (m *Manager) LoadSession(ctx context.Context, id string) (*Session, error) {
+	session, err := m.loadSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	m.touch(ctx, session)
+	return session, nil
+}
+
+// PersistSession extends the session's expiry by the TTL and saves it to
+// the store.
+func (m *Manager) PersistSession(ctx context.Context, s *Session) error {
+	// Acquire lock to prevent race conditions with concurrent Session.Set/Delete calls.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return m.persistLocked(ctx, s)
+}
+
+// RegenerateID gives the session a new ID, e.g. to rotate a user's session
+// from a password-reset job: it saves the session under the new ID and
+// deletes the old one, returning the old ID. Sending the new ID to the
+// client is up to the caller. If the save fails, the session keeps its old
+// ID. If the old session cannot be deleted, the new one is deleted too and
+// an error is returned, so that the old ID is never left valid alongside a
+// new one; the caller must then treat the user as logged out.
+func (m *Manager) RegenerateID(ctx context.Context, s *Session) (string, error) {
+	return m.regenerate(ctx, s, func() error { return m.PersistSession(ctx, s) })
+}
+
+// regenerate implements RegenerateID and Regenerate, which saves the session
+// with save.
+func (m *Manager) regenerate(ctx context.Context, s *Session, save func() error) (string, error) {
+	oldID := s.ID
+	newID, err := m.newID()
+	if err != nil {
+		return "", err
+	}
+	s.ID = newID
+
+	// The session is stored as a new record under the new ID,
+	// so it starts over at version 0 for optimistic locking.
+	oldVersion := s.Version
+	s.Version = 0
+	s.RegenerateCount++
+
+	if err := save(); err != nil {
+		s.ID = oldID // Restore old ID on failure
+		s.Version = oldVersion
+		s.RegenerateCount--
+		return "", err
+	}
+
+	if err := m.storeDelete(ctx, oldID); err != nil {
+		// Security: If we fail to delete the old session, we must return an error.
+		// Failing to do so leaves the old session ID valid, which could be used
+		// in a session fixation attack. We must "fail closed" here.
+
+		// Attempt to cleanup the new session we just created
+		_ = m.storeDelete(ctx, newID)
+		return "", err
+	}
+
+	if m.onRegenerate != nil {
+		m.onRegenerate(oldID, newID)
+	}
+
+	return oldID, nil
+}
+
+// DeleteSession removes the session with the given ID from the store.
+func (m *Manager) DeleteSession(ctx context.Context, id string) error {
+	if err := m.storeDelete(ctx, id); err != nil {
+		return err
+	}
+
+	if m.metrics != nil {
+		m.metrics.SessionDestroyed()
+	}
+	if m.onDestroy != nil {
+		m.onDestroy(id)
+	}
+
+	return nil
+}
+
+// Revoke removes the sessions with the given IDs from the store, e.g. to log
+// out a set of leaked sessions. With a MultiDeleter store it takes a single
+// round trip, and the SQL stores delete all of the sessions or none;
+// otherwise they are deleted one by one. IDs are session IDs, not the
+// tokens handed to clients with Config.TokenKey.
+func (m *Manager) Revoke(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := m.storeDeleteMulti(ctx, ids); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if m.metrics != nil {
+			m.metrics.SessionDestroyed()
+		}
+		if m.onDestroy != nil {
+			m.onDestroy(id)
+		}
+	}
+
+	return nil
+}
+
+// loadSession fetches a live session by ID, without recording the access.
+func (m *Manager) loadSession(ctx context.Context, id string) (*Session, error) {
+	// Input validation: Ensure the session ID matches our expected format (32 hex characters).
+	// This prevents invalid or malicious keys from reaching the backend store.
+	if !m.validID(id) {
+		return nil, ErrSessionNotFound
+	}
+
+	known, gen := m.misses.has(id, m.clock.Now())
+	if known {
+		return nil, ErrSessionNotFound
+	}
+	session, found, err := m.storeGet(ctx, id)
+	if err != nil {
+		if m.onDecodeError == DecodeErrorDiscard && errors.Is(err, ErrCorruptSession) {
+			// The ID is not logged: it is a bearer credential.
+			slog.WarnContext(ctx, "dbsession: discarding corrupt session", "error", err)
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	if !found {
+		m.misses.add(id, m.clock.Now(), gen)
+		return nil, ErrSessionNotFound
+	}
+	// A remember-me entry is not a session, even though it is stored like one.
+	if isRememberEntry(session) {
+		return nil, ErrSessionNotFound
+	}
+
+	// Security: Enforce expiration check at the Manager level.
+	// Stores return expired sessions so that expiry can be reported, and some stores
+	// (like Memcached) might rely on lazy expiration or external TTLs,
+	// which can be unreliable or bypassed. We must ensure we never return an expired session.
+	now := m.clock.Now()
+	if session.ExpiresAt.Add(m.expiryLeeway).Before(now) {
+		return nil, ErrSessionExpired
+	}
+
+	// Sessions saved before LastAccessedAt existed have no access time to
+	// measure inactivity from.
+	if m.idleTimeout > 0 && !session.LastAccessedAt.IsZero() && now.Sub(session.LastAccessedAt) > m.idleTimeout {
+		// Best effort: an idle session is rejected even if it cannot be deleted.
+		_ = m.DeleteSession(ctx, id)
+		return nil, ErrSessionIdle
+	}
+
+	session.savedOwner = session.OwnerID
+	if m.metrics != nil {
+		m.metrics.SessionLoaded()
+	}
+	return session, nil
+}
+
+// ttlLocked returns the effective TTL of s: its own if set with
+// Session.SetTTL, the Manager's otherwise. The caller must hold s.mu.
+func (m *Manager) ttlLocked(s *Session) time.Duration {
+	if ttl := s.ttlLocked(); ttl > 0 {
+		return ttl
+	}
+	return m.ttl
+}
+
+// persistLocked saves the session. The caller must hold s.mu, which keeps
+// s.Values and s.encoded consistent.
+func (m *Manager) persistLocked(ctx context.Context, s *Session) error {
+	if s.destroyed {
+		return ErrSessionDestroyed
+	}
+	if s.degraded {
+		return ErrSessionDegraded
+	}
+	if !m.validID(s.ID) {
+		return ErrInvalidSessionID
+	}
+
+	s.ExpiresAt = m.clock.Now().Add(m.ttlLocked(s))
+
+	// Values are always encoded here, with the store's codec, so that a
+	// value it cannot encode fails every Save the same way whatever the size
+	// limit. The encoding is checked against the size limit, measured for
+	// Metrics, and reused by the store.
+	// Optimization: Skip encoding if the session is empty.
+	// This saves allocations and CPU cycles for new/empty sessions.
+	if len(s.Values) > 0 {
+		buf := bufferPool.Get().(*bytes.Buffer)
+		resetBuffer(buf)
+		defer PutBuffer(buf)
+
+		if err := m.codec.Encode(buf, s.Values); err != nil {
+			return encodeError(err)
+		}
+
+		if m.maxSessionBytes > 0 && buf.Len() > m.maxSessionBytes {
+			return &SessionTooLargeError{Size: buf.Len(), Limit: m.maxSessionBytes}
+		}
+
+		// Optimization: Store the encoded data in the session so the store doesn't have to re-encode it.
+		// Note: We use the buffer's bytes directly. The Store must consume it before we return from Save.
+		// Since store.Save is synchronous, this is safe, provided we clear s.encoded before returning.
+		s.encoded = buf.Bytes()
+		s.encodedBy = m.codec
+	}
+
+	err := m.storeSave(ctx, s)
+	size := len(s.encoded)
+	s.encoded = nil // Clear the cache to prevent use-after-free if buffer is reused
+	s.encodedBy = nil
+	if err != nil {
+		return err
+	}
+	s.modified = false
+	if m.metrics != nil {
+		m.metrics.SessionSaved(size)
+	}
+
+	if s.OwnerID != s.savedOwner {
+		if err := m.enforceSessionLimit(ctx, s); err != nil {
+			return err
+		}
+		s.savedOwner = s.OwnerID
+	}
+	return nil
+}
@@ -0,0 +1,418 @@
+package dbsession
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestManager_HeadlessAPI(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	clock := newFakeClock()
+	var destroyed string
+	mgr := NewManager(Config{
+		Store:     store,
+		TTL:       time.Hour,
+		Clock:     clock,
+		OnDestroy: func(id string) { destroyed = id },
+	})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s, err := mgr.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	// CreateSession stores the session straight away.
+	if _, err := mgr.LoadSession(ctx, s.ID); err != nil {
+		t.Fatalf("failed to load created session: %v", err)
+	}
+
+	s.Set("job", 42)
+	if err := mgr.PersistSession(ctx, s); err != nil {
+		t.Fatalf("failed to persist session: %v", err)
+	}
+	loaded, err := mgr.LoadSession(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if v, _ := loaded.Get("job"); v != 42 {
+		t.Errorf("expected 42, got %v", v)
+	}
+
+	if _, err := mgr.LoadSession(ctx, "not-an-id"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound for invalid id, got %v", err)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if _, err := mgr.LoadSession(ctx, s.ID); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired, got %v", err)
+	}
+
+	if err := mgr.DeleteSession(ctx, s.ID); err != nil {
+		t.Fatalf("failed to delete session: %v", err)
+	}
+	if destroyed != s.ID {
+		t.Errorf("expected OnDestroy for %q, got %q", s.ID, destroyed)
+	}
+	if _, err := mgr.LoadSession(ctx, s.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound after delete, got %v", err)
+	}
+}
+
+func TestSession_Modified(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if s.Modified() {
+		t.Error("expected a new session not to be modified")
+	}
+	s.Set("user", "alice")
+	if !s.Modified() {
+		t.Error("expected Set to mark the session modified")
+	}
+	if err := mgr.PersistSession(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if s.Modified() {
+		t.Error("expected saving to reset Modified")
+	}
+
+	loaded, err := mgr.LoadSession(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if loaded.Modified() {
+		t.Error("expected a loaded session not to be modified")
+	}
+	loaded.Delete("user")
+	if !loaded.Modified() {
+		t.Error("expected Delete to mark the session modified")
+	}
+}
+
+func TestSession_SetTTL(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	clock := newFakeClock()
+	mgr := NewManager(Config{Store: store, Clock: clock, TTL: 24 * time.Hour})
+	defer mgr.Close()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	user, _ := mgr.New()
+	admin, _ := mgr.New()
+	admin.SetTTL(15 * time.Minute)
+
+	w := httptest.NewRecorder()
+	if err := mgr.Save(w, r, user); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if err := mgr.Save(w, r, admin); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if want := clock.Now().Add(24 * time.Hour); !user.ExpiresAt.Equal(want) {
+		t.Errorf("expected the user session to expire at %v, got %v", want, user.ExpiresAt)
+	}
+	if want := clock.Now().Add(15 * time.Minute); !admin.ExpiresAt.Equal(want) {
+		t.Errorf("expected the admin session to expire at %v, got %v", want, admin.ExpiresAt)
+	}
+	cookies := w.Result().Cookies()
+	if cookies[0].MaxAge != 86400 || cookies[1].MaxAge != 900 {
+		t.Errorf("expected cookie lifetimes 86400 and 900, got %d and %d", cookies[0].MaxAge, cookies[1].MaxAge)
+	}
+
+	// The TTL is stored with the session, so sliding expiration keeps it.
+	ctx := context.Background()
+	clock.Advance(10 * time.Minute)
+	loaded, err := mgr.LoadSession(ctx, admin.ID)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if err := mgr.PersistSession(ctx, loaded); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if want := clock.Now().Add(15 * time.Minute); !loaded.ExpiresAt.Equal(want) {
+		t.Errorf("expected the reloaded admin session to expire at %v, got %v", want, loaded.ExpiresAt)
+	}
+
+	loaded.SetTTL(0)
+	if err := mgr.PersistSession(ctx, loaded); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if want := clock.Now().Add(24 * time.Hour); !loaded.ExpiresAt.Equal(want) {
+		t.Errorf("expected SetTTL(0) to restore the Manager TTL, got %v", loaded.ExpiresAt)
+	}
+}
+
+func TestSession_Metadata(t *testing.T) {
+	server := newFakeMemcached(t)
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	stores := map[string]Store{
+		"sqlite":    sqlite,
+		"memcached": NewMemcachedStore(time.Hour, server.addr()),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			mgr := NewManager(Config{Store: store, CleanupInterval: -1})
+			defer mgr.Close()
+			ctx := context.Background()
+
+			s, err := mgr.CreateSession(ctx)
+			if err != nil {
+				t.Fatalf("failed to create session: %v", err)
+			}
+			s.Set("user", "alice")
+			s.SetMetadata("ip", "203.0.113.7")
+			s.SetMetadata("user_agent", "Firefox")
+			s.Clear()
+			if ip, ok := s.GetMetadata("ip"); !ok || ip != "203.0.113.7" {
+				t.Errorf("expected Clear to keep metadata, got %q, %v", ip, ok)
+			}
+			if err := mgr.PersistSession(ctx, s); err != nil {
+				t.Fatalf("failed to save session: %v", err)
+			}
+
+			loaded, err := mgr.LoadSession(ctx, s.ID)
+			if err != nil {
+				t.Fatalf("failed to load session: %v", err)
+			}
+			if ua, _ := loaded.GetMetadata("user_agent"); ua != "Firefox" || len(loaded.Metadata) != 2 {
+				t.Errorf("expected metadata to round-trip, got %v", loaded.Metadata)
+			}
+			if len(loaded.Values) != 0 {
+				t.Errorf("expected no values, got %v", loaded.Values)
+			}
+		})
+	}
+}
+
+func TestManager_RegenerateID(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s, err := mgr.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.Set("user", "alice")
+	firstID := s.ID
+
+	oldID, err := mgr.RegenerateID(ctx, s)
+	if err != nil {
+		t.Fatalf("failed to regenerate: %v", err)
+	}
+	if oldID != firstID || s.ID == firstID {
+		t.Errorf("expected the ID to rotate from %s, got old %s, new %s", firstID, oldID, s.ID)
+	}
+	if _, err := mgr.LoadSession(ctx, oldID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected the old session to be deleted, got %v", err)
+	}
+	loaded, err := mgr.LoadSession(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to load regenerated session: %v", err)
+	}
+	if v, _ := loaded.Get("user"); v != "alice" || loaded.RegenerateCount != 1 {
+		t.Errorf("expected the values to carry over, got %v, count %d", v, loaded.RegenerateCount)
+	}
+}
+
+func TestSession_IsNew(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store})
+	defer mgr.Close()
+
+	s, err := mgr.Get(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if !s.IsNew() {
+		t.Error("expected a session created by Get to be new")
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	r.AddCookie(w.Result().Cookies()[0])
+	loaded, err := mgr.Get(r)
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if loaded.ID != s.ID || loaded.IsNew() {
+		t.Error("expected a loaded session not to be new")
+	}
+	if err := mgr.Regenerate(httptest.NewRecorder(), r, loaded); err != nil {
+		t.Fatalf("failed to regenerate: %v", err)
+	}
+	if loaded.IsNew() {
+		t.Error("expected Regenerate to keep an established session not new")
+	}
+}
+
+func TestSession_Clone(t *testing.T) {
+	s := &Session{
+		ID:       "original",
+		Values:   map[string]any{"user": "alice", "roles": []any{"admin"}},
+		Metadata: map[string]string{"ip": "203.0.113.7"},
+		isNew:    true,
+	}
+
+	c := s.Clone()
+	s.Set("user", "bob")
+	s.Values["roles"].([]any)[0] = "guest"
+	s.SetMetadata("ip", "198.51.100.1")
+
+	if c.Values["user"] != "alice" || c.Values["roles"].([]any)[0] != "admin" {
+		t.Errorf("expected the clone's values to be independent, got %v", c.Values)
+	}
+	if c.Metadata["ip"] != "203.0.113.7" {
+		t.Errorf("expected the clone's metadata to be independent, got %v", c.Metadata)
+	}
+	if c.ID != s.ID || !c.IsNew() || c.Modified() {
+		t.Errorf("unexpected clone state: ID %q, new %v, modified %v", c.ID, c.IsNew(), c.Modified())
+	}
+
+	// The clone has its own lock and can be read while the original changes.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			c.Get("user")
+		}
+	}()
+	for i := range 100 {
+		s.Set("n", i)
+	}
+	<-done
+}
+
+func TestSession_ToMap(t *testing.T) {
+	s := &Session{Values: map[string]any{"user": "alice", keyCSRFToken: "secret"}}
+
+	m := s.ToMap()
+	if len(m) != 1 || m["user"] != "alice" {
+		t.Errorf("expected only the application's values, got %v", m)
+	}
+	m["user"] = "mallory"
+	if v, _ := s.Get("user"); v != "alice" {
+		t.Errorf("expected changes to the copy not to affect the session, got %v", v)
+	}
+}
+
+// downStore fails every Get with err.
+type downStore struct {
+	MockStore
+	err error
+}
+
+func (d *downStore) Get(ctx context.Context, id string) (*Session, error) {
+	return nil, d.err
+}
+
+func TestManager_DegradeToNewSession(t *testing.T) {
+	down := &downStore{err: fmt.Errorf("failed to query session: %w", driver.ErrBadConn)}
+	mgr := NewManager(Config{Store: down, DegradeToNewSession: true})
+	defer mgr.Close()
+
+	id, err := mgr.newID()
+	if err != nil {
+		t.Fatalf("failed to generate id: %v", err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session_id", Value: id})
+
+	s, err := mgr.Get(r)
+	if err != nil {
+		t.Fatalf("expected a new session while the store is down, got %v", err)
+	}
+	if !s.Degraded() || s.ID == id {
+		t.Errorf("expected a new degraded session, got %s (degraded %v)", s.ID, s.Degraded())
+	}
+	if _, err := mgr.Load(r); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected Load to report the failure, got %v", err)
+	}
+
+	// The degraded session must not replace the client's cookie.
+	s.Set("k", "v")
+	w := httptest.NewRecorder()
+	if err := mgr.Save(w, r, s); !errors.Is(err, ErrSessionDegraded) {
+		t.Errorf("expected ErrSessionDegraded, got %v", err)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("expected no cookie for a degraded session")
+	}
+
+	// Other errors are not masked.
+	down.err = errors.New("permission denied for table sessions")
+	if _, err := mgr.Get(r); err == nil {
+		t.Error("expected a non-connection error to be returned")
+	}
+}
+
+// blockingStore blocks every Get until its context is done.
+type blockingStore struct {
+	MockStore
+}
+
+func (b *blockingStore) Get(ctx context.Context, id string) (*Session, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestManager_StoreTimeout(t *testing.T) {
+	mgr := NewManager(Config{Store: &blockingStore{}, StoreTimeout: 20 * time.Millisecond})
+	defer mgr.Close()
+
+	id, err := mgr.newID()
+	if err != nil {
+		t.Fatalf("failed to generate id: %v", err)
+	}
+	start := time.Now()
+	if _, err := mgr.LoadSession(context.Background(), id); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the call to give up after StoreTimeout, took %v", elapsed)
+	}
+
+	// A sooner deadline of the caller still applies.
+	mgr.storeTimeout = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := mgr.LoadSession(ctx, id); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the caller's deadline to apply, got %v", err)
+	}
+}
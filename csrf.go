@@ -0,0 +1,65 @@
+package dbsession
+
+import (
+	"crypto/hmac"
+	"net/http"
+)
+
+// keyCSRFToken stores the per-session CSRF token.
+const keyCSRFToken = reservedKeyPrefix + "csrf"
+
+const (
+	defaultCSRFHeader = "X-CSRF-Token"
+	defaultCSRFField  = "csrf_token"
+)
+
+// CSRFToken returns the session's CSRF token, generating and storing one on
+// first use. The token is kept in the session values, so the session must be
+// saved for a newly generated token to persist. It survives Regenerate.
+//
+// An empty string is returned if a token could not be generated.
+func (s *Session) CSRFToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token, ok := s.Values[keyCSRFToken].(string); ok && token != "" {
+		return token
+	}
+
+	// Reuse the session ID generator: it is already a CSPRNG-backed,
+	// 128-bit value suitable for an unguessable token.
+	token, err := generateID()
+	if err != nil {
+		return ""
+	}
+	if s.Values == nil {
+		s.Values = make(map[string]any)
+	}
+	s.Values[keyCSRFToken] = token
+	s.encoded = nil
+	return token
+}
+
+// ValidateCSRF reports whether the request carries the session's CSRF token,
+// either in the configured header or form field. The comparison is
+// constant-time. It returns false if the session has no token yet.
+func (m *Manager) ValidateCSRF(r *http.Request, session *Session) bool {
+	v, ok := session.Get(keyCSRFToken)
+	if !ok {
+		return false
+	}
+	expected, ok := v.(string)
+	if !ok || expected == "" {
+		return false
+	}
+
+	got := r.Header.Get(m.csrfHeader)
+	if got == "" {
+		got = r.FormValue(m.csrfField)
+	}
+	if got == "" {
+		return false
+	}
+
+	return hmac.Equal([]byte(got), []byte(expected))
+}
@@ -0,0 +1,89 @@
+package dbsession_test
+
+import (
+	"encoding/hex"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Morditux/dbsession"
+	"github.com/Morditux/dbsession/testutil"
+)
+
+func TestSession_CSRFToken(t *testing.T) {
+	mgr := dbsession.NewManager(dbsession.Config{Store: testutil.NewFakeStore()})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	token := s.CSRFToken()
+	if _, err := hex.DecodeString(token); err != nil || len(token) != 32 {
+		t.Fatalf("expected a 32-char hex token, got %q", token)
+	}
+	if again := s.CSRFToken(); again != token {
+		t.Errorf("expected the token to be stable, got %q then %q", token, again)
+	}
+
+	// The token must survive ID regeneration.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := mgr.Regenerate(w, r, s); err != nil {
+		t.Fatalf("failed to regenerate: %v", err)
+	}
+	if after := s.CSRFToken(); after != token {
+		t.Errorf("expected token to survive Regenerate, got %q", after)
+	}
+}
+
+func TestManager_ValidateCSRF(t *testing.T) {
+	mgr := dbsession.NewManager(dbsession.Config{Store: testutil.NewFakeStore()})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("X-CSRF-Token", "anything")
+	if mgr.ValidateCSRF(r, s) {
+		t.Error("expected validation to fail before a token was generated")
+	}
+
+	token := s.CSRFToken()
+
+	t.Run("Header", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-CSRF-Token", token)
+		if !mgr.ValidateCSRF(r, s) {
+			t.Error("expected valid header token to be accepted")
+		}
+	})
+
+	t.Run("Form", func(t *testing.T) {
+		form := url.Values{"csrf_token": {token}}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if !mgr.ValidateCSRF(r, s) {
+			t.Error("expected valid form token to be accepted")
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-CSRF-Token", strings.Repeat("0", len(token)))
+		if mgr.ValidateCSRF(r, s) {
+			t.Error("expected wrong token to be rejected")
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/", nil)
+		if mgr.ValidateCSRF(r, s) {
+			t.Error("expected missing token to be rejected")
+		}
+	})
+}
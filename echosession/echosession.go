@@ -0,0 +1,62 @@
+// Package echosession provides echo middleware loading a dbsession session
+// for each request and saving it after the handler if it was modified.
+//
+// It is a separate module, so that only the applications using it depend on
+// echo.
+package echosession
+
+import (
+	"github.com/Morditux/dbsession"
+	"github.com/labstack/echo/v4"
+)
+
+// contextKey is the echo context key the session is stored under.
+const contextKey = "github.com/Morditux/dbsession/echosession"
+
+// Middleware returns middleware that loads the request's session with
+// mgr.Get and makes it available to handlers through Default. If the
+// session is Modified, it is saved before the response headers are
+// written, so that its cookie can still be set, or after the handler if it
+// wrote nothing. A failure to save is returned unless the handler failed
+// itself.
+//
+// Only changes made with Session.Set, Delete or Clear mark the session
+// modified; call mgr.Save directly after changing Values by hand.
+func Middleware(mgr *dbsession.Manager) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			s, err := mgr.Get(c.Request())
+			if err != nil {
+				return err
+			}
+			c.Set(contextKey, s)
+
+			var saved bool
+			var saveErr error
+			save := func() {
+				if saved {
+					return
+				}
+				saved = true
+				if s.Modified() {
+					saveErr = mgr.Save(c.Response().Writer, c.Request(), s)
+				}
+			}
+			c.Response().Before(save)
+
+			err = next(c)
+			save()
+			if err != nil {
+				return err
+			}
+			return saveErr
+		}
+	}
+}
+
+// Default returns the session loaded by Middleware, or nil if the
+// middleware is not installed.
+func Default(c echo.Context) *dbsession.Session {
+	session, _ := c.Get(contextKey).(*dbsession.Session)
+	return session
+}
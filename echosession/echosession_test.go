@@ -0,0 +1,76 @@
+package echosession
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Morditux/dbsession"
+	"github.com/labstack/echo/v4"
+)
+
+func newTestServer(t *testing.T) *echo.Echo {
+	t.Helper()
+	store, err := dbsession.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := dbsession.NewManager(dbsession.Config{Store: store})
+	t.Cleanup(func() { mgr.Close() })
+
+	e := echo.New()
+	e.Use(Middleware(mgr))
+	e.POST("/login", func(c echo.Context) error {
+		Default(c).Set("user", "alice")
+		return c.String(http.StatusOK, "ok")
+	})
+	e.POST("/logout", func(c echo.Context) error {
+		Default(c).Delete("user")
+		return nil
+	})
+	e.GET("/me", func(c echo.Context) error {
+		user, _ := Default(c).Get("user")
+		return c.JSON(http.StatusOK, map[string]any{"user": user})
+	})
+	return e
+}
+
+func TestMiddleware(t *testing.T) {
+	e := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest("POST", "/login", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected the modified session to be saved, got cookies %v", cookies)
+	}
+
+	r := httptest.NewRequest("GET", "/me", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if body := strings.TrimSpace(w.Body.String()); body != `{"user":"alice"}` {
+		t.Errorf("expected the session to be loaded, got %s", body)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("expected an unmodified session not to be saved")
+	}
+
+	// A handler writing nothing still gets its session saved.
+	r = httptest.NewRequest("POST", "/logout", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if len(w.Result().Cookies()) != 1 {
+		t.Error("expected the session to be saved without a response")
+	}
+}
+
+func TestDefault_WithoutMiddleware(t *testing.T) {
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder())
+	if Default(c) != nil {
+		t.Error("expected nil without the middleware")
+	}
+}
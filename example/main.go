@@ -56,6 +56,11 @@ func main() {
 			return
 		}
 
+		// The cookie is set by Save, so write the body afterwards.
+		if session.IsNew() {
+			fmt.Fprint(w, "Welcome, first-time visitor!")
+			return
+		}
 		fmt.Fprintf(w, "Hello! You have visited this page %d times.", count)
 	})
 
@@ -0,0 +1,87 @@
+// Package ginsession provides gin middleware loading a dbsession session
+// for each request and saving it after the handler if it was modified.
+//
+// It is a separate module, so that only the applications using it depend on
+// gin.
+package ginsession
+
+import (
+	"net/http"
+
+	"github.com/Morditux/dbsession"
+	"github.com/gin-gonic/gin"
+)
+
+// contextKey is the gin context key the session is stored under.
+const contextKey = "github.com/Morditux/dbsession/ginsession"
+
+// Sessions returns middleware that loads the request's session with
+// mgr.Get and makes it available to handlers through Default. If the
+// session is Modified, it is saved before the response headers are
+// written, so that its cookie can still be set, or after the handler if it
+// wrote nothing. Failures to load abort the request with a 500; failures
+// to save are added to the context with c.Error.
+//
+// Only changes made with Session.Set, Delete or Clear mark the session
+// modified; call mgr.Save directly after changing Values by hand.
+func Sessions(mgr *dbsession.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s, err := mgr.Get(c.Request)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Set(contextKey, s)
+
+		w := &saveWriter{ResponseWriter: c.Writer}
+		w.save = func() {
+			if w.saved {
+				return
+			}
+			w.saved = true
+			if s.Modified() {
+				if err := mgr.Save(w.ResponseWriter, c.Request, s); err != nil {
+					c.Error(err)
+				}
+			}
+		}
+		c.Writer = w
+		c.Next()
+		w.save()
+	}
+}
+
+// Default returns the session loaded by Sessions, or nil if the middleware
+// is not installed.
+func Default(c *gin.Context) *dbsession.Session {
+	s, _ := c.Get(contextKey)
+	session, _ := s.(*dbsession.Session)
+	return session
+}
+
+// saveWriter saves the session before the response headers are written.
+type saveWriter struct {
+	gin.ResponseWriter
+	save  func()
+	saved bool
+}
+
+func (w *saveWriter) WriteHeaderNow() {
+	w.save()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *saveWriter) Write(b []byte) (int, error) {
+	w.save()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *saveWriter) WriteString(s string) (int, error) {
+	w.save()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *saveWriter) Flush() {
+	w.save()
+	w.ResponseWriter.Flush()
+}
@@ -0,0 +1,75 @@
+package ginsession
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Morditux/dbsession"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store, err := dbsession.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := dbsession.NewManager(dbsession.Config{Store: store})
+	t.Cleanup(func() { mgr.Close() })
+
+	router := gin.New()
+	router.Use(Sessions(mgr))
+	router.POST("/login", func(c *gin.Context) {
+		Default(c).Set("user", "alice")
+		c.String(http.StatusOK, "ok")
+	})
+	router.POST("/logout", func(c *gin.Context) {
+		Default(c).Delete("user")
+		c.Status(http.StatusNoContent)
+	})
+	router.GET("/me", func(c *gin.Context) {
+		user, _ := Default(c).Get("user")
+		c.JSON(http.StatusOK, gin.H{"user": user})
+	})
+	return router
+}
+
+func TestSessions(t *testing.T) {
+	router := newTestRouter(t)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/login", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected the modified session to be saved, got cookies %v", cookies)
+	}
+
+	r := httptest.NewRequest("GET", "/me", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if body := w.Body.String(); body != `{"user":"alice"}` {
+		t.Errorf("expected the session to be loaded, got %s", body)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("expected an unmodified session not to be saved")
+	}
+
+	// A handler writing no body still gets its session saved.
+	r = httptest.NewRequest("POST", "/logout", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if len(w.Result().Cookies()) != 1 {
+		t.Error("expected the session to be saved without a body")
+	}
+}
+
+func TestDefault_WithoutMiddleware(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if Default(c) != nil {
+		t.Error("expected nil without the middleware")
+	}
+}
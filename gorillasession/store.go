@@ -0,0 +1,145 @@
+// Package gorillasession adapts a dbsession.Manager to the gorilla/sessions
+// Store interface, so that handlers written against gorilla/sessions can
+// switch their backend to dbsession without changes.
+//
+// It is a separate module, so that only the applications using it depend on
+// gorilla/sessions.
+package gorillasession
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Morditux/dbsession"
+	"github.com/gorilla/sessions"
+)
+
+// Store implements sessions.Store on top of a dbsession.Manager. The gorilla
+// session name is the cookie name, and the cookie carries the Manager's
+// client token: the dbsession session ID, or a sealed token if the Manager
+// has a TokenKey. The gorilla session's ID is always the session ID.
+//
+// Values are stored by dbsession, so their keys must be strings and their
+// types registered with gob like for any dbsession session. The server-side
+// expiry follows the Manager's TTL; Options.MaxAge only sets the cookie's,
+// except that a negative MaxAge deletes the session on Save, as with
+// gorilla's own stores.
+type Store struct {
+	// Options are the default cookie options of new sessions.
+	Options *sessions.Options
+	mgr     *dbsession.Manager
+}
+
+// NewStore returns a Store backed by mgr. Its default Options match the
+// Manager's defaults: path "/", a 24 hour MaxAge, HttpOnly and SameSite=Lax.
+func NewStore(mgr *dbsession.Manager) *Store {
+	return &Store{
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   86400,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+		mgr: mgr,
+	}
+}
+
+// Get returns the named session for the request, loading it once per
+// request through the gorilla registry.
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session whose token is in the named cookie, or a new
+// session if there is none. Like gorilla's own stores, it returns a new
+// session along with the error if loading fails.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	stored, err := s.mgr.LoadClientToken(r.Context(), cookie.Value)
+	if isGone(err) {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+
+	session.ID = stored.ID
+	for k, v := range stored.Values {
+		session.Values[k] = v
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save persists the session and sets its cookie, or deletes the session and
+// its cookie if Options.MaxAge is negative.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	ctx := r.Context()
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.mgr.DeleteSession(ctx, session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	values := make(map[string]any, len(session.Values))
+	for k, v := range session.Values {
+		key, ok := k.(string)
+		if !ok {
+			return fmt.Errorf("gorillasession: session key %v is a %T, only string keys are supported", k, k)
+		}
+		values[key] = v
+	}
+
+	stored, err := s.stored(r, session.ID)
+	if err != nil {
+		return err
+	}
+	stored.Clear()
+	stored.Values = values
+	token, err := s.mgr.ClientToken(stored)
+	if err != nil {
+		return err
+	}
+	if err := s.mgr.PersistSession(ctx, stored); err != nil {
+		return err
+	}
+
+	session.ID = stored.ID
+	http.SetCookie(w, sessions.NewCookie(session.Name(), token, session.Options))
+	return nil
+}
+
+// stored returns the dbsession session with the given ID, or a new one if
+// the ID is empty or the session no longer exists. It is reloaded rather
+// than kept from New, so that its metadata (creation time, owner, version)
+// is current.
+func (s *Store) stored(r *http.Request, id string) (*dbsession.Session, error) {
+	if id != "" {
+		stored, err := s.mgr.LoadSession(r.Context(), id)
+		if err == nil {
+			return stored, nil
+		}
+		if !isGone(err) {
+			return nil, err
+		}
+	}
+	return s.mgr.New()
+}
+
+// isGone reports whether err means the session does not exist anymore.
+func isGone(err error) bool {
+	return errors.Is(err, dbsession.ErrSessionNotFound) || errors.Is(err, dbsession.ErrSessionExpired)
+}
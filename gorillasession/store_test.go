@@ -0,0 +1,154 @@
+package gorillasession
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Morditux/dbsession"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	backend, err := dbsession.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := dbsession.NewManager(dbsession.Config{Store: backend})
+	t.Cleanup(func() { mgr.Close() })
+	return NewStore(mgr)
+}
+
+// roundTrip saves the request's session after fn ran on it and returns the
+// cookie set by Save.
+func roundTrip(t *testing.T, store *Store, cookie *http.Cookie, fn func(values map[any]any)) *http.Cookie {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/", nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	session, err := store.Get(r, "app")
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	fn(session.Values)
+	if err := session.Save(r, w); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "app" {
+		t.Fatalf("expected one app cookie, got %v", cookies)
+	}
+	return cookies[0]
+}
+
+func TestStore_RoundTrip(t *testing.T) {
+	store := newTestStore(t)
+	cookie := roundTrip(t, store, nil, func(values map[any]any) {
+		values["user"] = "alice"
+	})
+	if cookie.MaxAge != 86400 || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("expected the default options on the cookie, got %+v", cookie)
+	}
+
+	var user any
+	roundTrip(t, store, cookie, func(values map[any]any) {
+		user = values["user"]
+	})
+	if user != "alice" {
+		t.Errorf("expected alice, got %v", user)
+	}
+}
+
+func TestStore_Flashes(t *testing.T) {
+	store := newTestStore(t)
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	session, _ := store.Get(r, "app")
+	session.AddFlash("saved")
+	if err := session.Save(r, w); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	session, _ = store.Get(r, "app")
+	if flashes := session.Flashes(); len(flashes) != 1 || flashes[0] != "saved" {
+		t.Errorf("expected the flash message, got %v", flashes)
+	}
+}
+
+func TestStore_NegativeMaxAgeDeletes(t *testing.T) {
+	store := newTestStore(t)
+	cookie := roundTrip(t, store, nil, func(values map[any]any) {
+		values["user"] = "alice"
+	})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	session, _ := store.Get(r, "app")
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		t.Fatalf("failed to delete session: %v", err)
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Errorf("expected the cookie to be cleared, got %v", c)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	if session, _ := store.Get(r, "app"); !session.IsNew {
+		t.Error("expected the deleted session not to load")
+	}
+}
+
+func TestStore_NonStringKey(t *testing.T) {
+	store := newTestStore(t)
+	r := httptest.NewRequest("GET", "/", nil)
+	session, _ := store.Get(r, "app")
+	session.Values[42] = "answer"
+	if err := session.Save(r, httptest.NewRecorder()); err == nil {
+		t.Error("expected an error for a non-string key")
+	}
+}
+
+func TestStore_TokenKey(t *testing.T) {
+	backend, err := dbsession.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := dbsession.NewManager(dbsession.Config{Store: backend, TokenKey: make([]byte, 32)})
+	t.Cleanup(func() { mgr.Close() })
+	store := NewStore(mgr)
+
+	cookie := roundTrip(t, store, nil, func(values map[any]any) {
+		values["user"] = "alice"
+	})
+	var user any
+	roundTrip(t, store, cookie, func(values map[any]any) {
+		user = values["user"]
+	})
+	if user != "alice" {
+		t.Fatalf("expected alice, got %v", user)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	session, err := store.New(r, "app")
+	if err != nil || session.IsNew {
+		t.Fatalf("expected the stored session, got %v", err)
+	}
+	id := session.ID
+	if cookie.Value == id {
+		t.Error("expected the cookie to carry a sealed token, not the session ID")
+	}
+
+	// The raw session ID is not accepted in place of the token.
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "app", Value: id})
+	if session, err := store.New(r, "app"); err != nil || !session.IsNew {
+		t.Errorf("expected a raw session ID to be rejected, got %v, new %v", err, session.IsNew)
+	}
+}
@@ -0,0 +1,92 @@
+package dbsession
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// IDEncoding selects how the Manager encodes the 128 random bits of a
+// session ID.
+type IDEncoding int
+
+const (
+	// IDEncodingHex encodes IDs as 32 lowercase hex characters.
+	IDEncodingHex IDEncoding = iota
+	// IDEncodingBase62 encodes IDs as 22 characters from [0-9A-Za-z], which
+	// are URL and cookie safe without escaping.
+	IDEncodingBase62
+)
+
+const (
+	hexIDLen    = 32
+	base62IDLen = 22 // 62^22 > 2^128
+)
+
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// validBase62Chars is a lookup table for base62Alphabet.
+var validBase62Chars = [256]bool{}
+
+func init() {
+	for i := 0; i < len(base62Alphabet); i++ {
+		validBase62Chars[base62Alphabet[i]] = true
+	}
+}
+
+func (e IDEncoding) valid() error {
+	switch e {
+	case IDEncodingHex, IDEncodingBase62:
+		return nil
+	}
+	return fmt.Errorf("dbsession: unknown IDEncoding %d", e)
+}
+
+// IDGenerator generates session IDs in a custom format, set with
+// Config.IDGenerator. IDs must be unguessable: they are the only credential
+// a session cookie carries.
+type IDGenerator interface {
+	// NewID returns a new, unique session ID.
+	NewID() (string, error)
+	// Valid reports whether id has the generator's format. It is checked
+	// before any store lookup, so it should be cheap.
+	Valid(id string) bool
+}
+
+// newID generates a session ID with the Manager's generator or encoding.
+func (m *Manager) newID() (string, error) {
+	if m.idGenerator != nil {
+		return m.idGenerator.NewID()
+	}
+	if m.idEncoding == IDEncodingBase62 {
+		return generateBase62ID()
+	}
+	return generateID()
+}
+
+// validID reports whether id is in the format of the Manager's generator or
+// of a built-in IDEncoding, so that existing sessions stay valid when the
+// format changes.
+func (m *Manager) validID(id string) bool {
+	return isValidID(id) || (m.idGenerator != nil && m.idGenerator.Valid(id))
+}
+
+// generateBase62ID is like generateID but encodes the ID in base62.
+func generateBase62ID() (string, error) {
+	rng, err := getRNG()
+	if err != nil {
+		return "", err
+	}
+	hi, lo := rng.Uint64(), rng.Uint64()
+	rngPool.Put(rng)
+
+	// Long division of the 128-bit value hi:lo by 62, filling the ID from
+	// its least significant digit. IDs are zero-padded to a fixed length.
+	var b [base62IDLen]byte
+	for i := len(b) - 1; i >= 0; i-- {
+		var r uint64
+		hi, r = bits.Div64(0, hi, 62)
+		lo, r = bits.Div64(r, lo, 62)
+		b[i] = base62Alphabet[r]
+	}
+	return string(b[:]), nil
+}
@@ -0,0 +1,135 @@
+package dbsession
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGenerateBase62ID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id, err := generateBase62ID()
+		if err != nil {
+			t.Fatalf("failed to generate id: %v", err)
+		}
+		if len(id) != 22 || !isValidID(id) {
+			t.Fatalf("invalid base62 id %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate id %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestIsValidID_Formats(t *testing.T) {
+	cases := map[string]bool{
+		"0123456789abcdef0123456789abcdef": true,
+		"0123456789ABCDEF0123456789abcdef": false, // hex is lowercase
+		"0123456789ABCDEFabcdef":           true,
+		"0123456789ABCDEFabcde-":           false,
+		"0123456789ABCDEFabcdef0":          false,
+		"":                                 false,
+	}
+	for id, want := range cases {
+		if got := isValidID(id); got != want {
+			t.Errorf("isValidID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestManager_IDEncodingBase62(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store, IDEncoding: IDEncodingBase62})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s, err := mgr.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if len(s.ID) != 22 {
+		t.Errorf("expected a 22-character id, got %q", s.ID)
+	}
+
+	// Sessions created before the switch keep working.
+	hexID, err := generateID()
+	if err != nil {
+		t.Fatalf("failed to generate id: %v", err)
+	}
+	old := &Session{ID: hexID, Values: map[string]any{"user": "alice"}}
+	if err := mgr.PersistSession(ctx, old); err != nil {
+		t.Fatalf("failed to save hex session: %v", err)
+	}
+	if loaded, err := mgr.LoadSession(ctx, hexID); err != nil || loaded == nil {
+		t.Errorf("expected hex session to load, got %v, %v", loaded, err)
+	}
+}
+
+func TestConfig_InvalidIDEncoding(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	if _, err := NewManagerE(Config{Store: store, IDEncoding: IDEncoding(7)}); err == nil {
+		t.Error("expected an error for an unknown IDEncoding")
+	}
+}
+
+func TestULIDGenerator(t *testing.T) {
+	var gen ULIDGenerator
+	first, err := gen.NewID()
+	if err != nil {
+		t.Fatalf("failed to generate id: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	second, _ := gen.NewID()
+
+	for _, id := range []string{first, second} {
+		if len(id) != 26 || !gen.Valid(id) {
+			t.Errorf("invalid ULID %q", id)
+		}
+	}
+	if first >= second {
+		t.Errorf("expected ULIDs to sort by time, got %q then %q", first, second)
+	}
+	for _, id := range []string{"", "01ARZ3NDEKTSV4RRFFQ69G5FA", "81ARZ3NDEKTSV4RRFFQ69G5FAV", "01ARZ3NDEKTSV4RRFFQ69G5FAU"} {
+		if gen.Valid(id) {
+			t.Errorf("expected %q to be invalid", id)
+		}
+	}
+}
+
+func TestManager_IDGenerator(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store, IDGenerator: ULIDGenerator{}})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s, err := mgr.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if !(ULIDGenerator{}).Valid(s.ID) {
+		t.Errorf("expected a ULID, got %q", s.ID)
+	}
+	if _, err := mgr.LoadSession(ctx, s.ID); err != nil {
+		t.Errorf("failed to load session: %v", err)
+	}
+
+	hexID, _ := generateID()
+	if err := mgr.PersistSession(ctx, &Session{ID: hexID, Values: map[string]any{}}); err != nil {
+		t.Errorf("expected hex IDs to remain valid, got %v", err)
+	}
+	if err := mgr.PersistSession(ctx, &Session{ID: "not-an-id", Values: map[string]any{}}); err != ErrInvalidSessionID {
+		t.Errorf("expected ErrInvalidSessionID, got %v", err)
+	}
+}
@@ -22,3 +22,12 @@ func BenchmarkGenerateID(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkGenerateBase62ID(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_, err := generateBase62ID()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
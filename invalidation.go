@@ -0,0 +1,123 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// invalidationPingInterval is how long the listener waits for a
+// notification before pinging its connection to detect that it dropped.
+const invalidationPingInterval = 90 * time.Second
+
+// InvalidationConfig holds configuration for an InvalidationListener.
+type InvalidationConfig struct {
+	// DSN is the PostgreSQL connection string. The listener holds a
+	// dedicated connection, outside the store's pool.
+	DSN string
+	// Channel is the channel to listen on, the PostgreSQLConfig.NotifyChannel
+	// of the publishing stores.
+	Channel string
+	// Evict is called with each invalidated session ID, e.g. to evict it
+	// from a TieredStore's L1 with TieredStore.Evict.
+	Evict func(id string)
+	// OnReset, if set, is called when the connection was re-established
+	// after dropping. Notifications sent in between are lost, so a local
+	// cache should be flushed or will serve deleted sessions until they
+	// expire from it.
+	OnReset func()
+	// MinReconnectInterval and MaxReconnectInterval bound the delay between
+	// reconnection attempts, which doubles after each failure. Default to 1
+	// second and 1 minute.
+	MinReconnectInterval time.Duration
+	MaxReconnectInterval time.Duration
+}
+
+// InvalidationListener evicts sessions deleted on other nodes from a local
+// cache, using PostgreSQL LISTEN/NOTIFY. It reconnects on its own when its
+// connection drops. Create it with ListenInvalidations and stop it with
+// Close.
+type InvalidationListener struct {
+	listener  *pq.Listener
+	cfg       InvalidationConfig
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// ListenInvalidations starts listening for invalidated session IDs on
+// cfg.Channel. It returns once the server acknowledged the LISTEN; while the
+// server is unreachable it keeps retrying until ctx is done.
+func ListenInvalidations(ctx context.Context, cfg InvalidationConfig) (*InvalidationListener, error) {
+	if err := validateIdentifier("notify channel", cfg.Channel); err != nil {
+		return nil, err
+	}
+	if cfg.Evict == nil {
+		return nil, errors.New("dbsession: InvalidationConfig.Evict is required")
+	}
+	if cfg.MinReconnectInterval <= 0 {
+		cfg.MinReconnectInterval = time.Second
+	}
+	if cfg.MaxReconnectInterval <= 0 {
+		cfg.MaxReconnectInterval = time.Minute
+	}
+
+	l := &InvalidationListener{
+		listener: pq.NewListener(cfg.DSN, cfg.MinReconnectInterval, cfg.MaxReconnectInterval, nil),
+		cfg:      cfg,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	listened := make(chan error, 1)
+	go func() { listened <- l.listener.Listen(cfg.Channel) }()
+	select {
+	case err := <-listened:
+		if err != nil {
+			l.listener.Close()
+			return nil, fmt.Errorf("failed to listen on %s: %w", cfg.Channel, err)
+		}
+	case <-ctx.Done():
+		l.listener.Close() // Unblocks Listen
+		return nil, fmt.Errorf("failed to listen on %s: %w", cfg.Channel, ctx.Err())
+	}
+	go l.run()
+	return l, nil
+}
+
+func (l *InvalidationListener) run() {
+	defer close(l.done)
+	for {
+		select {
+		case n := <-l.listener.Notify:
+			if n == nil {
+				// pq sends nil after reconnecting.
+				if l.cfg.OnReset != nil {
+					l.cfg.OnReset()
+				}
+				continue
+			}
+			l.cfg.Evict(n.Extra)
+		case <-time.After(invalidationPingInterval):
+			// A failed ping makes pq reconnect.
+			go l.listener.Ping()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Close stops the listener and closes its connection. It is safe to call
+// more than once.
+func (l *InvalidationListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.stop)
+		<-l.done
+		l.closeErr = l.listener.Close()
+	})
+	return l.closeErr
+}
@@ -0,0 +1,89 @@
+package dbsession
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestListenInvalidations_InvalidConfig(t *testing.T) {
+	evict := func(string) {}
+	for _, cfg := range []InvalidationConfig{
+		{DSN: getTestPostgreSQLDSN(), Channel: "bad channel;", Evict: evict},
+		{DSN: getTestPostgreSQLDSN(), Channel: "", Evict: evict},
+		{DSN: getTestPostgreSQLDSN(), Channel: "sessions"},
+	} {
+		if l, err := ListenInvalidations(context.Background(), cfg); err == nil {
+			l.Close()
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestListenInvalidations_Unreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := ListenInvalidations(ctx, InvalidationConfig{
+		DSN:     "postgres://postgres@127.0.0.1:1/none?sslmode=disable", // Nothing listens on port 1
+		Channel: "sessions",
+		Evict:   func(string) {},
+	})
+	if err == nil {
+		t.Error("expected an error when the server is unreachable")
+	}
+}
+
+func TestTieredStore_Evict(t *testing.T) {
+	l1, l2 := newMapStore(), newMapStore()
+	store := NewTieredStore(l1, l2)
+
+	ctx := context.Background()
+	s := &Session{ID: "evicted", Values: map[string]any{}, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if err := store.Evict(ctx, s.ID); err != nil {
+		t.Fatalf("failed to evict: %v", err)
+	}
+	if got, _ := l1.Get(ctx, s.ID); got != nil {
+		t.Error("expected the session to be evicted from L1")
+	}
+	if got, _ := l2.Get(ctx, s.ID); got == nil {
+		t.Error("expected the session to remain in L2")
+	}
+}
+
+func TestPostgreSQLStore_NotifyOnDelete(t *testing.T) {
+	dsn := getTestPostgreSQLDSN()
+	const channel = "dbsession_test_invalidate"
+	store, err := NewPostgreSQLStoreWithConfig(PostgreSQLConfig{DSN: dsn, NotifyChannel: channel})
+	if err != nil {
+		t.Skipf("Skipping PostgreSQL test: %v (is PostgreSQL running?)", err)
+	}
+	defer store.Close()
+
+	evicted := make(chan string, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l, err := ListenInvalidations(ctx, InvalidationConfig{
+		DSN:     dsn,
+		Channel: channel,
+		Evict:   func(id string) { evicted <- id },
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	if err := store.Delete(ctx, "notified-session"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	select {
+	case id := <-evicted:
+		if id != "notified-session" {
+			t.Errorf("expected notified-session, got %q", id)
+		}
+	case <-ctx.Done():
+		t.Fatal("expected a notification for the deleted session")
+	}
+}
@@ -0,0 +1,31 @@
+package dbsession
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// expiryLeeway is embedded by the stores implementing ExpiryLeewaySetter.
+// It is atomic, as the Manager may set it while the store is in use by
+// another Manager.
+type expiryLeeway struct {
+	leeway atomic.Int64
+}
+
+// SetExpiryLeeway implements ExpiryLeewaySetter.
+func (l *expiryLeeway) SetExpiryLeeway(d time.Duration) {
+	l.leeway.Store(int64(d))
+}
+
+// expiryCutoff returns the time before which a session expiring counts as
+// expired at now.
+func (l *expiryLeeway) expiryCutoff(now time.Time) time.Time {
+	return now.Add(-time.Duration(l.leeway.Load()))
+}
+
+// setExpiryLeeway passes d to store if it implements ExpiryLeewaySetter.
+func setExpiryLeeway(store Store, d time.Duration) {
+	if setter, ok := store.(ExpiryLeewaySetter); ok {
+		setter.SetExpiryLeeway(d)
+	}
+}
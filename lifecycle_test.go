@@ -0,0 +1,360 @@
+package dbsession_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Morditux/dbsession"
+	"github.com/Morditux/dbsession/testutil"
+)
+
+func TestManager_LifecycleCallbacks(t *testing.T) {
+	var created []string
+	var destroyed []string
+	var regenerated [][2]string
+
+	var mgr *dbsession.Manager
+	mgr = dbsession.NewManager(dbsession.Config{
+		Store: testutil.NewFakeStore(),
+		OnCreate: func(s *dbsession.Session) {
+			// Callbacks must be able to use Session methods without deadlocking.
+			s.Set("created", true)
+			created = append(created, s.ID)
+		},
+		OnDestroy: func(id string) {
+			destroyed = append(destroyed, id)
+		},
+		OnRegenerate: func(oldID, newID string) {
+			regenerated = append(regenerated, [2]string{oldID, newID})
+		},
+	})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if len(created) != 1 || created[0] != s.ID {
+		t.Fatalf("expected OnCreate for %s, got %v", s.ID, created)
+	}
+	if v, _ := s.Get("created"); v != true {
+		t.Error("expected OnCreate to be able to modify the session")
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	oldID := s.ID
+	if err := mgr.Regenerate(w, r, s); err != nil {
+		t.Fatalf("failed to regenerate: %v", err)
+	}
+	if len(regenerated) != 1 || regenerated[0] != [2]string{oldID, s.ID} {
+		t.Errorf("expected OnRegenerate(%s, %s), got %v", oldID, s.ID, regenerated)
+	}
+
+	id := s.ID
+	if err := mgr.Destroy(w, r, s); err != nil {
+		t.Fatalf("failed to destroy: %v", err)
+	}
+	if len(destroyed) != 1 || destroyed[0] != id {
+		t.Errorf("expected OnDestroy(%s), got %v", id, destroyed)
+	}
+}
+
+func TestManager_LifecycleCallbacksSkippedOnStoreFailure(t *testing.T) {
+	called := false
+	mgr := dbsession.NewManager(dbsession.Config{
+		Store:        failingStore(testutil.MethodDelete),
+		OnDestroy:    func(string) { called = true },
+		OnRegenerate: func(string, string) { called = true },
+	})
+	defer mgr.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if err := mgr.Regenerate(w, r, s); err == nil {
+		t.Fatal("expected Regenerate to fail")
+	}
+	s, err = mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if err := mgr.Destroy(w, r, s); err == nil {
+		t.Fatal("expected Destroy to fail")
+	}
+	if called {
+		t.Error("callbacks must not fire when the store operation fails")
+	}
+}
+
+// failingStore returns a FakeStore whose method always fails.
+func failingStore(method string) *testutil.FakeStore {
+	store := testutil.NewFakeStore()
+	store.SetError(method, errors.New(method+" failed"))
+	return store
+}
+
+func TestManager_RegenerateCount(t *testing.T) {
+	store, err := dbsession.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := dbsession.NewManager(dbsession.Config{Store: store})
+	defer mgr.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := mgr.Regenerate(w, r, s); err != nil {
+			t.Fatalf("failed to regenerate: %v", err)
+		}
+	}
+	if s.RegenerateCount != 2 {
+		t.Errorf("expected RegenerateCount 2, got %d", s.RegenerateCount)
+	}
+
+	stored, err := store.Get(context.Background(), s.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("failed to load regenerated session: %v, %v", stored, err)
+	}
+	if stored.RegenerateCount != 2 {
+		t.Errorf("expected stored RegenerateCount 2, got %d", stored.RegenerateCount)
+	}
+
+	failing := dbsession.NewManager(dbsession.Config{Store: failingStore(testutil.MethodSave)})
+	defer failing.Close()
+	if err := failing.Regenerate(w, r, s); err == nil {
+		t.Fatal("expected Regenerate to fail")
+	}
+	if s.RegenerateCount != 2 {
+		t.Errorf("expected RegenerateCount restored after failure, got %d", s.RegenerateCount)
+	}
+}
+
+func TestManager_DestroyIdempotent(t *testing.T) {
+	store, err := dbsession.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	destroyed := 0
+	mgr := dbsession.NewManager(dbsession.Config{Store: store, OnDestroy: func(string) { destroyed++ }})
+	defer mgr.Close()
+
+	r := httptest.NewRequest("POST", "/logout", nil)
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.Set("user", "alice")
+	if err := mgr.Save(httptest.NewRecorder(), r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		if err := mgr.Destroy(w, r, s); err != nil {
+			t.Fatalf("failed to destroy: %v", err)
+		}
+		if cookies := w.Result().Cookies(); len(cookies) == 0 || cookies[0].MaxAge != -1 {
+			t.Errorf("expected the cookie to be cleared, got %v", cookies)
+		}
+	}
+	if destroyed != 1 {
+		t.Errorf("expected OnDestroy to fire once, got %d", destroyed)
+	}
+
+	// A stray save must not resurrect the session.
+	s.Set("user", "mallory")
+	if err := mgr.Save(httptest.NewRecorder(), r, s); !errors.Is(err, dbsession.ErrSessionDestroyed) {
+		t.Errorf("expected ErrSessionDestroyed from Save, got %v", err)
+	}
+	if err := mgr.Regenerate(httptest.NewRecorder(), r, s); !errors.Is(err, dbsession.ErrSessionDestroyed) {
+		t.Errorf("expected ErrSessionDestroyed from Regenerate, got %v", err)
+	}
+	if got, _ := store.Get(context.Background(), s.ID); got != nil {
+		t.Errorf("expected the session to stay deleted, got %v", got.Values)
+	}
+}
+
+func TestManager_LoginLogout(t *testing.T) {
+	store, err := dbsession.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := dbsession.NewManager(dbsession.Config{Store: store})
+	defer mgr.Close()
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.Set("cart", "book")
+	if err := mgr.Save(httptest.NewRecorder(), r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	anonymousID := s.ID
+
+	w := httptest.NewRecorder()
+	err = mgr.Login(w, r, s, func(s *dbsession.Session) {
+		s.OwnerID = "alice"
+		s.Set("user", "alice")
+	})
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	if s.ID == anonymousID {
+		t.Error("expected Login to regenerate the session ID")
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Value != s.ID {
+		t.Errorf("expected a cookie for the new ID, got %v", cookies)
+	}
+	ctx := context.Background()
+	if _, err := mgr.LoadSession(ctx, anonymousID); !errors.Is(err, dbsession.ErrSessionNotFound) {
+		t.Errorf("expected the anonymous session to be gone, got %v", err)
+	}
+	loaded, err := mgr.LoadSession(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if v, _ := loaded.Get("user"); v != "alice" || loaded.OwnerID != "alice" {
+		t.Errorf("expected the login to be saved, got %v, owner %q", v, loaded.OwnerID)
+	}
+	if v, _ := loaded.Get("cart"); v != "book" {
+		t.Errorf("expected existing values to carry over, got %v", v)
+	}
+
+	if err := mgr.Logout(httptest.NewRecorder(), r, s); err != nil {
+		t.Fatalf("failed to log out: %v", err)
+	}
+	if _, err := mgr.LoadSession(ctx, s.ID); !errors.Is(err, dbsession.ErrSessionNotFound) {
+		t.Errorf("expected Logout to delete the session, got %v", err)
+	}
+}
+
+func TestManager_LoginRollsBackOnFailure(t *testing.T) {
+	mgr := dbsession.NewManager(dbsession.Config{Store: failingStore(testutil.MethodSave)})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	id := s.ID
+	err = mgr.Login(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil), s, func(s *dbsession.Session) {
+		s.OwnerID = "alice"
+		s.Set("user", "alice")
+	})
+	if err == nil {
+		t.Fatal("expected Login to fail")
+	}
+	if _, ok := s.Get("user"); ok || s.OwnerID != "" || s.ID != id {
+		t.Errorf("expected the login to be rolled back, got %v, owner %q, ID changed %v", s.Values, s.OwnerID, s.ID != id)
+	}
+}
+
+func TestManager_RegenerateIDRestoresOnFailure(t *testing.T) {
+	mgr := dbsession.NewManager(dbsession.Config{Store: failingStore(testutil.MethodSave)})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	id := s.ID
+	if _, err := mgr.RegenerateID(context.Background(), s); err == nil {
+		t.Fatal("expected RegenerateID to fail")
+	}
+	if s.ID != id || s.RegenerateCount != 0 {
+		t.Errorf("expected the session to keep ID %s, got %s (count %d)", id, s.ID, s.RegenerateCount)
+	}
+
+	// Fail closed: the new session is not left behind when the old one
+	// cannot be deleted.
+	failDelete := dbsession.NewManager(dbsession.Config{Store: failingStore(testutil.MethodDelete)})
+	defer failDelete.Close()
+	s, err = failDelete.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if _, err := failDelete.RegenerateID(context.Background(), s); err == nil {
+		t.Error("expected RegenerateID to fail when the old session cannot be deleted")
+	}
+}
+
+func TestRegenerate_FailSecure(t *testing.T) {
+	mgr := dbsession.NewManager(dbsession.Config{Store: failingStore(testutil.MethodDelete)})
+	defer mgr.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.ID = "old-id"
+
+	// Regenerate should fail if Delete fails
+	err = mgr.Regenerate(w, r, s)
+	if err == nil {
+		t.Error("Expected error when backend Delete fails, got nil (Fail Open)")
+	}
+
+	// Security: Verify that we fail closed by clearing the cookie.
+	// If we leave the cookie set to the NEW ID, the user is effectively logged in
+	// even though the security rotation failed.
+	cookies := w.Result().Cookies()
+	foundClear := false
+	for _, c := range cookies {
+		if c.Name == "session_id" && c.MaxAge < 0 {
+			foundClear = true
+		}
+	}
+
+	if !foundClear {
+		t.Error("Expected session cookie to be cleared (MaxAge < 0) when Regenerate fails, but it remained valid")
+	}
+}
+
+func TestDestroy_ClearsMemory_OnError(t *testing.T) {
+	mgr := dbsession.NewManager(dbsession.Config{Store: failingStore(testutil.MethodDelete)})
+	defer mgr.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	s.Set("secret", "sensitive-data")
+
+	// Destroy fails at store level
+	if err := mgr.Destroy(w, r, s); err == nil {
+		t.Fatal("Expected Destroy to return error from store")
+	}
+
+	// Verify memory should still be cleared for defense-in-depth
+	val, ok := s.Get("secret")
+	if ok || val != nil {
+		t.Error("Vulnerability: Session values persisted in memory after failed Destroy")
+	}
+	if len(s.Values) > 0 {
+		t.Errorf("Vulnerability: Values map not empty, len %d", len(s.Values))
+	}
+}
@@ -0,0 +1,172 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestManager_Load(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	live := &Session{
+		ID:        "0123456789abcdef0123456789abcdef",
+		Values:    map[string]any{"k": "v"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	expired := &Session{
+		ID:        "fedcba9876543210fedcba9876543210",
+		Values:    map[string]any{"k": "v"},
+		CreatedAt: time.Now().Add(-2 * time.Hour),
+		ExpiresAt: time.Now().Add(-time.Hour),
+	}
+	for _, s := range []*Session{live, expired} {
+		if err := store.Save(ctx, s); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+	}
+
+	load := func(value string) (*Session, error) {
+		r := httptest.NewRequest("GET", "/", nil)
+		if value != "" {
+			r.AddCookie(&http.Cookie{Name: "session_id", Value: value})
+		}
+		return mgr.Load(r)
+	}
+
+	if s, err := load(live.ID); err != nil || s.ID != live.ID {
+		t.Errorf("expected live session, got %v, %v", s, err)
+	}
+	if _, err := load(expired.ID); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired, got %v", err)
+	}
+	if _, err := load("00000000000000000000000000000000"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound for unknown ID, got %v", err)
+	}
+	if _, err := load("not-a-valid-id"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound for malformed ID, got %v", err)
+	}
+	if _, err := load(""); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound without cookie, got %v", err)
+	}
+}
+
+func TestSQLiteStore_ReturnsExpired(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	expired := &Session{
+		ID:        "expired",
+		Values:    map[string]any{},
+		CreatedAt: time.Now().Add(-2 * time.Hour),
+		ExpiresAt: time.Now().Add(-time.Hour),
+	}
+	if err := store.Save(ctx, expired); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	got, err := store.Get(ctx, expired.ID)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if got == nil {
+		t.Fatal("expected the store to surface the expired session")
+	}
+	if !got.ExpiresAt.Before(time.Now()) {
+		t.Errorf("expected past ExpiresAt, got %v", got.ExpiresAt)
+	}
+}
+
+func TestManager_NegativeCache(t *testing.T) {
+	backend := &countingStore{mapStore: newMapStore()}
+	clock := newFakeClock()
+	mgr := NewManager(Config{Store: backend, Clock: clock, NegativeCacheSize: 10, NegativeCacheTTL: time.Second})
+	defer mgr.Close()
+	ctx := context.Background()
+
+	s, _ := mgr.New()
+	for range 3 {
+		if _, err := mgr.LoadSession(ctx, s.ID); err != ErrSessionNotFound {
+			t.Fatalf("expected ErrSessionNotFound, got %v", err)
+		}
+	}
+	if n := backend.gets.Load(); n != 1 {
+		t.Errorf("expected repeated misses to reach the store once, got %d gets", n)
+	}
+
+	clock.Advance(2 * time.Second)
+	mgr.LoadSession(ctx, s.ID)
+	if n := backend.gets.Load(); n != 2 {
+		t.Errorf("expected the miss to be forgotten after the TTL, got %d gets", n)
+	}
+
+	// Saving the session makes it visible at once.
+	if err := mgr.PersistSession(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if _, err := mgr.LoadSession(ctx, s.ID); err != nil {
+		t.Errorf("expected the saved session to be found, got %v", err)
+	}
+}
+
+// emptyMissStore returns an empty session instead of nil for a miss.
+type emptyMissStore struct{ *mapStore }
+
+func (s emptyMissStore) Get(ctx context.Context, id string) (*Session, error) {
+	got, err := s.mapStore.Get(ctx, id)
+	if got == nil && err == nil {
+		return &Session{}, nil
+	}
+	return got, err
+}
+
+// okStore reports misses through GetOK only.
+type okStore struct{ *mapStore }
+
+func (s okStore) GetOK(ctx context.Context, id string) (*Session, bool, error) {
+	got, err := s.mapStore.Get(ctx, id)
+	return &Session{ID: id}, got != nil, err
+}
+
+func TestGetOK(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range map[string]Store{
+		"Get":         newMapStore(),
+		"empty miss":  emptyMissStore{newMapStore()},
+		"FoundGetter": okStore{newMapStore()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mgr := NewManager(Config{Store: store})
+			defer mgr.Close()
+
+			s, _ := mgr.New()
+			if _, found, err := GetOK(ctx, store, s.ID); found || err != nil {
+				t.Errorf("expected a miss, got found=%v, %v", found, err)
+			}
+			if _, err := mgr.LoadSession(ctx, s.ID); err != ErrSessionNotFound {
+				t.Errorf("expected ErrSessionNotFound, got %v", err)
+			}
+
+			if err := mgr.PersistSession(ctx, s); err != nil {
+				t.Fatalf("failed to save session: %v", err)
+			}
+			if got, found, err := GetOK(ctx, store, s.ID); !found || err != nil || got.ID != s.ID {
+				t.Errorf("expected the session, got found=%v, %v", found, err)
+			}
+		})
+	}
+}
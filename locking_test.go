@@ -0,0 +1,182 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStore_OptimisticLocking(t *testing.T) {
+	dbPath := "test_locking.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStoreWithConfig(SQLiteConfig{
+		DSN:               dbPath,
+		OptimisticLocking: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "locked-session",
+		Values:    map[string]any{"cart": 1},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save new session: %v", err)
+	}
+	if s.Version != 1 {
+		t.Errorf("expected version 1 after insert, got %d", s.Version)
+	}
+
+	// Two requests load the same session.
+	a, err := store.Get(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	b, err := store.Get(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+
+	a.Values["cart"] = 2
+	if err := store.Save(ctx, a); err != nil {
+		t.Fatalf("first concurrent save failed: %v", err)
+	}
+
+	b.Values["profile"] = "updated"
+	if err := store.Save(ctx, b); !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("expected ErrConcurrentModification, got %v", err)
+	}
+
+	// Retry pattern: reload, reapply, save.
+	b, err = store.Get(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	b.Values["profile"] = "updated"
+	if err := store.Save(ctx, b); err != nil {
+		t.Fatalf("retry save failed: %v", err)
+	}
+
+	got, err := store.Get(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if got.Values["cart"] != 2 || got.Values["profile"] != "updated" {
+		t.Errorf("expected both updates to survive, got %v", got.Values)
+	}
+	if got.Version != 3 {
+		t.Errorf("expected version 3, got %d", got.Version)
+	}
+
+	// A second insert of the same ID is a conflict too.
+	dup := &Session{ID: s.ID, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, dup); !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("expected ErrConcurrentModification for duplicate insert, got %v", err)
+	}
+}
+
+func TestSQLiteStore_MigratesVersionColumn(t *testing.T) {
+	dbPath := "test_migrate.db"
+	defer os.Remove(dbPath)
+
+	// Open once to create the schema, then drop the column by recreating
+	// the table as an earlier version of the package would have.
+	store, err := NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if _, err := store.db.Exec(`
+		DROP TABLE sessions;
+		CREATE TABLE sessions (id TEXT PRIMARY KEY, data BLOB, created_at DATETIME, expires_at DATETIME);
+	`); err != nil {
+		t.Fatalf("failed to recreate legacy table: %v", err)
+	}
+	store.Close()
+
+	store, err = NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open legacy database: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	s := &Session{ID: "legacy", Values: map[string]any{"a": 1}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if _, err := store.Get(ctx, s.ID); err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+}
+
+func TestSQLiteStore_OptimisticLockingExistingRows(t *testing.T) {
+	dbPath := "test_locking_enable.db"
+	defer os.Remove(dbPath)
+
+	// A session written before optimistic locking was turned on keeps
+	// version 0, since the plain upsert never bumps it.
+	store, err := NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	s := &Session{ID: "pre-locking", Values: map[string]any{"a": 1}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	store.Close()
+
+	store, err = NewSQLiteStoreWithConfig(SQLiteConfig{DSN: dbPath, OptimisticLocking: true})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	a, err := store.Get(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if a.Version != 0 {
+		t.Fatalf("expected version 0 for a row written without locking, got %d", a.Version)
+	}
+	b, err := store.Get(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+
+	a.Values["a"] = 2
+	if err := store.Save(ctx, a); err != nil {
+		t.Fatalf("failed to save existing row with locking on: %v", err)
+	}
+	if a.Version != 1 {
+		t.Errorf("expected version 1, got %d", a.Version)
+	}
+	if err := store.Save(ctx, a); err != nil {
+		t.Fatalf("failed to save again: %v", err)
+	}
+
+	// The other copy is still at version 0 and now stale.
+	b.Values["b"] = 1
+	if err := store.Save(ctx, b); !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("expected ErrConcurrentModification for stale copy, got %v", err)
+	}
+
+	got, err := store.Get(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if got.Values["a"] != 2 || got.Version != 2 {
+		t.Errorf("expected a=2 at version 2, got %v at version %d", got.Values, got.Version)
+	}
+}
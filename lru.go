@@ -0,0 +1,61 @@
+package dbsession
+
+import "container/list"
+
+// lru is a map bounded to max entries, evicting the least recently used
+// entry when full. It is not safe for concurrent use.
+type lru[V any] struct {
+	max   int
+	order *list.List // Front is most recently used
+	items map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRU[V any](max int) *lru[V] {
+	return &lru[V]{max: max, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns the value for key and marks it as most recently used.
+func (c *lru[V]) get(key string) (V, bool) {
+	e, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry[V]).value, true
+}
+
+// add sets the value for key, evicting the least recently used entry if
+// the cache is full.
+func (c *lru[V]) add(key string, value V) {
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruEntry[V]).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value})
+	if c.order.Len() > c.max {
+		c.remove(c.order.Back().Value.(*lruEntry[V]).key)
+	}
+}
+
+func (c *lru[V]) remove(key string) {
+	if e, ok := c.items[key]; ok {
+		c.order.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+func (c *lru[V]) clear() {
+	c.order.Init()
+	clear(c.items)
+}
+
+func (c *lru[V]) len() int {
+	return c.order.Len()
+}
@@ -1,58 +1,492 @@
 package dbsession
 
 import (
-	"bytes"
+	"cmp"
 	"context"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/binary"
-	"encoding/gob"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"maps"
 	mrand "math/rand/v2"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
 
 var (
-	// ErrSessionTooLarge is returned when the session data exceeds the configured MaxSessionBytes.
+	// ErrSessionTooLarge is matched by the *SessionTooLargeError returned when
+	// the session data exceeds the configured MaxSessionBytes.
 	ErrSessionTooLarge = errors.New("session data too large")
 
 	// ErrInvalidSessionID is returned when the session ID format is invalid.
 	ErrInvalidSessionID = errors.New("invalid session id")
+
+	// ErrSessionNotFound is returned by Load when the request carries no
+	// valid session: the cookie is missing or malformed, the store has no
+	// such session, or it fails client binding checks.
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrSessionExpired is returned by Load when the session exists but its
+	// expiration time has passed.
+	ErrSessionExpired = errors.New("session expired")
+
+	// ErrSessionIdle is returned by Load when the session was not accessed
+	// within Config.IdleTimeout. It wraps ErrSessionExpired, so it only needs
+	// checking to tell inactivity apart from the end of the session's TTL.
+	ErrSessionIdle = fmt.Errorf("%w: idle timeout exceeded", ErrSessionExpired)
+
+	// ErrConcurrentModification is returned by stores with optimistic locking
+	// enabled when the session was modified by another request since it was
+	// loaded. The recommended recovery is to reload the session with Get,
+	// reapply the changes and Save again, retrying a small bounded number of
+	// times.
+	ErrConcurrentModification = errors.New("session was modified concurrently")
+
+	// ErrCorruptSession is wrapped by the errors stores return when a
+	// session's stored data cannot be decoded. See Config.OnDecodeError.
+	ErrCorruptSession = errors.New("session data corrupt")
+
+	// ErrDecodedTooLarge is wrapped by the errors stores return when a
+	// session's data decodes to more than their MaxDecodedBytes, e.g. a
+	// small compressed blob that expands into gigabytes. See LimitDecoded.
+	ErrDecodedTooLarge = errors.New("decoded session data too large")
+
+	// ErrHeadersWritten is returned by Save and Regenerate when called
+	// through Middleware after the response headers were sent, since the
+	// session cookie could no longer be set. The session is not saved.
+	ErrHeadersWritten = errors.New("response headers already written")
+
+	// ErrSessionDestroyed is returned when saving or regenerating a session
+	// that was removed by Destroy, which would otherwise resurrect it.
+	ErrSessionDestroyed = errors.New("session was destroyed")
+
+	// ErrSessionDegraded is returned when saving or regenerating a session
+	// that Get issued because the store was unavailable, see
+	// Config.DegradeToNewSession.
+	ErrSessionDegraded = errors.New("session was issued while the store was unavailable")
+)
+
+// SessionTooLargeError is the error returned when a session's encoded data
+// exceeds MaxSessionBytes, by the Manager or a store. It matches
+// ErrSessionTooLarge with errors.Is; use errors.As to read the sizes.
+type SessionTooLargeError struct {
+	Size  int // Encoded size of the session data in bytes
+	Limit int // The MaxSessionBytes that was exceeded
+}
+
+func (e *SessionTooLargeError) Error() string {
+	return fmt.Sprintf("%v: %d bytes, limit is %d", ErrSessionTooLarge, e.Size, e.Limit)
+}
+
+// Is reports whether target is ErrSessionTooLarge.
+func (e *SessionTooLargeError) Is(target error) bool {
+	return target == ErrSessionTooLarge
+}
+
+const (
+	defaultCookieName     = "session_id"
+	defaultCleanupTimeout = 30 * time.Second
+	// closeWait bounds how long Close waits for the cleanup worker to exit,
+	// in case the store ignores cancellation.
+	closeWait = 5 * time.Second
 )
 
 type Manager struct {
 	store           Store
 	ttl             time.Duration
+	cookieTTL       time.Duration // 0: the session's TTL
 	cookie          string
 	cookiePath      string
 	cookieDomain    string
 	cleanup         time.Duration
-	stopChan        chan struct{}
+	cleanupTimeout  time.Duration
+	storeTimeout    time.Duration
+	ctx             context.Context    // Canceled by Close to abort in-flight cleanups
+	cancel          context.CancelFunc // Cancels ctx
+	workerDone      chan struct{}      // Closed when the cleanup worker exits; nil without a worker
+	closeOnce       sync.Once
+	closeErr        error // Result of closing the store
 	httpOnly        bool
 	secure          *bool
 	sameSite        http.SameSite
 	maxSessionBytes int
+	codec           Codec // The store's, see CodecStore
+	tracer          Tracer
+	metrics         Metrics
+	cookieMutator   func(*http.Cookie)
+	misses          *negativeCache // IDs recently found absent; nil if disabled
+	backend         string
+	onCreate        func(*Session)
+	onDestroy       func(id string)
+	onRegenerate    func(oldID, newID string)
+	validateIP      bool
+	clientIP        func(*http.Request) string
+	ipv4PrefixLen   int
+	ipv6PrefixLen   int
+	bindUserAgent   bool
+	csrfHeader      string
+	csrfField       string
+	trustForwarded  bool
+	singleCleanup   bool
+	cleanupJitter   time.Duration
+	cleanupStores   []Store // Cleaned after store, see Config.CleanupStores
+	expiryLeeway    time.Duration
+	touchInterval   time.Duration
+	rememberCookie  string
+	persistent      bool
+	clock           Clock
+	tokenExtractor  func(*http.Request) string
+	requireSession  bool
+	degrade         bool
+	maxPerUser      int
+	eviction        EvictionPolicy
+	idEncoding      IDEncoding
+	idleTimeout     time.Duration
+	idGenerator     IDGenerator
+	onDecodeError   DecodeErrorPolicy
+	securePrefix    bool
+	partitioned     bool
+	fallbackCookies []string
+	tokenAEAD       cipher.AEAD
 }
 
 type Config struct {
-	Store           Store
-	TTL             time.Duration
-	CookieName      string
-	CookiePath      string
-	CookieDomain    string
+	Store        Store
+	TTL          time.Duration
+	CookieName   string
+	CookiePath   string
+	CookieDomain string
+	// CleanupInterval is how often the background worker removes expired
+	// sessions. Defaults to 10 minutes. A negative value disables the worker,
+	// e.g. for short-lived serverless processes; call Cleanup from a
+	// scheduled job instead.
 	CleanupInterval time.Duration
+	// CleanupJitter randomizes each cleanup run, including the first, by up
+	// to ±CleanupJitter around CleanupInterval, so that instances started
+	// together do not clean up in lockstep. A value of 20% of the interval is
+	// a good start. It is capped to half the interval.
+	CleanupJitter time.Duration
+	// CleanupTimeout bounds each background cleanup run. Defaults to 30
+	// seconds. Close also aborts a run in progress.
+	CleanupTimeout time.Duration
+	// StoreTimeout, if set, bounds each store Get, Save and Delete the
+	// Manager makes, whatever the deadline of the caller's context, which
+	// still applies if sooner. It gives stuck store calls, e.g. under a
+	// request context without deadline, a predictable upper bound; a call
+	// exceeding it fails with context.DeadlineExceeded. Cleanup runs are
+	// bounded by CleanupTimeout instead.
+	StoreTimeout time.Duration
+	// ExpiryLeeway keeps sessions valid for this long past their ExpiresAt,
+	// so that clock skew between the servers saving, loading and cleaning
+	// up sessions does not end them early. The Manager accepts sessions up
+	// to ExpiresAt+ExpiryLeeway, and passes the leeway to stores
+	// implementing ExpiryLeewaySetter, such as the built-in ones, so that
+	// their cleanup keeps those sessions too. A stolen session stays usable
+	// for that much longer, so keep it to a few seconds.
+	ExpiryLeeway time.Duration
+	// CleanupStores are further stores to clean up along with Store, e.g.
+	// the L1 cache of a TieredStore, whose Cleanup only covers L2.
+	// The worker and Cleanup clean each of them in turn; a store failing
+	// does not stop the others. The Manager does not close them.
+	CleanupStores   []Store
 	HttpOnly        *bool
 	Secure          *bool
 	SameSite        http.SameSite
 	MaxSessionBytes int // Maximum size in bytes of the serialized session data. 0 means unlimited.
+
+	// PersistentCookie controls whether the session cookie carries Expires
+	// and MaxAge. Defaults to true. When false, the cookie is a browser-session
+	// cookie deleted when the browser closes, while the stored session still
+	// expires after TTL.
+	PersistentCookie *bool
+	// CookieTTL, if set, is the lifetime of the persistent session cookie,
+	// instead of the session's TTL. A cookie outliving its session lets Load
+	// report ErrSessionExpired, e.g. to tell the user they were logged out,
+	// rather than see an anonymous request; the store still enforces
+	// ExpiresAt, and once cleanup removes the session, Load reports
+	// ErrSessionNotFound. It applies to sessions with their own TTL too.
+	CookieTTL time.Duration
+
+	// Clock is the time source for expiry decisions. Defaults to the system
+	// clock; tests can inject a fake one.
+	Clock Clock
+
+	// Tracer, if set, wraps every store operation (Get, Save, Delete, Cleanup)
+	// in a span. The incoming request context is propagated so spans nest
+	// under the request span.
+	Tracer Tracer
+
+	// Metrics, if set, is told about session lifecycle events and the
+	// latency of every store operation.
+	Metrics Metrics
+
+	// Lifecycle callbacks, useful for audit logging. They are invoked without
+	// holding the session lock, so they may safely call Session methods.
+	//
+	// OnCreate is called by New when a session is created in memory.
+	OnCreate func(*Session)
+	// OnDestroy is called by Destroy and Revoke after the session was removed
+	// from the store.
+	OnDestroy func(id string)
+	// OnRegenerate is called by Regenerate after the old session was removed from the store.
+	OnRegenerate func(oldID, newID string)
+
+	// ValidateIP binds a session to the client IP it was first saved from.
+	// Get treats a session requested from a different IP as invalid and
+	// returns a new session instead. Sessions saved before binding was
+	// enabled are bound on their next Save.
+	ValidateIP bool
+	// ClientIP extracts the client IP from a request. Defaults to the host
+	// part of r.RemoteAddr. When running behind a proxy, supply an extractor
+	// that reads X-Forwarded-For; trusting that header is the application's
+	// responsibility.
+	ClientIP func(*http.Request) string
+	// IPv4PrefixLen and IPv6PrefixLen relax ValidateIP to a subnet match
+	// (e.g. 24 for a /24), tolerating legitimate address changes within a
+	// network. 0 requires an exact match.
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+
+	// BindUserAgent binds a session to a SHA-256 hash of the User-Agent it was
+	// first saved with. Get returns a new session when a cookie is presented
+	// by a different User-Agent, e.g. after being lifted to another device.
+	// Note that legitimate browser upgrades change the User-Agent and will
+	// invalidate the session; this is intended for high-security flows.
+	BindUserAgent bool
+
+	// CSRFHeader and CSRFField name the request header and form field that
+	// ValidateCSRF reads the token from. The header is checked first.
+	// Default to "X-CSRF-Token" and "csrf_token".
+	CSRFHeader string
+	CSRFField  string
+
+	// TrustForwardedProto treats requests with "X-Forwarded-Proto: https" as
+	// secure when Secure is not set explicitly, for deployments that
+	// terminate TLS at a reverse proxy. Only enable it if the proxy sets (and
+	// overwrites) the header, since clients can spoof it otherwise.
+	TrustForwardedProto bool
+
+	// SingleInstanceCleanup makes the cleanup worker skip a run when another
+	// process is already cleaning the same store, so that a fleet of
+	// instances sharing one database does not purge it concurrently. It only
+	// has an effect on stores implementing ExclusiveCleaner, currently the
+	// PostgreSQL store (SQLite is a single local file and Memcached expires
+	// entries on its own).
+	SingleInstanceCleanup bool
+
+	// TouchInterval controls how often loading a session writes its
+	// LastAccessedAt back to the store. Get always updates LastAccessedAt in
+	// memory, and Save persists it; with TouchInterval set, Get also saves the
+	// session if the stored value is older than the interval, so read-only
+	// requests are tracked at the cost of at most one write per interval.
+	// 0 disables these writes.
+	TouchInterval time.Duration
+
+	// RememberCookieName is the name of the remember-me cookie set by
+	// SetRemember. Defaults to "remember_token".
+	RememberCookieName string
+
+	// TokenExtractor, if set, reads the session ID from the request instead
+	// of the session cookie, e.g. BearerToken for API clients that cannot
+	// store cookies. Pair it with SaveToken, which saves without setting a
+	// cookie and returns the ID to hand back to the client.
+	TokenExtractor func(*http.Request) string
+
+	// RequireSession stops Get from creating a session when the request has
+	// no valid one: it returns Load's ErrSessionNotFound or
+	// ErrSessionExpired instead, and Middleware responds 401 Unauthorized.
+	// It suits APIs using TokenExtractor, whose clients must
+	// re-authenticate rather than carry on with an empty session. A
+	// remember-me cookie still restores a session.
+	RequireSession bool
+
+	// DegradeToNewSession makes Get return a new, empty session instead of
+	// an error when the store fails with a connection error (see
+	// DefaultRetryable), e.g. while PostgreSQL is briefly down, so that pages
+	// which do not need the session still render. The failure is logged
+	// with slog. The session is marked Degraded and cannot be saved: Save
+	// fails with ErrSessionDegraded rather than replace the client's cookie
+	// with a session that lost its values. Load still reports the error.
+	// Security: this masks outages, so that logged-in users appear anonymous
+	// and authorization based on the session fails closed rather than
+	// visibly; monitor store errors (Config.Metrics) instead of relying on
+	// failed requests.
+	DegradeToNewSession bool
+
+	// MaxSessionsPerUser limits how many live sessions one Session.OwnerID
+	// can have. When a session is saved with a new owner, that owner's
+	// surplus sessions are deleted according to EvictionPolicy. It requires
+	// a store implementing OwnerLister and is ignored otherwise (NewManagerE
+	// reports it). 0 means no limit.
+	MaxSessionsPerUser int
+	// EvictionPolicy selects which sessions MaxSessionsPerUser evicts.
+	// Defaults to EvictOldest.
+	EvictionPolicy EvictionPolicy
+
+	// IdleTimeout, if set, invalidates a session that was not accessed for
+	// this long, even though its TTL has not passed: Load deletes it and
+	// returns ErrSessionIdle, and Get returns a new session. Accesses are
+	// tracked with LastAccessedAt, which must be persisted for reads too, so
+	// TouchInterval defaults to a quarter of IdleTimeout.
+	IdleTimeout time.Duration
+
+	// IDEncoding selects the format of new session IDs. Defaults to
+	// IDEncodingHex. IDs in either format are accepted regardless, so
+	// existing sessions stay valid when switching.
+	IDEncoding IDEncoding
+	// IDGenerator, if set, generates session IDs instead of IDEncoding, e.g.
+	// ULIDGenerator for time-sortable IDs. IDs in the built-in formats are
+	// still accepted.
+	IDGenerator IDGenerator
+
+	// UseSecurePrefix prepends "__Secure-" to the session and remember-me
+	// cookie names, which makes browsers reject the cookies unless they are
+	// Secure and set over HTTPS, so they cannot be overwritten by an
+	// insecure origin. It forces Secure; NewManagerE reports an explicit
+	// Secure=false or a cookie name that already carries a "__Secure-" or
+	// "__Host-" prefix.
+	UseSecurePrefix bool
+
+	// FallbackCookieNames are former names of the session cookie, e.g.
+	// while renaming it. When the request has no cookie under CookieName,
+	// Load and Get read the session ID from the first of these cookies
+	// present; the next Save then sets the cookie under CookieName and
+	// deletes the old one, and Destroy deletes them all. The old cookies are
+	// deleted with the current CookiePath and CookieDomain, which must
+	// therefore be unchanged.
+	FallbackCookieNames []string
+
+	// TokenKey, if set, hides session IDs from clients: the cookie, and the
+	// value returned by SaveToken, carry an opaque token sealing the ID with
+	// AES-GCM under this 16, 24 or 32 byte key, which Load opens without an
+	// extra store lookup. Regenerate then rotates the token instead of the
+	// ID, revoking the session's earlier tokens while its stored record
+	// stays in place. Enabling it, or changing the key, invalidates the
+	// sessions of existing clients. NewManager panics on an invalid key.
+	TokenKey []byte
+
+	// Partitioned sets the Partitioned attribute on the session and
+	// remember-me cookies (CHIPS), so that browsers partitioning third-party
+	// cookies still send them to a widget embedded on other sites, keyed by
+	// the embedding site. It requires SameSite=None and Secure cookies:
+	// NewManagerE reports another SameSite or an explicit Secure=false, and
+	// Save fails if a response would not meet them.
+	Partitioned bool
+
+	// CookieMutator, if set, is called with every cookie the Manager sets,
+	// the session and remember-me cookies and the cookies deleting them,
+	// just before it is written. It runs after the Manager has set its own
+	// attributes, so it can override them, and is an escape hatch for
+	// attributes http.Cookie does not model: entries appended to
+	// Cookie.Unparsed, such as "Priority=High", are written as attributes.
+	CookieMutator func(*http.Cookie)
+
+	// NegativeCacheSize, if positive, makes the Manager remember up to this
+	// many session IDs recently found absent from the store, so that
+	// repeated lookups of the same unknown ID, as sent by scanners, are
+	// rejected without querying the store again. Saving a session through
+	// the Manager forgets its ID at once, but a session saved by another
+	// process under a remembered ID is not found until the entry expires.
+	// Off by default.
+	NegativeCacheSize int
+	// NegativeCacheTTL is how long an absent ID is remembered. Defaults to
+	// 5 seconds.
+	NegativeCacheTTL time.Duration
+
+	// OnDecodeError selects what Load does when a session's stored data
+	// cannot be decoded. Defaults to DecodeErrorPropagate.
+	OnDecodeError DecodeErrorPolicy
 }
 
+// NewManager creates a Manager from cfg, applying defaults for unset fields.
+// It does not validate cfg beyond panicking on a nil Store; use NewManagerE
+// to have inconsistent configuration reported as an error.
 func NewManager(cfg Config) *Manager {
+	if cfg.Store == nil {
+		panic("dbsession: NewManager called with a nil Store")
+	}
+	return newManager(cfg)
+}
+
+// NewManagerE is like NewManager but validates cfg first and returns a
+// descriptive error for invalid values or combinations, instead of silently
+// adjusting them.
+func NewManagerE(cfg Config) (*Manager, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return newManager(cfg), nil
+}
+
+// validate reports the first problem found in cfg.
+func (cfg *Config) validate() error {
+	switch {
+	case cfg.Store == nil:
+		return errors.New("dbsession: Store is required")
+	case cfg.TTL < 0:
+		return fmt.Errorf("dbsession: TTL must not be negative, got %v", cfg.TTL)
+	case cfg.SameSite == http.SameSiteNoneMode && cfg.Secure != nil && !*cfg.Secure:
+		return errors.New("dbsession: SameSite=None requires Secure cookies, but Secure is false")
+	case cfg.MaxSessionBytes < 0:
+		return fmt.Errorf("dbsession: MaxSessionBytes must not be negative, got %d", cfg.MaxSessionBytes)
+	case cfg.CleanupJitter < 0:
+		return fmt.Errorf("dbsession: CleanupJitter must not be negative, got %v", cfg.CleanupJitter)
+	case cfg.CleanupTimeout < 0:
+		return fmt.Errorf("dbsession: CleanupTimeout must not be negative, got %v", cfg.CleanupTimeout)
+	case cfg.StoreTimeout < 0:
+		return fmt.Errorf("dbsession: StoreTimeout must not be negative, got %v", cfg.StoreTimeout)
+	case cfg.CookieTTL < 0:
+		return fmt.Errorf("dbsession: CookieTTL must not be negative, got %v", cfg.CookieTTL)
+	case cfg.ExpiryLeeway < 0:
+		return fmt.Errorf("dbsession: ExpiryLeeway must not be negative, got %v", cfg.ExpiryLeeway)
+	case slices.Contains(cfg.CleanupStores, nil):
+		return errors.New("dbsession: CleanupStores must not contain a nil store")
+	case cfg.TouchInterval < 0:
+		return fmt.Errorf("dbsession: TouchInterval must not be negative, got %v", cfg.TouchInterval)
+	case cfg.IdleTimeout < 0:
+		return fmt.Errorf("dbsession: IdleTimeout must not be negative, got %v", cfg.IdleTimeout)
+	case cfg.IdleTimeout > 0 && cfg.TouchInterval >= cfg.IdleTimeout:
+		return fmt.Errorf("dbsession: TouchInterval (%v) must be shorter than IdleTimeout (%v), or active sessions would time out", cfg.TouchInterval, cfg.IdleTimeout)
+	case cfg.IPv4PrefixLen < 0 || cfg.IPv4PrefixLen > 32:
+		return fmt.Errorf("dbsession: IPv4PrefixLen must be between 0 and 32, got %d", cfg.IPv4PrefixLen)
+	case cfg.IPv6PrefixLen < 0 || cfg.IPv6PrefixLen > 128:
+		return fmt.Errorf("dbsession: IPv6PrefixLen must be between 0 and 128, got %d", cfg.IPv6PrefixLen)
+	case cfg.MaxSessionsPerUser < 0:
+		return fmt.Errorf("dbsession: MaxSessionsPerUser must not be negative, got %d", cfg.MaxSessionsPerUser)
+	case cfg.MaxSessionsPerUser > 0 && !isOwnerLister(cfg.Store):
+		return fmt.Errorf("dbsession: MaxSessionsPerUser requires a store that can list sessions by owner, %T cannot", cfg.Store)
+	case cfg.IDEncoding.valid() != nil:
+		return cfg.IDEncoding.valid()
+	case cfg.UseSecurePrefix && cfg.Secure != nil && !*cfg.Secure:
+		return errors.New("dbsession: UseSecurePrefix requires Secure cookies, but Secure is false")
+	case cfg.UseSecurePrefix && (hasCookiePrefix(cfg.CookieName) || hasCookiePrefix(cfg.RememberCookieName)):
+		return errors.New("dbsession: UseSecurePrefix adds the __Secure- prefix, cookie names must not already have a __Secure- or __Host- prefix")
+	case cfg.Partitioned && (cfg.SameSite != http.SameSiteNoneMode || cfg.Secure != nil && !*cfg.Secure):
+		return errors.New("dbsession: Partitioned requires SameSite=None and Secure cookies")
+	case slices.Contains(cfg.FallbackCookieNames, cmp.Or(cfg.CookieName, defaultCookieName)):
+		return fmt.Errorf("dbsession: FallbackCookieNames must not contain the cookie name %q", cmp.Or(cfg.CookieName, defaultCookieName))
+	case len(cfg.TokenKey) > 0 && len(cfg.TokenKey) != 16 && len(cfg.TokenKey) != 24 && len(cfg.TokenKey) != 32:
+		return fmt.Errorf("dbsession: TokenKey must be 16, 24 or 32 bytes long, got %d", len(cfg.TokenKey))
+	case cfg.NegativeCacheSize < 0:
+		return fmt.Errorf("dbsession: NegativeCacheSize must not be negative, got %d", cfg.NegativeCacheSize)
+	case cfg.NegativeCacheTTL < 0:
+		return fmt.Errorf("dbsession: NegativeCacheTTL must not be negative, got %v", cfg.NegativeCacheTTL)
+	case cfg.CookieName != "" && cfg.CookieName == cfg.RememberCookieName:
+		return fmt.Errorf("dbsession: CookieName and RememberCookieName must differ, both are %q", cfg.CookieName)
+	}
+	return nil
+}
+
+func newManager(cfg Config) *Manager {
 	if cfg.CookieName == "" {
-		cfg.CookieName = "session_id"
+		cfg.CookieName = defaultCookieName
 	}
 	if cfg.CookiePath == "" {
 		cfg.CookiePath = "/"
@@ -60,28 +494,107 @@ func NewManager(cfg Config) *Manager {
 	if cfg.TTL == 0 {
 		cfg.TTL = 24 * time.Hour
 	}
+	if cfg.CSRFHeader == "" {
+		cfg.CSRFHeader = defaultCSRFHeader
+	}
+	if cfg.CSRFField == "" {
+		cfg.CSRFField = defaultCSRFField
+	}
+	if cfg.RememberCookieName == "" {
+		cfg.RememberCookieName = defaultRememberCookie
+	}
 	if cfg.CleanupInterval == 0 {
 		cfg.CleanupInterval = 10 * time.Minute
 	}
+	if cfg.CleanupTimeout <= 0 {
+		cfg.CleanupTimeout = defaultCleanupTimeout
+	}
+	if cfg.IdleTimeout > 0 && cfg.TouchInterval == 0 {
+		cfg.TouchInterval = cfg.IdleTimeout / 4
+	}
+	if cfg.UseSecurePrefix {
+		secure := true
+		cfg.Secure = &secure
+	}
 
 	m := &Manager{
 		store:           cfg.Store,
 		ttl:             cfg.TTL,
-		cookie:          cfg.CookieName,
+		cookieTTL:       cfg.CookieTTL,
+		cookie:          cookieName(cfg.CookieName, cfg.UseSecurePrefix),
 		cookiePath:      cfg.CookiePath,
 		cookieDomain:    cfg.CookieDomain,
 		cleanup:         cfg.CleanupInterval,
-		stopChan:        make(chan struct{}),
+		cleanupTimeout:  cfg.CleanupTimeout,
+		storeTimeout:    cfg.StoreTimeout,
 		httpOnly:        true, // Default
+		persistent:      true, // Default
+		clock:           cfg.Clock,
 		secure:          cfg.Secure,
 		sameSite:        http.SameSiteLaxMode, // Default
 		maxSessionBytes: cfg.MaxSessionBytes,
+		tracer:          cfg.Tracer,
+		metrics:         cfg.Metrics,
+		cookieMutator:   cfg.CookieMutator,
+		backend:         storeBackend(cfg.Store),
+		codec:           storeCodec(cfg.Store),
+		onCreate:        cfg.OnCreate,
+		onDestroy:       cfg.OnDestroy,
+		onRegenerate:    cfg.OnRegenerate,
+		validateIP:      cfg.ValidateIP,
+		clientIP:        cfg.ClientIP,
+		ipv4PrefixLen:   cfg.IPv4PrefixLen,
+		ipv6PrefixLen:   cfg.IPv6PrefixLen,
+		bindUserAgent:   cfg.BindUserAgent,
+		csrfHeader:      cfg.CSRFHeader,
+		csrfField:       cfg.CSRFField,
+		trustForwarded:  cfg.TrustForwardedProto,
+		singleCleanup:   cfg.SingleInstanceCleanup,
+		cleanupJitter:   min(cfg.CleanupJitter, cfg.CleanupInterval/2),
+		cleanupStores:   slices.DeleteFunc(slices.Clone(cfg.CleanupStores), func(s Store) bool { return s == nil }),
+		expiryLeeway:    max(cfg.ExpiryLeeway, 0),
+		touchInterval:   cfg.TouchInterval,
+		rememberCookie:  cookieName(cfg.RememberCookieName, cfg.UseSecurePrefix),
+		securePrefix:    cfg.UseSecurePrefix,
+		partitioned:     cfg.Partitioned,
+		fallbackCookies: slices.Clone(cfg.FallbackCookieNames),
+		tokenExtractor:  cfg.TokenExtractor,
+		requireSession:  cfg.RequireSession,
+		degrade:         cfg.DegradeToNewSession,
+		maxPerUser:      cfg.MaxSessionsPerUser,
+		eviction:        cfg.EvictionPolicy,
+		idEncoding:      cfg.IDEncoding,
+		idleTimeout:     cfg.IdleTimeout,
+		idGenerator:     cfg.IDGenerator,
+		onDecodeError:   cfg.OnDecodeError,
+		misses:          newNegativeCache(cfg.NegativeCacheSize, cfg.NegativeCacheTTL),
+	}
+
+	if len(cfg.TokenKey) > 0 {
+		aead, err := newTokenAEAD(cfg.TokenKey)
+		if err != nil {
+			// Falling back to raw IDs would silently expose them.
+			panic(err.Error())
+		}
+		m.tokenAEAD = aead
+	}
+
+	if m.clientIP == nil {
+		m.clientIP = remoteAddrIP
+	}
+
+	if m.clock == nil {
+		m.clock = systemClock{}
 	}
 
 	if cfg.HttpOnly != nil {
 		m.httpOnly = *cfg.HttpOnly
 	}
 
+	if cfg.PersistentCookie != nil {
+		m.persistent = *cfg.PersistentCookie
+	}
+
 	if cfg.SameSite != 0 {
 		m.sameSite = cfg.SameSite
 	}
@@ -94,214 +607,577 @@ func NewManager(cfg Config) *Manager {
 		m.secure = &secure
 	}
 
-	go m.cleanupWorker()
+	// A store shared with another Manager keeps its leeway unless this one
+	// sets its own.
+	if m.expiryLeeway > 0 {
+		for _, store := range append([]Store{m.store}, m.cleanupStores...) {
+			setExpiryLeeway(store, m.expiryLeeway)
+		}
+	}
+
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	if m.cleanup > 0 {
+		m.workerDone = make(chan struct{})
+		go m.cleanupWorker()
+	}
 
 	return m
 }
 
 func (m *Manager) cleanupWorker() {
-	ticker := time.NewTicker(m.cleanup)
-	defer ticker.Stop()
+	defer close(m.workerDone)
+
+	// A timer rather than a ticker, so that each run (including the first)
+	// can be jittered independently.
+	timer := time.NewTimer(m.nextCleanup())
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			_ = m.store.Cleanup(ctx)
+		case <-timer.C:
+			ctx, cancel := context.WithTimeout(m.ctx, m.cleanupTimeout)
+			_ = m.cleanupAll(ctx)
 			cancel()
-		case <-m.stopChan:
+			timer.Reset(m.nextCleanup())
+		case <-m.ctx.Done():
 			return
 		}
 	}
 }
 
+// nextCleanup returns the delay before the next cleanup run: the cleanup
+// interval shifted by a random offset within ±cleanupJitter.
+func (m *Manager) nextCleanup() time.Duration {
+	if m.cleanupJitter <= 0 {
+		return m.cleanup
+	}
+	rng, err := getRNG()
+	if err != nil {
+		return m.cleanup
+	}
+	offset := time.Duration(rng.Int64N(int64(2*m.cleanupJitter)+1)) - m.cleanupJitter
+	rngPool.Put(rng)
+	return m.cleanup + offset
+}
+
+// Cleanup removes expired sessions from the store and the CleanupStores.
+// The background worker calls it periodically; it can also be called on
+// demand, e.g. after a mass logout, from an admin endpoint, or from a
+// scheduled job when the worker is disabled. It is safe to call while the
+// worker is running. The errors of the stores that failed are joined.
+func (m *Manager) Cleanup(ctx context.Context) error {
+	return m.cleanupAll(ctx)
+}
+
+// cleanupAll cleans up every store, logging each failure, so that one
+// failing store neither stops the others nor goes unnoticed when the
+// worker drops the error.
+func (m *Manager) cleanupAll(ctx context.Context) error {
+	var errs []error
+	for i, store := range append([]Store{m.store}, m.cleanupStores...) {
+		if err := m.storeCleanup(ctx, store); err != nil {
+			slog.WarnContext(ctx, "dbsession: cleanup failed", "store", i, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Ping checks that the session store is reachable, e.g. for a readiness
+// probe.
+func (m *Manager) Ping(ctx context.Context) error {
+	return m.storePing(ctx)
+}
+
+// Close stops the cleanup worker, aborting a cleanup in progress, waits for
+// it to exit and then closes the store, returning any error from doing so.
+// The wait is bounded by closeWait; use CloseContext to choose the bound.
+// Calling Close again returns the same result.
 func (m *Manager) Close() error {
-	close(m.stopChan)
-	return m.store.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), closeWait)
+	defer cancel()
+	return m.CloseContext(ctx)
 }
 
+// CloseContext is like Close but waits for the cleanup worker only until ctx
+// is done, so a cleanup stuck in a store that ignores cancellation cannot
+// hang shutdown. In that case the store is closed anyway, and the context's
+// error is returned along with any error from closing the store.
+func (m *Manager) CloseContext(ctx context.Context) error {
+	m.cancel()
+
+	var waitErr error
+	if m.workerDone != nil {
+		select {
+		case <-m.workerDone:
+		case <-ctx.Done():
+			waitErr = fmt.Errorf("cleanup worker did not stop: %w", ctx.Err())
+		}
+	}
+
+	m.closeOnce.Do(func() {
+		m.closeErr = m.store.Close()
+	})
+	return errors.Join(waitErr, m.closeErr)
+}
+
+// Get returns the session for the request, or a new session if the request
+// has no valid session. Errors are only returned for store failures, unless
+// Config.DegradeToNewSession is set, or with Config.RequireSession when
+// there is no valid session.
+// Use Load to find out why no existing session was returned.
 func (m *Manager) Get(r *http.Request) (*Session, error) {
-	cookie, err := r.Cookie(m.cookie)
-	if err != nil {
-		return m.New(), nil
+	session, err := m.get(r)
+	if err != nil && m.degrade && DefaultRetryable(err) {
+		slog.WarnContext(r.Context(), "dbsession: store unavailable, issuing a new session", "error", err)
+		if session, err = m.New(); err != nil {
+			return nil, err
+		}
+		session.degraded = true
+		return session, nil
 	}
+	return session, err
+}
 
-	// Input validation: Ensure the session ID matches our expected format (32 hex characters).
-	// This prevents invalid or malicious keys from reaching the backend store.
-	if !isValidID(cookie.Value) {
-		return m.New(), nil
+// get implements Get.
+func (m *Manager) get(r *http.Request) (*Session, error) {
+	session, err := m.Load(r)
+	if errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrSessionExpired) {
+		restored, rerr := m.restoreRemembered(r)
+		if rerr != nil {
+			return nil, rerr
+		}
+		if restored != nil {
+			return restored, nil
+		}
+		if m.requireSession {
+			return nil, err
+		}
+		return m.New()
 	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Load returns the existing session for the request. Unlike Get, it does not
+// create a new session: it returns ErrSessionNotFound when there is no valid
+// session and ErrSessionExpired when the session has expired, so callers can
+// tell a first visit apart from a timed-out login. A session that exceeded
+// Config.IdleTimeout is reported as ErrSessionIdle, which wraps
+// ErrSessionExpired.
+func (m *Manager) Load(r *http.Request) (*Session, error) {
+	return m.load(r, true)
+}
 
-	session, err := m.store.Get(r.Context(), cookie.Value)
+// Peek is like Load but leaves the session untouched: it neither records
+// the access in LastAccessedAt nor writes it back with TouchInterval, so a
+// probe such as "is this user logged in?" does not count as activity
+// against IdleTimeout. The session is validated as by Load. A session past
+// IdleTimeout is still deleted, as it is no longer valid.
+func (m *Manager) Peek(r *http.Request) (*Session, error) {
+	return m.load(r, false)
+}
+
+// load implements Load and Peek, recording the access if touch is set.
+func (m *Manager) load(r *http.Request, touch bool) (*Session, error) {
+	token, fallback := m.requestID(r)
+	session, err := m.loadClientToken(r.Context(), token)
 	if err != nil {
 		return nil, err
 	}
 
-	if session == nil {
-		return m.New(), nil
+	// Security: Reject sessions presented from a different client than the one
+	// they were bound to, to mitigate replay of stolen cookies.
+	if !m.clientMatches(session, r) {
+		return nil, ErrSessionNotFound
+	}
+
+	if !touch {
+		return session, nil
 	}
+	m.touch(r.Context(), session)
 
-	// Security: Enforce expiration check at the Manager level.
-	// Some stores (like Memcached) might rely on lazy expiration or external TTLs,
-	// which can be unreliable or bypassed. We must ensure we never return an expired session.
-	if session.ExpiresAt.Before(time.Now()) {
-		return m.New(), nil
+	if fallback != "" {
+		// Save moves the session to the current cookie name.
+		session.legacyCookie = fallback
+		session.modified = true
 	}
 
 	return session, nil
 }
 
-func (m *Manager) Save(w http.ResponseWriter, r *http.Request, s *Session) error {
-	// Acquire lock to prevent race conditions with concurrent Session.Set/Delete calls.
-	// This ensures that s.Values and s.encoded are accessed consistently.
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if !isValidID(s.ID) {
-		return ErrInvalidSessionID
+// touch records that session was accessed now. The new LastAccessedAt is
+// persisted with the next Save; with TouchInterval set, it is also written
+// immediately if the stored value is older than the interval. That write is
+// best-effort: a failure does not fail the request.
+func (m *Manager) touch(ctx context.Context, session *Session) {
+	now := m.clock.Now()
+	stale := m.touchInterval > 0 && now.Sub(session.LastAccessedAt) >= m.touchInterval
+	session.LastAccessedAt = now
+	if stale {
+		_ = m.storeSave(ctx, session)
 	}
+}
 
-	s.ExpiresAt = time.Now().Add(m.ttl)
-
-	// Check session size if limit is configured
-	// Optimization: Skip encoding if the session is empty.
-	// This saves allocations and CPU cycles for new/empty sessions.
-	if m.maxSessionBytes > 0 && len(s.Values) > 0 {
-		buf := bufferPool.Get().(*bytes.Buffer)
-		buf.Reset()
-		defer PutBuffer(buf)
-
-		if err := gob.NewEncoder(buf).Encode(s.Values); err != nil {
-			return err
-		}
+// Save persists the session and sets its cookie. The cookie is a response
+// header, so Save must be called before the handler writes the response
+// headers or body; streaming handlers can use Middleware, which saves
+// modified sessions just before the headers are sent.
+func (m *Manager) Save(w http.ResponseWriter, r *http.Request, s *Session) error {
+	return m.SaveWithOptions(w, r, s, CookieOptions{})
+}
 
-		if buf.Len() > m.maxSessionBytes {
-			return ErrSessionTooLarge
-		}
+// CookieOptions override the Manager's session cookie attributes for a
+// single response. Zero fields keep the configured behavior.
+type CookieOptions struct {
+	// SameSite overrides Config.SameSite, e.g. http.SameSiteNoneMode for a
+	// response loaded in a cross-site iframe.
+	SameSite http.SameSite
+	// Secure overrides Config.Secure and TLS detection.
+	Secure *bool
+	// MaxAge overrides the cookie lifetime in seconds; a negative value makes
+	// it a browser-session cookie. The stored session still expires after
+	// the TTL.
+	MaxAge int
+}
 
-		// Optimization: Store the encoded data in the session so the store doesn't have to re-encode it.
-		// Note: We use the buffer's bytes directly. The Store must consume it before we return from Save.
-		// Since store.Save is synchronous, this is safe, provided we clear s.encoded before returning.
-		s.encoded = buf.Bytes()
+// SaveWithOptions is like Save but sets the session cookie with opts,
+// without changing the defaults for other responses. As for Config,
+// SameSite=None requires a Secure cookie: when opts override SameSite or
+// Secure, SaveWithOptions checks it before saving anything.
+func (m *Manager) SaveWithOptions(w http.ResponseWriter, r *http.Request, s *Session, opts CookieOptions) error {
+	if headersWritten(w) {
+		return ErrHeadersWritten
 	}
 
-	err := m.store.Save(r.Context(), s)
-	s.encoded = nil // Clear the cache to prevent use-after-free if buffer is reused
+	secure, sameSite, err := m.cookieSecurity(r, opts)
+	if err != nil {
+		return err
+	}
+	value, err := m.clientToken(s)
 	if err != nil {
 		return err
 	}
+	if err := m.persist(r, s); err != nil {
+		return err
+	}
 
-	secure := r.TLS != nil
-	if m.secure != nil {
-		secure = *m.secure
-	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     m.cookie,
-		Value:    s.ID,
-		Path:     m.cookiePath,
-		Domain:   m.cookieDomain,
-		Expires:  s.ExpiresAt,
-		MaxAge:   int(m.ttl.Seconds()),
-		HttpOnly: m.httpOnly,
-		Secure:   secure,
-		SameSite: m.sameSite,
-	})
+	// A value rather than a pointer, so that it stays on the stack: Save of
+	// an unchanged empty session allocates only the header value.
+	cookie := m.sessionCookie(s, value, secure, sameSite, opts)
+	m.setCookie(w, &cookie)
+
+	// A session loaded from a fallback cookie now has its cookie under the
+	// current name.
+	s.mu.Lock()
+	legacy := s.legacyCookie
+	s.legacyCookie = ""
+	s.mu.Unlock()
+	if legacy != "" {
+		m.expireCookie(w, r, legacy)
+	}
 
+	m.deliverRemember(w, r, s)
 	return nil
 }
 
-// Regenerate regenerates the session ID to prevent session fixation attacks.
-// It creates a new session ID, saves the session with the new ID,
-// and removes the old session from the store.
-func (m *Manager) Regenerate(w http.ResponseWriter, r *http.Request, s *Session) error {
-	oldID := s.ID
-	newID, err := generateID()
+// SessionCookie returns the session cookie Save would set for r, with the
+// configured attributes and after Config.CookieMutator, without writing it,
+// e.g. for a framework that emits its own Set-Cookie headers. Call it after
+// the session is saved, e.g. with SaveToken, as a persistent cookie expires
+// with the session. Unparsed attributes added by the mutator are not written
+// by http.SetCookie. Unlike Save, it neither deletes a fallback cookie nor
+// delivers a rotated remember token.
+func (m *Manager) SessionCookie(r *http.Request, s *Session) (*http.Cookie, error) {
+	secure, sameSite, err := m.cookieSecurity(r, CookieOptions{})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	value, err := m.clientToken(s)
+	if err != nil {
+		return nil, err
+	}
+	cookie := m.sessionCookie(s, value, secure, sameSite, CookieOptions{})
+	if m.cookieMutator != nil {
+		m.cookieMutator(&cookie)
 	}
-	s.ID = newID
+	return &cookie, nil
+}
 
-	if err := m.Save(w, r, s); err != nil {
-		s.ID = oldID // Restore old ID on failure
-		return err
+// cookieSecurity returns the Secure and SameSite attributes of the session
+// cookie for r, checking that they are compatible with each other and with
+// the cookie name prefix and partitioning.
+func (m *Manager) cookieSecurity(r *http.Request, opts CookieOptions) (bool, http.SameSite, error) {
+	secure := m.isSecure(r)
+	if opts.Secure != nil {
+		secure = *opts.Secure
+	}
+	sameSite := m.sameSite
+	if opts.SameSite != 0 {
+		sameSite = opts.SameSite
+	}
+	overridden := opts.Secure != nil || opts.SameSite != 0
+	if overridden && sameSite == http.SameSiteNoneMode && !secure {
+		return false, 0, errors.New("dbsession: SameSite=None requires a Secure cookie")
+	}
+	if m.securePrefix && !secure {
+		return false, 0, errors.New("dbsession: a __Secure- cookie requires Secure")
+	}
+	if m.partitioned && (sameSite != http.SameSiteNoneMode || !secure) {
+		return false, 0, errors.New("dbsession: a Partitioned cookie requires SameSite=None and Secure")
 	}
+	return secure, sameSite, nil
+}
 
-	if err := m.store.Delete(r.Context(), oldID); err != nil {
-		// Security: If we fail to delete the old session, we must return an error.
-		// Failing to do so leaves the old session ID valid, which could be used
-		// in a session fixation attack. We must "fail closed" here.
+// sessionCookie builds the session cookie carrying value.
+func (m *Manager) sessionCookie(s *Session, value string, secure bool, sameSite http.SameSite, opts CookieOptions) http.Cookie {
+	cookie := http.Cookie{
+		Name:        m.cookie,
+		Value:       value,
+		Path:        m.cookiePath,
+		Domain:      m.cookieDomain,
+		HttpOnly:    m.httpOnly,
+		Secure:      secure,
+		SameSite:    sameSite,
+		Partitioned: m.partitioned,
+	}
+	// Without Expires and MaxAge the browser drops the cookie when it closes;
+	// the store entry still expires after the TTL.
+	switch {
+	case opts.MaxAge > 0:
+		cookie.Expires = m.clock.Now().Add(time.Duration(opts.MaxAge) * time.Second)
+		cookie.MaxAge = opts.MaxAge
+	case opts.MaxAge == 0 && m.persistent && m.cookieTTL > 0:
+		cookie.Expires = m.clock.Now().Add(m.cookieTTL)
+		cookie.MaxAge = int(m.cookieTTL.Seconds())
+	case opts.MaxAge == 0 && m.persistent:
+		s.mu.RLock()
+		cookie.Expires = s.ExpiresAt
+		cookie.MaxAge = int(m.ttlLocked(s).Seconds())
+		s.mu.RUnlock()
+	}
+	return cookie
+}
 
-		// Attempt to cleanup the new session we just created
-		_ = m.store.Delete(r.Context(), newID)
+// persist binds the session to the requesting client and saves it.
+func (m *Manager) persist(r *http.Request, s *Session) error {
+	// Acquire lock to prevent race conditions with concurrent Session.Set/Delete calls.
+	// This ensures that s.Values and s.encoded are accessed consistently.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m.bindClient(s, r)
+	return m.persistLocked(r.Context(), s)
+}
+
+// isSecure reports whether cookies for this request should carry the Secure
+// attribute. An explicit Config.Secure takes precedence over detection.
+func (m *Manager) isSecure(r *http.Request) bool {
+	if m.secure != nil {
+		return *m.secure
+	}
+	if r.TLS != nil {
+		return true
+	}
+	return m.trustForwarded && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// Regenerate regenerates the session ID to prevent session fixation attacks.
+// It creates a new session ID, saves the session with the new ID,
+// and removes the old session from the store, as RegenerateID does, and sets
+// the cookie for the new ID. If the old session cannot be removed, it
+// clears the cookie instead.
+func (m *Manager) Regenerate(w http.ResponseWriter, r *http.Request, s *Session) error {
+	if headersWritten(w) {
+		return ErrHeadersWritten
+	}
+	if m.tokenAEAD != nil {
+		return m.rotateToken(w, r, s)
+	}
 
+	saved := false
+	_, err := m.regenerate(r.Context(), s, func() error {
+		if err := m.Save(w, r, s); err != nil {
+			return err
+		}
+		saved = true
+		return nil
+	})
+	if err != nil && saved {
 		// Force logout by clearing the cookie.
 		// This ensures the client is not left with a valid session (newID)
 		// while the old session (oldID) might still be valid in the store.
-		secure := r.TLS != nil
-		if m.secure != nil {
-			secure = *m.secure
-		}
+		m.expireCookie(w, r, m.cookie)
+	}
+	return err
+}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     m.cookie,
-			Value:    "",
-			Path:     m.cookiePath,
-			Domain:   m.cookieDomain,
-			MaxAge:   -1,
-			HttpOnly: m.httpOnly,
-			Secure:   secure,
-			SameSite: m.sameSite,
-		})
+// Cookie name prefixes that browsers enforce attribute requirements for.
+const (
+	securePrefix = "__Secure-"
+	hostPrefix   = "__Host-"
+)
 
-		return err
+// cookieName derives a cookie's name from its configured name, so that
+// every path reading or writing the cookie agrees on it.
+func cookieName(name string, useSecurePrefix bool) string {
+	if useSecurePrefix {
+		return securePrefix + name
 	}
+	return name
+}
 
-	return nil
+// hasCookiePrefix reports whether name already carries a browser-enforced
+// prefix.
+func hasCookiePrefix(name string) bool {
+	return strings.HasPrefix(name, securePrefix) || strings.HasPrefix(name, hostPrefix)
 }
 
+// setCookie adds a Set-Cookie header for cookie, after passing it to
+// Config.CookieMutator. Unlike http.SetCookie, it also writes the cookie's
+// Unparsed attributes, skipping any that would break the header.
+func (m *Manager) setCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	if m.cookieMutator == nil {
+		http.SetCookie(w, cookie)
+		return
+	}
+	// The mutator gets a copy, so that cookie does not escape to the heap
+	// when there is none.
+	c := *cookie
+	m.cookieMutator(&c)
+	v := c.String()
+	if v == "" {
+		return
+	}
+	for _, attr := range c.Unparsed {
+		if validCookieAttr(attr) {
+			v += "; " + attr
+		}
+	}
+	w.Header().Add("Set-Cookie", v)
+}
+
+// validCookieAttr reports whether attr can be appended to a Set-Cookie
+// header as an attribute: it is non-empty printable ASCII without ';'.
+func validCookieAttr(attr string) bool {
+	if attr == "" {
+		return false
+	}
+	for i := 0; i < len(attr); i++ {
+		if c := attr[i]; c < 0x20 || c >= 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// expireCookie sets a cookie that deletes the cookie with the given name.
+func (m *Manager) expireCookie(w http.ResponseWriter, r *http.Request, name string) {
+	m.setCookie(w, &http.Cookie{
+		Name:        name,
+		Value:       "",
+		Path:        m.cookiePath,
+		Domain:      m.cookieDomain,
+		MaxAge:      -1,
+		HttpOnly:    m.httpOnly,
+		Secure:      m.isSecure(r),
+		SameSite:    m.sameSite,
+		Partitioned: m.partitioned,
+	})
+}
+
+// Destroy deletes the session from the store, wipes its values and clears
+// the session and remember-me cookies. It is idempotent: destroying a
+// session again, e.g. on a double-submitted logout, only clears the cookies.
+// A destroyed session cannot be saved or regenerated; if the deletion
+// fails, Destroy can be retried.
 func (m *Manager) Destroy(w http.ResponseWriter, r *http.Request, s *Session) error {
 	// Always clear the cookie, even if store deletion fails.
 	// This ensures the client side is logged out ("fail safe" for the user).
-	secure := r.TLS != nil
-	if m.secure != nil {
-		secure = *m.secure
-	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     m.cookie,
-		Value:    "",
-		Path:     m.cookiePath,
-		Domain:   m.cookieDomain,
-		MaxAge:   -1,
-		HttpOnly: m.httpOnly,
-		Secure:   secure,
-		SameSite: m.sameSite,
-	})
+	m.expireCookie(w, r, m.cookie)
+	for _, name := range m.fallbackCookies {
+		if _, err := r.Cookie(name); err == nil {
+			m.expireCookie(w, r, name)
+		}
+	}
 
 	// Security: Clear the session values from memory regardless of whether
 	// the store deletion succeeds or fails. This ensures sensitive data
 	// is wiped from memory (Defense in Depth).
-	defer s.Clear()
+	defer s.wipe()
 
-	if err := m.store.Delete(r.Context(), s.ID); err != nil {
+	if err := m.ClearRemember(w, r); err != nil {
 		return err
 	}
 
+	// The lock is not held while deleting, as OnDestroy may use the session.
+	s.mu.RLock()
+	id, destroyed := s.ID, s.destroyed
+	s.mu.RUnlock()
+	if destroyed {
+		return nil
+	}
+	if err := m.DeleteSession(r.Context(), id); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.destroyed = true
+	s.mu.Unlock()
+	return nil
+}
+
+// Login authenticates the session: it calls fn to record the login, e.g. by
+// setting OwnerID and the user's values, then regenerates the session ID and
+// saves the session, so that an ID planted before login (session fixation)
+// never becomes authenticated. If Login fails, the changes made by fn to
+// Values, OwnerID and Metadata are rolled back, so the session can still be
+// used anonymously.
+func (m *Manager) Login(w http.ResponseWriter, r *http.Request, s *Session, fn func(*Session)) error {
+	s.mu.RLock()
+	values, owner, metadata := cloneValues(s.Values), s.OwnerID, maps.Clone(s.Metadata)
+	s.mu.RUnlock()
+
+	fn(s)
+	if err := m.Regenerate(w, r, s); err != nil {
+		s.mu.Lock()
+		s.Values, s.OwnerID, s.Metadata = values, owner, metadata
+		s.encoded = nil
+		s.mu.Unlock()
+		return err
+	}
 	return nil
 }
 
-func (m *Manager) New() *Session {
-	id, err := generateID()
+// Logout is Destroy, the counterpart of Login.
+func (m *Manager) Logout(w http.ResponseWriter, r *http.Request, s *Session) error {
+	return m.Destroy(w, r, s)
+}
+
+// New creates a new, unsaved session. It fails only if a session ID cannot
+// be generated because the system's random source is unavailable.
+func (m *Manager) New() (*Session, error) {
+	id, err := m.newID()
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to generate session id: %w", err)
 	}
-	return &Session{
-		ID:        id,
-		Values:    make(map[string]any),
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(m.ttl),
+	now := m.clock.Now()
+	s := &Session{
+		ID:             id,
+		Values:         make(map[string]any),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(m.ttl),
+		LastAccessedAt: now,
+		isNew:          true,
 	}
+	if m.metrics != nil {
+		m.metrics.SessionCreated()
+	}
+	if m.onCreate != nil {
+		m.onCreate(s)
+	}
+	return s, nil
 }
 
 // rngPool reuses *math/rand/v2.Rand instances to amortize the cost of
@@ -309,6 +1185,20 @@ func (m *Manager) New() *Session {
 // for ID generation.
 var rngPool = sync.Pool{}
 
+// getRNG returns a generator from rngPool. The caller must return it with
+// rngPool.Put.
+func getRNG() (*mrand.Rand, error) {
+	if v := rngPool.Get(); v != nil {
+		return v.(*mrand.Rand), nil
+	}
+	// First time use or pool is empty: seed a new generator from crypto/rand.
+	var seed [32]byte
+	if _, err := io.ReadFull(rand.Reader, seed[:]); err != nil {
+		return nil, err
+	}
+	return mrand.New(mrand.NewChaCha8(seed)), nil
+}
+
 func generateID() (string, error) {
 	ptr := idBufferPool.Get().(*[]byte)
 	b := *ptr
@@ -317,19 +1207,11 @@ func generateID() (string, error) {
 	entropy := b[:16]
 
 	// Retrieve a seeded generator from the pool.
-	v := rngPool.Get()
-	var rng *mrand.Rand
-	if v == nil {
-		// First time use or pool is empty: seed a new generator from crypto/rand.
-		var seed [32]byte
-		if _, err := io.ReadFull(rand.Reader, seed[:]); err != nil {
-			clear(b)
-			idBufferPool.Put(ptr)
-			return "", err
-		}
-		rng = mrand.New(mrand.NewChaCha8(seed))
-	} else {
-		rng = v.(*mrand.Rand)
+	rng, err := getRNG()
+	if err != nil {
+		clear(b)
+		idBufferPool.Put(ptr)
+		return "", err
 	}
 
 	// Read 16 bytes (128 bits) of randomness.
@@ -364,17 +1246,36 @@ func init() {
 	}
 }
 
+// isValidID reports whether id is a session ID in one of the IDEncoding
+// formats. The formats have different lengths, so the length selects the
+// charset to check.
+//
+// It returns early on the first invalid character. That is fine: it checks
+// the format of an ID, which reveals nothing about valid IDs, and the ID is
+// then found by an exact store lookup rather than compared against a
+// secret. Comparisons of client input with a secret value (CSRF tokens,
+// User-Agent bindings, client token generations) must instead be constant
+// time, using crypto/subtle or hmac.Equal, so that timing does not reveal
+// how much of the secret was guessed.
 func isValidID(id string) bool {
-	if len(id) != 32 {
-		return false
-	}
-	// Optimization: Iterate exactly 32 times. Since we verified len(id) == 32,
-	// the compiler can eliminate bounds checks for id[i] inside the loop.
-	for i := 0; i < 32; i++ {
-		// Lookup table is faster than multiple comparisons
-		if !validIDChars[id[i]] {
-			return false
+	switch len(id) {
+	case hexIDLen:
+		// Optimization: Iterate exactly 32 times. Since we verified the
+		// length, the compiler can eliminate bounds checks for id[i].
+		for i := 0; i < hexIDLen; i++ {
+			// Lookup table is faster than multiple comparisons
+			if !validIDChars[id[i]] {
+				return false
+			}
+		}
+		return true
+	case base62IDLen:
+		for i := 0; i < base62IDLen; i++ {
+			if !validBase62Chars[id[i]] {
+				return false
+			}
 		}
+		return true
 	}
-	return true
+	return false
 }
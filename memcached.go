@@ -4,28 +4,98 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 )
 
+// memcachedMaxRelativeExpiration is the longest expiration Memcached accepts
+// as a number of seconds from now.
+const memcachedMaxRelativeExpiration = 30 * 24 * time.Hour
+
 // MemcachedStore implements the Store interface using Memcached.
 type MemcachedStore struct {
 	client          *memcache.Client
 	ttl             time.Duration
 	maxSessionBytes int
+	maxDecodedBytes int
+	clock           Clock // Time source for expirations; replaceable in tests
+	codec           Codec
+	keyPrefix       string
+	chunkSize       int // Items larger than this are chunked; 0 disables chunking
+
+	expiryLeeway // See ExpiryLeewaySetter
 }
 
 // MemcachedConfig holds configuration for the Memcached store.
 type MemcachedConfig struct {
-	Servers         []string
+	Servers []string
+	// WeightedServers, if set, is used instead of Servers for servers of
+	// different sizes: each server receives a share of the keys
+	// proportional to its weight.
+	WeightedServers []MemcachedServer
+	// Selector, if set, picks the server for each key instead of the
+	// default hashing over Servers, e.g. a consistent-hash selector, so that
+	// adding a server moves few sessions.
+	Selector memcache.ServerSelector
+	// Client, if set, is used instead of creating a client for Servers, e.g.
+	// one configured with custom MaxIdleConns. Timeout is not applied to it.
+	Client          *memcache.Client
 	TTL             time.Duration
 	MaxSessionBytes int
-	Timeout         time.Duration // Timeout for Memcached operations. Defaults to 0 (no timeout) if not set.
+	// Timeout for Memcached operations. Defaults to 0 (no timeout) if not
+	// set. Operations also return when their context is done, but a call
+	// abandoned that way keeps running in the background for up to Timeout.
+	Timeout time.Duration
+	// Codec encodes session values. Defaults to GobCodec.
+	Codec Codec
+	// MaxDecodedBytes limits the size of a session's data once decoded by
+	// the codec, e.g. decompressed, guarding against small blobs that
+	// expand into gigabytes. It is enforced while decoding by codecs
+	// implementing LimitedDecoder. 0 means unlimited.
+	MaxDecodedBytes int
+	// KeyPrefix is prepended to session IDs to form Memcached keys, so that
+	// several environments or applications can share a cluster without
+	// seeing each other's sessions. It is not part of the cookie value.
+	KeyPrefix string
+	// ChunkSize is the largest item the store writes. A larger session is
+	// split across several items and reassembled on Get, as Memcached
+	// rejects items over its item size limit (1 MiB by default). Defaults
+	// to just under 1 MiB; a negative value disables chunking. MaxSessionBytes
+	// applies to the total size before chunking.
+	ChunkSize int
+}
+
+// MemcachedServer is a Memcached server with a weight, see
+// MemcachedConfig.WeightedServers.
+type MemcachedServer struct {
+	Addr string
+	// Weight is the server's share of keys relative to the other servers,
+	// e.g. its memory size in GiB. Servers with a weight below 1 get none.
+	Weight int
 }
 
-// NewMemcachedStore creates a new MemcachedStore.
+// weightedServerList returns a selector over servers that picks each server
+// in proportion to its weight, by listing it Weight times: the default
+// selector hashes keys uniformly over its list. Unresolvable servers are
+// ignored, as memcache.New does.
+func weightedServerList(servers []MemcachedServer) *memcache.ServerList {
+	var addrs []string
+	for _, s := range servers {
+		for range s.Weight {
+			addrs = append(addrs, s.Addr)
+		}
+	}
+	ss := new(memcache.ServerList)
+	_ = ss.SetServers(addrs...)
+	return ss
+}
+
+// NewMemcachedStore creates a new MemcachedStore. The servers are not
+// validated; see NewMemcachedStoreE.
 func NewMemcachedStore(ttl time.Duration, servers ...string) *MemcachedStore {
 	return NewMemcachedStoreWithConfig(MemcachedConfig{
 		Servers: servers,
@@ -36,99 +106,321 @@ func NewMemcachedStore(ttl time.Duration, servers ...string) *MemcachedStore {
 	})
 }
 
+// NewMemcachedStoreWithClient creates a new MemcachedStore using a pre-built client.
+// The client is shared, so Close leaves it untouched.
+func NewMemcachedStoreWithClient(client *memcache.Client, ttl time.Duration) *MemcachedStore {
+	return NewMemcachedStoreWithConfig(MemcachedConfig{
+		Client: client,
+		TTL:    ttl,
+	})
+}
+
 // NewMemcachedStoreWithConfig creates a new MemcachedStore with custom configuration.
 func NewMemcachedStoreWithConfig(cfg MemcachedConfig) *MemcachedStore {
-	client := memcache.New(cfg.Servers...)
-	client.Timeout = cfg.Timeout
+	client := cfg.Client
+	if client == nil {
+		switch {
+		case cfg.Selector != nil:
+			client = memcache.NewFromSelector(cfg.Selector)
+		case len(cfg.WeightedServers) > 0:
+			client = memcache.NewFromSelector(weightedServerList(cfg.WeightedServers))
+		default:
+			client = memcache.New(cfg.Servers...)
+		}
+		client.Timeout = cfg.Timeout
+	}
 
-	return &MemcachedStore{
+	store := &MemcachedStore{
 		client:          client,
 		ttl:             cfg.TTL,
 		maxSessionBytes: cfg.MaxSessionBytes,
+		maxDecodedBytes: cfg.MaxDecodedBytes,
+		clock:           systemClock{},
+		keyPrefix:       cfg.KeyPrefix,
+		codec:           cfg.Codec,
+		chunkSize:       cfg.ChunkSize,
 	}
+	if store.chunkSize == 0 {
+		store.chunkSize = defaultChunkSize
+	} else if store.chunkSize < 0 {
+		store.chunkSize = 0
+	}
+	if store.codec == nil {
+		store.codec = GobCodec{}
+	}
+	return store
 }
 
+// NewMemcachedStoreE is like NewMemcachedStoreWithConfig but validates cfg
+// first, returning an error if no server is configured or an address
+// cannot be resolved, instead of a store whose every operation fails.
+func NewMemcachedStoreE(cfg MemcachedConfig) (*MemcachedStore, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return NewMemcachedStoreWithConfig(cfg), nil
+}
+
+// validate reports the first problem found in cfg.
+func (cfg *MemcachedConfig) validate() error {
+	if cfg.Client != nil || cfg.Selector != nil {
+		return nil
+	}
+	var addrs []string
+	if len(cfg.WeightedServers) > 0 {
+		for _, server := range cfg.WeightedServers {
+			if server.Weight <= 0 {
+				return fmt.Errorf("dbsession: memcached server %q has weight %d, must be positive", server.Addr, server.Weight)
+			}
+			addrs = append(addrs, server.Addr)
+		}
+	} else {
+		addrs = cfg.Servers
+	}
+	if len(addrs) == 0 {
+		return errors.New("dbsession: no memcached servers configured")
+	}
+	// memcache.New ignores resolution errors, leaving the address unusable.
+	if err := new(memcache.ServerList).SetServers(addrs...); err != nil {
+		return fmt.Errorf("dbsession: invalid memcached server: %w", err)
+	}
+	return nil
+}
+
+// sessionEnvelope is the Memcached item format. Values are encoded
+// separately into Data, the same bytes the SQL stores keep in their data
+// column, so the Manager's pre-encoding can be reused instead of encoding
+// the values a second time.
 type sessionEnvelope struct {
-	Values    map[string]any
-	CreatedAt time.Time
-	ExpiresAt time.Time
+	Values          map[string]any // Written by earlier versions only; superseded by Data
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+	LastAccessedAt  time.Time
+	RegenerateCount int
+	// Version is carried along, not enforced: Memcached does no optimistic
+	// locking, but a TieredStore must hand the durable store's version back.
+	Version  int
+	OwnerID  string
+	Metadata map[string]string
+	// Data holds the values encoded with the store's codec.
+	Data []byte
 }
 
-// Get retrieves a session from Memcached.
+// Get retrieves a session from Memcached. A session past its ExpiresAt is
+// deleted and reported as not found.
 func (s *MemcachedStore) Get(ctx context.Context, id string) (*Session, error) {
-	item, err := s.client.Get(id)
-	if err == memcache.ErrCacheMiss {
+	value, err := memcachedValue(ctx, func() ([]byte, error) {
+		item, err := s.client.Get(s.keyPrefix + id)
+		if err == memcache.ErrCacheMiss {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get from memcached: %w", err)
+		}
+		return s.assemble(item.Key, item.Value)
+	}, nil)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	session, err := s.decode(id, value)
+	if err != nil {
+		return nil, err
+	}
+	if s.expired(session) {
+		s.deleteExpired(ctx, id)
 		return nil, nil
 	}
+	return session, nil
+}
+
+// expired reports whether session is past its ExpiresAt. Memcached's own
+// expiration may lag behind it, as relative expirations are whole seconds.
+func (s *MemcachedStore) expired(session *Session) bool {
+	return !session.ExpiresAt.IsZero() && session.ExpiresAt.Before(s.expiryCutoff(s.clock.Now()))
+}
+
+// deleteExpired deletes an expired session found by a read. A failure only
+// leaves the item to Memcached's own expiration, so it is not reported.
+func (s *MemcachedStore) deleteExpired(ctx context.Context, id string) {
+	_ = s.Delete(ctx, id)
+}
+
+// GetMulti retrieves several sessions in one round trip per server.
+// Sessions that do not exist or have expired are absent from the result.
+func (s *MemcachedStore) GetMulti(ctx context.Context, ids []string) (map[string]*Session, error) {
+	sessions := make(map[string]*Session, len(ids))
+	if len(ids) == 0 {
+		return sessions, nil
+	}
+
+	keys := ids
+	if s.keyPrefix != "" {
+		keys = make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = s.keyPrefix + id
+		}
+	}
+
+	values, err := memcachedValue(ctx, func() (map[string][]byte, error) {
+		items, err := s.client.GetMulti(keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get from memcached: %w", err)
+		}
+		values := make(map[string][]byte, len(items))
+		for key, item := range items {
+			value, err := s.assemble(key, item.Value)
+			if err != nil {
+				return nil, err
+			}
+			if value != nil {
+				values[key] = value
+			}
+		}
+		return values, nil
+	}, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get from memcached: %w", err)
+		return nil, err
+	}
+	for key, value := range values {
+		id := strings.TrimPrefix(key, s.keyPrefix)
+		session, err := s.decode(id, value)
+		if err != nil {
+			return nil, err
+		}
+		if s.expired(session) {
+			s.deleteExpired(ctx, id)
+			continue
+		}
+		sessions[id] = session
 	}
+	return sessions, nil
+}
 
-	if s.maxSessionBytes > 0 && len(item.Value) > s.maxSessionBytes {
-		return nil, ErrSessionTooLarge
+// decode decodes a stored session envelope.
+func (s *MemcachedStore) decode(id string, value []byte) (*Session, error) {
+	if s.maxSessionBytes > 0 && len(value) > s.maxSessionBytes {
+		return nil, &SessionTooLargeError{Size: len(value), Limit: s.maxSessionBytes}
 	}
 
 	var env sessionEnvelope
 
 	reader := readerPool.Get().(*bytes.Reader)
-	reader.Reset(item.Value)
+	reader.Reset(value)
 	defer readerPool.Put(reader)
 
 	if err := gob.NewDecoder(reader).Decode(&env); err != nil {
-		return nil, fmt.Errorf("failed to decode session data: %w", err)
+		return nil, fmt.Errorf("failed to decode session data: %w: %w", ErrCorruptSession, err)
 	}
 
+	if env.Data != nil {
+		values, err := decodeValues(s.codec, env.Data, int64(s.maxDecodedBytes))
+		if err != nil {
+			return nil, err
+		}
+		env.Values = values
+	}
 	if env.Values == nil {
 		env.Values = make(map[string]any)
 	}
 
 	return &Session{
-		ID:        id,
-		Values:    env.Values,
-		CreatedAt: env.CreatedAt,
-		ExpiresAt: env.ExpiresAt,
+		ID:              id,
+		Values:          env.Values,
+		CreatedAt:       env.CreatedAt,
+		ExpiresAt:       env.ExpiresAt,
+		LastAccessedAt:  env.LastAccessedAt,
+		RegenerateCount: env.RegenerateCount,
+		Version:         env.Version,
+		OwnerID:         env.OwnerID,
+		Metadata:        env.Metadata,
 	}, nil
 }
 
 // Save stores a session in Memcached.
 func (s *MemcachedStore) Save(ctx context.Context, session *Session) error {
 	buf := bufferPool.Get().(*bytes.Buffer)
-	buf.Reset()
-	defer PutBuffer(buf)
+	resetBuffer(buf)
+	// buf is released by the write below, which may outlive Save.
+	release := func() { PutBuffer(buf) }
+	written := false
+	defer func() {
+		if !written {
+			release()
+		}
+	}()
 
 	env := sessionEnvelope{
-		Values:    session.Values,
-		CreatedAt: session.CreatedAt,
-		ExpiresAt: session.ExpiresAt,
+		CreatedAt:       session.CreatedAt,
+		ExpiresAt:       session.ExpiresAt,
+		LastAccessedAt:  session.LastAccessedAt,
+		RegenerateCount: session.RegenerateCount,
+		Version:         session.Version,
+		OwnerID:         session.OwnerID,
+		Metadata:        session.Metadata,
+	}
+	if len(session.Values) > 0 {
+		data := bufferPool.Get().(*bytes.Buffer)
+		defer PutBuffer(data)
+		blob, err := encodeValues(s.codec, session, data)
+		if err != nil {
+			return err
+		}
+		env.Data = blob
 	}
 	if err := gob.NewEncoder(buf).Encode(env); err != nil {
-		return fmt.Errorf("failed to encode session data: %w", err)
+		return encodeError(err)
 	}
 
 	if s.maxSessionBytes > 0 && buf.Len() > s.maxSessionBytes {
-		return ErrSessionTooLarge
+		return &SessionTooLargeError{Size: buf.Len(), Limit: s.maxSessionBytes}
 	}
 
 	// Use specified TTL or calculate from session.ExpiresAt
 	var expiration int32
 	if !session.ExpiresAt.IsZero() {
-		diff := time.Until(session.ExpiresAt)
+		// The item outlives ExpiresAt by the leeway, like rows in the SQL stores.
+		expiresAt := session.ExpiresAt.Add(time.Duration(s.leeway.Load()))
+		diff := expiresAt.Sub(s.clock.Now())
 		if diff <= 0 {
 			return nil // Already expired
 		}
 		expiration = int32(diff.Seconds())
+		if diff > memcachedMaxRelativeExpiration {
+			// Memcached reads larger values as a Unix timestamp.
+			expiration = int32(expiresAt.Unix())
+		}
 	} else {
 		expiration = int32(s.ttl.Seconds())
 	}
 
-	err := s.client.Set(&memcache.Item{
-		Key:        session.ID,
-		Value:      buf.Bytes(),
-		Expiration: expiration,
-	})
+	key := s.keyPrefix + session.ID
+	written = true
+	return memcachedCall(ctx, func() error {
+		var err error
+		if s.chunkSize > 0 && buf.Len() > s.chunkSize {
+			err = s.setChunked(key, buf.Bytes(), expiration)
+		} else {
+			err = s.client.Set(&memcache.Item{
+				Key:        key,
+				Value:      buf.Bytes(),
+				Expiration: expiration,
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save to memcached: %w", err)
+		}
+		return nil
+	}, release)
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to save to memcached: %w", err)
+// SaveMulti saves several sessions. Memcached has no multi-set or
+// transactions, so sessions are written one by one and a failure leaves the
+// earlier ones saved.
+func (s *MemcachedStore) SaveMulti(ctx context.Context, sessions []*Session) error {
+	for _, session := range sessions {
+		if err := s.Save(ctx, session); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -139,11 +431,33 @@ func init() {
 
 // Delete removes a session from Memcached.
 func (s *MemcachedStore) Delete(ctx context.Context, id string) error {
-	err := s.client.Delete(id)
-	if err != nil && err != memcache.ErrCacheMiss {
-		return fmt.Errorf("failed to delete from memcached: %w", err)
+	key := s.keyPrefix + id
+	return memcachedCall(ctx, func() error {
+		if s.chunkSize > 0 {
+			if err := s.deleteChunks(key); err != nil {
+				return fmt.Errorf("failed to delete from memcached: %w", err)
+			}
+		}
+		err := s.client.Delete(key)
+		if err != nil && err != memcache.ErrCacheMiss {
+			return fmt.Errorf("failed to delete from memcached: %w", err)
+		}
+		return nil
+	}, nil)
+}
+
+// DeleteMulti removes the sessions with the given IDs, see MultiDeleter.
+// Memcached has no batch delete, so each session is deleted in turn; a
+// failure does not stop the others from being deleted, and the errors are
+// returned together.
+func (s *MemcachedStore) DeleteMulti(ctx context.Context, ids []string) error {
+	var errs []error
+	for _, id := range ids {
+		if err := s.Delete(ctx, id); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 // Cleanup is a no-op for Memcached as it handles expiration automatically.
@@ -151,6 +465,77 @@ func (s *MemcachedStore) Cleanup(ctx context.Context) error {
 	return nil
 }
 
+// pingKey is written and read back by Ping. It can never collide with a
+// session, as generated IDs contain no dots.
+const pingKey = "_dbsession.ping"
+
+// Ping checks that Memcached accepts writes and serves reads by setting and
+// getting a reserved key.
+func (s *MemcachedStore) Ping(ctx context.Context) error {
+	return memcachedCall(ctx, func() error {
+		if err := s.client.Set(&memcache.Item{Key: s.keyPrefix + pingKey, Value: []byte{1}, Expiration: 1}); err != nil {
+			return fmt.Errorf("failed to ping memcached: %w", err)
+		}
+		if _, err := s.client.Get(s.keyPrefix + pingKey); err != nil && err != memcache.ErrCacheMiss {
+			return fmt.Errorf("failed to ping memcached: %w", err)
+		}
+		return nil
+	}, nil)
+}
+
+// memcachedCall runs op, a call to the client, and returns ctx.Err() as soon
+// as ctx is done. gomemcache takes no context, so an abandoned op keeps
+// running in its goroutine until it completes, which the client's Timeout
+// bounds; its result is then dropped. release, if set, is called once op
+// has returned, so that memory op uses is not reused while it runs.
+func memcachedCall(ctx context.Context, op func() error, release func()) error {
+	_, err := memcachedValue(ctx, func() (struct{}, error) {
+		return struct{}{}, op()
+	}, release)
+	return err
+}
+
+// memcachedValue is like memcachedCall for an op that returns a value. The
+// value is handed back over the same channel as the error, so an abandoned
+// op never writes to memory the caller can still read.
+func memcachedValue[T any](ctx context.Context, op func() (T, error), release func()) (T, error) {
+	if release == nil {
+		release = func() {}
+	}
+	// A context that can never be canceled needs no goroutine.
+	if ctx.Done() == nil {
+		defer release()
+		return op()
+	}
+	var zero T
+	if err := ctx.Err(); err != nil {
+		release()
+		return zero, err
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer release()
+		value, err := op()
+		done <- result{value, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// Codec returns the codec session values are encoded with, see CodecStore.
+func (s *MemcachedStore) Codec() Codec {
+	return s.codec
+}
+
 // Close is a no-op for Memcached client.
 func (s *MemcachedStore) Close() error {
 	return nil
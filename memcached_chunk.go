@@ -0,0 +1,133 @@
+package dbsession
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// defaultChunkSize keeps each item under Memcached's default 1 MiB item
+// size limit, leaving room for the key and the item overhead.
+const defaultChunkSize = 1000 * 1024
+
+// chunkHeaderPrefix starts the value of an item that points to chunk items
+// instead of holding an envelope. A gob stream never starts with a zero byte.
+var chunkHeaderPrefix = []byte("\x00chunks:")
+
+// chunkHeader describes a value split across several items. The chunks of
+// each save get a new generation, so that a reader never mixes chunks of
+// two concurrent saves; chunks of earlier generations expire on their own.
+type chunkHeader struct {
+	gen   string
+	count int
+	size  int // Total size of the reassembled value
+}
+
+func (h chunkHeader) encode() []byte {
+	return fmt.Appendf(bytes.Clone(chunkHeaderPrefix), "%s:%d:%d", h.gen, h.count, h.size)
+}
+
+// parseChunkHeader reports whether value is a chunk header and parses it.
+func parseChunkHeader(value []byte) (chunkHeader, bool, error) {
+	rest, ok := bytes.CutPrefix(value, chunkHeaderPrefix)
+	if !ok {
+		return chunkHeader{}, false, nil
+	}
+	fields := strings.Split(string(rest), ":")
+	if len(fields) == 3 {
+		count, err1 := strconv.Atoi(fields[1])
+		size, err2 := strconv.Atoi(fields[2])
+		if err1 == nil && err2 == nil && count > 0 && size >= count {
+			return chunkHeader{gen: fields[0], count: count, size: size}, true, nil
+		}
+	}
+	return chunkHeader{}, true, fmt.Errorf("failed to decode session data: %w: malformed chunk header %q", ErrCorruptSession, rest)
+}
+
+// chunkKeys returns the keys of the chunks of the item at key.
+func (h chunkHeader) chunkKeys(key string) []string {
+	keys := make([]string, h.count)
+	for i := range keys {
+		keys[i] = key + ":" + h.gen + ":" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// setChunked stores value across chunk items, then points key to them.
+func (s *MemcachedStore) setChunked(key string, value []byte, expiration int32) error {
+	rng, err := getRNG()
+	if err != nil {
+		return err
+	}
+	gen := strconv.FormatUint(rng.Uint64(), 36)
+	rngPool.Put(rng)
+
+	header := chunkHeader{
+		gen:   gen,
+		count: (len(value) + s.chunkSize - 1) / s.chunkSize,
+		size:  len(value),
+	}
+	for i, chunkKey := range header.chunkKeys(key) {
+		chunk := value[i*s.chunkSize : min((i+1)*s.chunkSize, len(value))]
+		if err := s.client.Set(&memcache.Item{Key: chunkKey, Value: chunk, Expiration: expiration}); err != nil {
+			return err
+		}
+	}
+	// Written last, so readers only ever see complete sets of chunks.
+	return s.client.Set(&memcache.Item{Key: key, Value: header.encode(), Expiration: expiration})
+}
+
+// assemble returns the value of the item at key, reassembling it if it is a
+// chunk header. It returns nil if a chunk is missing, e.g. evicted, in which
+// case the session is lost as if the item itself had been evicted.
+func (s *MemcachedStore) assemble(key string, value []byte) ([]byte, error) {
+	header, ok, err := parseChunkHeader(value)
+	if !ok || err != nil {
+		return value, err
+	}
+	if s.maxSessionBytes > 0 && header.size > s.maxSessionBytes {
+		return nil, &SessionTooLargeError{Size: header.size, Limit: s.maxSessionBytes}
+	}
+
+	keys := header.chunkKeys(key)
+	items, err := s.client.GetMulti(keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get from memcached: %w", err)
+	}
+	out := make([]byte, 0, header.size)
+	for _, k := range keys {
+		item, ok := items[k]
+		if !ok {
+			return nil, nil
+		}
+		out = append(out, item.Value...)
+	}
+	if len(out) != header.size {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// deleteChunks removes the chunks the item at key points to, if any.
+func (s *MemcachedStore) deleteChunks(key string) error {
+	item, err := s.client.Get(key)
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	header, ok, err := parseChunkHeader(item.Value)
+	if !ok || err != nil {
+		return nil // Not chunked; a malformed header has nothing to delete
+	}
+	for _, k := range header.chunkKeys(key) {
+		if err := s.client.Delete(k); err != nil && err != memcache.ErrCacheMiss {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,8 +1,13 @@
 package dbsession
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
 )
 
 func TestMemcachedStore_TimeoutConfig(t *testing.T) {
@@ -40,3 +45,94 @@ func TestMemcachedStore_TimeoutConfig(t *testing.T) {
 		}
 	})
 }
+
+func TestMemcachedStore_WithClient(t *testing.T) {
+	client := memcache.New("localhost:11211")
+	client.Timeout = 3 * time.Second
+	client.MaxIdleConns = 10
+
+	store := NewMemcachedStoreWithClient(client, time.Hour)
+
+	if store.client != client {
+		t.Fatal("Expected the provided client to be used")
+	}
+	if store.client.Timeout != 3*time.Second {
+		t.Errorf("Expected the client's own timeout to be kept, got %v", store.client.Timeout)
+	}
+	if store.ttl != time.Hour {
+		t.Errorf("Expected TTL of 1h, got %v", store.ttl)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Expected Close to be a no-op, got %v", err)
+	}
+}
+
+func TestMemcachedStore_WeightedServers(t *testing.T) {
+	light, heavy := newFakeMemcached(t), newFakeMemcached(t)
+	store := NewMemcachedStoreWithConfig(MemcachedConfig{
+		WeightedServers: []MemcachedServer{
+			{Addr: light.addr(), Weight: 1},
+			{Addr: heavy.addr(), Weight: 3},
+		},
+		TTL:     time.Hour,
+		Timeout: time.Second,
+	})
+
+	for i := range 200 {
+		if err := store.Save(context.Background(), &Session{ID: fmt.Sprintf("session-%d", i), Values: map[string]any{}, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+	}
+	if l, h := len(light.keys()), len(heavy.keys()); l == 0 || h < 2*l {
+		t.Errorf("expected about three times as many sessions on the heavier server, got %d and %d", l, h)
+	}
+}
+
+func TestMemcachedStore_Selector(t *testing.T) {
+	server := newFakeMemcached(t)
+	ss := new(memcache.ServerList)
+	if err := ss.SetServers(server.addr()); err != nil {
+		t.Fatalf("failed to set servers: %v", err)
+	}
+	store := NewMemcachedStoreWithConfig(MemcachedConfig{Selector: ss, TTL: time.Hour, Timeout: 2 * time.Second})
+
+	if store.client.Timeout != 2*time.Second {
+		t.Errorf("Expected the configured timeout, got %v", store.client.Timeout)
+	}
+	if err := store.Save(context.Background(), &Session{ID: "abc", Values: map[string]any{}, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if len(server.keys()) != 1 {
+		t.Errorf("expected the session on the selected server, got %v", server.keys())
+	}
+}
+
+func TestNewMemcachedStoreE(t *testing.T) {
+	invalid := map[string]MemcachedConfig{
+		"no servers":    {},
+		"empty servers": {Servers: []string{}},
+		"missing port":  {Servers: []string{"127.0.0.1"}},
+		"zero weight":   {WeightedServers: []MemcachedServer{{Addr: "127.0.0.1:11211"}}},
+		"no weighted":   {WeightedServers: []MemcachedServer{}},
+	}
+	for name, cfg := range invalid {
+		if _, err := NewMemcachedStoreE(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := NewMemcachedStoreE(MemcachedConfig{}); err == nil || !strings.Contains(err.Error(), "no memcached servers configured") {
+		t.Errorf("expected a clear error without servers, got %v", err)
+	}
+
+	valid := []MemcachedConfig{
+		{Servers: []string{"127.0.0.1:11211"}},
+		{WeightedServers: []MemcachedServer{{Addr: "127.0.0.1:11211", Weight: 2}}},
+		{Client: memcache.New()},
+		{Selector: new(memcache.ServerList)},
+	}
+	for _, cfg := range valid {
+		if _, err := NewMemcachedStoreE(cfg); err != nil {
+			t.Errorf("unexpected error for %+v: %v", cfg, err)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package dbsession
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcached is a minimal in-process server speaking the subset of the
+// Memcached text protocol the store uses (gets, set, delete), so Memcached
+// behaviour can be tested without a running server.
+type fakeMemcached struct {
+	mu    sync.Mutex
+	items map[string][]byte
+	delay time.Duration // Before answering a gets, see setDelay
+	ln    net.Listener
+}
+
+func newFakeMemcached(t testing.TB) *fakeMemcached {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	f := &fakeMemcached{items: make(map[string][]byte), ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeMemcached) addr() string { return f.ln.Addr().String() }
+
+// setDelay makes the server wait d before answering each gets.
+func (f *fakeMemcached) setDelay(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delay = d
+}
+
+func (f *fakeMemcached) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.items))
+	for k := range f.items {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (f *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+		switch fields[0] {
+		case "gets":
+			f.mu.Lock()
+			delay := f.delay
+			f.mu.Unlock()
+			time.Sleep(delay)
+			f.mu.Lock()
+			for _, key := range fields[1:] {
+				if v, ok := f.items[key]; ok {
+					fmt.Fprintf(w, "VALUE %s 0 %d 1\r\n%s\r\n", key, len(v), v)
+				}
+			}
+			f.mu.Unlock()
+			w.WriteString("END\r\n")
+		case "set":
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.items[fields[1]] = data[:size]
+			f.mu.Unlock()
+			w.WriteString("STORED\r\n")
+		case "delete":
+			f.mu.Lock()
+			_, ok := f.items[fields[1]]
+			delete(f.items, fields[1])
+			f.mu.Unlock()
+			if ok {
+				w.WriteString("DELETED\r\n")
+			} else {
+				w.WriteString("NOT_FOUND\r\n")
+			}
+		default:
+			w.WriteString("ERROR\r\n")
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func TestMemcachedStore_KeyPrefix(t *testing.T) {
+	server := newFakeMemcached(t)
+	staging := NewMemcachedStoreWithConfig(MemcachedConfig{
+		Servers:   []string{server.addr()},
+		TTL:       time.Hour,
+		KeyPrefix: "staging:",
+	})
+	prod := NewMemcachedStoreWithConfig(MemcachedConfig{
+		Servers:   []string{server.addr()},
+		TTL:       time.Hour,
+		KeyPrefix: "prod:",
+	})
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "shared-id",
+		Values:    map[string]any{"env": "prod"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := prod.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if keys := server.keys(); len(keys) != 1 || keys[0] != "prod:shared-id" {
+		t.Errorf("expected key prod:shared-id, got %v", keys)
+	}
+
+	if got, err := staging.Get(ctx, s.ID); err != nil || got != nil {
+		t.Errorf("expected staging not to see prod session, got %v, %v", got, err)
+	}
+	if got, err := staging.GetMulti(ctx, []string{s.ID}); err != nil || len(got) != 0 {
+		t.Errorf("expected staging GetMulti to be empty, got %v, %v", got, err)
+	}
+
+	got, err := prod.Get(ctx, s.ID)
+	if err != nil || got == nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if got.ID != s.ID {
+		t.Errorf("expected unprefixed ID %q, got %q", s.ID, got.ID)
+	}
+	multi, err := prod.GetMulti(ctx, []string{s.ID})
+	if err != nil || multi[s.ID] == nil {
+		t.Errorf("expected GetMulti keyed by unprefixed ID, got %v, %v", multi, err)
+	}
+
+	if err := staging.Delete(ctx, s.ID); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if got, _ := prod.Get(ctx, s.ID); got == nil {
+		t.Error("expected staging delete to leave prod session intact")
+	}
+}
+
+func BenchmarkManager_Save_Memcached(b *testing.B) {
+	server := newFakeMemcached(b)
+	mgr := NewManager(Config{
+		Store:           NewMemcachedStore(time.Hour, server.addr()),
+		MaxSessionBytes: 4096, // The size check pre-encodes the values
+	})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		b.Fatalf("failed to create session: %v", err)
+	}
+	for i := range 20 {
+		s.Set(fmt.Sprintf("key-%d", i), strings.Repeat("v", 32))
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := mgr.PersistSession(ctx, s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMemcachedStore_ReadsLegacyEnvelope(t *testing.T) {
+	store := NewMemcachedStore(time.Hour)
+
+	// Items written before values moved into Data carry them in Values.
+	var buf bytes.Buffer
+	legacy := sessionEnvelope{Values: map[string]any{"user": "alice"}, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := gob.NewEncoder(&buf).Encode(legacy); err != nil {
+		t.Fatalf("failed to encode envelope: %v", err)
+	}
+
+	s, err := store.decode("legacy", buf.Bytes())
+	if err != nil {
+		t.Fatalf("failed to decode legacy envelope: %v", err)
+	}
+	if s.Values["user"] != "alice" {
+		t.Errorf("expected alice, got %v", s.Values["user"])
+	}
+}
+
+func TestMemcachedStore_RoundTrip(t *testing.T) {
+	server := newFakeMemcached(t)
+	store := NewMemcachedStore(time.Hour, server.addr())
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "round-trip",
+		Values:    map[string]any{"user": "alice"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+		OwnerID:   "alice",
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	got, err := store.Get(ctx, s.ID)
+	if err != nil || got == nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if got.Values["user"] != "alice" || got.OwnerID != "alice" {
+		t.Errorf("unexpected session: %+v", got)
+	}
+}
+
+func TestMemcachedStore_ContextDeadline(t *testing.T) {
+	// A server that accepts connections but never answers.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	store := NewMemcachedStoreWithConfig(MemcachedConfig{
+		Servers: []string{ln.Addr().String()},
+		TTL:     time.Hour,
+		Timeout: 2 * time.Second,
+	})
+
+	s := &Session{ID: "slow", Values: map[string]any{"user": "alice"}, ExpiresAt: time.Now().Add(time.Hour)}
+	ops := map[string]func(context.Context) error{
+		"Get":    func(ctx context.Context) error { _, err := store.Get(ctx, s.ID); return err },
+		"Save":   func(ctx context.Context) error { return store.Save(ctx, s) },
+		"Delete": func(ctx context.Context) error { return store.Delete(ctx, s.ID) },
+	}
+	for name, op := range ops {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err := op(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected the context deadline, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: returned after %v, not at the context deadline", name, elapsed)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.Save(ctx, s); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a canceled context to fail Save, got %v", err)
+	}
+}
+
+func TestMemcachedStore_ReplyAfterDeadline(t *testing.T) {
+	// The server answers, but only after the caller has given up, so the
+	// abandoned read completes while the caller returns. Run with -race.
+	server := newFakeMemcached(t)
+	store := NewMemcachedStore(time.Hour, server.addr())
+
+	s := &Session{ID: "late", Values: map[string]any{"user": "alice"}, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(context.Background(), s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	server.setDelay(50 * time.Millisecond)
+
+	// Each abandoned read is left to finish before the next call, which
+	// would otherwise synchronize with it through the client's pool.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	got, err := store.Get(ctx, s.ID)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) || got != nil {
+		t.Errorf("Get: expected the context deadline, got %v, %v", got, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	multi, err := store.GetMulti(ctx, []string{s.ID})
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) || multi != nil {
+		t.Errorf("GetMulti: expected the context deadline, got %v, %v", multi, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	server.setDelay(0)
+	got, err = store.Get(context.Background(), s.ID)
+	if err != nil || got == nil || got.Values["user"] != "alice" {
+		t.Errorf("expected the session once the server is fast again, got %v, %v", got, err)
+	}
+}
+
+func TestMemcachedStore_DeletesExpiredOnRead(t *testing.T) {
+	server := newFakeMemcached(t)
+	store := NewMemcachedStore(time.Hour, server.addr())
+	clock := newFakeClock()
+	store.clock = clock
+
+	ctx := context.Background()
+	for _, id := range []string{"expired-get", "expired-multi"} {
+		s := &Session{ID: id, Values: map[string]any{"user": "alice"}, CreatedAt: clock.Now(), ExpiresAt: clock.Now().Add(time.Minute)}
+		if err := store.Save(ctx, s); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+	}
+	// The envelopes outlive their ExpiresAt, as Memcached's own expiration
+	// may lag behind it.
+	clock.Advance(2 * time.Minute)
+
+	if got, err := store.Get(ctx, "expired-get"); err != nil || got != nil {
+		t.Errorf("expected an expired session to be reported missing, got %v, %v", got, err)
+	}
+	sessions, err := store.GetMulti(ctx, []string{"expired-multi"})
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("expected no sessions from GetMulti, got %v, %v", sessions, err)
+	}
+	if keys := server.keys(); len(keys) != 0 {
+		t.Errorf("expected expired items to be deleted, got %v", keys)
+	}
+}
+
+func TestMemcachedStore_DeleteMulti(t *testing.T) {
+	server := newFakeMemcached(t)
+	store := NewMemcachedStore(time.Hour, server.addr())
+
+	ctx := context.Background()
+	for _, id := range []string{"revoke-a", "revoke-b", "keep"} {
+		s := &Session{ID: id, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+		if err := store.Save(ctx, s); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+	}
+	if err := store.DeleteMulti(ctx, []string{"revoke-a", "revoke-b", "missing"}); err != nil {
+		t.Fatalf("failed to delete sessions: %v", err)
+	}
+	if keys := server.keys(); len(keys) != 1 || keys[0] != "keep" {
+		t.Errorf("expected only keep to remain, got %v", keys)
+	}
+}
+
+func TestMemcachedStore_Chunking(t *testing.T) {
+	server := newFakeMemcached(t)
+	store := NewMemcachedStoreWithConfig(MemcachedConfig{
+		Servers:   []string{server.addr()},
+		TTL:       time.Hour,
+		ChunkSize: 64,
+	})
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "chunked",
+		Values:    map[string]any{"blob": strings.Repeat("x", 1000)},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if n := len(server.keys()); n < 3 {
+		t.Fatalf("expected the session to be split across items, got %d keys", n)
+	}
+
+	got, err := store.Get(ctx, s.ID)
+	if err != nil || got == nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if got.Values["blob"] != s.Values["blob"] {
+		t.Error("reassembled session does not match the saved one")
+	}
+	multi, err := store.GetMulti(ctx, []string{s.ID})
+	if err != nil || multi[s.ID] == nil {
+		t.Errorf("expected GetMulti to reassemble the session, got %v, %v", multi, err)
+	}
+
+	if err := store.Delete(ctx, s.ID); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if keys := server.keys(); len(keys) != 0 {
+		t.Errorf("expected delete to remove all chunks, got %v", keys)
+	}
+}
+
+func TestMemcachedStore_ChunkingMissingChunk(t *testing.T) {
+	server := newFakeMemcached(t)
+	store := NewMemcachedStoreWithConfig(MemcachedConfig{
+		Servers:   []string{server.addr()},
+		TTL:       time.Hour,
+		ChunkSize: 64,
+	})
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "evicted",
+		Values:    map[string]any{"blob": strings.Repeat("x", 1000)},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	// Simulate the eviction of one chunk.
+	server.mu.Lock()
+	for k := range server.items {
+		if strings.HasSuffix(k, ":1") {
+			delete(server.items, k)
+		}
+	}
+	server.mu.Unlock()
+
+	if got, err := store.Get(ctx, s.ID); err != nil || got != nil {
+		t.Errorf("expected a session with a missing chunk to be not found, got %v, %v", got, err)
+	}
+}
+
+func TestMemcachedStore_ChunkingMaxSessionBytes(t *testing.T) {
+	server := newFakeMemcached(t)
+	store := NewMemcachedStoreWithConfig(MemcachedConfig{
+		Servers:         []string{server.addr()},
+		TTL:             time.Hour,
+		ChunkSize:       64,
+		MaxSessionBytes: 512,
+	})
+
+	s := &Session{
+		ID:        "too-large",
+		Values:    map[string]any{"blob": strings.Repeat("x", 1000)},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(context.Background(), s); !errors.Is(err, ErrSessionTooLarge) {
+		t.Errorf("expected ErrSessionTooLarge for the total size, got %v", err)
+	}
+	if keys := server.keys(); len(keys) != 0 {
+		t.Errorf("expected nothing to be written, got %v", keys)
+	}
+}
@@ -0,0 +1,32 @@
+package dbsession
+
+import "time"
+
+// Metrics receives counts and timings from the Manager, for export to a
+// monitoring system. Like Tracer, it is minimal so that dbsession does not
+// depend on a metrics library; the dbsession/prometheus module implements it
+// for Prometheus. Methods are called synchronously and must be safe for
+// concurrent use.
+type Metrics interface {
+	// SessionCreated is called when New creates a session in memory.
+	SessionCreated()
+	// SessionLoaded is called when a live session is loaded from the store.
+	SessionLoaded()
+	// SessionSaved is called when a session has been saved, with the size of
+	// its gob-encoded values in bytes.
+	SessionSaved(size int)
+	// SessionDestroyed is called when a session has been deleted.
+	SessionDestroyed()
+	// StoreOperation is called after every store call with the operation
+	// ("Get", "Save", "Delete", "DeleteMulti", "Cleanup" or "Ping"), how
+	// long it took and the error it returned, if any.
+	StoreOperation(op string, d time.Duration, err error)
+}
+
+// observeStore reports a store operation started at start to the Metrics,
+// if any.
+func (m *Manager) observeStore(op string, start time.Time, err error) {
+	if m.metrics != nil {
+		m.metrics.StoreOperation(op, time.Since(start), err)
+	}
+}
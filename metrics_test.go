@@ -0,0 +1,104 @@
+package dbsession
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics records the Metrics calls it receives.
+type recordingMetrics struct {
+	mu                                sync.Mutex
+	created, loaded, saved, destroyed int
+	lastSize                          int
+	ops                               []string
+}
+
+func (r *recordingMetrics) SessionCreated()   { r.mu.Lock(); r.created++; r.mu.Unlock() }
+func (r *recordingMetrics) SessionLoaded()    { r.mu.Lock(); r.loaded++; r.mu.Unlock() }
+func (r *recordingMetrics) SessionDestroyed() { r.mu.Lock(); r.destroyed++; r.mu.Unlock() }
+
+func (r *recordingMetrics) SessionSaved(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.saved++
+	r.lastSize = size
+}
+
+func (r *recordingMetrics) StoreOperation(op string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+}
+
+func TestManager_Metrics(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	metrics := &recordingMetrics{}
+	mgr := NewManager(Config{Store: store, Metrics: metrics})
+	defer mgr.Close()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	s, _ := mgr.New()
+	s.Set("user", "alice")
+	w := httptest.NewRecorder()
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	r.AddCookie(w.Result().Cookies()[0])
+	loaded, err := mgr.Load(r)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if err := mgr.Destroy(httptest.NewRecorder(), r, loaded); err != nil {
+		t.Fatalf("failed to destroy session: %v", err)
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.created != 1 || metrics.loaded != 1 || metrics.saved != 1 || metrics.destroyed != 1 {
+		t.Errorf("expected one of each event, got created=%d loaded=%d saved=%d destroyed=%d",
+			metrics.created, metrics.loaded, metrics.saved, metrics.destroyed)
+	}
+	if metrics.lastSize == 0 {
+		t.Error("expected the encoded size of the saved session")
+	}
+	want := []string{"Save", "Get", "Delete"}
+	if len(metrics.ops) != len(want) {
+		t.Fatalf("expected store operations %v, got %v", want, metrics.ops)
+	}
+	for i, op := range want {
+		if metrics.ops[i] != op {
+			t.Errorf("expected store operations %v, got %v", want, metrics.ops)
+			break
+		}
+	}
+}
+
+func TestSQLiteStore_Count(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	now := time.Now()
+	for _, s := range []*Session{
+		{ID: "live1", ExpiresAt: now.Add(time.Hour)},
+		{ID: "live2", ExpiresAt: now.Add(time.Hour)},
+		{ID: "expired", ExpiresAt: now.Add(-time.Hour)},
+	} {
+		if err := store.Save(ctx, s); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+	}
+	var counter Counter = store
+	if n, err := counter.Count(ctx); err != nil || n != 2 {
+		t.Errorf("expected 2 live sessions, got %d, %v", n, err)
+	}
+}
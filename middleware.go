@@ -0,0 +1,107 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// sessionContextKey is the context key Middleware stores the session under.
+type sessionContextKey struct{}
+
+// Middleware returns net/http middleware that loads the request's session
+// with Get and makes it available to next through FromContext. If the
+// session is Modified, it is saved just before the response headers are
+// written, by the first WriteHeader, Write or Flush (including through
+// http.ResponseController), so that the cookie is set even by streaming
+// handlers; otherwise after next returns. Failures to load respond with a
+// 500, or a 401 for a missing session with Config.RequireSession; failures
+// to save are logged with slog, as the response may already be under way.
+//
+// Only changes made with Session.Set, Delete, Clear or SetMetadata mark the
+// session modified; call Save directly after changing Values by hand.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := m.Get(r)
+		if errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrSessionExpired) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, s))
+
+		sw := &saveWriter{ResponseWriter: w}
+		sw.save = func() {
+			if s.Modified() {
+				if err := m.Save(sw.ResponseWriter, r, s); err != nil {
+					slog.ErrorContext(r.Context(), "dbsession: failed to save session", "error", err)
+				}
+			}
+		}
+		next.ServeHTTP(sw, r)
+		sw.writeHeaders()
+	})
+}
+
+// FromContext returns the session loaded by Middleware, or nil if the
+// middleware is not installed.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return s
+}
+
+// saveWriter saves the session before the response headers are written.
+type saveWriter struct {
+	http.ResponseWriter
+	save        func()
+	wroteHeader bool
+}
+
+// writeHeaders runs save once, before the headers are sent.
+func (w *saveWriter) writeHeaders() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.save()
+}
+
+func (w *saveWriter) WriteHeader(code int) {
+	// Informational responses do not send the final headers.
+	if code >= 200 {
+		w.writeHeaders()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *saveWriter) Write(b []byte) (int, error) {
+	w.writeHeaders()
+	return w.ResponseWriter.Write(b)
+}
+
+// FlushError is used by http.ResponseController.Flush.
+func (w *saveWriter) FlushError() error {
+	w.writeHeaders()
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *saveWriter) Flush() {
+	_ = w.FlushError()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer's other
+// features, such as deadlines.
+func (w *saveWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// headersWritten reports whether w is a Middleware writer whose headers
+// were already sent, so that a cookie set now would be lost.
+func headersWritten(w http.ResponseWriter) bool {
+	sw, ok := w.(*saveWriter)
+	return ok && sw.wroteHeader
+}
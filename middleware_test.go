@@ -0,0 +1,79 @@
+package dbsession
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestManager_Middleware(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store})
+	defer mgr.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Set("user", "alice")
+		w.Header().Set("Content-Type", "text/event-stream")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("failed to flush: %v", err)
+		}
+		io.WriteString(w, "data: hello\n\n")
+		if err := mgr.Save(w, r, FromContext(r.Context())); err != ErrHeadersWritten {
+			t.Errorf("expected ErrHeadersWritten after the headers were sent, got %v", err)
+		}
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		user, _ := FromContext(r.Context()).Get("user")
+		io.WriteString(w, user.(string))
+	})
+	handler := mgr.Middleware(mux)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/stream", nil))
+	if !w.Flushed {
+		t.Error("expected the response to be flushed")
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected the session cookie to be set before the flush, got %v", cookies)
+	}
+
+	r := httptest.NewRequest("GET", "/me", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if body := w.Body.String(); body != "alice" {
+		t.Errorf("expected the session to be loaded, got %q", body)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("expected an unmodified session not to be saved")
+	}
+}
+
+func TestManager_MiddlewareSavesAfterHandler(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store})
+	defer mgr.Close()
+
+	// A handler writing nothing still gets its session saved.
+	handler := mgr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Set("user", "alice")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if len(w.Result().Cookies()) != 1 {
+		t.Error("expected the session to be saved after the handler")
+	}
+
+	if FromContext(httptest.NewRequest("GET", "/", nil).Context()) != nil {
+		t.Error("expected no session without the middleware")
+	}
+}
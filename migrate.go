@@ -0,0 +1,44 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Migrate copies every live session from src to dst, e.g. when moving from
+// SQLite to PostgreSQL without logging users out, and returns the number of
+// sessions copied. src must implement Enumerator; dst can be any Store.
+//
+// Expired sessions are skipped. Sessions that cannot be decoded are logged
+// and skipped, so a few corrupt rows do not abort the migration. Copies
+// start over at version 0 in dst. Migrate stops at the first failed write.
+// It can then be run again, as saving an already-copied session overwrites
+// it, unless dst uses optimistic locking.
+func Migrate(ctx context.Context, src, dst Store) (int, error) {
+	enum, ok := src.(Enumerator)
+	if !ok {
+		return 0, errors.New("source store does not support enumeration")
+	}
+
+	now := time.Now()
+	copied := 0
+	err := enum.ForEach(ctx, func(s *Session, err error) error {
+		if err != nil {
+			slog.WarnContext(ctx, "dbsession: skipping session during migration", "error", err)
+			return nil
+		}
+		if s.ExpiresAt.Before(now) {
+			return nil
+		}
+		s.Version = 0
+		if err := dst.Save(ctx, s); err != nil {
+			return fmt.Errorf("failed to migrate session %s: %w", s.ID, err)
+		}
+		copied++
+		return nil
+	})
+	return copied, err
+}
@@ -0,0 +1,70 @@
+package dbsession
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMigrate(t *testing.T) {
+	src, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer src.Close()
+	dst, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer dst.Close()
+
+	ctx := context.Background()
+	live := &Session{
+		ID:        "live",
+		Values:    map[string]any{"user": "alice"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+		Version:   4,
+	}
+	expired := &Session{
+		ID:        "expired",
+		Values:    map[string]any{},
+		CreatedAt: time.Now().Add(-2 * time.Hour),
+		ExpiresAt: time.Now().Add(-time.Hour),
+	}
+	for _, s := range []*Session{live, expired} {
+		if err := src.Save(ctx, s); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+	}
+	// A corrupt row must not abort the migration.
+	if _, err := src.db.Exec("INSERT INTO sessions (id, data, created_at, expires_at) VALUES ('corrupt', x'00ff', ?, ?)",
+		time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("failed to insert corrupt row: %v", err)
+	}
+
+	n, err := Migrate(ctx, src, dst)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 session migrated, got %d", n)
+	}
+
+	got, err := dst.Get(ctx, live.ID)
+	if err != nil || got == nil {
+		t.Fatalf("expected live session in destination, got %v, %v", got, err)
+	}
+	if got.Values["user"] != "alice" {
+		t.Errorf("expected alice, got %v", got.Values["user"])
+	}
+	if got, _ := dst.Get(ctx, expired.ID); got != nil {
+		t.Error("expected expired session to be skipped")
+	}
+}
+
+func TestMigrate_NotEnumerable(t *testing.T) {
+	if _, err := Migrate(context.Background(), &MockStore{}, &MockStore{}); err == nil {
+		t.Error("expected error for a source without enumeration")
+	}
+}
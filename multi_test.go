@@ -0,0 +1,197 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStore_Multi(t *testing.T) {
+	dbPath := "test_multi.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	var _ MultiStore = store
+
+	ctx := context.Background()
+	// More than one GetMulti batch.
+	sessions := make([]*Session, sqliteMaxBatch+10)
+	ids := make([]string, 0, len(sessions)+1)
+	for i := range sessions {
+		sessions[i] = &Session{
+			ID:        fmt.Sprintf("multi-%d", i),
+			Values:    map[string]any{"n": i},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		ids = append(ids, sessions[i].ID)
+	}
+	ids = append(ids, "missing")
+
+	if err := store.SaveMulti(ctx, sessions); err != nil {
+		t.Fatalf("failed to save sessions: %v", err)
+	}
+
+	got, err := store.GetMulti(ctx, ids)
+	if err != nil {
+		t.Fatalf("failed to get sessions: %v", err)
+	}
+	if len(got) != len(sessions) {
+		t.Fatalf("expected %d sessions, got %d", len(sessions), len(got))
+	}
+	if _, ok := got["missing"]; ok {
+		t.Error("expected missing session to be absent")
+	}
+	for i, s := range sessions {
+		if got[s.ID] == nil || got[s.ID].Values["n"] != i {
+			t.Fatalf("unexpected session %s: %+v", s.ID, got[s.ID])
+		}
+	}
+
+	if got, err := store.GetMulti(ctx, nil); err != nil || len(got) != 0 {
+		t.Errorf("expected empty result for no IDs, got %v, %v", got, err)
+	}
+}
+
+func TestSQLiteStore_SaveMultiAtomic(t *testing.T) {
+	dbPath := "test_multi_atomic.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: dbPath, MaxSessionBytes: 64})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	ok := &Session{ID: "multi-ok", Values: map[string]any{"a": 1}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	big := &Session{ID: "multi-big", Values: map[string]any{"a": string(make([]byte, 1024))}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+
+	if err := store.SaveMulti(ctx, []*Session{ok, big}); !errors.Is(err, ErrSessionTooLarge) {
+		t.Fatalf("expected ErrSessionTooLarge, got %v", err)
+	}
+	if got, _ := store.Get(ctx, ok.ID); got != nil {
+		t.Error("expected the batch to be rolled back")
+	}
+}
+
+func TestSQLiteStore_SaveMultiOptimistic(t *testing.T) {
+	dbPath := "test_multi_locking.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: dbPath, OptimisticLocking: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	a := &Session{ID: "multi-a", Values: map[string]any{"v": 1}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	b := &Session{ID: "multi-b", Values: map[string]any{"v": 1}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.SaveMulti(ctx, []*Session{a, b}); err != nil {
+		t.Fatalf("failed to save sessions: %v", err)
+	}
+	if a.Version != 1 || b.Version != 1 {
+		t.Fatalf("expected versions 1, got %d and %d", a.Version, b.Version)
+	}
+
+	// b is modified elsewhere, so our copy is stale.
+	other, _ := store.Get(ctx, b.ID)
+	if err := store.Save(ctx, other); err != nil {
+		t.Fatalf("failed to save other copy: %v", err)
+	}
+
+	a.Set("v", 2)
+	b.Set("v", 2)
+	if err := store.SaveMulti(ctx, []*Session{a, b}); !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("expected ErrConcurrentModification, got %v", err)
+	}
+	if a.Version != 1 {
+		t.Errorf("expected version of a unchanged after failed batch, got %d", a.Version)
+	}
+	if got, _ := store.Get(ctx, a.ID); got.Values["v"] != 1 {
+		t.Errorf("expected a to be rolled back, got %v", got.Values["v"])
+	}
+}
+
+func TestSQLiteStore_DeleteMulti(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	var _ MultiDeleter = store
+
+	ctx := context.Background()
+	// More than one DELETE batch.
+	sessions := make([]*Session, sqliteMaxBatch+10)
+	ids := make([]string, 0, len(sessions))
+	for i := range sessions {
+		sessions[i] = &Session{ID: fmt.Sprintf("revoke-%d", i), CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+		ids = append(ids, sessions[i].ID)
+	}
+	keep := &Session{ID: "keep", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.SaveMulti(ctx, append(sessions, keep)); err != nil {
+		t.Fatalf("failed to save sessions: %v", err)
+	}
+
+	if err := store.DeleteMulti(ctx, append(ids, "missing")); err != nil {
+		t.Fatalf("failed to delete sessions: %v", err)
+	}
+	got, err := store.GetMulti(ctx, append(ids, keep.ID))
+	if err != nil {
+		t.Fatalf("failed to get sessions: %v", err)
+	}
+	if len(got) != 1 || got[keep.ID] == nil {
+		t.Errorf("expected only %q to remain, got %d sessions", keep.ID, len(got))
+	}
+}
+
+func TestManager_Revoke(t *testing.T) {
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	// mapStore is not a MultiDeleter: Revoke deletes one by one.
+	for name, store := range map[string]Store{"MultiDeleter": sqlite, "Fallback": newMapStore()} {
+		t.Run(name, func(t *testing.T) {
+			var destroyed []string
+			mgr := NewManager(Config{Store: store, OnDestroy: func(id string) { destroyed = append(destroyed, id) }})
+			defer mgr.Close()
+
+			ctx := context.Background()
+			var ids []string
+			for range 3 {
+				s, err := mgr.CreateSession(ctx)
+				if err != nil {
+					t.Fatalf("failed to create session: %v", err)
+				}
+				ids = append(ids, s.ID)
+			}
+
+			if err := mgr.Revoke(ctx, ids[:2]...); err != nil {
+				t.Fatalf("failed to revoke sessions: %v", err)
+			}
+			for _, id := range ids[:2] {
+				if _, err := mgr.LoadSession(ctx, id); !errors.Is(err, ErrSessionNotFound) {
+					t.Errorf("expected %s to be revoked, got %v", id, err)
+				}
+			}
+			if _, err := mgr.LoadSession(ctx, ids[2]); err != nil {
+				t.Errorf("expected %s to remain, got %v", ids[2], err)
+			}
+			if len(destroyed) != 2 {
+				t.Errorf("expected OnDestroy for 2 sessions, got %v", destroyed)
+			}
+		})
+	}
+}
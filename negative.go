@@ -0,0 +1,71 @@
+package dbsession
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultNegativeCacheTTL is how long an absent ID is remembered by default.
+const defaultNegativeCacheTTL = 5 * time.Second
+
+// negativeCache remembers session IDs recently found absent from the store,
+// so that scanners probing the same unknown ID repeatedly do not reach the
+// store each time. A nil *negativeCache is disabled.
+type negativeCache struct {
+	ttl time.Duration
+
+	mu     sync.Mutex
+	misses *lru[time.Time] // When each miss stops being remembered
+	gen    uint64          // Incremented by every forget
+}
+
+func newNegativeCache(size int, ttl time.Duration) *negativeCache {
+	if size <= 0 {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = defaultNegativeCacheTTL
+	}
+	return &negativeCache{ttl: ttl, misses: newLRU[time.Time](size)}
+}
+
+// has reports whether id was found absent less than the TTL before now. It
+// also returns the generation to pass to add if the ID is then looked up.
+func (c *negativeCache) has(id string, now time.Time) (bool, uint64) {
+	if c == nil {
+		return false, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires, ok := c.misses.get(id)
+	if ok && !now.Before(expires) {
+		c.misses.remove(id)
+		ok = false
+	}
+	return ok, c.gen
+}
+
+// add remembers that id was found absent at now, unless an ID was forgotten
+// since generation gen was returned by has: the lookup may then predate a
+// save of id.
+func (c *negativeCache) add(id string, now time.Time, gen uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen == gen {
+		c.misses.add(id, now.Add(c.ttl))
+	}
+}
+
+// forget removes id, so that a session saved under it is found.
+func (c *negativeCache) forget(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.misses.remove(id)
+	c.gen++
+}
@@ -0,0 +1,181 @@
+package dbsession
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// keyTokenGen holds the generation of the session's client tokens when
+// Config.TokenKey is set. Tokens sealed for another generation are rejected,
+// so changing it revokes every token issued before. It is a random string
+// rather than a counter, so that a session whose values were cleared does
+// not fall back to an earlier generation.
+const keyTokenGen = reservedKeyPrefix + "tokengen"
+
+// tokenAAD binds sealed tokens to their purpose.
+var tokenAAD = []byte("dbsession client token v1")
+
+// newTokenAEAD returns the cipher sealing client tokens with key.
+func newTokenAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("dbsession: invalid TokenKey: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealToken returns a client token for session ID id at generation gen.
+// Each call uses a fresh nonce, so tokens for the same session differ.
+func (m *Manager) sealToken(id, gen string) (string, error) {
+	aead := m.tokenAEAD
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(id)+1+len(gen)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate token nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(id+":"+gen), tokenAAD)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// openToken returns the session ID and generation sealed in token, or ok
+// false if the token is malformed or was not sealed with the Manager's key.
+// GCM verifies the authentication tag in constant time.
+func (m *Manager) openToken(token string) (id, gen string, ok bool) {
+	aead := m.tokenAEAD
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return "", "", false
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(ciphertext[:0], nonce, ciphertext, tokenAAD)
+	if err != nil {
+		return "", "", false
+	}
+	// Custom IDGenerators may use ':', generations do not.
+	i := strings.LastIndexByte(string(plain), ':')
+	if i < 0 {
+		return "", "", false
+	}
+	return string(plain[:i]), string(plain[i+1:]), true
+}
+
+// tokenGenLocked returns the session's token generation, or "" if it has
+// none. The caller must hold s.mu.
+func tokenGenLocked(s *Session) string {
+	gen, _ := s.Values[keyTokenGen].(string)
+	return gen
+}
+
+// setTokenGenLocked gives the session a new token generation. The caller
+// must hold s.mu.
+func setTokenGenLocked(s *Session) error {
+	gen, err := generateID()
+	if err != nil {
+		return err
+	}
+	if s.Values == nil {
+		s.Values = make(map[string]any)
+	}
+	s.Values[keyTokenGen] = gen
+	s.encoded = nil
+	return nil
+}
+
+// clientToken returns the value handed to the client for s: its ID, or a
+// sealed token if Config.TokenKey is set. A session without a token
+// generation gets one, which the caller must then save.
+func (m *Manager) clientToken(s *Session) (string, error) {
+	if m.tokenAEAD == nil {
+		return s.ID, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tokenGenLocked(s) == "" {
+		if err := setTokenGenLocked(s); err != nil {
+			return "", err
+		}
+	}
+	return m.sealToken(s.ID, tokenGenLocked(s))
+}
+
+// ClientToken returns the value to hand to the client for s: its ID, or
+// with Config.TokenKey a token sealing it. It is meant for adapters that set
+// their own cookies, which read it back with LoadClientToken. A session
+// issued its first token is changed, so it must be saved afterwards.
+func (m *Manager) ClientToken(s *Session) (string, error) {
+	return m.clientToken(s)
+}
+
+// LoadClientToken is LoadSession for a value returned by ClientToken. With
+// Config.TokenKey set, a token that cannot be opened or was revoked by
+// Regenerate is reported as ErrSessionNotFound.
+func (m *Manager) LoadClientToken(ctx context.Context, token string) (*Session, error) {
+	session, err := m.loadClientToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	m.touch(ctx, session)
+	return session, nil
+}
+
+// loadClientToken loads the session token was issued for, without recording
+// the access.
+func (m *Manager) loadClientToken(ctx context.Context, token string) (*Session, error) {
+	if m.tokenAEAD == nil {
+		return m.loadSession(ctx, token)
+	}
+	id, gen, ok := m.openToken(token)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	session, err := m.loadSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// A token of a previous generation was revoked by Regenerate.
+	if subtle.ConstantTimeCompare([]byte(tokenGenLocked(session)), []byte(gen)) != 1 {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// rotateToken is Regenerate for a Manager with a TokenKey: it gives the
+// session a new token generation and saves it, which sets the cookie for
+// the new token and revokes the earlier ones. The session keeps its ID.
+func (m *Manager) rotateToken(w http.ResponseWriter, r *http.Request, s *Session) error {
+	s.mu.Lock()
+	oldGen, hadGen := s.Values[keyTokenGen]
+	err := setTokenGenLocked(s)
+	if err == nil {
+		s.RegenerateCount++
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := m.Save(w, r, s); err != nil {
+		s.mu.Lock()
+		if hadGen {
+			s.Values[keyTokenGen] = oldGen
+		} else {
+			delete(s.Values, keyTokenGen)
+		}
+		s.encoded = nil
+		s.RegenerateCount--
+		s.mu.Unlock()
+		return err
+	}
+
+	if m.onRegenerate != nil {
+		m.onRegenerate(s.ID, s.ID)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package dbsession
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newOpaqueManager(t *testing.T) (*Manager, *SQLiteStore) {
+	t.Helper()
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr, err := NewManagerE(Config{Store: store, TokenKey: bytes.Repeat([]byte{7}, 32)})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	t.Cleanup(func() { mgr.Close() })
+	return mgr, store
+}
+
+func TestManager_TokenKey(t *testing.T) {
+	mgr, _ := newOpaqueManager(t)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	s, _ := mgr.New()
+	s.Set("user", "alice")
+	w := httptest.NewRecorder()
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	cookie := w.Result().Cookies()[0]
+	if strings.Contains(cookie.Value, s.ID) {
+		t.Errorf("expected the cookie not to expose the session ID, got %q", cookie.Value)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	loaded, err := mgr.Load(r)
+	if err != nil || loaded.ID != s.ID {
+		t.Fatalf("expected the token to resolve to the session, got %v", err)
+	}
+	if _, ok := loaded.ToMap()[keyTokenGen]; ok {
+		t.Error("expected the token generation to be hidden from ToMap")
+	}
+
+	// The raw ID is not accepted in place of a token.
+	raw := httptest.NewRequest("GET", "/", nil)
+	raw.AddCookie(&http.Cookie{Name: cookie.Name, Value: s.ID})
+	if _, err := mgr.Load(raw); err != ErrSessionNotFound {
+		t.Errorf("expected a raw session ID to be rejected, got %v", err)
+	}
+
+	// Regenerate rotates the token but keeps the stored record.
+	w = httptest.NewRecorder()
+	if err := mgr.Regenerate(w, r, loaded); err != nil {
+		t.Fatalf("failed to regenerate: %v", err)
+	}
+	if loaded.ID != s.ID {
+		t.Errorf("expected the session ID to stay %s, got %s", s.ID, loaded.ID)
+	}
+	rotated := w.Result().Cookies()[0]
+	if rotated.Value == cookie.Value {
+		t.Fatal("expected a new token")
+	}
+	if _, err := mgr.Load(r); err != ErrSessionNotFound {
+		t.Errorf("expected the old token to be revoked, got %v", err)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(rotated)
+	if got, err := mgr.Load(r); err != nil || got.ID != s.ID {
+		t.Errorf("expected the new token to resolve to the session, got %v", err)
+	}
+}
+
+func TestManager_TokenKeyClearedSession(t *testing.T) {
+	mgr, _ := newOpaqueManager(t)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	s, _ := mgr.New()
+	w := httptest.NewRecorder()
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	first := w.Result().Cookies()[0]
+	if err := mgr.Regenerate(httptest.NewRecorder(), r, s); err != nil {
+		t.Fatalf("failed to regenerate: %v", err)
+	}
+
+	// Clearing the values must not bring the revoked token back.
+	s.Clear()
+	if err := mgr.Save(httptest.NewRecorder(), r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	r.AddCookie(first)
+	if _, err := mgr.Load(r); err != ErrSessionNotFound {
+		t.Errorf("expected the revoked token to stay revoked, got %v", err)
+	}
+}
+
+func TestManager_TokenKeySaveToken(t *testing.T) {
+	mgr, _ := newOpaqueManager(t)
+
+	s, _ := mgr.New()
+	token, err := mgr.SaveToken(httptest.NewRequest("POST", "/", nil), s)
+	if err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if token == s.ID {
+		t.Error("expected SaveToken to return an opaque token")
+	}
+	if id, _, ok := mgr.openToken(token); !ok || id != s.ID {
+		t.Errorf("expected the token to seal the session ID, got %q, %v", id, ok)
+	}
+	if _, _, ok := mgr.openToken(token[:len(token)-2] + "AA"); ok {
+		t.Error("expected a tampered token to be rejected")
+	}
+}
@@ -0,0 +1,80 @@
+package dbsession
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures a Manager created by NewManagerWithOptions.
+type Option func(*Config)
+
+// NewManagerWithOptions creates a Manager for store configured by opts.
+// Unlike Config, options state explicit values, so there is no need for
+// pointer fields to tell "false" apart from "use the default".
+//
+//	mgr := dbsession.NewManagerWithOptions(store,
+//		dbsession.WithTTL(2*time.Hour),
+//		dbsession.WithSecure(true),
+//	)
+func NewManagerWithOptions(store Store, opts ...Option) *Manager {
+	cfg := Config{Store: store}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return NewManager(cfg)
+}
+
+// WithTTL sets how long sessions live. See Config.TTL.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Config) { c.TTL = ttl }
+}
+
+// WithCookieTTL sets the lifetime of the session cookie. See
+// Config.CookieTTL.
+func WithCookieTTL(ttl time.Duration) Option {
+	return func(c *Config) { c.CookieTTL = ttl }
+}
+
+// WithCookieName sets the session cookie name. See Config.CookieName.
+func WithCookieName(name string) Option {
+	return func(c *Config) { c.CookieName = name }
+}
+
+// WithSecure sets the cookie's Secure attribute, overriding detection from
+// the request. See Config.Secure.
+func WithSecure(secure bool) Option {
+	return func(c *Config) { c.Secure = &secure }
+}
+
+// WithSecurePrefix prefixes the cookie names with "__Secure-" and forces
+// Secure. See Config.UseSecurePrefix.
+func WithSecurePrefix() Option {
+	return func(c *Config) { c.UseSecurePrefix = true }
+}
+
+// WithHttpOnly sets the cookie's HttpOnly attribute. See Config.HttpOnly.
+func WithHttpOnly(httpOnly bool) Option {
+	return func(c *Config) { c.HttpOnly = &httpOnly }
+}
+
+// WithSameSite sets the cookie's SameSite attribute. See Config.SameSite.
+func WithSameSite(sameSite http.SameSite) Option {
+	return func(c *Config) { c.SameSite = sameSite }
+}
+
+// WithCleanupInterval sets how often expired sessions are removed; a
+// negative interval disables the worker. See Config.CleanupInterval.
+func WithCleanupInterval(d time.Duration) Option {
+	return func(c *Config) { c.CleanupInterval = d }
+}
+
+// WithMaxSessionBytes limits the encoded size of a session. See
+// Config.MaxSessionBytes.
+func WithMaxSessionBytes(n int) Option {
+	return func(c *Config) { c.MaxSessionBytes = n }
+}
+
+// WithIDEncoding selects the format of new session IDs. See Config.IDEncoding.
+func WithIDEncoding(e IDEncoding) Option {
+	return func(c *Config) { c.IDEncoding = e }
+}
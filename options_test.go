@@ -0,0 +1,52 @@
+package dbsession
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewManagerWithOptions(t *testing.T) {
+	store := &MockStore{}
+	mgr := NewManagerWithOptions(store,
+		WithTTL(2*time.Hour),
+		WithCookieName("sid"),
+		WithSecure(false),
+		WithHttpOnly(false),
+		WithSameSite(http.SameSiteStrictMode),
+		WithCleanupInterval(-1),
+		WithMaxSessionBytes(1024),
+	)
+	defer mgr.Close()
+
+	if mgr.store != store {
+		t.Error("expected store to be set")
+	}
+	if mgr.ttl != 2*time.Hour {
+		t.Errorf("expected TTL 2h, got %v", mgr.ttl)
+	}
+	if mgr.cookie != "sid" {
+		t.Errorf("expected cookie name sid, got %q", mgr.cookie)
+	}
+	if mgr.secure == nil || *mgr.secure {
+		t.Errorf("expected Secure explicitly false, got %v", mgr.secure)
+	}
+	if mgr.httpOnly {
+		t.Error("expected HttpOnly false")
+	}
+	if mgr.sameSite != http.SameSiteStrictMode {
+		t.Errorf("expected SameSite Strict, got %v", mgr.sameSite)
+	}
+	if mgr.cleanup != -1 {
+		t.Errorf("expected cleanup disabled, got %v", mgr.cleanup)
+	}
+	if mgr.maxSessionBytes != 1024 {
+		t.Errorf("expected MaxSessionBytes 1024, got %d", mgr.maxSessionBytes)
+	}
+
+	defaults := NewManagerWithOptions(store)
+	defer defaults.Close()
+	if defaults.ttl != 24*time.Hour || defaults.cookie != "session_id" || !defaults.httpOnly || defaults.secure != nil {
+		t.Error("expected NewManager defaults without options")
+	}
+}
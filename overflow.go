@@ -0,0 +1,125 @@
+package dbsession
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+)
+
+// defaultOverflowThreshold is the encoded size above which OverflowStore
+// moves a session to its durable store unless configured otherwise.
+const defaultOverflowThreshold = 64 * 1024
+
+// keyOverflow marks an entry in the fast store as a pointer to a session
+// held by the durable store.
+const keyOverflow = reservedKeyPrefix + "overflow"
+
+// OverflowStore routes sessions between two stores by size: sessions up to
+// a threshold are kept entirely in a fast store (e.g. Memcached), larger
+// ones are saved to a durable store (e.g. PostgreSQL) with only a small
+// pointer entry left in the fast store. Get resolves pointers transparently.
+//
+// The fast store decides whether a session exists: if it loses an entry,
+// e.g. to eviction, the session is gone even if the durable store still
+// holds a copy. When a session shrinks back under the threshold, its copy in
+// the durable store is left to expire and be removed by Cleanup.
+// Optimistic locking is only supported on the durable store.
+type OverflowStore struct {
+	fast      Store
+	durable   Store
+	threshold int
+}
+
+// OverflowConfig holds configuration for the overflow store.
+type OverflowConfig struct {
+	Fast    Store
+	Durable Store
+	// Threshold is the size in bytes above which a session is moved to the
+	// durable store. Sizes are measured on the gob encoding, like
+	// Config.MaxSessionBytes, whose encoding is reused when set. Defaults
+	// to 64 KiB.
+	Threshold int
+}
+
+// NewOverflowStore creates an OverflowStore keeping sessions up to the
+// default threshold in fast and larger ones in durable.
+func NewOverflowStore(fast, durable Store) *OverflowStore {
+	return NewOverflowStoreWithConfig(OverflowConfig{Fast: fast, Durable: durable})
+}
+
+// NewOverflowStoreWithConfig creates an OverflowStore with custom configuration.
+func NewOverflowStoreWithConfig(cfg OverflowConfig) *OverflowStore {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = defaultOverflowThreshold
+	}
+	return &OverflowStore{fast: cfg.Fast, durable: cfg.Durable, threshold: cfg.Threshold}
+}
+
+// Get returns the session from the fast store, following its pointer to
+// the durable store if it overflowed.
+func (o *OverflowStore) Get(ctx context.Context, id string) (*Session, error) {
+	s, err := o.fast.Get(ctx, id)
+	if err != nil || s == nil {
+		return s, err
+	}
+	if overflowed, _ := s.Values[keyOverflow].(bool); !overflowed {
+		return s, nil
+	}
+	return o.durable.Get(ctx, id)
+}
+
+// Save writes the session to the fast store, or to the durable store
+// followed by a pointer in the fast store if it exceeds the threshold.
+func (o *OverflowStore) Save(ctx context.Context, s *Session) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer PutBuffer(buf)
+	data, err := encodeValues(GobCodec{}, s, buf)
+	if err != nil {
+		return err
+	}
+	if len(data) <= o.threshold {
+		return o.fast.Save(ctx, s)
+	}
+
+	// The durable copy is written first, so that the pointer never dangles.
+	if err := o.durable.Save(ctx, s); err != nil {
+		return err
+	}
+	pointer := &Session{
+		ID:              s.ID,
+		Values:          map[string]any{keyOverflow: true},
+		CreatedAt:       s.CreatedAt,
+		ExpiresAt:       s.ExpiresAt,
+		LastAccessedAt:  s.LastAccessedAt,
+		RegenerateCount: s.RegenerateCount,
+		OwnerID:         s.OwnerID,
+	}
+	return o.fast.Save(ctx, pointer)
+}
+
+// Delete removes the session from both stores.
+func (o *OverflowStore) Delete(ctx context.Context, id string) error {
+	return errors.Join(o.fast.Delete(ctx, id), o.durable.Delete(ctx, id))
+}
+
+// SetExpiryLeeway passes d to both stores, see ExpiryLeewaySetter.
+func (o *OverflowStore) SetExpiryLeeway(d time.Duration) {
+	setExpiryLeeway(o.fast, d)
+	setExpiryLeeway(o.durable, d)
+}
+
+// Cleanup removes expired sessions from both stores.
+func (o *OverflowStore) Cleanup(ctx context.Context) error {
+	return errors.Join(o.fast.Cleanup(ctx), o.durable.Cleanup(ctx))
+}
+
+// Ping checks both stores, as sessions may be in either.
+func (o *OverflowStore) Ping(ctx context.Context) error {
+	return errors.Join(o.fast.Ping(ctx), o.durable.Ping(ctx))
+}
+
+// Close closes both stores.
+func (o *OverflowStore) Close() error {
+	return errors.Join(o.fast.Close(), o.durable.Close())
+}
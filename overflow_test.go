@@ -0,0 +1,73 @@
+package dbsession
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverflowStore(t *testing.T) {
+	fast, durable := newMapStore(), newMapStore()
+	store := NewOverflowStoreWithConfig(OverflowConfig{Fast: fast, Durable: durable, Threshold: 256})
+
+	ctx := context.Background()
+	small := &Session{ID: "small", Values: map[string]any{"k": "v"}, ExpiresAt: time.Now().Add(time.Hour)}
+	large := &Session{ID: "large", Values: map[string]any{"cart": strings.Repeat("x", 1024)}, ExpiresAt: time.Now().Add(time.Hour)}
+	for _, s := range []*Session{small, large} {
+		if err := store.Save(ctx, s); err != nil {
+			t.Fatalf("failed to save %s: %v", s.ID, err)
+		}
+	}
+
+	if !fast.has("small") || durable.has("small") {
+		t.Error("expected the small session to stay in the fast store only")
+	}
+	if !durable.has("large") {
+		t.Error("expected the large session to overflow to the durable store")
+	}
+	if pointer, _ := fast.Get(ctx, "large"); pointer == nil || pointer.Values["cart"] != nil {
+		t.Errorf("expected only a pointer in the fast store, got %+v", pointer)
+	}
+
+	for _, s := range []*Session{small, large} {
+		got, err := store.Get(ctx, s.ID)
+		if err != nil || got == nil {
+			t.Fatalf("failed to get %s: %v", s.ID, err)
+		}
+		for k, v := range s.Values {
+			if got.Values[k] != v {
+				t.Errorf("%s: expected %s to round-trip", s.ID, k)
+			}
+		}
+	}
+
+	// The fast store decides: a lost pointer loses the session.
+	fast.Delete(ctx, "large")
+	if got, _ := store.Get(ctx, "large"); got != nil {
+		t.Error("expected a session without a pointer not to be found")
+	}
+
+	if err := store.Delete(ctx, "small"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if fast.has("small") {
+		t.Error("expected the session to be deleted")
+	}
+}
+
+func TestOverflowStore_ReusesManagerEncoding(t *testing.T) {
+	fast, durable := newMapStore(), newMapStore()
+	store := NewOverflowStoreWithConfig(OverflowConfig{Fast: fast, Durable: durable, Threshold: 8})
+	mgr := NewManager(Config{Store: store, MaxSessionBytes: 1 << 20})
+	defer mgr.Close()
+
+	s, _ := mgr.New()
+	s.Set("cart", strings.Repeat("x", 64))
+	if err := mgr.PersistSession(context.Background(), s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if !durable.has(s.ID) {
+		t.Error("expected the session to overflow")
+	}
+}
@@ -0,0 +1,73 @@
+package dbsession
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// EvictionPolicy selects which sessions are removed when an owner exceeds
+// Config.MaxSessionsPerUser.
+type EvictionPolicy int
+
+const (
+	// EvictOldest removes the sessions created first.
+	EvictOldest EvictionPolicy = iota
+	// EvictLeastRecentlyUsed removes the sessions accessed least recently.
+	EvictLeastRecentlyUsed
+)
+
+func isOwnerLister(s Store) bool {
+	_, ok := s.(OwnerLister)
+	return ok
+}
+
+// enforceSessionLimit deletes s's owner's surplus sessions so that, with s,
+// the owner has at most MaxSessionsPerUser live sessions. It runs when a
+// session is saved with a new owner, typically at login.
+func (m *Manager) enforceSessionLimit(ctx context.Context, s *Session) error {
+	lister, ok := m.store.(OwnerLister)
+	if m.maxPerUser <= 0 || s.OwnerID == "" || !ok {
+		return nil
+	}
+
+	sessions, err := lister.ListByOwner(ctx, s.OwnerID)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions for owner: %w", err)
+	}
+
+	now := m.clock.Now()
+	others := sessions[:0]
+	for _, o := range sessions {
+		if o.ID != s.ID && !o.ExpiresAt.Before(now) {
+			others = append(others, o)
+		}
+	}
+	surplus := len(others) - (m.maxPerUser - 1)
+	if surplus <= 0 {
+		return nil
+	}
+
+	// ListByOwner returns the oldest first.
+	if m.eviction == EvictLeastRecentlyUsed {
+		slices.SortStableFunc(others, func(a, b *Session) int {
+			return lastUsed(a).Compare(lastUsed(b))
+		})
+	}
+	for _, o := range others[:surplus] {
+		if err := m.DeleteSession(ctx, o.ID); err != nil {
+			return fmt.Errorf("failed to evict session: %w", err)
+		}
+	}
+	return nil
+}
+
+// lastUsed returns when s was last accessed, falling back to its creation
+// for rows written before access was tracked.
+func lastUsed(s *Session) time.Time {
+	if s.LastAccessedAt.IsZero() {
+		return s.CreatedAt
+	}
+	return s.LastAccessedAt
+}
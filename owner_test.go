@@ -0,0 +1,111 @@
+package dbsession
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// loginN creates n sessions for owner, a clock tick apart, and returns them.
+func loginN(t *testing.T, mgr *Manager, clock *fakeClock, owner string, n int) []*Session {
+	t.Helper()
+	ctx := context.Background()
+	sessions := make([]*Session, n)
+	for i := range sessions {
+		clock.Advance(time.Second)
+		s, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		s.OwnerID = owner
+		if err := mgr.PersistSession(ctx, s); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+		sessions[i] = s
+	}
+	return sessions
+}
+
+func TestManager_MaxSessionsPerUser(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	clock := newFakeClock()
+	var evicted []string
+	mgr := NewManager(Config{
+		Store:              store,
+		Clock:              clock,
+		MaxSessionsPerUser: 2,
+		OnDestroy:          func(id string) { evicted = append(evicted, id) },
+	})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	alice := loginN(t, mgr, clock, "alice", 3)
+	loginN(t, mgr, clock, "bob", 1)
+
+	if len(evicted) != 1 || evicted[0] != alice[0].ID {
+		t.Fatalf("expected the oldest session to be evicted, got %v", evicted)
+	}
+	list, err := store.ListByOwner(ctx, "alice")
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != alice[1].ID || list[1].ID != alice[2].ID {
+		t.Errorf("expected alice's two newest sessions, got %v", list)
+	}
+
+	// Saving again without changing the owner does not re-check the limit.
+	alice[2].Set("k", "v")
+	if err := mgr.PersistSession(ctx, alice[2]); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if len(evicted) != 1 {
+		t.Errorf("expected no further eviction, got %v", evicted)
+	}
+}
+
+func TestManager_MaxSessionsPerUser_LRU(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	clock := newFakeClock()
+	mgr := NewManager(Config{
+		Store:              store,
+		Clock:              clock,
+		MaxSessionsPerUser: 2,
+		EvictionPolicy:     EvictLeastRecentlyUsed,
+	})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	alice := loginN(t, mgr, clock, "alice", 2)
+
+	// Use the oldest session, so the second one becomes least recently used.
+	clock.Advance(time.Second)
+	loaded, err := mgr.LoadSession(ctx, alice[0].ID)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if err := mgr.PersistSession(ctx, loaded); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	loginN(t, mgr, clock, "alice", 1)
+
+	if got, _ := store.Get(ctx, alice[1].ID); got != nil {
+		t.Error("expected the least recently used session to be evicted")
+	}
+	if got, _ := store.Get(ctx, alice[0].ID); got == nil {
+		t.Error("expected the recently used session to be kept")
+	}
+}
+
+func TestConfig_MaxSessionsPerUserRequiresOwnerLister(t *testing.T) {
+	_, err := NewManagerE(Config{Store: &MockStore{}, MaxSessionsPerUser: 3})
+	if err == nil {
+		t.Error("expected error for a store that cannot list sessions by owner")
+	}
+}
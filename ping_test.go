@@ -0,0 +1,63 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestManager_Ping(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store})
+
+	ctx := context.Background()
+	if err := mgr.Ping(ctx); err != nil {
+		t.Errorf("expected ping to succeed, got %v", err)
+	}
+
+	mgr.Close()
+	if err := mgr.Ping(ctx); err == nil {
+		t.Error("expected ping to fail on a closed store")
+	}
+}
+
+func TestMemcachedStore_PingUnreachable(t *testing.T) {
+	// Nothing listens on port 1.
+	store := NewMemcachedStore(0, "127.0.0.1:1")
+	if err := store.Ping(context.Background()); err == nil {
+		t.Error("expected ping to fail when memcached is unreachable")
+	}
+}
+
+func TestTieredStore_PingIgnoresL1(t *testing.T) {
+	l1 := newMapStore()
+	l1.err = errors.New("cache unavailable")
+	l2 := newMapStore()
+	store := NewTieredStore(l1, l2)
+
+	if err := store.Ping(context.Background()); err != nil {
+		t.Errorf("expected ping to succeed with L1 down, got %v", err)
+	}
+	l2.err = errors.New("database unavailable")
+	if err := store.Ping(context.Background()); err == nil {
+		t.Error("expected ping to fail with L2 down")
+	}
+}
+
+func TestSQLiteStore_Stats(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+	if stats := store.Stats(); stats.OpenConnections == 0 {
+		t.Errorf("expected an open connection, got %+v", stats)
+	}
+}
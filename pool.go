@@ -3,8 +3,13 @@ package dbsession
 import (
 	"bytes"
 	"sync"
+	"sync/atomic"
 )
 
+// maxEncodedSizeHint caps the size buffers are grown to before encoding, so
+// that a few huge sessions do not make every buffer huge.
+const maxEncodedSizeHint = 64 << 10
+
 var readerPool = sync.Pool{
 	New: func() any {
 		return bytes.NewReader(nil)
@@ -25,15 +30,57 @@ var idBufferPool = sync.Pool{
 	},
 }
 
+// encodedSizeHint is a moving average of the sizes of recently encoded
+// sessions, see resetBuffer.
+var encodedSizeHint atomic.Int64
+
+// resetBuffer empties buf and grows it to fit a typical encoded session, so
+// that encoding into a fresh buffer does not reallocate it several times.
+func resetBuffer(buf *bytes.Buffer) {
+	buf.Reset()
+	if n := encodedSizeHint.Load(); n > 0 {
+		// Some headroom for sessions slightly larger than average.
+		buf.Grow(int(n + n/4))
+	}
+}
+
+// recordEncodedSize updates encodedSizeHint with the size of an encoded
+// session. Concurrent updates may lose a sample, which is harmless for an
+// estimate.
+func recordEncodedSize(n int) {
+	if n == 0 {
+		return
+	}
+	size := int64(min(n, maxEncodedSizeHint))
+	old := encodedSizeHint.Load()
+	if old == 0 {
+		encodedSizeHint.Store(size)
+		return
+	}
+	encodedSizeHint.Store(old + (size-old)/8)
+}
+
+// MaxPooledBufferSize is the largest capacity, in bytes, of a buffer that
+// PutBuffer returns to the pool. Larger buffers, grown by an unusually big
+// session, are left to the garbage collector instead of staying resident.
+// Zero or less pools buffers of any size. It must be set before the package
+// is used, e.g. in an init function or at the start of main.
+var MaxPooledBufferSize = 1 << 20
+
 // PutBuffer wipes the buffer's content and returns it to the pool.
 // This is a security enhancement to ensure sensitive session data
-// is not retained in memory longer than necessary.
+// is not retained in memory longer than necessary. Buffers larger than
+// MaxPooledBufferSize are wiped but not pooled.
 func PutBuffer(buf *bytes.Buffer) {
 	// Securely wipe the used portion of the buffer
 	// buf.Bytes() returns the unread portion of the buffer, which
 	// corresponds to the data we just wrote (and presumably read/used).
 	b := buf.Bytes()
+	recordEncodedSize(len(b))
 	clear(b)
 	buf.Reset()
+	if MaxPooledBufferSize > 0 && buf.Cap() > MaxPooledBufferSize {
+		return
+	}
 	bufferPool.Put(buf)
 }
@@ -3,31 +3,117 @@ package dbsession
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"database/sql"
-	"encoding/gob"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+)
+
+// PostgreSQLFormat selects how the PostgreSQL store persists session values.
+type PostgreSQLFormat string
+
+const (
+	// PostgreSQLFormatGob stores values gob-encoded in a BYTEA column. It is
+	// the default and round-trips any gob-registered type.
+	PostgreSQLFormatGob PostgreSQLFormat = "gob"
+	// PostgreSQLFormatJSONB stores values as a JSON object in a JSONB column,
+	// so they can be indexed and queried with SQL. JSON cannot represent Go
+	// types exactly: numbers are loaded back as float64, structs as
+	// map[string]any, []byte as a base64 string, and time.Time as a string.
+	PostgreSQLFormatJSONB PostgreSQLFormat = "jsonb"
 )
 
 type PostgreSQLStore struct {
 	db              *sql.DB
 	saveStmt        *sql.Stmt
 	getStmt         *sql.Stmt
+	getMultiStmt    *sql.Stmt
 	deleteStmt      *sql.Stmt
+	deleteMultiStmt *sql.Stmt
 	cleanupStmt     *sql.Stmt
+	insertStmt      *sql.Stmt // Optimistic locking: insert of a new session
+	updateStmt      *sql.Stmt // Optimistic locking: versioned update
 	maxSessionBytes int
+	maxDecodedBytes int
+	optimistic      bool
+	ownsDB          bool   // Whether Close should close db
+	table           string // Table name, schema-qualified if configured
+	jsonb           bool   // Values are stored as JSONB instead of gob
+	cleanupLockKey  int64  // Advisory lock key for CleanupExclusive
+	clock           Clock  // Time source for cleanup; replaceable in tests
+	codec           Codec
+	notifyChannel   string     // Channel Delete publishes invalidated IDs on, if set
+	batcher         *pgBatcher // Coalesces saves, if WriteBatchSize is set
+
+	expiryLeeway // See ExpiryLeewaySetter
 }
 
 // PostgreSQLConfig holds configuration for the PostgreSQL store.
 type PostgreSQLConfig struct {
-	DSN             string
+	DSN string
+	// TLSConfig, if set, encrypts the connections opened for DSN with it,
+	// e.g. to present a client certificate loaded from memory. It replaces
+	// the DSN's sslmode, sslcert, sslkey and sslrootcert settings.
+	TLSConfig *tls.Config
+	// DB, if set, is used instead of opening DSN. The store does not own it:
+	// Close leaves it open, and DSN and the pool settings are left to the
+	// caller.
+	DB *sql.DB
+	// TableName is the table sessions are stored in. Defaults to "sessions".
+	// It must be a plain identifier (letters, digits and underscores).
+	TableName string
+	// Schema, if set, qualifies the table (and its index) with a schema, for
+	// deployments that cannot use the search_path default. The schema must
+	// already exist. Like TableName, it is not quoted, so PostgreSQL folds it
+	// to lower case. Empty keeps the table name unqualified.
+	Schema          string
 	MaxOpenConns    int
 	MaxIdleConns    int
 	ConnMaxLifetime time.Duration
 	ConnMaxIdleTime time.Duration
 	MaxSessionBytes int
+	// OptimisticLocking makes Save fail with ErrConcurrentModification if the
+	// session was saved by someone else since it was loaded, instead of
+	// overwriting their changes. See Session.Version.
+	OptimisticLocking bool
+	// Format selects how values are stored. Defaults to PostgreSQLFormatGob.
+	// The data column type depends on it, so a table cannot be shared between
+	// formats.
+	Format PostgreSQLFormat
+	// Codec encodes session values in the gob format's BYTEA column, e.g. to
+	// substitute another binary encoding. Defaults to GobCodec. It cannot be
+	// combined with PostgreSQLFormatJSONB, which always stores JSON.
+	Codec Codec
+	// MaxDecodedBytes limits the size of a session's data once decoded by
+	// the codec, e.g. decompressed, guarding against small blobs that
+	// expand into gigabytes. It is enforced while decoding by codecs
+	// implementing LimitedDecoder. 0 means unlimited.
+	MaxDecodedBytes int
+	// NotifyChannel, if set, makes Delete publish the deleted session ID on
+	// this channel with NOTIFY, so that nodes running an
+	// InvalidationListener evict it from their local cache. It must be a
+	// plain identifier.
+	NotifyChannel string
+	// WriteBatchSize, if greater than 1, coalesces concurrent Saves: each
+	// waits up to WriteBatchDelay for others, and they are written together
+	// by a single multi-row upsert of at most WriteBatchSize sessions. This
+	// trades a little latency for far fewer round trips under load. A Save
+	// still returns only once its session is written, and Get and GetMulti
+	// see sessions whose batch is pending, unlike ForEach, ListByOwner and
+	// Count, which read the table only. Delete drops pending saves of the
+	// session. A failed upsert fails every Save in the batch.
+	// It cannot be combined with OptimisticLocking and is at most 1000.
+	WriteBatchSize int
+	// WriteBatchDelay is how long a batched Save waits for others to join
+	// its batch. Defaults to 2ms.
+	WriteBatchDelay time.Duration
 }
 
 // NewPostgreSQLStore creates a new PostgreSQL store with default configuration.
@@ -41,9 +127,53 @@ func NewPostgreSQLStore(dsn string) (*PostgreSQLStore, error) {
 	})
 }
 
+// NewPostgreSQLStoreWithDB creates a PostgreSQL store on an existing
+// connection pool, e.g. one with custom tuning or instrumentation.
+// The table is created if needed, but db is not closed by Close.
+func NewPostgreSQLStoreWithDB(db *sql.DB) (*PostgreSQLStore, error) {
+	return NewPostgreSQLStoreWithConfig(PostgreSQLConfig{DB: db})
+}
+
 // NewPostgreSQLStoreWithConfig creates a new PostgreSQL store with custom configuration.
 func NewPostgreSQLStoreWithConfig(cfg PostgreSQLConfig) (*PostgreSQLStore, error) {
-	db, err := sql.Open("postgres", cfg.DSN)
+	if cfg.TableName == "" {
+		cfg.TableName = defaultTableName
+	}
+	if err := validateIdentifier("table name", cfg.TableName); err != nil {
+		return nil, err
+	}
+	if cfg.Schema != "" {
+		if err := validateIdentifier("schema name", cfg.Schema); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.NotifyChannel != "" {
+		if err := validateIdentifier("notify channel", cfg.NotifyChannel); err != nil {
+			return nil, err
+		}
+	}
+	switch cfg.Format {
+	case "":
+		cfg.Format = PostgreSQLFormatGob
+	case PostgreSQLFormatGob, PostgreSQLFormatJSONB:
+	default:
+		return nil, fmt.Errorf("invalid postgresql format %q", cfg.Format)
+	}
+	if cfg.Format == PostgreSQLFormatJSONB && cfg.Codec != nil {
+		return nil, errors.New("postgresql jsonb format cannot be combined with a codec")
+	}
+	if cfg.WriteBatchSize > maxWriteBatchSize {
+		return nil, fmt.Errorf("postgresql write batch size %d exceeds %d", cfg.WriteBatchSize, maxWriteBatchSize)
+	}
+	if cfg.WriteBatchSize > 1 && cfg.OptimisticLocking {
+		return nil, errors.New("postgresql write batching cannot be combined with optimistic locking")
+	}
+
+	if cfg.DB != nil {
+		return newPostgreSQLStore(cfg.DB, cfg, false)
+	}
+
+	db, err := openPostgreSQL(cfg.DSN, cfg.TLSConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open postgresql database: %w", err)
 	}
@@ -62,74 +192,206 @@ func NewPostgreSQLStoreWithConfig(cfg PostgreSQLConfig) (*PostgreSQLStore, error
 		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 	}
 
+	return newPostgreSQLStore(db, cfg, true)
+}
+
+// newPostgreSQLStore creates the schema and prepares statements on db.
+// On failure, db is closed only if owned.
+func newPostgreSQLStore(db *sql.DB, cfg PostgreSQLConfig, ownsDB bool) (*PostgreSQLStore, error) {
+	store := &PostgreSQLStore{
+		db:              db,
+		maxSessionBytes: cfg.MaxSessionBytes,
+		maxDecodedBytes: cfg.MaxDecodedBytes,
+		optimistic:      cfg.OptimisticLocking,
+		ownsDB:          ownsDB,
+		table:           postgresTableName(cfg.Schema, cfg.TableName),
+		jsonb:           cfg.Format == PostgreSQLFormatJSONB,
+		clock:           systemClock{},
+		codec:           cfg.Codec,
+		notifyChannel:   cfg.NotifyChannel,
+	}
+	if store.jsonb {
+		store.codec = JSONCodec{}
+	} else if store.codec == nil {
+		store.codec = GobCodec{}
+	}
+	store.cleanupLockKey = advisoryLockKey("dbsession.cleanup:" + store.table)
+
 	// Test the connection
 	if err := db.Ping(); err != nil {
-		db.Close()
+		store.Close()
 		return nil, fmt.Errorf("failed to ping postgresql database: %w", err)
 	}
 
+	dataType := "bytea"
+	if store.jsonb {
+		dataType = "jsonb"
+	}
+
 	// Create table if not exists
-	query := `
-	CREATE TABLE IF NOT EXISTS sessions (
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %[1]s (
 		id TEXT PRIMARY KEY,
-		data BYTEA,
+		data %[3]s,
 		created_at TIMESTAMP WITH TIME ZONE NOT NULL,
-		expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		version INTEGER NOT NULL DEFAULT 0,
+		last_accessed_at TIMESTAMP WITH TIME ZONE,
+		regenerate_count INTEGER NOT NULL DEFAULT 0,
+		owner_id TEXT,
+		metadata TEXT
 	);
-	CREATE INDEX IF NOT EXISTS idx_expires_at ON sessions(expires_at);
-	`
+	CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s(expires_at);
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS last_accessed_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS regenerate_count INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS owner_id TEXT;
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS metadata TEXT;
+	CREATE INDEX IF NOT EXISTS %[4]s ON %[1]s(owner_id);
+	`, store.table, expiresIndexName(cfg.TableName), dataType, ownerIndexName(cfg.TableName))
 	if _, err := db.Exec(query); err != nil {
-		db.Close()
+		store.Close()
 		return nil, fmt.Errorf("failed to create sessions table: %w", err)
 	}
 
-	store := &PostgreSQLStore{
-		db:              db,
-		maxSessionBytes: cfg.MaxSessionBytes,
+	// An existing table may have been created with the other format. Writing
+	// JSON into BYTEA (or gob into JSONB) would corrupt or reject every row,
+	// so refuse to start instead.
+	var actual string
+	err := db.QueryRow(`
+		SELECT data_type FROM information_schema.columns
+		WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema())
+			AND table_name = $2 AND column_name = 'data'
+	`, strings.ToLower(cfg.Schema), strings.ToLower(cfg.TableName)).Scan(&actual)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to inspect sessions table: %w", err)
+	}
+	if actual != dataType {
+		store.Close()
+		return nil, fmt.Errorf("sessions table %s has a %s data column, but format %q needs %s", store.table, actual, cfg.Format, dataType)
 	}
 
 	// Prepare statements
-	store.saveStmt, err = db.Prepare(`
-		INSERT INTO sessions (id, data, created_at, expires_at)
-		VALUES ($1, $2, $3, $4)
+	store.saveStmt, err = db.Prepare(fmt.Sprintf(`
+		INSERT INTO %s (id, data, created_at, expires_at, last_accessed_at, regenerate_count, owner_id, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		ON CONFLICT(id) DO UPDATE SET
 			data = EXCLUDED.data,
-			expires_at = EXCLUDED.expires_at
-	`)
+			expires_at = EXCLUDED.expires_at,
+			last_accessed_at = EXCLUDED.last_accessed_at,
+			regenerate_count = EXCLUDED.regenerate_count,
+			owner_id = EXCLUDED.owner_id,
+			metadata = EXCLUDED.metadata
+	`, store.table))
 	if err != nil {
-		db.Close()
+		store.Close()
 		return nil, fmt.Errorf("failed to prepare save statement: %w", err)
 	}
 
-	store.getStmt, err = db.Prepare("SELECT data, created_at, expires_at FROM sessions WHERE id = $1 AND expires_at > $2")
+	// Expired rows are returned as-is so the Manager can tell "expired" apart
+	// from "not found"; they are removed by Cleanup.
+	store.getStmt, err = db.Prepare(fmt.Sprintf("SELECT %s FROM %s WHERE id = $1", sqlSessionColumns, store.table))
 	if err != nil {
 		store.Close()
 		return nil, fmt.Errorf("failed to prepare get statement: %w", err)
 	}
 
-	store.deleteStmt, err = db.Prepare("DELETE FROM sessions WHERE id = $1")
+	store.getMultiStmt, err = db.Prepare(fmt.Sprintf("SELECT id, %s FROM %s WHERE id = ANY($1)", sqlSessionColumns, store.table))
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to prepare get multi statement: %w", err)
+	}
+
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE id = $1", store.table)
+	if store.notifyChannel != "" {
+		// The notification is sent when the deletion commits, whether or
+		// not a row was deleted: a cache may still hold a session that
+		// is already gone from the table.
+		deleteQuery = fmt.Sprintf("WITH deleted AS (%s) SELECT pg_notify($2, $1)", deleteQuery)
+	}
+	store.deleteStmt, err = db.Prepare(deleteQuery)
 	if err != nil {
 		store.Close()
 		return nil, fmt.Errorf("failed to prepare delete statement: %w", err)
 	}
 
-	store.cleanupStmt, err = db.Prepare("DELETE FROM sessions WHERE expires_at < $1")
+	deleteMultiQuery := fmt.Sprintf("DELETE FROM %s WHERE id = ANY($1)", store.table)
+	if store.notifyChannel != "" {
+		deleteMultiQuery = fmt.Sprintf("WITH deleted AS (%s) SELECT pg_notify($2, id) FROM unnest($1::text[]) AS id", deleteMultiQuery)
+	}
+	store.deleteMultiStmt, err = db.Prepare(deleteMultiQuery)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to prepare delete multi statement: %w", err)
+	}
+
+	store.cleanupStmt, err = db.Prepare(fmt.Sprintf("DELETE FROM %s WHERE expires_at < $1", store.table))
 	if err != nil {
 		store.Close()
 		return nil, fmt.Errorf("failed to prepare cleanup statement: %w", err)
 	}
 
+	if store.optimistic {
+		store.insertStmt, err = db.Prepare(fmt.Sprintf(`
+			INSERT INTO %s (id, data, created_at, expires_at, last_accessed_at, regenerate_count, owner_id, metadata, version)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 1)
+			ON CONFLICT(id) DO NOTHING
+		`, store.table))
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to prepare insert statement: %w", err)
+		}
+
+		store.updateStmt, err = db.Prepare(fmt.Sprintf(`
+			UPDATE %s SET data = $1, expires_at = $2, last_accessed_at = $3, regenerate_count = $4, owner_id = $5,
+				metadata = $6, version = version + 1
+			WHERE id = $7 AND version = $8
+		`, store.table))
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to prepare update statement: %w", err)
+		}
+	}
+
+	if cfg.WriteBatchSize > 1 {
+		store.batcher = newPGBatcher(store, cfg.WriteBatchSize, cfg.WriteBatchDelay)
+	}
 	return store, nil
 }
 
+// advisoryLockKey derives a PostgreSQL advisory lock key from name, so that
+// stores on different tables do not contend for the same lock.
+func advisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// postgresTableName returns the table name, qualified with schema if set.
+// Neither part is quoted, like the index names, so PostgreSQL folds them
+// all to lower case alike. An index created on a qualified table lives in
+// the same schema, so the index name itself stays unqualified.
+func postgresTableName(schema, table string) string {
+	if schema == "" {
+		return table
+	}
+	return schema + "." + table
+}
+
 func (s *PostgreSQLStore) Get(ctx context.Context, id string) (*Session, error) {
-	// Use sql.RawBytes to avoid allocation if the driver supports it.
-	// data is valid only until rows.Close() is called.
-	var data sql.RawBytes
-	var createdAt, expiresAt time.Time
+	// A session whose save is still waiting for its batch is newer than
+	// the stored row.
+	if s.batcher != nil {
+		if session, ok, err := s.batcher.get(id); ok {
+			return session, err
+		}
+	}
+
+	var row sqlRow
 
 	// Use QueryContext instead of QueryRowContext to support sql.RawBytes.
-	rows, err := s.getStmt.QueryContext(ctx, id, time.Now())
+	rows, err := s.getStmt.QueryContext(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query session: %w", err)
 	}
@@ -139,101 +401,336 @@ func (s *PostgreSQLStore) Get(ctx context.Context, id string) (*Session, error)
 		if err := rows.Err(); err != nil {
 			return nil, fmt.Errorf("failed to iterate rows: %w", err)
 		}
-		return nil, nil // Not found or expired
+		return nil, nil // Not found
 	}
 
-	if err := rows.Scan(&data, &createdAt, &expiresAt); err != nil {
+	if err := rows.Scan(row.dest()...); err != nil {
 		return nil, fmt.Errorf("failed to scan session: %w", err)
 	}
 
-	if s.maxSessionBytes > 0 && len(data) > s.maxSessionBytes {
-		return nil, ErrSessionTooLarge
+	values, err := s.decode(row.data)
+	if err != nil {
+		return nil, err
 	}
 
-	var values map[string]any
+	return row.session(id, values)
+}
 
-	// Optimize for empty/new sessions: skip Gob decoding if data is empty/NULL.
-	if len(data) > 0 {
-		reader := readerPool.Get().(*bytes.Reader)
-		reader.Reset(data)
-		defer readerPool.Put(reader)
+// GetMulti retrieves several sessions with a single query. Sessions that do
+// not exist are absent from the result. As with Get, expired sessions are
+// returned as stored, and sessions whose batch is pending as last saved.
+func (s *PostgreSQLStore) GetMulti(ctx context.Context, ids []string) (map[string]*Session, error) {
+	sessions := make(map[string]*Session, len(ids))
+	if len(ids) == 0 {
+		return sessions, nil
+	}
+	if s.batcher != nil {
+		pending, err := s.batcher.getMulti(ids)
+		if err != nil {
+			return nil, err
+		}
+		if len(pending) > 0 {
+			ids = slices.DeleteFunc(slices.Clone(ids), func(id string) bool { return pending[id] != nil })
+			maps.Copy(sessions, pending)
+			if len(ids) == 0 {
+				return sessions, nil
+			}
+		}
+	}
 
-		if err := gob.NewDecoder(reader).Decode(&values); err != nil {
-			return nil, fmt.Errorf("failed to decode session data: %w", err)
+	rows, err := s.getMultiStmt.QueryContext(ctx, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var row sqlRow
+		if err := rows.Scan(append([]any{&id}, row.dest()...)...); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		values, err := s.decode(row.data)
+		if err != nil {
+			return nil, err
+		}
+		session, err := row.session(id, values)
+		if err != nil {
+			return nil, err
 		}
+		sessions[id] = session
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return sessions, nil
+}
+
+// ForEach calls fn for every stored session, see Enumerator. The query
+// holds a connection until ForEach returns.
+func (s *PostgreSQLStore) ForEach(ctx context.Context, fn func(*Session, error) error) error {
+	return querySessions(ctx, s.db, s.decode, fn, fmt.Sprintf("SELECT id, %s FROM %s", sqlSessionColumns, s.table))
+}
+
+// Count returns the number of sessions that have not expired, see Counter.
+func (s *PostgreSQLStore) Count(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE expires_at >= $1", s.table), s.expiryCutoff(s.clock.Now())).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sessions: %w", err)
 	}
+	return n, nil
+}
+
+// ListByOwner returns the sessions owned by owner, oldest first, expired
+// ones included.
+func (s *PostgreSQLStore) ListByOwner(ctx context.Context, owner string) ([]*Session, error) {
+	return listSessions(ctx, s.db, s.decode,
+		fmt.Sprintf("SELECT id, %s FROM %s WHERE owner_id = $1 ORDER BY created_at", sqlSessionColumns, s.table), owner)
+}
 
-	if values == nil {
-		values = make(map[string]any)
+// decode decodes stored session data. An empty map is returned for NULL data.
+func (s *PostgreSQLStore) decode(data []byte) (map[string]any, error) {
+	if s.maxSessionBytes > 0 && len(data) > s.maxSessionBytes {
+		return nil, &SessionTooLargeError{Size: len(data), Limit: s.maxSessionBytes}
 	}
 
-	return &Session{
-		ID:        id,
-		Values:    values,
-		CreatedAt: createdAt,
-		ExpiresAt: expiresAt,
-	}, nil
+	// Optimize for empty/new sessions: decoding is skipped if data is empty/NULL.
+	return decodeValues(s.codec, data, int64(s.maxDecodedBytes))
 }
 
 func (s *PostgreSQLStore) Save(ctx context.Context, session *Session) error {
-	var blob []byte
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer PutBuffer(buf)
 
-	// Optimize for empty sessions: store NULL instead of Gob encoded empty map.
-	// This saves allocations and CPU cycles for sessions that are just created but not populated.
-	if len(session.Values) > 0 {
-		if session.encoded != nil {
-			blob = session.encoded
-		} else {
-			buf := bufferPool.Get().(*bytes.Buffer)
-			buf.Reset()
-			defer PutBuffer(buf)
-
-			if err := gob.NewEncoder(buf).Encode(session.Values); err != nil {
-				return fmt.Errorf("failed to encode session data: %w", err)
-			}
-			blob = buf.Bytes()
-		}
+	data, err := s.encode(session, buf)
+	if err != nil {
+		return err
+	}
+	if s.batcher != nil {
+		return s.batcher.save(ctx, session, data)
 	}
 
-	if s.maxSessionBytes > 0 && len(blob) > s.maxSessionBytes {
-		return ErrSessionTooLarge
+	if err := s.saveStmts().exec(ctx, s.optimistic, session, data); err != nil {
+		return err
+	}
+	if s.optimistic {
+		session.Version++
+	}
+	return nil
+}
+
+// SaveMulti saves several sessions in one transaction: either all of them
+// are saved or none is. With optimistic locking, a single stale session
+// fails the whole batch with ErrConcurrentModification.
+func (s *PostgreSQLStore) SaveMulti(ctx context.Context, sessions []*Session) error {
+	if len(sessions) == 0 {
+		return nil
 	}
 
-	_, err := s.saveStmt.ExecContext(ctx, session.ID, blob, session.CreatedAt, session.ExpiresAt)
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer PutBuffer(buf)
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to save session: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmts := s.saveStmts().bind(ctx, tx)
+	for _, session := range sessions {
+		// buf is reused: the previous data has been written by now.
+		data, err := s.encode(session, buf)
+		if err != nil {
+			return err
+		}
+		if err := stmts.exec(ctx, s.optimistic, session, data); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if s.optimistic {
+		for _, session := range sessions {
+			session.Version++
+		}
 	}
 	return nil
 }
 
+// encode returns the query argument for session's data: nil for an empty
+// session, []byte for gob and string for JSONB (lib/pq sends []byte as
+// bytea, which a JSONB column rejects). buf is used as scratch space, so the
+// result is only valid until buf is reused.
+func (s *PostgreSQLStore) encode(session *Session, buf *bytes.Buffer) (any, error) {
+	// Optimize for empty sessions: store NULL instead of an encoded empty map.
+	// This saves allocations and CPU cycles for sessions that are just created but not populated.
+	if len(session.Values) == 0 {
+		return nil, nil
+	}
+
+	blob, err := encodeValues(s.codec, session, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.maxSessionBytes > 0 && len(blob) > s.maxSessionBytes {
+		return nil, &SessionTooLargeError{Size: len(blob), Limit: s.maxSessionBytes}
+	}
+	if s.jsonb {
+		return string(blob), nil
+	}
+	return blob, nil
+}
+
+// saveStmts returns the statements used to write a session.
+func (s *PostgreSQLStore) saveStmts() sqlSaveStmts {
+	return sqlSaveStmts{save: s.saveStmt, insert: s.insertStmt, update: s.updateStmt}
+}
+
 func (s *PostgreSQLStore) Delete(ctx context.Context, id string) error {
-	_, err := s.deleteStmt.ExecContext(ctx, id)
+	if s.batcher != nil {
+		if err := s.batcher.cancel(ctx, []string{id}); err != nil {
+			return fmt.Errorf("failed to delete session: %w", err)
+		}
+	}
+	args := []any{id}
+	if s.notifyChannel != "" {
+		args = append(args, s.notifyChannel)
+	}
+	_, err := s.deleteStmt.ExecContext(ctx, args...)
 	if err != nil {
 		return fmt.Errorf("failed to delete session: %w", err)
 	}
 	return nil
 }
 
+// DeleteMulti removes the sessions with the given IDs with a single
+// statement, which deletes all of them or none, see MultiDeleter.
+func (s *PostgreSQLStore) DeleteMulti(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if s.batcher != nil {
+		if err := s.batcher.cancel(ctx, ids); err != nil {
+			return fmt.Errorf("failed to delete sessions: %w", err)
+		}
+	}
+	args := []any{pq.Array(ids)}
+	if s.notifyChannel != "" {
+		args = append(args, s.notifyChannel)
+	}
+	if _, err := s.deleteMultiStmt.ExecContext(ctx, args...); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return nil
+}
+
 func (s *PostgreSQLStore) Cleanup(ctx context.Context) error {
-	_, err := s.cleanupStmt.ExecContext(ctx, time.Now())
+	_, err := s.CleanupCount(ctx)
+	return err
+}
+
+// CleanupExclusive removes expired sessions if no other process is cleaning
+// the same table, using a transaction-scoped advisory lock that is released
+// on commit. It reports whether the cleanup ran.
+func (s *PostgreSQLStore) CleanupExclusive(ctx context.Context) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to cleanup expired sessions: %w", err)
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", s.cleanupLockKey).Scan(&locked); err != nil {
+		return false, fmt.Errorf("failed to acquire cleanup lock: %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+
+	cutoff := s.expiryCutoff(s.clock.Now())
+	if s.batcher != nil {
+		s.batcher.cancelExpired(cutoff)
+	}
+	if _, err := tx.StmtContext(ctx, s.cleanupStmt).ExecContext(ctx, cutoff); err != nil {
+		return false, fmt.Errorf("failed to cleanup expired sessions: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// CleanupCount removes expired sessions and returns how many were removed.
+func (s *PostgreSQLStore) CleanupCount(ctx context.Context) (int64, error) {
+	cutoff := s.expiryCutoff(s.clock.Now())
+	if s.batcher != nil {
+		s.batcher.cancelExpired(cutoff)
+	}
+	res, err := s.cleanupStmt.ExecContext(ctx, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cleanup expired sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to cleanup expired sessions: %w", err)
+	}
+	return n, nil
+}
+
+// Ping checks that the database is reachable.
+func (s *PostgreSQLStore) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
 	}
 	return nil
 }
 
+// Stats returns the connection pool statistics of the underlying database,
+// e.g. to graph open and in-use connections or wait counts.
+func (s *PostgreSQLStore) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// Codec returns the codec session values are encoded with, see CodecStore.
+func (s *PostgreSQLStore) Codec() Codec {
+	return s.codec
+}
+
 func (s *PostgreSQLStore) Close() error {
+	// Queued saves are written before the statements and pool go away.
+	if s.batcher != nil {
+		s.batcher.close()
+	}
 	if s.saveStmt != nil {
 		s.saveStmt.Close()
 	}
 	if s.getStmt != nil {
 		s.getStmt.Close()
 	}
+	if s.getMultiStmt != nil {
+		s.getMultiStmt.Close()
+	}
 	if s.deleteStmt != nil {
 		s.deleteStmt.Close()
 	}
+	if s.deleteMultiStmt != nil {
+		s.deleteMultiStmt.Close()
+	}
 	if s.cleanupStmt != nil {
 		s.cleanupStmt.Close()
 	}
+	if s.insertStmt != nil {
+		s.insertStmt.Close()
+	}
+	if s.updateStmt != nil {
+		s.updateStmt.Close()
+	}
+	if !s.ownsDB {
+		return nil
+	}
 	return s.db.Close()
 }
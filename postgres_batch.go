@@ -0,0 +1,344 @@
+package dbsession
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultWriteBatchDelay is how long a batched Save waits for others by
+	// default.
+	defaultWriteBatchDelay = 2 * time.Millisecond
+	// maxWriteBatchSize keeps a batch's upsert well below PostgreSQL's limit
+	// of 65535 parameters.
+	maxWriteBatchSize = 1000
+)
+
+// errPostgreSQLClosed is returned by batched saves after Close.
+var errPostgreSQLClosed = errors.New("postgresql store is closed")
+
+// pgWrite is a save waiting in a pgBatcher.
+type pgWrite struct {
+	ctx      context.Context
+	session  *Session // Snapshot without Values, for Get
+	data     any      // Query argument, see PostgreSQLStore.encode; owned by the write
+	metadata any
+	done     chan error // Receives the result, buffered
+
+	// Guarded by pgBatcher.mu.
+	cancelled bool          // Dropped by a delete, see pgBatcher.cancel
+	flushing  chan struct{} // Closed once its batch is written; nil until flushed
+}
+
+// pgBatcher coalesces concurrent saves into multi-row upserts, see
+// PostgreSQLConfig.WriteBatchSize.
+type pgBatcher struct {
+	store  *PostgreSQLStore
+	size   int
+	delay  time.Duration
+	writes chan *pgWrite
+	done   chan struct{} // Closed when the flush loop exits
+
+	mu      sync.Mutex
+	pending map[string][]*pgWrite // Unwritten saves of each session, oldest first
+	closed  bool
+}
+
+func newPGBatcher(store *PostgreSQLStore, size int, delay time.Duration) *pgBatcher {
+	if delay <= 0 {
+		delay = defaultWriteBatchDelay
+	}
+	b := &pgBatcher{
+		store:   store,
+		size:    size,
+		delay:   delay,
+		writes:  make(chan *pgWrite, size),
+		done:    make(chan struct{}),
+		pending: make(map[string][]*pgWrite),
+	}
+	go b.loop()
+	return b
+}
+
+// save queues session for the next batch and waits until it is written.
+// data is copied, so the caller may reuse its buffer once save returns.
+func (b *pgBatcher) save(ctx context.Context, session *Session, data any) error {
+	metadata, err := encodeMetadata(session.Metadata)
+	if err != nil {
+		return err
+	}
+	if blob, ok := data.([]byte); ok {
+		data = bytes.Clone(blob)
+	}
+	w := &pgWrite{
+		ctx: ctx,
+		session: &Session{
+			ID:              session.ID,
+			CreatedAt:       session.CreatedAt,
+			ExpiresAt:       session.ExpiresAt,
+			LastAccessedAt:  session.LastAccessedAt,
+			RegenerateCount: session.RegenerateCount,
+			OwnerID:         session.OwnerID,
+			Metadata:        maps.Clone(session.Metadata),
+		},
+		data:     data,
+		metadata: metadata,
+		done:     make(chan error, 1),
+	}
+
+	// The lock is held while sending, so that close cannot close the
+	// channel under a sender; the loop receives without it.
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return fmt.Errorf("failed to save session: %w", errPostgreSQLClosed)
+	}
+	b.pending[session.ID] = append(b.pending[session.ID], w)
+	b.writes <- w
+	b.mu.Unlock()
+	return <-w.done
+}
+
+// get returns the session with the given ID if a save of it is waiting to
+// be written, so that it can be read before the batch is flushed.
+func (b *pgBatcher) get(id string) (*Session, bool, error) {
+	b.mu.Lock()
+	writes := b.pending[id]
+	b.mu.Unlock()
+	if len(writes) == 0 {
+		return nil, false, nil
+	}
+	w := writes[len(writes)-1]
+
+	var raw []byte
+	switch data := w.data.(type) {
+	case []byte:
+		raw = data
+	case string:
+		raw = []byte(data)
+	}
+	values, err := b.store.decode(raw)
+	if err != nil {
+		return nil, true, err
+	}
+	s := w.session
+	return &Session{
+		ID:              s.ID,
+		Values:          values,
+		CreatedAt:       s.CreatedAt,
+		ExpiresAt:       s.ExpiresAt,
+		LastAccessedAt:  s.LastAccessedAt,
+		RegenerateCount: s.RegenerateCount,
+		OwnerID:         s.OwnerID,
+		Metadata:        maps.Clone(s.Metadata),
+	}, true, nil
+}
+
+// getMulti is like get for several sessions. Sessions with no pending save
+// are absent from the result.
+func (b *pgBatcher) getMulti(ids []string) (map[string]*Session, error) {
+	sessions := make(map[string]*Session)
+	for _, id := range ids {
+		session, ok, err := b.get(id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			sessions[id] = session
+		}
+	}
+	return sessions, nil
+}
+
+// cancel drops the pending saves of the given sessions before they are
+// deleted, so that flushing their batch does not bring them back. A dropped
+// Save reports success, as if it had been written just before the delete.
+// Saves whose batch is already being written cannot be dropped; cancel
+// waits for them instead, so that the delete comes after them.
+func (b *pgBatcher) cancel(ctx context.Context, ids []string) error {
+	var flushing []chan struct{}
+	b.mu.Lock()
+	for _, id := range ids {
+		for _, w := range b.pending[id] {
+			if w.flushing != nil {
+				flushing = append(flushing, w.flushing)
+			} else {
+				w.cancelled = true
+			}
+		}
+		delete(b.pending, id)
+	}
+	b.mu.Unlock()
+
+	for _, ch := range flushing {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// cancelExpired drops the pending saves of sessions that expire before
+// cutoff, which Cleanup removes from the table.
+func (b *pgBatcher) cancelExpired(cutoff time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, writes := range b.pending {
+		writes = slices.DeleteFunc(writes, func(w *pgWrite) bool {
+			if w.flushing == nil && w.session.ExpiresAt.Before(cutoff) {
+				w.cancelled = true
+				return true
+			}
+			return false
+		})
+		if len(writes) == 0 {
+			delete(b.pending, id)
+		} else {
+			b.pending[id] = writes
+		}
+	}
+}
+
+// close writes the queued saves and stops the batcher.
+func (b *pgBatcher) close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	close(b.writes)
+	b.mu.Unlock()
+	<-b.done
+}
+
+func (b *pgBatcher) loop() {
+	defer close(b.done)
+	timer := time.NewTimer(b.delay)
+	timer.Stop()
+	batch := make([]*pgWrite, 0, b.size)
+	for w := range b.writes {
+		batch = append(batch[:0], w)
+		timer.Reset(b.delay)
+	collect:
+		for len(batch) < b.size {
+			select {
+			case w, ok := <-b.writes:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, w)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		b.flush(batch)
+	}
+}
+
+// flush writes batch with a single upsert and reports the results. A row
+// cannot be upserted twice by one statement, so only one save of each
+// session is written: the latest whose caller is still waiting, whose
+// result the earlier saves share. Saves dropped by cancel are skipped.
+func (b *pgBatcher) flush(batch []*pgWrite) {
+	written := make(chan struct{})
+	live := make([]bool, len(batch))
+	chosen := make(map[string]*pgWrite, len(batch))
+	b.mu.Lock()
+	for i, w := range batch {
+		if w.cancelled {
+			continue
+		}
+		// From here on cancel waits for the batch rather than dropping w.
+		w.flushing = written
+		if w.ctx.Err() == nil {
+			live[i] = true
+			chosen[w.session.ID] = w
+		}
+	}
+	b.mu.Unlock()
+
+	var err error
+	if len(chosen) > 0 {
+		rows := make([]*pgWrite, 0, len(chosen))
+		for _, w := range batch {
+			if chosen[w.session.ID] == w {
+				rows = append(rows, w)
+			}
+		}
+		// Not bound to a caller's context: one cancellation would fail
+		// every save in the batch.
+		err = b.store.upsert(context.Background(), rows)
+	}
+
+	b.mu.Lock()
+	for _, w := range batch {
+		id := w.session.ID
+		writes := slices.DeleteFunc(b.pending[id], func(p *pgWrite) bool { return p == w })
+		if len(writes) == 0 {
+			delete(b.pending, id)
+		} else {
+			b.pending[id] = writes
+		}
+	}
+	b.mu.Unlock()
+	close(written)
+
+	for i, w := range batch {
+		switch {
+		case w.cancelled:
+			w.done <- nil
+		case !live[i]:
+			w.done <- w.ctx.Err()
+		default:
+			w.done <- err
+		}
+	}
+}
+
+// upsert writes rows with one multi-row INSERT ... ON CONFLICT statement.
+func (s *PostgreSQLStore) upsert(ctx context.Context, rows []*pgWrite) error {
+	dataType := "bytea"
+	if s.jsonb {
+		dataType = "jsonb"
+	}
+	// Placeholders are cast explicitly: in a multi-row VALUES list,
+	// PostgreSQL would otherwise take untyped parameters for text.
+	var query strings.Builder
+	fmt.Fprintf(&query, "INSERT INTO %s (id, data, created_at, expires_at, last_accessed_at, regenerate_count, owner_id, metadata) VALUES ", s.table)
+	args := make([]any, 0, 8*len(rows))
+	for i, w := range rows {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&query, "($%d::text, $%d::%s, $%d::timestamptz, $%d::timestamptz, $%d::timestamptz, $%d::integer, $%d::text, $%d::text)",
+			n+1, n+2, dataType, n+3, n+4, n+5, n+6, n+7, n+8)
+		session := w.session
+		args = append(args, session.ID, w.data, session.CreatedAt, session.ExpiresAt,
+			nullTime(session.LastAccessedAt), session.RegenerateCount, nullString(session.OwnerID), w.metadata)
+	}
+	query.WriteString(`
+		ON CONFLICT(id) DO UPDATE SET
+			data = EXCLUDED.data,
+			expires_at = EXCLUDED.expires_at,
+			last_accessed_at = EXCLUDED.last_accessed_at,
+			regenerate_count = EXCLUDED.regenerate_count,
+			owner_id = EXCLUDED.owner_id,
+			metadata = EXCLUDED.metadata`)
+
+	if _, err := s.db.ExecContext(ctx, query.String(), args...); err != nil {
+		return fmt.Errorf("failed to save sessions: %w", err)
+	}
+	return nil
+}
@@ -1,8 +1,12 @@
 package dbsession
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -189,10 +193,21 @@ func BenchmarkPostgreSQLStore_Get(b *testing.B) {
 	}
 }
 
+// BenchmarkPostgreSQLStore_SaveParallel contrasts a round trip per Save with
+// write batching.
 func BenchmarkPostgreSQLStore_SaveParallel(b *testing.B) {
-	dsn := getTestPostgreSQLDSN()
+	b.Run("PerCall", func(b *testing.B) {
+		benchmarkPostgreSQLSaveParallel(b, PostgreSQLConfig{})
+	})
+	b.Run("Batched", func(b *testing.B) {
+		benchmarkPostgreSQLSaveParallel(b, PostgreSQLConfig{WriteBatchSize: 100})
+	})
+}
 
-	store, err := NewPostgreSQLStore(dsn)
+func benchmarkPostgreSQLSaveParallel(b *testing.B, cfg PostgreSQLConfig) {
+	cfg.DSN = getTestPostgreSQLDSN()
+	cfg.MaxOpenConns = 25
+	store, err := NewPostgreSQLStoreWithConfig(cfg)
 	if err != nil {
 		b.Skipf("Skipping PostgreSQL benchmark: %v", err)
 	}
@@ -200,6 +215,8 @@ func BenchmarkPostgreSQLStore_SaveParallel(b *testing.B) {
 
 	ctx := context.Background()
 
+	b.ReportAllocs()
+	b.SetParallelism(16)
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		i := 0
@@ -252,3 +269,382 @@ func BenchmarkPostgreSQLStore_GetParallel(b *testing.B) {
 		}
 	})
 }
+
+func TestPostgreSQLStore_JSONB(t *testing.T) {
+	store, err := NewPostgreSQLStoreWithConfig(PostgreSQLConfig{
+		DSN:       getTestPostgreSQLDSN(),
+		TableName: "sessions_jsonb_test",
+		Format:    PostgreSQLFormatJSONB,
+	})
+	if err != nil {
+		t.Skipf("Skipping PostgreSQL test: %v (is PostgreSQL running?)", err)
+	}
+	defer store.Close()
+	defer store.db.Exec("DROP TABLE sessions_jsonb_test")
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "test-pg-jsonb-session",
+		Values:    map[string]any{"role": "admin", "count": 42, "tags": []string{"a", "b"}},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	got, err := store.Get(ctx, s.ID)
+	if err != nil || got == nil {
+		t.Fatalf("failed to get session: %v, %v", got, err)
+	}
+	if got.Values["role"] != "admin" {
+		t.Errorf("expected role admin, got %v", got.Values["role"])
+	}
+	// JSON numbers come back as float64.
+	if got.Values["count"] != float64(42) {
+		t.Errorf("expected count 42 as float64, got %#v", got.Values["count"])
+	}
+	if tags, ok := got.Values["tags"].([]any); !ok || len(tags) != 2 || tags[0] != "a" {
+		t.Errorf("unexpected tags: %#v", got.Values["tags"])
+	}
+
+	// The values must be queryable with SQL.
+	var n int
+	err = store.db.QueryRow("SELECT COUNT(*) FROM sessions_jsonb_test WHERE data->>'role' = 'admin'").Scan(&n)
+	if err != nil {
+		t.Fatalf("failed to query jsonb: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 admin session, got %d", n)
+	}
+
+	// An empty session is stored as NULL.
+	empty := &Session{ID: "test-pg-jsonb-empty", Values: map[string]any{}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, empty); err != nil {
+		t.Fatalf("failed to save empty session: %v", err)
+	}
+	if got, err := store.Get(ctx, empty.ID); err != nil || got == nil || len(got.Values) != 0 {
+		t.Errorf("unexpected empty session: %v, %v", got, err)
+	}
+
+	// The gob format must refuse a JSONB table.
+	if _, err := NewPostgreSQLStoreWithConfig(PostgreSQLConfig{DB: store.db, TableName: "sessions_jsonb_test"}); err == nil {
+		t.Error("expected error opening a jsonb table with the gob format")
+	}
+}
+
+func TestPostgreSQLStore_InvalidFormat(t *testing.T) {
+	_, err := NewPostgreSQLStoreWithConfig(PostgreSQLConfig{DSN: "postgres://invalid", Format: "xml"})
+	if err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestPostgreSQLStore_WriteBatchConfig(t *testing.T) {
+	for _, cfg := range []PostgreSQLConfig{
+		{WriteBatchSize: maxWriteBatchSize + 1},
+		{WriteBatchSize: 10, OptimisticLocking: true},
+	} {
+		cfg.DSN = "postgres://invalid"
+		if _, err := NewPostgreSQLStoreWithConfig(cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestPostgreSQLStore_WriteBatch(t *testing.T) {
+	store, err := NewPostgreSQLStoreWithConfig(PostgreSQLConfig{
+		DSN:             getTestPostgreSQLDSN(),
+		WriteBatchSize:  50,
+		WriteBatchDelay: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Skipf("Skipping PostgreSQL test: %v (is PostgreSQL running?)", err)
+	}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := &Session{
+				ID:        fmt.Sprintf("test-pg-batch-%d", i),
+				Values:    map[string]any{"n": i},
+				CreatedAt: time.Now(),
+				ExpiresAt: time.Now().Add(time.Hour),
+				OwnerID:   "batch-owner",
+			}
+			if err := store.Save(ctx, s); err != nil {
+				t.Errorf("failed to save session %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range 20 {
+		id := fmt.Sprintf("test-pg-batch-%d", i)
+		got, err := store.Get(ctx, id)
+		if err != nil || got == nil {
+			t.Fatalf("failed to get %s: %v, %v", id, got, err)
+		}
+		if got.Values["n"] != i || got.OwnerID != "batch-owner" {
+			t.Errorf("unexpected session %s: %+v", id, got)
+		}
+		store.Delete(ctx, id)
+	}
+
+	store.Close()
+	s := &Session{ID: "test-pg-batch-closed", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, s); !errors.Is(err, errPostgreSQLClosed) {
+		t.Errorf("expected a closed store error, got %v", err)
+	}
+}
+
+// TestPGBatcher_ReadYourWrites checks that a session is visible while its
+// batch is pending. The batcher's loop is not started, so nothing is
+// written to a database.
+func TestPGBatcher_ReadYourWrites(t *testing.T) {
+	b := &pgBatcher{
+		store:   &PostgreSQLStore{codec: GobCodec{}},
+		writes:  make(chan *pgWrite, 1),
+		pending: make(map[string][]*pgWrite),
+	}
+	store := b.store
+	buf := new(bytes.Buffer)
+	s := &Session{
+		ID:        "pending",
+		Values:    map[string]any{"user": "alice"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+		Metadata:  map[string]string{"ip": "127.0.0.1"},
+	}
+	data, err := store.encode(s, buf)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+
+	saved := make(chan error, 1)
+	go func() { saved <- b.save(context.Background(), s, data) }()
+	w := <-b.writes
+	// The encoding buffer may be reused once queued.
+	buf.Reset()
+	buf.WriteString("garbage")
+
+	got, ok, err := b.get("pending")
+	if err != nil || !ok {
+		t.Fatalf("expected the pending session, got %v, %v", ok, err)
+	}
+	if got.Values["user"] != "alice" || got.Metadata["ip"] != "127.0.0.1" || !got.ExpiresAt.Equal(s.ExpiresAt) {
+		t.Errorf("unexpected pending session: %+v", got)
+	}
+	if _, ok, _ := b.get("other"); ok {
+		t.Error("expected no pending session for another ID")
+	}
+
+	// Once written, the session is read from the database again.
+	delete(b.pending, w.session.ID)
+	w.done <- nil
+	if err := <-saved; err != nil {
+		t.Errorf("unexpected save error: %v", err)
+	}
+	if _, ok, _ := b.get("pending"); ok {
+		t.Error("expected the session to be no longer pending")
+	}
+}
+
+// TestPGBatcher_Cancel checks that deleting a session drops its pending
+// save, so that flushing the batch does not bring it back, and waits for a
+// save whose batch is already being written. No database is involved: a
+// dropped save is never upserted.
+func TestPGBatcher_Cancel(t *testing.T) {
+	b := &pgBatcher{
+		store:   &PostgreSQLStore{codec: GobCodec{}},
+		writes:  make(chan *pgWrite, 2),
+		pending: make(map[string][]*pgWrite),
+	}
+	s := &Session{ID: "pending", Values: map[string]any{"user": "alice"}, ExpiresAt: time.Now().Add(time.Hour)}
+	data, err := b.store.encode(s, new(bytes.Buffer))
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+
+	saved := make(chan error, 2)
+	go func() { saved <- b.save(context.Background(), s, data) }()
+	first := <-b.writes
+	go func() { saved <- b.save(context.Background(), s, data) }()
+	second := <-b.writes
+
+	if err := b.cancel(context.Background(), []string{s.ID}); err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+	if _, ok, _ := b.get(s.ID); ok {
+		t.Error("expected a deleted session not to be served from the batch")
+	}
+	if sessions, err := b.getMulti([]string{s.ID}); err != nil || len(sessions) != 0 {
+		t.Errorf("expected GetMulti not to serve a deleted session, got %v, %v", sessions, err)
+	}
+	// Flushing would panic on the nil database if anything were written.
+	b.flush([]*pgWrite{first, second})
+	for range 2 {
+		if err := <-saved; err != nil {
+			t.Errorf("expected a dropped save to succeed, got %v", err)
+		}
+	}
+
+	// A save already being written is waited for instead.
+	go func() { saved <- b.save(context.Background(), s, data) }()
+	w := <-b.writes
+	written := make(chan struct{})
+	b.mu.Lock()
+	w.flushing = written
+	b.mu.Unlock()
+
+	cancelled := make(chan error, 1)
+	go func() { cancelled <- b.cancel(context.Background(), []string{s.ID}) }()
+	select {
+	case err := <-cancelled:
+		t.Fatalf("expected cancel to wait for the batch being written, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(written)
+	if err := <-cancelled; err != nil {
+		t.Errorf("unexpected cancel error: %v", err)
+	}
+	w.done <- nil
+	<-saved
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	go func() { saved <- b.save(context.Background(), s, data) }()
+	w = <-b.writes
+	b.mu.Lock()
+	w.flushing = make(chan struct{})
+	b.mu.Unlock()
+	if err := b.cancel(ctx, []string{s.ID}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancel to stop waiting with its context, got %v", err)
+	}
+	w.done <- nil
+	<-saved
+}
+
+// TestPGBatcher_CancelExpired checks that Cleanup drops pending saves of
+// expired sessions only.
+func TestPGBatcher_CancelExpired(t *testing.T) {
+	b := &pgBatcher{
+		store:   &PostgreSQLStore{codec: GobCodec{}},
+		writes:  make(chan *pgWrite, 2),
+		pending: make(map[string][]*pgWrite),
+	}
+	now := time.Now()
+	saved := make(chan error, 2)
+	for _, s := range []*Session{
+		{ID: "expired", ExpiresAt: now.Add(-time.Minute)},
+		{ID: "live", ExpiresAt: now.Add(time.Hour)},
+	} {
+		go func() { saved <- b.save(context.Background(), s, nil) }()
+		<-b.writes
+	}
+
+	b.cancelExpired(now)
+	if _, ok, _ := b.get("expired"); ok {
+		t.Error("expected the expired session's save to be dropped")
+	}
+	if _, ok, _ := b.get("live"); !ok {
+		t.Error("expected the live session's save to be kept")
+	}
+}
+
+// TestPostgreSQLStore_WriteBatchDelete checks that a session deleted while
+// its save is waiting for a batch stays deleted.
+func TestPostgreSQLStore_WriteBatchDelete(t *testing.T) {
+	store, err := NewPostgreSQLStoreWithConfig(PostgreSQLConfig{
+		DSN:             getTestPostgreSQLDSN(),
+		WriteBatchSize:  50,
+		WriteBatchDelay: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Skipf("Skipping PostgreSQL test: %v (is PostgreSQL running?)", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	s := &Session{ID: "batch-delete", Values: map[string]any{"user": "alice"}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	saved := make(chan error, 1)
+	go func() { saved <- store.Save(ctx, s) }()
+	time.Sleep(10 * time.Millisecond) // Let the save join a batch
+	if err := store.Delete(ctx, s.ID); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if err := <-saved; err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	if got, err := store.Get(ctx, s.ID); err != nil || got != nil {
+		t.Errorf("expected the session to stay deleted, got %v, %v", got, err)
+	}
+}
+
+func TestPostgreSQLStore_CleanupExclusive(t *testing.T) {
+	store, err := NewPostgreSQLStore(getTestPostgreSQLDSN())
+	if err != nil {
+		t.Skipf("Skipping PostgreSQL test: %v (is PostgreSQL running?)", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	ran, err := store.CleanupExclusive(ctx)
+	if err != nil || !ran {
+		t.Fatalf("expected cleanup to run, got %v, %v", ran, err)
+	}
+
+	// Another instance holds the lock.
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", store.cleanupLockKey); err != nil {
+		t.Fatalf("failed to take lock: %v", err)
+	}
+
+	ran, err = store.CleanupExclusive(ctx)
+	if err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if ran {
+		t.Error("expected cleanup to be skipped while the lock is held")
+	}
+}
+
+func TestPostgreSQLStore_DeleteMulti(t *testing.T) {
+	store, err := NewPostgreSQLStore(getTestPostgreSQLDSN())
+	if err != nil {
+		t.Skipf("Skipping PostgreSQL test: %v (is PostgreSQL running?)", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	ids := []string{"test-pg-revoke-a", "test-pg-revoke-b", "test-pg-revoke-keep"}
+	for _, id := range ids {
+		s := &Session{ID: id, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+		if err := store.Save(ctx, s); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+	}
+	defer store.Delete(ctx, ids[2])
+
+	if err := store.DeleteMulti(ctx, append(ids[:2:2], "test-pg-missing")); err != nil {
+		t.Fatalf("failed to delete sessions: %v", err)
+	}
+	got, err := store.GetMulti(ctx, ids)
+	if err != nil {
+		t.Fatalf("failed to get sessions: %v", err)
+	}
+	if len(got) != 1 || got[ids[2]] == nil {
+		t.Errorf("expected only %s to remain, got %d sessions", ids[2], len(got))
+	}
+}
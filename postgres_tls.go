@@ -0,0 +1,115 @@
+package dbsession
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgresSSLRequest is the code of the message asking a PostgreSQL server to
+// switch the connection to TLS.
+const postgresSSLRequest = 80877103
+
+// openPostgreSQL opens a pool on dsn. If tlsConfig is set, connections
+// negotiate TLS with it instead of the DSN's sslmode settings.
+func openPostgreSQL(dsn string, tlsConfig *tls.Config) (*sql.DB, error) {
+	if tlsConfig == nil {
+		return sql.Open("postgres", dsn)
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		if dsn, err = pq.ParseURL(dsn); err != nil {
+			return nil, err
+		}
+	}
+	// The dialer returns a connection that is already encrypted, so pq must
+	// not negotiate TLS again. Later settings override earlier ones.
+	connector, err := pq.NewConnector(dsn + " sslmode=disable")
+	if err != nil {
+		return nil, err
+	}
+	connector.Dialer(&postgresTLSDialer{config: tlsConfig})
+	return sql.OpenDB(connector), nil
+}
+
+// postgresTLSDialer is a pq.Dialer that performs the PostgreSQL TLS
+// handshake with its own tls.Config.
+type postgresTLSDialer struct {
+	config *tls.Config
+	dialer net.Dialer
+}
+
+func (d *postgresTLSDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *postgresTLSDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+func (d *postgresTLSDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	// PostgreSQL does not support TLS over Unix sockets.
+	if network == "unix" {
+		return conn, nil
+	}
+	tlsConn, err := d.handshake(ctx, conn, address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// handshake asks the server behind conn to switch to TLS and performs the
+// handshake.
+func (d *postgresTLSDialer) handshake(ctx context.Context, conn net.Conn, address string) (*tls.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint32(msg[0:4], 8)
+	binary.BigEndian.PutUint32(msg[4:8], postgresSSLRequest)
+	if _, err := conn.Write(msg[:]); err != nil {
+		return nil, fmt.Errorf("failed to request tls: %w", err)
+	}
+	var reply [1]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return nil, fmt.Errorf("failed to request tls: %w", err)
+	}
+	if reply[0] != 'S' {
+		return nil, pq.ErrSSLNotSupported
+	}
+
+	config := d.config
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to perform tls handshake: %w", err)
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,129 @@
+package dbsession
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// newTestCertificate returns a self-signed certificate for 127.0.0.1.
+func newTestCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// servePostgresTLS accepts one connection, answers its SSLRequest with
+// reply and, if reply is 'S', completes a TLS handshake requiring a client
+// certificate, reporting the result on the returned channel.
+func servePostgresTLS(t *testing.T, reply byte, config *tls.Config) (string, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		var msg [8]byte
+		if _, err := io.ReadFull(conn, msg[:]); err != nil {
+			done <- err
+			return
+		}
+		if code := binary.BigEndian.Uint32(msg[4:]); code != postgresSSLRequest {
+			t.Errorf("expected an SSLRequest, got code %d", code)
+		}
+		conn.Write([]byte{reply})
+		if reply != 'S' {
+			done <- nil
+			return
+		}
+		done <- tls.Server(conn, config).Handshake()
+	}()
+	return ln.Addr().String(), done
+}
+
+func TestPostgresTLSDialer(t *testing.T) {
+	cert := newTestCertificate(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+
+	addr, done := servePostgresTLS(t, 'S', &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	d := &postgresTLSDialer{config: &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}}
+	conn, err := d.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if err := <-done; err != nil {
+		t.Errorf("expected the server to accept the client certificate, got %v", err)
+	}
+	if _, ok := conn.(*tls.Conn); !ok {
+		t.Errorf("expected a TLS connection, got %T", conn)
+	}
+}
+
+func TestPostgresTLSDialer_NotSupported(t *testing.T) {
+	addr, _ := servePostgresTLS(t, 'N', nil)
+	d := &postgresTLSDialer{config: &tls.Config{}}
+	if _, err := d.DialTimeout("tcp", addr, 5*time.Second); err != pq.ErrSSLNotSupported {
+		t.Errorf("expected ErrSSLNotSupported, got %v", err)
+	}
+}
+
+func TestOpenPostgreSQL_TLSConfig(t *testing.T) {
+	for _, dsn := range []string{
+		"postgres://user@localhost/db?sslmode=verify-full",
+		"host=localhost dbname=db sslmode=verify-full",
+	} {
+		db, err := openPostgreSQL(dsn, &tls.Config{})
+		if err != nil {
+			t.Errorf("failed to open %q: %v", dsn, err)
+			continue
+		}
+		db.Close()
+	}
+	if _, err := openPostgreSQL("postgres://%zz", &tls.Config{}); err == nil {
+		t.Error("expected an invalid URL to be rejected")
+	}
+}
@@ -0,0 +1,103 @@
+package dbsession
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSQLiteStore_Pragmas(t *testing.T) {
+	store, err := NewSQLiteStoreWithConfig(SQLiteConfig{
+		DSN:          filepath.Join(t.TempDir(), "pragmas.db"),
+		MaxOpenConns: 4,
+		Pragmas: map[string]string{
+			"busy_timeout": "12345",
+			"journal_mode": "DELETE",
+			"cache_size":   "-4000",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	cases := map[string]string{
+		"busy_timeout": "12345", // Overridden
+		"journal_mode": "delete",
+		"cache_size":   "-4000", // Added
+		"synchronous":  "1",     // Default NORMAL
+	}
+	for name, want := range cases {
+		var got string
+		if err := store.db.QueryRow("PRAGMA " + name).Scan(&got); err != nil {
+			t.Fatalf("failed to read pragma %s: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("pragma %s: expected %s, got %s", name, want, got)
+		}
+	}
+}
+
+func TestSQLiteStore_InvalidPragmas(t *testing.T) {
+	for _, pragmas := range []map[string]string{
+		{"busy_timeout": "1000&_pragma=foo"},
+		{"busy_timeout": ""},
+		{"busy timeout": "1000"},
+		{"synchronous=OFF&x": "1"},
+	} {
+		store, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: ":memory:", Pragmas: pragmas})
+		if err == nil {
+			store.Close()
+			t.Errorf("expected an error for pragmas %v", pragmas)
+		}
+	}
+}
+
+func TestSQLitePragmas_DSN(t *testing.T) {
+	cases := []struct {
+		dsn     string
+		skipped []string // Defaults left to the DSN
+	}{
+		{"busy_timeout.db", nil},
+		{"sessions.db?_pragma=journal_size_limit(1000)", nil},
+		{"sessions.db?_pragma=busy_timeout(100)", []string{"busy_timeout"}},
+		{"sessions.db?_pragma=Synchronous%3DOFF&_pragma=main.journal_mode(DELETE)", []string{"synchronous", "journal_mode"}},
+		{"file:synchronous.db?mode=rwc&_pragma=busy_timeout=100", []string{"busy_timeout"}},
+	}
+	for _, c := range cases {
+		merged, err := sqlitePragmas(c.dsn, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.dsn, err)
+		}
+		for name := range defaultSQLitePragmas {
+			_, set := merged[name]
+			if skip := slices.Contains(c.skipped, name); set == skip {
+				t.Errorf("%s: pragma %s set %v, expected %v", c.dsn, name, set, !skip)
+			}
+		}
+	}
+
+	if _, err := sqlitePragmas("sessions.db?_pragma=busy_timeout(100)", map[string]string{"BUSY_TIMEOUT": "200"}); err == nil {
+		t.Error("expected an error for a pragma set in both the DSN and Pragmas")
+	}
+	if _, err := sqlitePragmas("sessions.db?_pragma=%zz", nil); err == nil {
+		t.Error("expected an error for an invalid DSN query")
+	}
+}
+
+func TestSQLiteStore_PragmaNamedFile(t *testing.T) {
+	// A file name containing a pragma name does not suppress its default.
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "busy_timeout.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	var got string
+	if err := store.db.QueryRow("PRAGMA busy_timeout").Scan(&got); err != nil {
+		t.Fatalf("failed to read pragma: %v", err)
+	}
+	if got != "5000" {
+		t.Errorf("expected the default busy_timeout, got %s", got)
+	}
+}
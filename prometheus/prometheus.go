@@ -0,0 +1,146 @@
+// Package prometheus exports dbsession metrics to Prometheus, by
+// implementing dbsession.Metrics.
+//
+// It is a separate module, so that only the applications using it depend on
+// the Prometheus client.
+package prometheus
+
+import (
+	"context"
+	"time"
+
+	"github.com/Morditux/dbsession"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Options configures the metrics created by New.
+type Options struct {
+	// Namespace prefixes the metric names. Defaults to "dbsession".
+	Namespace string
+	// Store, if set, is counted on every scrape to report the number of
+	// live sessions. The SQL stores implement dbsession.Counter.
+	Store dbsession.Counter
+	// CountTimeout bounds each count of Store. Defaults to 5 seconds.
+	CountTimeout time.Duration
+	// Buckets are the store latency histogram buckets, in seconds. Defaults
+	// to prometheus.DefBuckets.
+	Buckets []float64
+}
+
+// Metrics is a dbsession.Metrics recording to Prometheus. Set it as
+// dbsession.Config.Metrics.
+type Metrics struct {
+	created     prom.Counter
+	loaded      prom.Counter
+	saved       prom.Counter
+	destroyed   prom.Counter
+	encodedSize prom.Gauge
+	latency     *prom.HistogramVec
+	errors      *prom.CounterVec
+}
+
+var _ dbsession.Metrics = (*Metrics)(nil)
+
+// New creates the metrics and registers them with reg:
+//
+//   - dbsession_sessions_created_total, _loaded_total, _saved_total and
+//     _destroyed_total count session lifecycle events;
+//   - dbsession_store_operation_duration_seconds is a histogram of store
+//     call latency by operation;
+//   - dbsession_store_errors_total counts failed store calls by operation;
+//   - dbsession_session_encoded_bytes is the size of the last saved session;
+//   - dbsession_sessions_active is the number of live sessions in
+//     Options.Store, if set.
+func New(reg prom.Registerer, opts Options) (*Metrics, error) {
+	if opts.Namespace == "" {
+		opts.Namespace = "dbsession"
+	}
+	if opts.CountTimeout <= 0 {
+		opts.CountTimeout = 5 * time.Second
+	}
+	if opts.Buckets == nil {
+		opts.Buckets = prom.DefBuckets
+	}
+
+	counter := func(name, help string) prom.Counter {
+		return prom.NewCounter(prom.CounterOpts{Namespace: opts.Namespace, Name: name, Help: help})
+	}
+	m := &Metrics{
+		created:   counter("sessions_created_total", "Sessions created in memory."),
+		loaded:    counter("sessions_loaded_total", "Live sessions loaded from the store."),
+		saved:     counter("sessions_saved_total", "Sessions saved to the store."),
+		destroyed: counter("sessions_destroyed_total", "Sessions deleted from the store."),
+		encodedSize: prom.NewGauge(prom.GaugeOpts{
+			Namespace: opts.Namespace,
+			Name:      "session_encoded_bytes",
+			Help:      "Size of the values of the last saved session, gob-encoded.",
+		}),
+		latency: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: opts.Namespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Latency of store operations.",
+			Buckets:   opts.Buckets,
+		}, []string{"operation"}),
+		errors: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: opts.Namespace,
+			Name:      "store_errors_total",
+			Help:      "Store operations that failed.",
+		}, []string{"operation"}),
+	}
+
+	collectors := []prom.Collector{m.created, m.loaded, m.saved, m.destroyed, m.encodedSize, m.latency, m.errors}
+	if opts.Store != nil {
+		collectors = append(collectors, &activeCollector{
+			store:   opts.Store,
+			timeout: opts.CountTimeout,
+			desc: prom.NewDesc(prom.BuildFQName(opts.Namespace, "", "sessions_active"),
+				"Sessions in the store that have not expired.", nil, nil),
+		})
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) SessionCreated()   { m.created.Inc() }
+func (m *Metrics) SessionLoaded()    { m.loaded.Inc() }
+func (m *Metrics) SessionDestroyed() { m.destroyed.Inc() }
+
+func (m *Metrics) SessionSaved(size int) {
+	m.saved.Inc()
+	m.encodedSize.Set(float64(size))
+}
+
+func (m *Metrics) StoreOperation(op string, d time.Duration, err error) {
+	m.latency.WithLabelValues(op).Observe(d.Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(op).Inc()
+	}
+}
+
+// activeCollector reports the number of live sessions, counted on each
+// scrape.
+type activeCollector struct {
+	store   dbsession.Counter
+	timeout time.Duration
+	desc    *prom.Desc
+}
+
+func (c *activeCollector) Describe(ch chan<- *prom.Desc) {
+	ch <- c.desc
+}
+
+func (c *activeCollector) Collect(ch chan<- prom.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	n, err := c.store.Count(ctx)
+	if err != nil {
+		// Fails the scrape rather than reporting a wrong count.
+		ch <- prom.NewInvalidMetric(c.desc, err)
+		return
+	}
+	ch <- prom.MustNewConstMetric(c.desc, prom.GaugeValue, float64(n))
+}
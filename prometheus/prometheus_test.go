@@ -0,0 +1,50 @@
+package prometheus
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Morditux/dbsession"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	store, err := dbsession.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	reg := prom.NewRegistry()
+	metrics, err := New(reg, Options{Store: store})
+	if err != nil {
+		t.Fatalf("failed to register metrics: %v", err)
+	}
+	mgr := dbsession.NewManager(dbsession.Config{Store: store, Metrics: metrics})
+	defer mgr.Close()
+
+	s, _ := mgr.New()
+	s.Set("user", "alice")
+	if err := mgr.Save(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.created); got != 1 {
+		t.Errorf("expected 1 created session, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.saved); got != 1 {
+		t.Errorf("expected 1 saved session, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.encodedSize); got == 0 {
+		t.Error("expected the encoded size of the saved session")
+	}
+	if got := testutil.CollectAndCount(metrics.latency); got != 1 {
+		t.Errorf("expected latency for one operation, got %d", got)
+	}
+	if n, err := testutil.GatherAndCount(reg, "dbsession_sessions_active"); err != nil || n != 1 {
+		t.Errorf("expected the active session gauge, got %d, %v", n, err)
+	}
+
+	if _, err := New(reg, Options{}); err == nil {
+		t.Error("expected registering the metrics twice to fail")
+	}
+}
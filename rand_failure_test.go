@@ -15,6 +15,15 @@ func (f *FaultyReader) Read(p []byte) (n int, err error) {
 	return 0, errors.New("simulated entropy failure")
 }
 
+// drainRNGPool empties rngPool, so that the next ID has to be generated by
+// a generator seeded from rand.Reader. Earlier tests may have left seeded
+// generators in the pool, which would never read the faulty reader.
+func drainRNGPool() {
+	for rngPool.Get() != nil {
+		// Discard every pooled generator.
+	}
+}
+
 func TestRegenerate_RandFailure(t *testing.T) {
 	// NOTE: This test modifies global rand.Reader. Do NOT run this test in parallel (t.Parallel()).
 	// It is not thread-safe with other tests that use crypto/rand.
@@ -40,6 +49,8 @@ func TestRegenerate_RandFailure(t *testing.T) {
 	origReader := rand.Reader
 	defer func() { rand.Reader = origReader }()
 
+	drainRNGPool()
+
 	// Inject faulty reader
 	rand.Reader = &FaultyReader{}
 
@@ -52,3 +63,22 @@ func TestRegenerate_RandFailure(t *testing.T) {
 		t.Errorf("Expected 'simulated entropy failure', got: %v", err)
 	}
 }
+
+func TestNew_RandFailure(t *testing.T) {
+	// NOTE: modifies global rand.Reader, see TestRegenerate_RandFailure.
+	mgr := NewManager(Config{Store: &MockStore{}})
+	defer mgr.Close()
+
+	origReader := rand.Reader
+	defer func() { rand.Reader = origReader }()
+	drainRNGPool()
+	rand.Reader = &FaultyReader{}
+
+	s, err := mgr.New()
+	if err == nil {
+		t.Fatal("Expected error on random failure, got nil")
+	}
+	if s != nil {
+		t.Errorf("Expected no session on failure, got %v", s)
+	}
+}
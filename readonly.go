@@ -0,0 +1,45 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// readOnlyStore restricts a Store to ReadOnlyStore. It keeps the store
+// unexported, so the handle cannot be converted back into a Store.
+type readOnlyStore struct {
+	store Store
+}
+
+// ReadOnly returns a view of store that can read sessions but not save,
+// delete, clean up or close them. The mutating methods are not merely
+// failing: the returned value does not have them, and the underlying store
+// cannot be recovered from it by a type assertion.
+func ReadOnly(store Store) ReadOnlyStore {
+	return readOnlyStore{store: store}
+}
+
+func (r readOnlyStore) Get(ctx context.Context, id string) (*Session, error) {
+	return r.store.Get(ctx, id)
+}
+
+func (r readOnlyStore) ForEach(ctx context.Context, fn func(*Session, error) error) error {
+	e, ok := r.store.(Enumerator)
+	if !ok {
+		return fmt.Errorf("dbsession: %T cannot enumerate sessions: %w", r.store, errors.ErrUnsupported)
+	}
+	return e.ForEach(ctx, fn)
+}
+
+func (r readOnlyStore) ListByOwner(ctx context.Context, owner string) ([]*Session, error) {
+	l, ok := r.store.(OwnerLister)
+	if !ok {
+		return nil, fmt.Errorf("dbsession: %T cannot list sessions by owner: %w", r.store, errors.ErrUnsupported)
+	}
+	return l.ListByOwner(ctx, owner)
+}
+
+func (r readOnlyStore) Ping(ctx context.Context) error {
+	return r.store.Ping(ctx)
+}
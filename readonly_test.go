@@ -0,0 +1,48 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "report",
+		Values:    map[string]any{"user": "alice"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+		OwnerID:   "alice",
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	view := ReadOnly(store)
+	if _, ok := view.(Store); ok {
+		t.Fatal("expected the read-only view not to be a Store")
+	}
+	if got, err := view.Get(ctx, s.ID); err != nil || got == nil {
+		t.Errorf("failed to get session: %v", err)
+	}
+	count := 0
+	if err := view.ForEach(ctx, func(*Session, error) error { count++; return nil }); err != nil || count != 1 {
+		t.Errorf("expected 1 session, got %d, %v", count, err)
+	}
+	if owned, err := view.ListByOwner(ctx, "alice"); err != nil || len(owned) != 1 {
+		t.Errorf("expected 1 session for alice, got %d, %v", len(owned), err)
+	}
+
+	memcached := ReadOnly(NewMemcachedStore(time.Hour))
+	if err := memcached.ForEach(ctx, func(*Session, error) error { return nil }); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for a store that cannot enumerate, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package dbsession
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"time"
+)
+
+// keyRemember marks a store entry as a remember-me token rather than a
+// session, so that neither can be presented as the other.
+const keyRemember = reservedKeyPrefix + "remember"
+
+// keyRememberOwner holds the Session.OwnerID carried over by a remember-me
+// entry. The entry itself has no owner, so it is not counted as a session.
+const keyRememberOwner = reservedKeyPrefix + "remember_owner"
+
+const defaultRememberCookie = "remember_token"
+
+// rememberRotation is a replacement remember-me token issued by Get that
+// Save still has to deliver to the client.
+type rememberRotation struct {
+	token   string    // New token
+	expires time.Time // Unchanged by rotation
+}
+
+// SetRemember issues a remember-me token valid for d and sets it in a
+// persistent cookie, typically right after login when the user ticked "keep
+// me logged in". The token is stored as its own entry holding a copy of the
+// session's current values.
+//
+// When a request has no valid session but a valid remember-me cookie, Get
+// returns a new session holding those values, and rotates the token: the
+// used token is revoked by Get itself, so it works once, and the
+// replacement is set by the next Save. A Get that is not followed by a Save
+// therefore ends "keep me logged in". Rotation keeps the original expiry,
+// so the token cannot be extended indefinitely.
+func (m *Manager) SetRemember(w http.ResponseWriter, r *http.Request, session *Session, d time.Duration) error {
+	session.mu.RLock()
+	values := appValues(session.Values)
+	owner := session.OwnerID
+	session.mu.RUnlock()
+
+	expires := m.clock.Now().Add(d)
+	token, err := m.issueRemember(r.Context(), owner, values, expires)
+	if err != nil {
+		return err
+	}
+	m.setRememberCookie(w, r, token, expires)
+	return nil
+}
+
+// ClearRemember revokes the request's remember-me token, if any, and clears
+// its cookie. Destroy calls it, so logging out also ends "keep me logged in".
+func (m *Manager) ClearRemember(w http.ResponseWriter, r *http.Request) error {
+	m.expireCookie(w, r, m.rememberCookie)
+
+	cookie, err := r.Cookie(m.rememberCookie)
+	if err != nil || !m.validID(cookie.Value) {
+		return nil
+	}
+	return m.storeDelete(r.Context(), cookie.Value)
+}
+
+// issueRemember stores a new remember-me entry for owner and values and
+// returns its token.
+func (m *Manager) issueRemember(ctx context.Context, owner string, values map[string]any, expires time.Time) (string, error) {
+	token, err := m.newID()
+	if err != nil {
+		return "", err
+	}
+	values[keyRemember] = true
+	if owner != "" {
+		values[keyRememberOwner] = owner
+	}
+	entry := &Session{
+		ID:        token,
+		Values:    values,
+		CreatedAt: m.clock.Now(),
+		ExpiresAt: expires,
+	}
+	if err := m.storeSave(ctx, entry); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// restoreRemembered returns a new session populated from the request's
+// remember-me token, or nil if there is no valid token.
+func (m *Manager) restoreRemembered(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(m.rememberCookie)
+	if err != nil || !m.validID(cookie.Value) {
+		return nil, nil
+	}
+
+	entry, found, err := m.storeGet(r.Context(), cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	if !found || !isRememberEntry(entry) || entry.ExpiresAt.Add(m.expiryLeeway).Before(m.clock.Now()) {
+		return nil, nil
+	}
+
+	values := appValues(entry.Values)
+	owner, _ := entry.Values[keyRememberOwner].(string)
+	token, err := m.issueRemember(r.Context(), owner, maps.Clone(values), entry.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	// The used token is revoked now rather than on Save, so that a stolen
+	// cookie cannot be replayed by requests that never save.
+	if err := m.storeDelete(r.Context(), cookie.Value); err != nil {
+		_ = m.storeDelete(r.Context(), token)
+		return nil, err
+	}
+
+	s, err := m.New()
+	if err != nil {
+		return nil, err
+	}
+	s.Values = values
+	s.OwnerID = owner
+	s.modified = true // Must be saved to deliver the rotated token
+	s.isNew = false
+	s.remember = &rememberRotation{
+		token:   token,
+		expires: entry.ExpiresAt,
+	}
+	return s, nil
+}
+
+// deliverRemember sets the cookie for a rotated remember-me token.
+func (m *Manager) deliverRemember(w http.ResponseWriter, r *http.Request, s *Session) {
+	s.mu.Lock()
+	rotation := s.remember
+	s.remember = nil
+	s.mu.Unlock()
+	if rotation != nil {
+		m.setRememberCookie(w, r, rotation.token, rotation.expires)
+	}
+}
+
+func (m *Manager) setRememberCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	m.setCookie(w, &http.Cookie{
+		Name:        m.rememberCookie,
+		Value:       token,
+		Path:        m.cookiePath,
+		Domain:      m.cookieDomain,
+		Expires:     expires,
+		MaxAge:      int(expires.Sub(m.clock.Now()).Seconds()),
+		HttpOnly:    m.httpOnly,
+		Secure:      m.isSecure(r),
+		SameSite:    m.sameSite,
+		Partitioned: m.partitioned,
+	})
+}
+
+// isRememberEntry reports whether a store entry is a remember-me token.
+func isRememberEntry(s *Session) bool {
+	v, _ := s.Values[keyRemember].(bool)
+	return v
+}
@@ -0,0 +1,184 @@
+package dbsession
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// cookieNamed returns the cookie named name set on w, or nil.
+func cookieNamed(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestManager_Remember(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store, RememberCookieName: "keep_me"})
+	defer mgr.Close()
+
+	// Login with "keep me logged in".
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.Set("user", "alice")
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if err := mgr.SetRemember(w, r, s, 30*24*time.Hour); err != nil {
+		t.Fatalf("failed to set remember: %v", err)
+	}
+	remember := cookieNamed(w, "keep_me")
+	if remember == nil || remember.MaxAge <= 0 {
+		t.Fatalf("expected a persistent remember cookie, got %v", remember)
+	}
+
+	// The browser restarts: only the remember cookie is sent.
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(remember)
+	restored, err := mgr.Get(r)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if v, _ := restored.Get("user"); v != "alice" {
+		t.Fatalf("expected session restored from remember token, got %v", restored.Values)
+	}
+	if restored.ID == s.ID {
+		t.Error("expected a new session ID for the restored session")
+	}
+	if restored.IsNew() {
+		t.Error("expected a restored session not to be new")
+	}
+
+	w = httptest.NewRecorder()
+	if err := mgr.Save(w, r, restored); err != nil {
+		t.Fatalf("failed to save restored session: %v", err)
+	}
+	rotated := cookieNamed(w, "keep_me")
+	if rotated == nil || rotated.Value == remember.Value {
+		t.Fatalf("expected the remember token to be rotated, got %v", rotated)
+	}
+
+	// The used token was revoked by Get.
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(remember)
+	if again, _ := mgr.Get(r); again.Values["user"] != nil {
+		t.Error("expected the used remember token to be revoked")
+	}
+
+	// Destroy (logout) revokes the rotated token too.
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(rotated)
+	w = httptest.NewRecorder()
+	if err := mgr.Destroy(w, r, restored); err != nil {
+		t.Fatalf("failed to destroy: %v", err)
+	}
+	if c := cookieNamed(w, "keep_me"); c == nil || c.MaxAge >= 0 {
+		t.Errorf("expected Destroy to clear the remember cookie, got %v", c)
+	}
+	if entry, _ := store.Get(context.Background(), rotated.Value); entry != nil {
+		t.Error("expected Destroy to revoke the remember token")
+	}
+}
+
+// TestManager_RememberSingleUse checks that a remember-me token is revoked
+// by the Get that uses it, even if the session is never saved, so that a
+// replayed cookie neither works again nor adds store entries.
+func TestManager_RememberSingleUse(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.Set("user", "alice")
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := mgr.SetRemember(w, r, s, time.Hour); err != nil {
+		t.Fatalf("failed to set remember: %v", err)
+	}
+	remember := cookieNamed(w, defaultRememberCookie)
+	ctx := context.Background()
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(remember)
+	restored, err := mgr.Get(r)
+	if err != nil || restored.Values["user"] != "alice" {
+		t.Fatalf("expected the session restored, got %v, %v", restored, err)
+	}
+	if entry, _ := store.Get(ctx, remember.Value); entry != nil {
+		t.Error("expected Get to revoke the used token")
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(remember)
+	again, err := mgr.Get(r)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if again.Values["user"] != nil {
+		t.Error("expected a replayed remember token to be rejected")
+	}
+	if n, err := store.Count(ctx); err != nil || n != 1 {
+		t.Errorf("expected only the rotated token's entry, got %d, %v", n, err)
+	}
+}
+
+func TestManager_RememberTokenIsNotASession(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.Set("user", "alice")
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if err := mgr.SetRemember(w, r, s, time.Hour); err != nil {
+		t.Fatalf("failed to set remember: %v", err)
+	}
+	token := cookieNamed(w, defaultRememberCookie).Value
+
+	// A remember token presented as a session cookie is rejected.
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session_id", Value: token})
+	if _, err := mgr.Load(r); err != ErrSessionNotFound {
+		t.Errorf("expected ErrSessionNotFound for a remember token, got %v", err)
+	}
+
+	// A session ID presented as a remember token is rejected.
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: defaultRememberCookie, Value: s.ID})
+	got, err := mgr.Get(r)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if got.Values["user"] != nil {
+		t.Error("expected a session ID not to be accepted as a remember token")
+	}
+}
@@ -0,0 +1,183 @@
+package dbsession
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 50 * time.Millisecond
+	defaultMaxBackoff   = time.Second
+)
+
+// RetryStore wraps a Store and retries Get, Save and Delete on transient
+// errors with exponential backoff. It never waits past the context deadline.
+type RetryStore struct {
+	store      Store
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	retryable  func(error) bool
+}
+
+// RetryConfig holds configuration for the retry wrapper.
+type RetryConfig struct {
+	MaxRetries int           // Retries after the first attempt (default: 3)
+	Backoff    time.Duration // Delay before the first retry, doubled each time (default: 50ms)
+	MaxBackoff time.Duration // Upper bound on a single delay (default: 1s)
+	// Retryable reports whether an error is transient. Defaults to
+	// DefaultRetryable. ErrSessionTooLarge, ErrInvalidSessionID,
+	// ErrConcurrentModification and context errors are never retried,
+	// whatever it returns.
+	Retryable func(error) bool
+}
+
+// NewRetryStore wraps store with retries on transient errors.
+func NewRetryStore(store Store, cfg RetryConfig) *RetryStore {
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultRetryBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.Retryable == nil {
+		cfg.Retryable = DefaultRetryable
+	}
+	return &RetryStore{
+		store:      store,
+		maxRetries: cfg.MaxRetries,
+		backoff:    cfg.Backoff,
+		maxBackoff: cfg.MaxBackoff,
+		retryable:  cfg.Retryable,
+	}
+}
+
+// DefaultRetryable reports whether err looks like a transient connection
+// failure: a dropped or refused connection, a network timeout, a PostgreSQL
+// connection exception or shutdown, or a Memcached connect timeout.
+func DefaultRetryable(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08: connection exception; 57P01-57P03: server shutting down
+		// or not yet accepting connections, as during a failover.
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03":
+			return true
+		}
+		return pqErr.Code.Class() == "08"
+	}
+
+	var timeoutErr *memcache.ConnectTimeoutError
+	if errors.As(err, &timeoutErr) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// shouldRetry applies the configured classification, except for errors that
+// a retry can never fix.
+func (r *RetryStore) shouldRetry(err error) bool {
+	if errors.Is(err, ErrSessionTooLarge) ||
+		errors.Is(err, ErrInvalidSessionID) ||
+		errors.Is(err, ErrConcurrentModification) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return r.retryable(err)
+}
+
+// do runs op until it succeeds, fails permanently or retries run out. It
+// gives up early, returning the last error, when the next delay would
+// overrun the context deadline.
+func (r *RetryStore) do(ctx context.Context, op func() error) error {
+	delay := r.backoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= r.maxRetries || !r.shouldRetry(err) {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay = min(delay*2, r.maxBackoff)
+	}
+}
+
+// Get retrieves a session, retrying transient errors.
+func (r *RetryStore) Get(ctx context.Context, id string) (*Session, error) {
+	var session *Session
+	err := r.do(ctx, func() error {
+		var err error
+		session, err = r.store.Get(ctx, id)
+		return err
+	})
+	return session, err
+}
+
+// Save persists a session, retrying transient errors.
+func (r *RetryStore) Save(ctx context.Context, session *Session) error {
+	return r.do(ctx, func() error {
+		return r.store.Save(ctx, session)
+	})
+}
+
+// Delete removes a session, retrying transient errors.
+func (r *RetryStore) Delete(ctx context.Context, id string) error {
+	return r.do(ctx, func() error {
+		return r.store.Delete(ctx, id)
+	})
+}
+
+// SetExpiryLeeway passes d to the wrapped store, see ExpiryLeewaySetter.
+func (r *RetryStore) SetExpiryLeeway(d time.Duration) {
+	setExpiryLeeway(r.store, d)
+}
+
+// Cleanup runs the wrapped store's cleanup once. It runs in the background
+// on a timer, so a failed round is simply retried at the next tick.
+func (r *RetryStore) Cleanup(ctx context.Context) error {
+	return r.store.Cleanup(ctx)
+}
+
+// Ping checks the wrapped store once, without retries, so that a health
+// check reports failures promptly.
+func (r *RetryStore) Ping(ctx context.Context) error {
+	return r.store.Ping(ctx)
+}
+
+// Close closes the wrapped store.
+func (r *RetryStore) Close() error {
+	return r.store.Close()
+}
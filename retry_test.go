@@ -0,0 +1,101 @@
+package dbsession
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// flakyStore fails the first n calls to each operation with err.
+type flakyStore struct {
+	MockStore
+	err   error
+	fails int
+	calls int
+}
+
+func (f *flakyStore) Save(ctx context.Context, s *Session) error {
+	f.calls++
+	if f.calls <= f.fails {
+		return f.err
+	}
+	return nil
+}
+
+func TestRetryStore(t *testing.T) {
+	ctx := context.Background()
+	s := &Session{ID: "retry"}
+
+	inner := &flakyStore{err: driver.ErrBadConn, fails: 2}
+	store := NewRetryStore(inner, RetryConfig{Backoff: time.Millisecond})
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("expected save to succeed after retries, got %v", err)
+	}
+	if inner.calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", inner.calls)
+	}
+
+	// Retries run out.
+	inner = &flakyStore{err: driver.ErrBadConn, fails: 10}
+	store = NewRetryStore(inner, RetryConfig{MaxRetries: 2, Backoff: time.Millisecond})
+	if err := store.Save(ctx, s); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected ErrBadConn, got %v", err)
+	}
+	if inner.calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", inner.calls)
+	}
+
+	// Permanent errors are returned at once, even if the classifier says otherwise.
+	always := func(error) bool { return true }
+	for _, permanent := range []error{ErrSessionTooLarge, ErrInvalidSessionID} {
+		inner = &flakyStore{err: permanent, fails: 10}
+		store = NewRetryStore(inner, RetryConfig{Backoff: time.Millisecond, Retryable: always})
+		if err := store.Save(ctx, s); !errors.Is(err, permanent) {
+			t.Errorf("expected %v, got %v", permanent, err)
+		}
+		if inner.calls != 1 {
+			t.Errorf("%v: expected 1 attempt, got %d", permanent, inner.calls)
+		}
+	}
+}
+
+func TestRetryStore_Deadline(t *testing.T) {
+	inner := &flakyStore{err: driver.ErrBadConn, fails: 10}
+	store := NewRetryStore(inner, RetryConfig{Backoff: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := store.Save(ctx, &Session{ID: "retry"}); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected ErrBadConn, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected retries to stop before the deadline, took %v", elapsed)
+	}
+	if inner.calls != 1 {
+		t.Errorf("expected 1 attempt, got %d", inner.calls)
+	}
+}
+
+func TestDefaultRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{driver.ErrBadConn, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "57P01"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{errors.New("boom"), false},
+	}
+	for _, c := range cases {
+		if got := DefaultRetryable(c.err); got != c.want {
+			t.Errorf("DefaultRetryable(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
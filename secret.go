@@ -0,0 +1,197 @@
+package dbsession
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// keySecrets lists the keys whose values are encrypted at rest, see
+// Session.SetSecret. It is stored in plain with the values, so that any
+// process holding the key can tell which values to decrypt.
+const keySecrets = reservedKeyPrefix + "secrets"
+
+// SetSecret stores a value like Set and marks key as sensitive: stores
+// whose codec is a SecretCodec encrypt its value at rest, while the other
+// values stay readable, e.g. for debugging. The key stays sensitive until it
+// is deleted. With any other codec, the value is stored in plain like the
+// others.
+func (s *Session) SetSecret(key string, val any) {
+	s.mu.Lock()
+	if s.Values == nil {
+		s.Values = make(map[string]any)
+	}
+	s.Values[key] = val
+	if keys := secretKeys(s.Values); !slices.Contains(keys, key) {
+		// A new slice, as clones of the session may share the old one.
+		s.Values[keySecrets] = append(slices.Clip(keys), key)
+	}
+	s.encoded = nil
+	s.modified = true
+	s.mu.Unlock()
+}
+
+// unmarkSecretLocked removes key from the sensitive keys, once its value is
+// deleted. The caller must hold s.mu for writing.
+func (s *Session) unmarkSecretLocked(key string) {
+	keys := secretKeys(s.Values)
+	i := slices.Index(keys, key)
+	if i < 0 {
+		return
+	}
+	if len(keys) == 1 {
+		delete(s.Values, keySecrets)
+		return
+	}
+	s.Values[keySecrets] = slices.Delete(slices.Clone(keys), i, i+1)
+}
+
+// secretKeys returns the keys marked with SetSecret in values.
+func secretKeys(values map[string]any) []string {
+	switch v := values[keySecrets].(type) {
+	case []string:
+		return v
+	case []any: // Decoded from JSON
+		keys := make([]string, 0, len(v))
+		for _, k := range v {
+			if k, ok := k.(string); ok {
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	default:
+		return nil
+	}
+}
+
+// secretAAD prefixes the additional data binding an encrypted value to its
+// key, so that values cannot be swapped between keys.
+const secretAAD = "dbsession secret v1:"
+
+// secretValue is the plaintext of an encrypted value. Values are gob-encoded
+// whatever the wrapped codec, so that they keep their type.
+type secretValue struct {
+	Value any
+}
+
+// SecretCodec encrypts the values of the keys marked with Session.SetSecret
+// with AES-GCM, and encodes the session with another codec, which stores
+// the other values as usual. An encrypted value is stored as a base64
+// string. Sensitive values are gob-encoded before encryption, so values of
+// custom types must be registered with Register, whatever the wrapped codec.
+// A store must be read with the key it was written with.
+type SecretCodec struct {
+	codec Codec
+	aead  cipher.AEAD
+}
+
+// NewSecretCodec returns a SecretCodec encrypting with key, which must be
+// 16, 24 or 32 bytes long, and wrapping codec, GobCodec if nil.
+func NewSecretCodec(codec Codec, key []byte) (*SecretCodec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("dbsession: invalid secret key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("dbsession: invalid secret key: %w", err)
+	}
+	if codec == nil {
+		codec = GobCodec{}
+	}
+	return &SecretCodec{codec: codec, aead: aead}, nil
+}
+
+func (c *SecretCodec) Encode(w io.Writer, values map[string]any) error {
+	keys := secretKeys(values)
+	if len(keys) == 0 {
+		return c.codec.Encode(w, values)
+	}
+	sealed := maps.Clone(values)
+	for _, key := range keys {
+		v, ok := values[key]
+		if !ok {
+			continue
+		}
+		s, err := c.seal(key, v)
+		if err != nil {
+			return err
+		}
+		sealed[key] = s
+	}
+	return c.codec.Encode(w, sealed)
+}
+
+func (c *SecretCodec) Decode(r io.Reader) (map[string]any, error) {
+	values, err := c.codec.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(values)
+}
+
+// DecodeLimited decodes like Decode, see LimitedDecoder. The limit applies
+// to the data decoded by the wrapped codec.
+func (c *SecretCodec) DecodeLimited(r io.Reader, limit int64) (map[string]any, error) {
+	var values map[string]any
+	var err error
+	if limited, ok := c.codec.(LimitedDecoder); ok {
+		values, err = limited.DecodeLimited(r, limit)
+	} else {
+		values, err = c.codec.Decode(LimitDecoded(r, limit))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.open(values)
+}
+
+// seal encrypts the value of key.
+func (c *SecretCodec) seal(key string, v any) (string, error) {
+	var plain bytes.Buffer
+	if err := gob.NewEncoder(&plain).Encode(secretValue{Value: v}); err != nil {
+		return "", fmt.Errorf("failed to encode secret %q: %w", key, err)
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+plain.Len()+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate secret nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, plain.Bytes(), []byte(secretAAD+key))
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts the sensitive values in values, in place.
+func (c *SecretCodec) open(values map[string]any) (map[string]any, error) {
+	for _, key := range secretKeys(values) {
+		v, ok := values[key]
+		if !ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("secret %q is not encrypted", key)
+		}
+		sealed, err := base64.RawStdEncoding.DecodeString(s)
+		if err != nil || len(sealed) < c.aead.NonceSize()+c.aead.Overhead() {
+			return nil, fmt.Errorf("secret %q is malformed", key)
+		}
+		nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+		plain, err := c.aead.Open(ciphertext[:0], nonce, ciphertext, []byte(secretAAD+key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret %q: %w", key, err)
+		}
+		var sv secretValue
+		if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&sv); err != nil {
+			return nil, fmt.Errorf("failed to decode secret %q: %w", key, err)
+		}
+		values[key] = sv.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,90 @@
+package dbsession
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSecretCodec(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	ctx := context.Background()
+	for name, inner := range map[string]Codec{"Gob": nil, "JSON": JSONCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := NewSecretCodec(inner, key)
+			if err != nil {
+				t.Fatalf("failed to create codec: %v", err)
+			}
+			store, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: ":memory:", Codec: codec})
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			defer store.Close()
+
+			s := &Session{ID: "secret", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+			s.Set("visits", "plain-counter")
+			s.SetSecret("token", "hunter2-access-token")
+			if err := store.Save(ctx, s); err != nil {
+				t.Fatalf("failed to save session: %v", err)
+			}
+
+			var raw []byte
+			if err := store.db.QueryRow("SELECT data FROM sessions WHERE id = ?", s.ID).Scan(&raw); err != nil {
+				t.Fatalf("failed to read data: %v", err)
+			}
+			if bytes.Contains(raw, []byte("hunter2")) {
+				t.Error("expected the secret to be encrypted at rest")
+			}
+			if !bytes.Contains(raw, []byte("plain-counter")) {
+				t.Error("expected other values to stay in plain")
+			}
+
+			// Another process with the same key decodes the session.
+			reader, _ := NewSecretCodec(inner, key)
+			values, err := decodeValues(reader, raw, 0)
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if values["token"] != "hunter2-access-token" || values["visits"] != "plain-counter" {
+				t.Errorf("unexpected values %v", values)
+			}
+
+			// Another key cannot.
+			wrong, _ := NewSecretCodec(inner, bytes.Repeat([]byte{2}, 32))
+			if _, err := decodeValues(wrong, raw, 0); !errors.Is(err, ErrCorruptSession) {
+				t.Errorf("expected ErrCorruptSession with another key, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSession_SetSecret(t *testing.T) {
+	s := &Session{}
+	s.SetSecret("token", "t")
+	s.SetSecret("token", "u")
+	s.SetSecret("card", "c")
+	if keys := secretKeys(s.Values); len(keys) != 2 {
+		t.Errorf("expected two sensitive keys, got %v", keys)
+	}
+	if _, ok := s.ToMap()[keySecrets]; ok {
+		t.Error("expected ToMap to leave out the sensitive keys")
+	}
+
+	s.Delete("token")
+	s.Set("token", "plain again")
+	if keys := secretKeys(s.Values); len(keys) != 1 || keys[0] != "card" {
+		t.Errorf("expected Delete to unmark the key, got %v", keys)
+	}
+	s.Delete("card")
+	if _, ok := s.Values[keySecrets]; ok {
+		t.Error("expected no sensitive keys left")
+	}
+}
+
+func TestNewSecretCodec_InvalidKey(t *testing.T) {
+	if _, err := NewSecretCodec(nil, []byte("short")); err == nil {
+		t.Error("expected a short key to be rejected")
+	}
+}
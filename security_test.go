@@ -2,6 +2,7 @@ package dbsession
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -19,7 +20,10 @@ func TestSecurityConfig(t *testing.T) {
 
 		w := httptest.NewRecorder()
 		r := httptest.NewRequest("GET", "/", nil)
-		s := mgr.New()
+		s, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
 
 		if err := mgr.Save(w, r, s); err != nil {
 			t.Fatalf("Save failed: %v", err)
@@ -61,7 +65,10 @@ func TestSecurityConfig(t *testing.T) {
 
 		w := httptest.NewRecorder()
 		r := httptest.NewRequest("GET", "/", nil) // Non-TLS request
-		s := mgr.New()
+		s, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
 
 		if err := mgr.Save(w, r, s); err != nil {
 			t.Fatalf("Save failed: %v", err)
@@ -91,7 +98,10 @@ func TestSecurityConfig(t *testing.T) {
 
 		w := httptest.NewRecorder()
 		r := httptest.NewRequest("GET", "/app/dashboard", nil)
-		s := mgr.New()
+		s, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
 
 		if err := mgr.Save(w, r, s); err != nil {
 			t.Fatalf("Save failed: %v", err)
@@ -131,7 +141,10 @@ func TestSecurityConfig(t *testing.T) {
 
 		w := httptest.NewRecorder()
 		r := httptest.NewRequest("GET", "/", nil)
-		s := mgr.New()
+		s, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
 
 		// Save first to verify Secure is set on creation
 		if err := mgr.Save(w, r, s); err != nil {
@@ -170,7 +183,10 @@ func TestSecurityConfig(t *testing.T) {
 
 		w := httptest.NewRecorder()
 		r := httptest.NewRequest("GET", "/", nil) // Non-TLS
-		s := mgr.New()
+		s, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
 
 		if err := mgr.Save(w, r, s); err != nil {
 			t.Fatalf("Save failed: %v", err)
@@ -197,18 +213,25 @@ func TestSecurityConfig(t *testing.T) {
 
 		w := httptest.NewRecorder()
 		r := httptest.NewRequest("GET", "/", nil)
-		s := mgr.New()
+		s, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
 
 		// Add data that exceeds the limit
 		// "key" + "val" + map overhead > 10 bytes
 		s.Set("key", strings.Repeat("a", 20))
 
-		err := mgr.Save(w, r, s)
+		err = mgr.Save(w, r, s)
 		if err == nil {
 			t.Error("Expected error for large session data, got nil")
-		} else if err != ErrSessionTooLarge {
+		} else if !errors.Is(err, ErrSessionTooLarge) {
 			t.Errorf("Expected ErrSessionTooLarge, got: %v", err)
 		}
+		var tooLarge *SessionTooLargeError
+		if !errors.As(err, &tooLarge) || tooLarge.Limit != 10 || tooLarge.Size <= 10 {
+			t.Errorf("Expected the size and limit in a SessionTooLargeError, got: %#v", tooLarge)
+		}
 	})
 }
 
@@ -219,48 +242,9 @@ func (m *MockStore) Get(ctx context.Context, id string) (*Session, error) { retu
 func (m *MockStore) Save(ctx context.Context, s *Session) error           { return nil }
 func (m *MockStore) Delete(ctx context.Context, id string) error          { return nil }
 func (m *MockStore) Cleanup(ctx context.Context) error                    { return nil }
+func (m *MockStore) Ping(ctx context.Context) error                       { return nil }
 func (m *MockStore) Close() error                                         { return nil }
 
-type MockStoreFailDelete struct {
-	MockStore
-}
-
-func (m *MockStoreFailDelete) Delete(ctx context.Context, id string) error {
-	return context.DeadlineExceeded // Simulate a failure
-}
-
-func TestRegenerate_FailSecure(t *testing.T) {
-	store := &MockStoreFailDelete{}
-	mgr := NewManager(Config{Store: store})
-	defer mgr.Close()
-
-	w := httptest.NewRecorder()
-	r := httptest.NewRequest("GET", "/", nil)
-	s := mgr.New()
-	s.ID = "old-id"
-
-	// Regenerate should fail if Delete fails
-	err := mgr.Regenerate(w, r, s)
-	if err == nil {
-		t.Error("Expected error when backend Delete fails, got nil (Fail Open)")
-	}
-
-	// Security: Verify that we fail closed by clearing the cookie.
-	// If we leave the cookie set to the NEW ID, the user is effectively logged in
-	// even though the security rotation failed.
-	cookies := w.Result().Cookies()
-	foundClear := false
-	for _, c := range cookies {
-		if c.Name == "session_id" && c.MaxAge < 0 {
-			foundClear = true
-		}
-	}
-
-	if !foundClear {
-		t.Error("Expected session cookie to be cleared (MaxAge < 0) when Regenerate fails, but it remained valid")
-	}
-}
-
 func TestSave_ValidatesSessionID(t *testing.T) {
 	// Mock store
 	store := &MockStore{}
@@ -271,10 +255,13 @@ func TestSave_ValidatesSessionID(t *testing.T) {
 	r := httptest.NewRequest("GET", "/", nil)
 
 	t.Run("Invalid ID rejected", func(t *testing.T) {
-		s := mgr.New()
+		s, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
 		s.ID = "bad-id" // Not 32 hex chars
 
-		err := mgr.Save(w, r, s)
+		err = mgr.Save(w, r, s)
 		if err == nil {
 			t.Fatal("Expected error when saving session with invalid ID, got nil")
 		}
@@ -284,9 +271,12 @@ func TestSave_ValidatesSessionID(t *testing.T) {
 	})
 
 	t.Run("Valid ID accepted", func(t *testing.T) {
-		s := mgr.New() // Generates valid ID
+		s, err := mgr.New() // Generates valid ID
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
 
-		err := mgr.Save(w, r, s)
+		err = mgr.Save(w, r, s)
 		if err != nil {
 			t.Fatalf("Expected no error for valid ID, got %v", err)
 		}
@@ -300,7 +290,10 @@ func TestDestroy_ClearsMemory(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("GET", "/", nil)
-	s := mgr.New()
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
 
 	s.Set("secret", "sensitive-data")
 
@@ -359,33 +352,324 @@ func TestManager_EnforcesExpiry(t *testing.T) {
 	}
 }
 
-func TestDestroy_ClearsMemory_OnError(t *testing.T) {
-	// Setup store that fails on Delete
-	store := &MockStoreFailDelete{}
+func TestSecure_ForwardedProto(t *testing.T) {
+	store := &MockStore{}
+
+	secureCookie := func(t *testing.T, mgr *Manager, proto string) bool {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if proto != "" {
+			r.Header.Set("X-Forwarded-Proto", proto)
+		}
+		s, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		if err := mgr.Save(w, r, s); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		return w.Result().Cookies()[0].Secure
+	}
+
+	t.Run("Ignored By Default", func(t *testing.T) {
+		mgr := NewManager(Config{Store: store})
+		defer mgr.Close()
+		if secureCookie(t, mgr, "https") {
+			t.Error("X-Forwarded-Proto must not be trusted unless enabled")
+		}
+	})
+
+	t.Run("Trusted When Enabled", func(t *testing.T) {
+		mgr := NewManager(Config{Store: store, TrustForwardedProto: true})
+		defer mgr.Close()
+		if !secureCookie(t, mgr, "https") {
+			t.Error("expected Secure cookie for X-Forwarded-Proto: https")
+		}
+		if secureCookie(t, mgr, "http") {
+			t.Error("expected non-Secure cookie for X-Forwarded-Proto: http")
+		}
+
+		// Destroy uses the same detection.
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		s, err := mgr.New()
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		if err := mgr.Destroy(w, r, s); err != nil {
+			t.Fatalf("Destroy failed: %v", err)
+		}
+		if !w.Result().Cookies()[0].Secure {
+			t.Error("expected Secure deletion cookie for X-Forwarded-Proto: https")
+		}
+	})
+
+	t.Run("Explicit Secure Wins", func(t *testing.T) {
+		secure := false
+		mgr := NewManager(Config{Store: store, TrustForwardedProto: true, Secure: &secure})
+		defer mgr.Close()
+		if secureCookie(t, mgr, "https") {
+			t.Error("explicit Secure=false must take precedence")
+		}
+	})
+}
+
+func TestManager_SaveWithOptions(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
 	mgr := NewManager(Config{Store: store})
 	defer mgr.Close()
 
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	yes := true
 	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/checkout", nil)
+	opts := CookieOptions{SameSite: http.SameSiteNoneMode, Secure: &yes, MaxAge: 600}
+	if err := mgr.SaveWithOptions(w, r, s, opts); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	c := w.Result().Cookies()[0]
+	if c.SameSite != http.SameSiteNoneMode || !c.Secure || c.MaxAge != 600 {
+		t.Errorf("expected the overridden attributes, got %+v", c)
+	}
+
+	// Other responses keep the defaults.
+	w = httptest.NewRecorder()
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	c = w.Result().Cookies()[0]
+	if c.SameSite != http.SameSiteLaxMode || c.Secure || c.MaxAge != int((24*time.Hour).Seconds()) {
+		t.Errorf("expected the default attributes, got %+v", c)
+	}
+
+	// SameSite=None without Secure is rejected before saving.
+	s2, _ := mgr.New()
+	w = httptest.NewRecorder()
+	if err := mgr.SaveWithOptions(w, r, s2, CookieOptions{SameSite: http.SameSiteNoneMode}); err == nil {
+		t.Error("expected an error for SameSite=None on an insecure request")
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("expected no cookie to be set")
+	}
+	if stored, _ := store.Get(context.Background(), s2.ID); stored != nil {
+		t.Error("expected the session not to be saved")
+	}
+}
+
+func TestManager_SecurePrefix(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManagerWithOptions(store, WithCookieName("sid"), WithSecurePrefix())
+	defer mgr.Close()
+
+	// A plain HTTP request would not be detected as secure.
 	r := httptest.NewRequest("GET", "/", nil)
-	s := mgr.New()
+	s, _ := mgr.New()
+	w := httptest.NewRecorder()
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != "__Secure-sid" || !cookie.Secure {
+		t.Errorf("expected a Secure __Secure-sid cookie, got %s (Secure %v)", cookie.Name, cookie.Secure)
+	}
 
-	s.Set("secret", "sensitive-data")
+	r.AddCookie(cookie)
+	loaded, err := mgr.Get(r)
+	if err != nil || loaded.ID != s.ID {
+		t.Fatalf("expected the prefixed cookie to be read back, got %v", err)
+	}
 
-	// Destroy fails at store level
-	if err := mgr.Destroy(w, r, s); err == nil {
-		t.Fatal("Expected Destroy to return error from store")
+	w = httptest.NewRecorder()
+	if err := mgr.Regenerate(w, r, loaded); err != nil {
+		t.Fatalf("failed to regenerate: %v", err)
+	}
+	if c := w.Result().Cookies()[0]; c.Name != "__Secure-sid" || !c.Secure {
+		t.Errorf("expected Regenerate to set the prefixed cookie, got %s (Secure %v)", c.Name, c.Secure)
+	}
+	w = httptest.NewRecorder()
+	if err := mgr.Destroy(w, r, loaded); err != nil {
+		t.Fatalf("failed to destroy: %v", err)
+	}
+	if c := w.Result().Cookies()[0]; c.Name != "__Secure-sid" || !c.Secure || c.MaxAge != -1 {
+		t.Errorf("expected Destroy to clear the prefixed cookie, got %+v", c)
 	}
 
-	// Verify memory should still be cleared for defense-in-depth
-	val, ok := s.Get("secret")
-	if ok || val != nil {
-		t.Error("Vulnerability: Session values persisted in memory after failed Destroy")
+	insecure := false
+	s, _ = mgr.New()
+	if err := mgr.SaveWithOptions(httptest.NewRecorder(), r, s, CookieOptions{Secure: &insecure}); err == nil {
+		t.Error("expected SaveWithOptions to refuse an insecure __Secure- cookie")
 	}
+}
 
-	// Verify internal map
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	if len(s.Values) > 0 {
-		t.Errorf("Vulnerability: Values map not empty, len %d", len(s.Values))
+func TestManager_Partitioned(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	secure := true
+	mgr := NewManager(Config{Store: store, Partitioned: true, SameSite: http.SameSiteNoneMode, Secure: &secure})
+	defer mgr.Close()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	s, _ := mgr.New()
+	w := httptest.NewRecorder()
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	header := w.Header().Get("Set-Cookie")
+	for _, attr := range []string{"Partitioned", "SameSite=None", "Secure"} {
+		if !strings.Contains(header, attr) {
+			t.Errorf("expected %s in Set-Cookie, got %q", attr, header)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	if err := mgr.Destroy(w, r, s); err != nil {
+		t.Fatalf("failed to destroy: %v", err)
+	}
+	for _, header := range w.Header().Values("Set-Cookie") {
+		if !strings.Contains(header, "Partitioned") {
+			t.Errorf("expected the cleared cookies to be partitioned, got %q", header)
+		}
+	}
+
+	s, _ = mgr.New()
+	if err := mgr.SaveWithOptions(httptest.NewRecorder(), r, s, CookieOptions{SameSite: http.SameSiteLaxMode}); err == nil {
+		t.Error("expected SaveWithOptions to refuse a partitioned cookie without SameSite=None")
+	}
+}
+
+func TestManager_CookieMutator(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store, CookieMutator: func(c *http.Cookie) {
+		c.SameSite = http.SameSiteStrictMode
+		c.Unparsed = append(c.Unparsed, "Priority=High", "Bad\r\nSet-Cookie: x=y", "A;B")
+	}})
+	defer mgr.Close()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	s, _ := mgr.New()
+	w := httptest.NewRecorder()
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	header := w.Header().Get("Set-Cookie")
+	if !strings.HasSuffix(header, "; SameSite=Strict; Priority=High") {
+		t.Errorf("expected the mutated attributes in Set-Cookie, got %q", header)
+	}
+
+	w = httptest.NewRecorder()
+	if err := mgr.Destroy(w, r, s); err != nil {
+		t.Fatalf("failed to destroy: %v", err)
+	}
+	for _, header := range w.Header().Values("Set-Cookie") {
+		if !strings.Contains(header, "Priority=High") {
+			t.Errorf("expected the cleared cookies to be mutated too, got %q", header)
+		}
+	}
+}
+
+func TestManager_SessionCookie(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store, TTL: time.Hour, CookieMutator: func(c *http.Cookie) {
+		c.Domain = "example.com"
+	}})
+	defer mgr.Close()
+
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	s, _ := mgr.New()
+	s.Set("user", "alice")
+	token, err := mgr.SaveToken(r, s)
+	if err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	cookie, err := mgr.SessionCookie(r, s)
+	if err != nil {
+		t.Fatalf("failed to build cookie: %v", err)
+	}
+	if cookie.Name != "session_id" || cookie.Value != token || !cookie.Secure || !cookie.HttpOnly ||
+		cookie.Domain != "example.com" || !cookie.Expires.Equal(s.ExpiresAt) {
+		t.Errorf("unexpected cookie: %+v", cookie)
+	}
+
+	// It is the cookie Save sets.
+	w := httptest.NewRecorder()
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	cookie, _ = mgr.SessionCookie(r, s)
+	if got := w.Header().Get("Set-Cookie"); got != cookie.String() {
+		t.Errorf("expected Save to set %q, got %q", cookie.String(), got)
+	}
+}
+
+func TestManager_FallbackCookieNames(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	old := NewManager(Config{Store: store, CookieName: "sid", CleanupInterval: -1})
+	mgr := NewManager(Config{Store: store, CookieName: "session_id", FallbackCookieNames: []string{"sid"}})
+	defer mgr.Close()
+
+	// A session issued before the rename.
+	r := httptest.NewRequest("GET", "/", nil)
+	s, _ := old.New()
+	s.Set("user", "alice")
+	w := httptest.NewRecorder()
+	if err := old.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	legacy := w.Result().Cookies()[0]
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(legacy)
+	loaded, err := mgr.Get(r)
+	if err != nil || loaded.ID != s.ID {
+		t.Fatalf("expected the session to be read from the fallback cookie, got %v", err)
+	}
+	if !loaded.Modified() {
+		t.Error("expected a session loaded from a fallback cookie to need saving")
+	}
+
+	w = httptest.NewRecorder()
+	if err := mgr.Save(w, r, loaded); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	current, cleared := cookieNamed(w, "session_id"), cookieNamed(w, "sid")
+	if current == nil || current.Value != s.ID {
+		t.Errorf("expected the session to be reissued under the new name, got %v", current)
+	}
+	if cleared == nil || cleared.MaxAge != -1 {
+		t.Errorf("expected the old cookie to be cleared, got %v", cleared)
+	}
+
+	// Destroy clears both names.
+	r.AddCookie(current)
+	w = httptest.NewRecorder()
+	if err := mgr.Destroy(w, r, loaded); err != nil {
+		t.Fatalf("failed to destroy: %v", err)
+	}
+	for _, name := range []string{"session_id", "sid"} {
+		if c := cookieNamed(w, name); c == nil || c.MaxAge != -1 {
+			t.Errorf("expected Destroy to clear %s, got %v", name, c)
+		}
 	}
 }
@@ -2,18 +2,57 @@ package dbsession
 
 import (
 	"context"
+	"maps"
+	"strings"
 	"sync"
 	"time"
 )
 
+// reservedKeyPrefix prefixes keys in Session.Values that are managed by
+// dbsession itself (e.g. client binding data). Applications should not
+// write keys with this prefix.
+const reservedKeyPrefix = "_dbsession."
+
+// keyTTL holds the session's own TTL set by Session.SetTTL, in nanoseconds.
+const keyTTL = reservedKeyPrefix + "ttl"
+
 // Session represents a user session.
 type Session struct {
 	ID        string
 	Values    map[string]any
 	CreatedAt time.Time
 	ExpiresAt time.Time
-	encoded   []byte // Cache for encoded values
-	mu        sync.RWMutex
+	// LastAccessedAt is when the session was last created or loaded by the
+	// Manager. See Config.TouchInterval for when it is persisted.
+	LastAccessedAt time.Time
+	// RegenerateCount is the number of times the session ID (or, with
+	// Config.TokenKey, its client token) was rotated by Manager.Regenerate,
+	// for auditing. It is stored in its own column by
+	// the SQL stores and in the envelope by the Memcached store, not in Values.
+	RegenerateCount int
+	// Version is the revision of the stored session. Stores with optimistic
+	// locking enabled use it to detect concurrent modifications and increment
+	// it on every successful save.
+	Version int
+	// OwnerID identifies the user the session belongs to, e.g. set at login.
+	// The SQL stores index it, so a user's sessions can be listed and
+	// Config.MaxSessionsPerUser enforced. Empty means no owner.
+	OwnerID string
+	// Metadata holds application-managed display data about the session,
+	// such as the IP address and User-Agent it was created from, e.g. for an
+	// "active sessions" page. Unlike Values it is not cleared by Clear, and
+	// the SQL stores keep it in its own column.
+	Metadata     map[string]string
+	savedOwner   string            // OwnerID as last loaded or saved by the Manager
+	encoded      []byte            // Cache for encoded values
+	encodedBy    Codec             // Codec that produced encoded
+	remember     *rememberRotation // Remember-me token to deliver on Save
+	modified     bool              // Changed since last loaded or saved, see Modified
+	destroyed    bool              // Deleted by Manager.Destroy, must not be saved again
+	degraded     bool              // Issued while the store was unavailable, see Degraded
+	isNew        bool              // Created by Manager.New rather than loaded, see IsNew
+	legacyCookie string            // Fallback cookie the session was loaded from, deleted on Save
+	mu           sync.RWMutex
 }
 
 // Get retrieves a value from the session in a thread-safe manner.
@@ -32,6 +71,7 @@ func (s *Session) Set(key string, val any) {
 	}
 	s.Values[key] = val
 	s.encoded = nil
+	s.modified = true
 	s.mu.Unlock()
 }
 
@@ -39,7 +79,9 @@ func (s *Session) Set(key string, val any) {
 func (s *Session) Delete(key string) {
 	s.mu.Lock()
 	delete(s.Values, key)
+	s.unmarkSecretLocked(key)
 	s.encoded = nil
+	s.modified = true
 	s.mu.Unlock()
 }
 
@@ -49,12 +91,190 @@ func (s *Session) Clear() {
 	s.mu.Lock()
 	s.Values = nil
 	s.encoded = nil
+	s.modified = true
 	s.mu.Unlock()
 }
 
+// SetMetadata sets a metadata entry in a thread-safe manner.
+func (s *Session) SetMetadata(key, val string) {
+	s.mu.Lock()
+	if s.Metadata == nil {
+		s.Metadata = make(map[string]string)
+	}
+	s.Metadata[key] = val
+	s.modified = true
+	s.mu.Unlock()
+}
+
+// GetMetadata retrieves a metadata entry in a thread-safe manner.
+func (s *Session) GetMetadata(key string) (string, bool) {
+	s.mu.RLock()
+	val, ok := s.Metadata[key]
+	s.mu.RUnlock()
+	return val, ok
+}
+
+// SetTTL overrides the Manager's TTL for this session, e.g. to make admin
+// sessions shorter-lived. The Manager uses it to compute ExpiresAt and the
+// cookie lifetime on every save, so it also applies to sliding expiration.
+// A value of 0 or less restores the Manager's TTL.
+func (s *Session) SetTTL(d time.Duration) {
+	if d <= 0 {
+		s.Delete(keyTTL)
+		return
+	}
+	// Stored as an int64 so that every codec round-trips it.
+	s.Set(keyTTL, int64(d))
+}
+
+// ttlLocked returns the TTL set by SetTTL, or 0 if none. The caller must
+// hold s.mu.
+func (s *Session) ttlLocked() time.Duration {
+	switch v := s.Values[keyTTL].(type) {
+	case int64:
+		return time.Duration(v)
+	case float64: // Decoded from JSON
+		return time.Duration(v)
+	default:
+		return 0
+	}
+}
+
+// wipe is Clear for a destroyed session, which has nothing left to save.
+func (s *Session) wipe() {
+	s.mu.Lock()
+	s.Values = nil
+	s.encoded = nil
+	s.modified = false
+	s.mu.Unlock()
+}
+
+// Modified reports whether the session needs saving: it was changed with
+// Set, Delete, Clear or SetMetadata since it was loaded or last saved, or
+// was restored from a remember-me token. Changes made directly to Values
+// are not tracked. Middleware uses it to save only the sessions a request
+// changed.
+func (s *Session) Modified() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.modified
+}
+
+// IsNew reports whether the session was created by Manager.New, e.g. by Get
+// for a first visit, rather than loaded from the store. It stays true for
+// the lifetime of the Session value, including after Save. A session
+// restored from a remember-me token is not new, as it continues an
+// established login.
+func (s *Session) IsNew() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.isNew
+}
+
+// Degraded reports whether Manager.Get issued the session because the store
+// was unavailable, see Config.DegradeToNewSession. Such a session holds none
+// of the client's values and cannot be saved.
+func (s *Session) Degraded() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.degraded
+}
+
+// Clone returns a point-in-time copy of the session, taken under its read
+// lock, that can be handed to another goroutine while the original keeps
+// being modified. Values is copied deeply through nested map[string]any and
+// []any, and Metadata is copied; other values are copied as is, so pointers
+// stored in a session remain shared. A pending remember-me rotation stays
+// with the original, which is the one to Save.
+func (s *Session) Clone() *Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := cloneSession(s)
+	c.savedOwner = s.savedOwner
+	c.isNew = s.isNew
+	c.destroyed = s.destroyed
+	c.degraded = s.degraded
+	return c
+}
+
+// ToMap returns a copy of the session's values, e.g. for logging or
+// template rendering, taken under its read lock. Changing the map does not
+// affect the session, but the copy is shallow: nested maps, slices and
+// pointers are shared with the session. The keys dbsession manages itself,
+// such as the CSRF token, are left out.
+func (s *Session) ToMap() map[string]any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values := appValues(s.Values)
+	delete(values, keySecrets)
+	return values
+}
+
+// appValues copies the application's values, leaving out the keys dbsession
+// manages itself (client binding, CSRF token, remember marker, TTL): those
+// belong to a single session and are recreated as needed. The sensitive
+// keys marked by SetSecret are kept, as they describe the values.
+func appValues(values map[string]any) map[string]any {
+	out := make(map[string]any, len(values))
+	for k, v := range values {
+		if !strings.HasPrefix(k, reservedKeyPrefix) || k == keySecrets {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// cloneSession returns a copy of s that shares no mutable state with it, for
+// stores holding sessions in memory. Values is copied deeply through nested
+// map[string]any and []any, the shapes produced by decoding; other values
+// are copied as is, so pointers stored in a session remain shared. The
+// caller must ensure s is not modified concurrently.
+func cloneSession(s *Session) *Session {
+	return &Session{
+		ID:              s.ID,
+		Values:          cloneValues(s.Values),
+		CreatedAt:       s.CreatedAt,
+		ExpiresAt:       s.ExpiresAt,
+		LastAccessedAt:  s.LastAccessedAt,
+		RegenerateCount: s.RegenerateCount,
+		Version:         s.Version,
+		OwnerID:         s.OwnerID,
+		Metadata:        maps.Clone(s.Metadata),
+	}
+}
+
+func cloneValues(values map[string]any) map[string]any {
+	if values == nil {
+		return nil
+	}
+	out := make(map[string]any, len(values))
+	for k, v := range values {
+		out[k] = cloneValue(v)
+	}
+	return out
+}
+
+func cloneValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return cloneValues(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = cloneValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
 // Store defines the interface for session persistence.
 type Store interface {
-	// Get retrieves a session by its ID.
+	// Get retrieves a session by its ID. It returns a nil session and a nil
+	// error if there is no such session, and expired sessions as they are:
+	// the Manager reports them. Implement FoundGetter to report misses
+	// explicitly instead.
 	Get(ctx context.Context, id string) (*Session, error)
 	// Save saves a session to the store.
 	Save(ctx context.Context, s *Session) error
@@ -62,6 +282,148 @@ type Store interface {
 	Delete(ctx context.Context, id string) error
 	// Cleanup removes expired sessions from the store.
 	Cleanup(ctx context.Context) error
+	// Ping checks that the backend is reachable.
+	Ping(ctx context.Context) error
 	// Close closes the store.
 	Close() error
 }
+
+// ReadOnlyStore is the reading half of a Store, for handing to components
+// that must not modify sessions, such as reporting. Create one with
+// ReadOnly.
+type ReadOnlyStore interface {
+	// Get retrieves a session by its ID.
+	Get(ctx context.Context, id string) (*Session, error)
+	// ForEach calls fn for every stored session, see Enumerator. It fails
+	// with an error wrapping errors.ErrUnsupported if the store cannot
+	// enumerate its sessions.
+	ForEach(ctx context.Context, fn func(*Session, error) error) error
+	// ListByOwner returns the sessions owned by owner, see OwnerLister. It
+	// fails with an error wrapping errors.ErrUnsupported if the store does
+	// not index owners.
+	ListByOwner(ctx context.Context, owner string) ([]*Session, error)
+	// Ping checks that the backend is reachable.
+	Ping(ctx context.Context) error
+}
+
+// FoundGetter is implemented by stores that report whether a session was
+// found separately from the session itself. It is optional; the Manager
+// looks sessions up with GetOK, which falls back to Get.
+type FoundGetter interface {
+	Store
+	// GetOK retrieves a session by its ID and reports whether it was found.
+	// The session is ignored when found is false.
+	GetOK(ctx context.Context, id string) (s *Session, found bool, err error)
+}
+
+// GetOK looks up a session in store, reporting whether it was found. It
+// calls GetOK on stores implementing FoundGetter, and Get otherwise, where
+// a nil session, or one with an empty ID as some implementations return
+// for a miss, is not found.
+func GetOK(ctx context.Context, store Store, id string) (*Session, bool, error) {
+	if g, ok := store.(FoundGetter); ok {
+		s, found, err := g.GetOK(ctx, id)
+		if err != nil || !found {
+			return nil, false, err
+		}
+		return s, true, nil
+	}
+	s, err := store.Get(ctx, id)
+	if err != nil || s == nil || s.ID == "" {
+		return nil, false, err
+	}
+	return s, true, nil
+}
+
+// Enumerator is implemented by stores that can list their sessions, such as
+// the SQL stores. Memcached cannot enumerate its keys. It is optional; check
+// for it with a type assertion on a Store.
+type Enumerator interface {
+	Store
+	// ForEach calls fn for every stored session, expired ones included, in
+	// no particular order. A session whose data cannot be decoded is passed
+	// to fn as a nil session and an error naming its ID. Iteration stops at
+	// the first error returned by fn, which ForEach returns.
+	ForEach(ctx context.Context, fn func(*Session, error) error) error
+}
+
+// OwnerLister is implemented by stores that index sessions by
+// Session.OwnerID, currently the SQL stores. It is optional; check for it
+// with a type assertion on a Store.
+type OwnerLister interface {
+	Store
+	// ListByOwner returns the sessions owned by owner, oldest first,
+	// expired ones included.
+	ListByOwner(ctx context.Context, owner string) ([]*Session, error)
+}
+
+// Counter is implemented by stores that can count their sessions, currently
+// the SQL stores. It is optional; check for it with a type assertion on a
+// Store.
+type Counter interface {
+	Store
+	// Count returns the number of sessions that have not expired,
+	// remember-me entries included.
+	Count(ctx context.Context) (int64, error)
+}
+
+// MultiStore is implemented by stores that can load and save several
+// sessions in one round trip. It is optional; check for it with a type
+// assertion on a Store.
+type MultiStore interface {
+	Store
+	// GetMulti retrieves the sessions with the given IDs. Sessions that do
+	// not exist are absent from the returned map.
+	GetMulti(ctx context.Context, ids []string) (map[string]*Session, error)
+	// SaveMulti saves all the given sessions, atomically if the backend
+	// supports transactions.
+	SaveMulti(ctx context.Context, sessions []*Session) error
+}
+
+// MultiDeleter is implemented by stores that can delete several sessions in
+// one round trip. It is optional; Manager.Revoke falls back to deleting the
+// sessions one by one.
+type MultiDeleter interface {
+	Store
+	// DeleteMulti removes the sessions with the given IDs, ignoring those
+	// that do not exist. Stores backed by a database delete all of them or
+	// none.
+	DeleteMulti(ctx context.Context, ids []string) error
+}
+
+// CleanupCounter is implemented by stores that can report how many expired
+// sessions a cleanup removed. The Manager uses it, when available, to record
+// the count on the cleanup span.
+type CleanupCounter interface {
+	// CleanupCount removes expired sessions like Store.Cleanup and returns
+	// the number of sessions removed.
+	CleanupCount(ctx context.Context) (int64, error)
+}
+
+// ExpiryLeewaySetter is implemented by stores that compare expiry times
+// themselves, e.g. in Cleanup or Count. The Manager passes them
+// Config.ExpiryLeeway, so that they keep sessions it still accepts.
+type ExpiryLeewaySetter interface {
+	// SetExpiryLeeway makes the store treat sessions as expired only once
+	// they are past their ExpiresAt by more than d.
+	SetExpiryLeeway(d time.Duration)
+}
+
+// CodecStore is implemented by stores that encode session values with a
+// Codec. The Manager encodes values with it on Save, so that the size limit
+// and unencodable values are checked against what the store writes, and the
+// store reuses that encoding. Other stores are assumed to use GobCodec.
+type CodecStore interface {
+	// Codec returns the codec the store encodes session values with.
+	Codec() Codec
+}
+
+// ExclusiveCleaner is implemented by stores that can coordinate cleanup
+// between processes sharing the same backend. It is used instead of
+// Store.Cleanup when Config.SingleInstanceCleanup is set.
+type ExclusiveCleaner interface {
+	// CleanupExclusive removes expired sessions unless another process is
+	// already doing so, in which case it returns immediately. It reports
+	// whether this call performed the cleanup.
+	CleanupExclusive(ctx context.Context) (bool, error)
+}
@@ -2,9 +2,14 @@ package dbsession
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -160,7 +165,10 @@ func TestManager(t *testing.T) {
 	defer mgr.Close()
 
 	// Test New and Save
-	s := mgr.New()
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
 	s.Values["user"] = "mordicus"
 
 	w := httptest.NewRecorder()
@@ -381,9 +389,34 @@ func BenchmarkMemcachedStore_Get(b *testing.B) {
 // Parallel benchmarks
 
 func BenchmarkSQLiteStore_SaveParallel(b *testing.B) {
-	// SQLite is not designed for high-concurrency parallel writes.
-	// For high write concurrency, use Memcached or another distributed store.
-	b.Skip("SQLite parallel writes not supported - use Memcached for concurrent workloads")
+	// A file rather than :memory:, which would give each pooled connection
+	// its own database. Concurrent saves are committed in batches by the
+	// store's writer goroutine.
+	store, err := NewSQLiteStore(filepath.Join(b.TempDir(), "sessions.db"))
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	var n atomic.Int64
+	b.SetParallelism(16) // Many concurrent requests per CPU, as in a server
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		session := &Session{
+			ID:        fmt.Sprintf("bench-parallel-%d", n.Add(1)),
+			Values:    map[string]any{"key": "value"},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		for pb.Next() {
+			if err := store.Save(ctx, session); err != nil {
+				b.Errorf("failed to save: %v", err)
+				return
+			}
+		}
+	})
 }
 
 func BenchmarkMemcachedStore_SaveParallel(b *testing.B) {
@@ -468,10 +501,14 @@ func BenchmarkManager_Save_Empty(b *testing.B) {
 	})
 	defer mgr.Close()
 
-	s := mgr.New() // Empty values
+	s, err := mgr.New() // Empty values
+	if err != nil {
+		b.Fatalf("failed to create session: %v", err)
+	}
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("GET", "/", nil)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		if err := mgr.Save(w, r, s); err != nil {
@@ -479,3 +516,104 @@ func BenchmarkManager_Save_Empty(b *testing.B) {
 		}
 	}
 }
+
+// headerWriter is a ResponseWriter that only keeps headers.
+type headerWriter struct{ header http.Header }
+
+func (w *headerWriter) Header() http.Header         { return w.header }
+func (w *headerWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *headerWriter) WriteHeader(int)             {}
+
+// TestManager_SaveEmptyAllocs guards the allocation-free Save path of an
+// empty session: with a store that does not allocate, the only allocation
+// left is the Set-Cookie header value.
+func TestManager_SaveEmptyAllocs(t *testing.T) {
+	mgr := NewManager(Config{Store: &MockStore{}, MaxSessionBytes: 4096})
+	defer mgr.Close()
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	w := &headerWriter{header: http.Header{"Set-Cookie": make([]string, 0, 1)}}
+	r := httptest.NewRequest("GET", "/", nil)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		w.header["Set-Cookie"] = w.header["Set-Cookie"][:0]
+		if err := mgr.Save(w, r, s); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 1 {
+		t.Errorf("expected at most 1 allocation per Save of an empty session, got %v", allocs)
+	}
+}
+
+func TestManager_BrowserSessionCookie(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	persistent := false
+	mgr := NewManager(Config{Store: store, TTL: time.Hour, PersistentCookie: &persistent})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	header := w.Header().Get("Set-Cookie")
+	if strings.Contains(header, "Max-Age") || strings.Contains(header, "Expires") {
+		t.Errorf("expected a browser-session cookie, got %q", header)
+	}
+
+	// The stored session still expires after the TTL.
+	stored, _ := store.Get(context.Background(), s.ID)
+	if stored == nil || time.Until(stored.ExpiresAt) > time.Hour || time.Until(stored.ExpiresAt) < 59*time.Minute {
+		t.Errorf("expected stored session to expire after the TTL, got %v", stored)
+	}
+
+	w = httptest.NewRecorder()
+	if err := mgr.Destroy(w, r, s); err != nil {
+		t.Fatalf("failed to destroy: %v", err)
+	}
+	if c := w.Result().Cookies()[0]; c.MaxAge >= 0 {
+		t.Errorf("expected Destroy to delete the cookie with MaxAge<0, got %d", c.MaxAge)
+	}
+}
+
+func TestManager_CookieTTL(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	clock := newFakeClock()
+	mgr := NewManager(Config{Store: store, Clock: clock, TTL: time.Hour, CookieTTL: 2 * time.Hour})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	cookie := saveFrom(t, mgr, s, "192.0.2.1:1234")
+	if cookie.MaxAge != 7200 {
+		t.Errorf("expected the cookie to live for CookieTTL, got MaxAge %d", cookie.MaxAge)
+	}
+	if !s.ExpiresAt.Equal(clock.Now().Add(time.Hour)) {
+		t.Errorf("expected the session to expire after TTL, got %v", s.ExpiresAt)
+	}
+
+	// The cookie outlives the session, which is reported as expired.
+	clock.Advance(90 * time.Minute)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.AddCookie(cookie)
+	if _, err := mgr.Load(r); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package dbsession
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStore_WithDB(t *testing.T) {
+	dbPath := "test_shared.db"
+	defer os.Remove(dbPath)
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	store, err := NewSQLiteStoreWithDB(db)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "shared-db-session",
+		Values:    map[string]any{"k": "v"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+
+	// The shared pool must still be usable after the store is closed.
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sessions WHERE id = ?", s.ID).Scan(&n); err != nil {
+		t.Fatalf("shared database was closed by the store: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 row, got %d", n)
+	}
+}
+
+func TestPostgreSQLStore_WithDB(t *testing.T) {
+	db, err := sql.Open("postgres", getTestPostgreSQLDSN())
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	store, err := NewPostgreSQLStoreWithDB(db)
+	if err != nil {
+		t.Skipf("Skipping PostgreSQL test: %v (is PostgreSQL running?)", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Errorf("shared database was closed by the store: %v", err)
+	}
+}
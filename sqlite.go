@@ -6,13 +6,26 @@ import (
 	"database/sql"
 	"encoding/gob"
 	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "modernc.org/sqlite"
 )
 
+// sqliteMaxBatch bounds the number of IDs bound in a single GetMulti query,
+// well below SQLite's host parameter limit.
+const sqliteMaxBatch = 500
+
+// defaultCleanupBatchSize is the number of expired rows Cleanup deletes per
+// statement unless configured otherwise.
+const defaultCleanupBatchSize = 1000
+
 type SQLiteStore struct {
 	db              *sql.DB
 	mu              sync.Mutex // Serializes writes to avoid SQLITE_BUSY
@@ -20,46 +33,186 @@ type SQLiteStore struct {
 	getStmt         *sql.Stmt
 	deleteStmt      *sql.Stmt
 	cleanupStmt     *sql.Stmt
+	insertStmt      *sql.Stmt // Optimistic locking: insert of a new session
+	updateStmt      *sql.Stmt // Optimistic locking: versioned update
 	maxSessionBytes int
+	maxDecodedBytes int
+	optimistic      bool
+	ownsDB          bool // Whether Close should close db
+	table           string
+	cleanupBatch    int
+	clock           Clock // Time source for cleanup; replaceable in tests
+	codec           Codec
+	stopMaintenance context.CancelFunc // Nil without a maintenance worker
+	maintenanceDone chan struct{}
+	memoryConn      *sql.Conn         // Keeps an in-memory database alive, see sqliteMemoryDSN
+	writes          chan *sqliteWrite // Saves queued for the writer goroutine
+	writerDone      chan struct{}     // Closed when the writer exits
+	closeMu         sync.RWMutex      // Held for writing by Close, for reading by senders to writes
+	closed          bool
+
+	expiryLeeway // See ExpiryLeewaySetter
 }
 
 // SQLiteConfig holds configuration for the SQLite store.
 type SQLiteConfig struct {
-	DSN             string
+	DSN string
+	// DB, if set, is used instead of opening DSN. The store does not own it:
+	// Close leaves it open, and DSN, the pool settings and PRAGMAs are left
+	// to the caller.
+	DB *sql.DB
+	// TableName is the table sessions are stored in. Defaults to "sessions".
+	// It must be a plain identifier (letters, digits and underscores).
+	TableName       string
 	MaxOpenConns    int
 	MaxIdleConns    int
 	ConnMaxLifetime time.Duration
 	MaxSessionBytes int
+	// OptimisticLocking makes Save fail with ErrConcurrentModification if the
+	// session was saved by someone else since it was loaded, instead of
+	// overwriting their changes. See Session.Version.
+	OptimisticLocking bool
+	// CleanupBatchSize is the maximum number of expired sessions deleted per
+	// statement by Cleanup. The write lock is released between batches so
+	// that a large purge does not stall concurrent saves. Defaults to 1000.
+	CleanupBatchSize int
+	// Codec encodes session values. Defaults to GobCodec.
+	Codec Codec
+	// MaxDecodedBytes limits the size of a session's data once decoded by
+	// the codec, e.g. decompressed, guarding against small blobs that
+	// expand into gigabytes. It is enforced while decoding by codecs
+	// implementing LimitedDecoder. 0 means unlimited.
+	MaxDecodedBytes int
+	// Pragmas sets SQLite PRAGMAs on every connection, e.g.
+	// {"synchronous": "OFF"}. They are merged over the defaults
+	// (synchronous=NORMAL, busy_timeout=5000, journal_mode=WAL) and take
+	// precedence over them. A default set by a _pragma parameter of the DSN
+	// is left to it, and a PRAGMA cannot be set both there and here. Names
+	// must be plain identifiers and values may only contain letters, digits,
+	// '_', '.', '+' and '-'.
+	Pragmas map[string]string
+	// CheckpointInterval, if positive, makes the store run Checkpoint in the
+	// background at this interval, so that the WAL file does not grow
+	// unbounded under steady churn.
+	CheckpointInterval time.Duration
+	// VacuumOnCheckpoint also runs Vacuum before each background checkpoint,
+	// so the database file shrinks after large cleanups. VACUUM rewrites the
+	// whole database and blocks writes while it runs; only enable it with a
+	// long CheckpointInterval.
+	VacuumOnCheckpoint bool
+}
+
+// defaultSQLitePragmas are the PRAGMAs set unless overridden by
+// SQLiteConfig.Pragmas or the DSN.
+var defaultSQLitePragmas = map[string]string{
+	"synchronous":  "NORMAL", // Safe in WAL mode and faster
+	"busy_timeout": "5000",   // Wait for locks
+	"journal_mode": "WAL",    // Better concurrent writes
+}
+
+// pragmaValuePattern restricts PRAGMA values to characters that cannot
+// break out of the DSN query string.
+var pragmaValuePattern = regexp.MustCompile(`^[A-Za-z0-9_.+-]+$`)
+
+// sqlitePragmas merges pragmas over the defaults, leaving out the defaults
+// already set in dsn, and validates the result. A PRAGMA set both in dsn and
+// in pragmas is an error, as only one of the values could apply.
+func sqlitePragmas(dsn string, pragmas map[string]string) (map[string]string, error) {
+	inDSN, err := sqliteDSNPragmas(dsn)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]string, len(defaultSQLitePragmas)+len(pragmas))
+	for name, value := range defaultSQLitePragmas {
+		if !inDSN[name] {
+			merged[name] = value
+		}
+	}
+	for name, value := range pragmas {
+		if err := validateIdentifier("sqlite pragma", name); err != nil {
+			return nil, err
+		}
+		if !pragmaValuePattern.MatchString(value) {
+			return nil, fmt.Errorf("invalid value %q for sqlite pragma %s: must match %s", value, name, pragmaValuePattern)
+		}
+		name = strings.ToLower(name)
+		if inDSN[name] {
+			return nil, fmt.Errorf("sqlite pragma %s is set both in the DSN and in Pragmas", name)
+		}
+		merged[name] = value
+	}
+	return merged, nil
+}
+
+// sqliteDSNPragmas returns the lowercased names of the PRAGMAs set by the
+// _pragma parameters of dsn, e.g. busy_timeout for "_pragma=busy_timeout(5000)"
+// or "_pragma=busy_timeout%3D5000". Like the driver, it reads the query from
+// the first '?'.
+func sqliteDSNPragmas(dsn string) (map[string]bool, error) {
+	_, query, _ := strings.Cut(dsn, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sqlite DSN parameters: %w", err)
+	}
+	names := make(map[string]bool)
+	for _, pragma := range params["_pragma"] {
+		name, _, _ := strings.Cut(pragma, "=")
+		name, _, _ = strings.Cut(name, "(")
+		// A schema may qualify the name, as in main.journal_mode.
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		}
+		names[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return names, nil
 }
 
 func NewSQLiteStore(dsn string) (*SQLiteStore, error) {
 	return NewSQLiteStoreWithConfig(SQLiteConfig{
 		DSN:          dsn,
-		MaxOpenConns: 16, // Allow concurrent readers (writers are serialized by mutex)
+		MaxOpenConns: 16, // Allow concurrent readers (saves go through a single writer)
 		MaxIdleConns: 16,
 	})
 }
 
+// NewSQLiteStoreWithDB creates a SQLite store on an existing connection
+// pool. The table is created if needed, but db is not closed by Close.
+func NewSQLiteStoreWithDB(db *sql.DB) (*SQLiteStore, error) {
+	return NewSQLiteStoreWithConfig(SQLiteConfig{DB: db})
+}
+
 func NewSQLiteStoreWithConfig(cfg SQLiteConfig) (*SQLiteStore, error) {
-	// Inject PRAGMAs into DSN to ensure they apply to all connections in the pool.
-	// Previous implementation using db.Exec only applied to the first connection.
+	if cfg.TableName == "" {
+		cfg.TableName = defaultTableName
+	}
+	if err := validateIdentifier("table name", cfg.TableName); err != nil {
+		return nil, err
+	}
 
-	// synchronous=NORMAL is safe in WAL mode and faster.
-	if !strings.Contains(cfg.DSN, "synchronous") {
-		separator := "?"
-		if strings.Contains(cfg.DSN, "?") {
-			separator = "&"
-		}
-		cfg.DSN = fmt.Sprintf("%s%s_pragma=synchronous=NORMAL", cfg.DSN, separator)
+	if cfg.DB != nil {
+		return newSQLiteStore(cfg.DB, cfg, false)
+	}
+
+	var inMemory bool
+	cfg.DSN, inMemory = sqliteMemoryDSN(cfg.DSN)
+
+	pragmas, err := sqlitePragmas(cfg.DSN, cfg.Pragmas)
+	if err != nil {
+		return nil, err
 	}
 
-	// busy_timeout to wait for locks
-	if !strings.Contains(cfg.DSN, "busy_timeout") {
+	// Inject PRAGMAs into DSN to ensure they apply to all connections in the pool.
+	// Previous implementation using db.Exec only applied to the first connection.
+	// journal_mode is persistent for the database file, so it is set once below.
+	for _, name := range slices.Sorted(maps.Keys(pragmas)) {
+		if name == "journal_mode" {
+			continue
+		}
 		separator := "?"
 		if strings.Contains(cfg.DSN, "?") {
 			separator = "&"
 		}
-		cfg.DSN = fmt.Sprintf("%s%s_pragma=busy_timeout=5000", cfg.DSN, separator)
+		cfg.DSN = fmt.Sprintf("%s%s_pragma=%s=%s", cfg.DSN, separator, name, pragmas[name])
 	}
 
 	db, err := sql.Open("sqlite", cfg.DSN)
@@ -69,6 +222,11 @@ func NewSQLiteStoreWithConfig(cfg SQLiteConfig) (*SQLiteStore, error) {
 
 	// Configure connection pool
 	if cfg.MaxOpenConns > 0 {
+		// The connection keeping an in-memory database alive is not
+		// available to queries.
+		if inMemory {
+			cfg.MaxOpenConns++
+		}
 		db.SetMaxOpenConns(cfg.MaxOpenConns)
 	}
 	if cfg.MaxIdleConns > 0 {
@@ -78,72 +236,201 @@ func NewSQLiteStoreWithConfig(cfg SQLiteConfig) (*SQLiteStore, error) {
 		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	}
 
-	// Enable WAL mode for better concurrent writes.
+	// An in-memory database is freed with its last connection, which the
+	// pool may close at any time: one is held until Close.
+	var memoryConn *sql.Conn
+	if inMemory {
+		if memoryConn, err = db.Conn(context.Background()); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+		}
+	}
+
+	// Set the journal mode (WAL by default, for better concurrent writes).
 	// This is persistent for the database file, so executing it once is sufficient.
-	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
+	if mode, ok := pragmas["journal_mode"]; ok {
+		if _, err := db.Exec("PRAGMA journal_mode=" + mode); err != nil {
+			if memoryConn != nil {
+				memoryConn.Close()
+			}
+			db.Close()
+			return nil, fmt.Errorf("failed to set journal mode %s: %w", mode, err)
+		}
+	}
+
+	store, err := newSQLiteStore(db, cfg, true)
+	if err != nil {
+		if memoryConn != nil {
+			memoryConn.Close()
+		}
+		return nil, err
+	}
+	store.memoryConn = memoryConn
+	return store, nil
+}
+
+// sqliteMemoryDBs numbers the in-memory databases opened by the stores.
+var sqliteMemoryDBs atomic.Int64
+
+// sqliteMemoryDSN rewrites an in-memory DSN (":memory:" or
+// "file::memory:") so that all the connections of the pool open the same
+// database, reporting whether it did. SQLite gives each connection to
+// ":memory:" a private database, in which the sessions saved through
+// another connection are missing. Databases of the memdb VFS are shared by
+// the connections naming them; each store gets its own.
+func sqliteMemoryDSN(dsn string) (string, bool) {
+	name, params, _ := strings.Cut(dsn, "?")
+	if name != ":memory:" && name != "file::memory:" {
+		return dsn, false
+	}
+	shared := fmt.Sprintf("file:/dbsession-%d?vfs=memdb", sqliteMemoryDBs.Add(1))
+	if params != "" {
+		shared += "&" + params
+	}
+	return shared, true
+}
+
+// newSQLiteStore creates the schema and prepares statements on db.
+// On failure, db is closed only if owned.
+func newSQLiteStore(db *sql.DB, cfg SQLiteConfig, ownsDB bool) (*SQLiteStore, error) {
+	store := &SQLiteStore{
+		db:              db,
+		maxSessionBytes: cfg.MaxSessionBytes,
+		maxDecodedBytes: cfg.MaxDecodedBytes,
+		cleanupBatch:    cfg.CleanupBatchSize,
+		optimistic:      cfg.OptimisticLocking,
+		ownsDB:          ownsDB,
+		table:           cfg.TableName,
+		clock:           systemClock{},
+		codec:           cfg.Codec,
+	}
+	if store.codec == nil {
+		store.codec = GobCodec{}
 	}
 
 	// Create table if not exists
-	query := `
-	CREATE TABLE IF NOT EXISTS sessions (
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %[1]s (
 		id TEXT PRIMARY KEY,
 		data BLOB,
 		created_at DATETIME,
-		expires_at DATETIME
+		expires_at DATETIME,
+		version INTEGER NOT NULL DEFAULT 0,
+		last_accessed_at DATETIME,
+		regenerate_count INTEGER NOT NULL DEFAULT 0,
+		owner_id TEXT,
+		metadata TEXT
 	);
-	CREATE INDEX IF NOT EXISTS idx_expires_at ON sessions(expires_at);
-	`
+	CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s(expires_at);
+	`, store.table, expiresIndexName(store.table))
 	if _, err := db.Exec(query); err != nil {
-		db.Close()
+		store.Close()
 		return nil, fmt.Errorf("failed to create sessions table: %w", err)
 	}
 
-	store := &SQLiteStore{
-		db:              db,
-		maxSessionBytes: cfg.MaxSessionBytes,
+	// Upgrade tables created by earlier versions.
+	if err := sqliteAddColumn(db, store.table, "version", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to migrate sessions table: %w", err)
+	}
+	if err := sqliteAddColumn(db, store.table, "last_accessed_at", "DATETIME"); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to migrate sessions table: %w", err)
+	}
+	if err := sqliteAddColumn(db, store.table, "regenerate_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to migrate sessions table: %w", err)
+	}
+	if err := sqliteAddColumn(db, store.table, "owner_id", "TEXT"); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to migrate sessions table: %w", err)
+	}
+	if err := sqliteAddColumn(db, store.table, "metadata", "TEXT"); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to migrate sessions table: %w", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s(owner_id)", ownerIndexName(store.table), store.table)); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to migrate sessions table: %w", err)
 	}
 
 	// Prepare statements
-	store.saveStmt, err = db.Prepare(`
-		INSERT INTO sessions (id, data, created_at, expires_at)
-		VALUES (?, ?, ?, ?)
+	var err error
+	store.saveStmt, err = db.Prepare(fmt.Sprintf(`
+		INSERT INTO %s (id, data, created_at, expires_at, last_accessed_at, regenerate_count, owner_id, metadata)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 			data = excluded.data,
-			expires_at = excluded.expires_at
-	`)
+			expires_at = excluded.expires_at,
+			last_accessed_at = excluded.last_accessed_at,
+			regenerate_count = excluded.regenerate_count,
+			owner_id = excluded.owner_id,
+			metadata = excluded.metadata
+	`, store.table))
 	if err != nil {
-		db.Close()
+		store.Close()
 		return nil, fmt.Errorf("failed to prepare save statement: %w", err)
 	}
 
-	store.getStmt, err = db.Prepare("SELECT data, created_at, expires_at FROM sessions WHERE id = ? AND expires_at > ?")
+	// Expired rows are returned as-is so the Manager can tell "expired" apart
+	// from "not found"; they are removed by Cleanup.
+	store.getStmt, err = db.Prepare(fmt.Sprintf("SELECT %s FROM %s WHERE id = ?", sqlSessionColumns, store.table))
 	if err != nil {
 		store.Close()
 		return nil, fmt.Errorf("failed to prepare get statement: %w", err)
 	}
 
-	store.deleteStmt, err = db.Prepare("DELETE FROM sessions WHERE id = ?")
+	store.deleteStmt, err = db.Prepare(fmt.Sprintf("DELETE FROM %s WHERE id = ?", store.table))
 	if err != nil {
 		store.Close()
 		return nil, fmt.Errorf("failed to prepare delete statement: %w", err)
 	}
 
-	store.cleanupStmt, err = db.Prepare("DELETE FROM sessions WHERE expires_at < ?")
+	if store.cleanupBatch <= 0 {
+		store.cleanupBatch = defaultCleanupBatchSize
+	}
+	// SQLite is not usually built with DELETE ... LIMIT, hence the subquery.
+	store.cleanupStmt, err = db.Prepare(fmt.Sprintf(
+		"DELETE FROM %[1]s WHERE id IN (SELECT id FROM %[1]s WHERE expires_at < ? LIMIT ?)", store.table))
 	if err != nil {
 		store.Close()
 		return nil, fmt.Errorf("failed to prepare cleanup statement: %w", err)
 	}
 
+	if store.optimistic {
+		store.insertStmt, err = db.Prepare(fmt.Sprintf(`
+			INSERT INTO %s (id, data, created_at, expires_at, last_accessed_at, regenerate_count, owner_id, metadata, version)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1)
+			ON CONFLICT(id) DO NOTHING
+		`, store.table))
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to prepare insert statement: %w", err)
+		}
+
+		store.updateStmt, err = db.Prepare(fmt.Sprintf(`
+			UPDATE %s SET data = ?, expires_at = ?, last_accessed_at = ?, regenerate_count = ?, owner_id = ?,
+				metadata = ?, version = version + 1
+			WHERE id = ? AND version = ?
+		`, store.table))
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to prepare update statement: %w", err)
+		}
+	}
+
+	store.startWriter()
+	if cfg.CheckpointInterval > 0 {
+		store.startMaintenance(cfg.CheckpointInterval, cfg.VacuumOnCheckpoint)
+	}
+
 	return store, nil
 }
 
 func (s *SQLiteStore) Get(ctx context.Context, id string) (*Session, error) {
-	var data sql.RawBytes
-	var createdAt, expiresAt time.Time
+	var row sqlRow
 
-	rows, err := s.getStmt.QueryContext(ctx, id, time.Now())
+	rows, err := s.getStmt.QueryContext(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query session: %w", err)
 	}
@@ -153,78 +440,200 @@ func (s *SQLiteStore) Get(ctx context.Context, id string) (*Session, error) {
 		if err := rows.Err(); err != nil {
 			return nil, fmt.Errorf("failed to iterate rows: %w", err)
 		}
-		return nil, nil // Not found or expired
+		return nil, nil // Not found
 	}
 
-	if err := rows.Scan(&data, &createdAt, &expiresAt); err != nil {
+	if err := rows.Scan(row.dest()...); err != nil {
 		return nil, fmt.Errorf("failed to scan session: %w", err)
 	}
 
-	if s.maxSessionBytes > 0 && len(data) > s.maxSessionBytes {
-		return nil, ErrSessionTooLarge
+	// data is valid only until the next Scan or Close, which the deferred
+	// rows.Close performs: decode must not keep it, see decodeValues.
+	values, err := s.decode(row.data)
+	if err != nil {
+		return nil, err
 	}
 
-	var values map[string]any
+	return row.session(id, values)
+}
 
-	// Optimize for empty/new sessions: skip Gob decoding if data is empty/NULL.
-	// sql.RawBytes is nil if the column is NULL.
-	if len(data) > 0 {
-		reader := readerPool.Get().(*bytes.Reader)
-		reader.Reset(data)
-		defer readerPool.Put(reader)
-
-		// data is valid only until next Scan/Close. gob.NewDecoder reads from it immediately.
-		if err := gob.NewDecoder(reader).Decode(&values); err != nil {
-			return nil, fmt.Errorf("failed to decode session data: %w", err)
+// GetMulti retrieves several sessions with one query per sqliteMaxBatch IDs.
+// Sessions that do not exist are absent from the result. As with Get,
+// expired sessions are returned as stored.
+func (s *SQLiteStore) GetMulti(ctx context.Context, ids []string) (map[string]*Session, error) {
+	sessions := make(map[string]*Session, len(ids))
+	for len(ids) > 0 {
+		n := min(len(ids), sqliteMaxBatch)
+		if err := s.getBatch(ctx, ids[:n], sessions); err != nil {
+			return nil, err
 		}
+		ids = ids[n:]
 	}
+	return sessions, nil
+}
 
-	if values == nil {
-		values = make(map[string]any)
+// getBatch loads the sessions with the given IDs into sessions.
+func (s *SQLiteStore) getBatch(ctx context.Context, ids []string, sessions map[string]*Session) error {
+	query := fmt.Sprintf("SELECT id, %s FROM %s WHERE id IN (%s)",
+		sqlSessionColumns, s.table, strings.Repeat("?, ", len(ids)-1)+"?")
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
 	}
 
-	return &Session{
-		ID:        id,
-		Values:    values,
-		CreatedAt: createdAt,
-		ExpiresAt: expiresAt,
-	}, nil
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var row sqlRow
+		if err := rows.Scan(append([]any{&id}, row.dest()...)...); err != nil {
+			return fmt.Errorf("failed to scan session: %w", err)
+		}
+		values, err := s.decode(row.data)
+		if err != nil {
+			return err
+		}
+		session, err := row.session(id, values)
+		if err != nil {
+			return err
+		}
+		sessions[id] = session
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return nil
+}
+
+// ForEach calls fn for every stored session, see Enumerator. The query
+// holds a connection until ForEach returns.
+func (s *SQLiteStore) ForEach(ctx context.Context, fn func(*Session, error) error) error {
+	return querySessions(ctx, s.db, s.decode, fn, fmt.Sprintf("SELECT id, %s FROM %s", sqlSessionColumns, s.table))
+}
+
+// Count returns the number of sessions that have not expired, see Counter.
+func (s *SQLiteStore) Count(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE expires_at >= ?", s.table), s.expiryCutoff(s.clock.Now())).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sessions: %w", err)
+	}
+	return n, nil
+}
+
+// ListByOwner returns the sessions owned by owner, oldest first, expired
+// ones included.
+func (s *SQLiteStore) ListByOwner(ctx context.Context, owner string) ([]*Session, error) {
+	return listSessions(ctx, s.db, s.decode,
+		fmt.Sprintf("SELECT id, %s FROM %s WHERE owner_id = ? ORDER BY created_at", sqlSessionColumns, s.table), owner)
+}
+
+// decode decodes stored session data. An empty map is returned for NULL data.
+func (s *SQLiteStore) decode(data []byte) (map[string]any, error) {
+	if s.maxSessionBytes > 0 && len(data) > s.maxSessionBytes {
+		return nil, &SessionTooLargeError{Size: len(data), Limit: s.maxSessionBytes}
+	}
+
+	// Optimize for empty/new sessions: decoding is skipped if data is empty/NULL.
+	// sql.RawBytes is nil if the column is NULL.
+	return decodeValues(s.codec, data, int64(s.maxDecodedBytes))
 }
 
+// Save writes the session. Concurrent saves are committed together by a
+// single writer goroutine, which spares them from contending for SQLite's
+// write lock; if several saves of the same session are pending, only the
+// last is written, unless optimistic locking is enabled.
 func (s *SQLiteStore) Save(ctx context.Context, session *Session) error {
-	var blob []byte
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer PutBuffer(buf)
 
-	// Optimize for empty sessions: store NULL instead of Gob encoded empty map.
-	// This saves allocations and CPU cycles for sessions that are just created but not populated.
-	if len(session.Values) > 0 {
-		if session.encoded != nil {
-			blob = session.encoded
-		} else {
-			buf := bufferPool.Get().(*bytes.Buffer)
-			buf.Reset()
-			defer PutBuffer(buf)
-
-			if err := gob.NewEncoder(buf).Encode(session.Values); err != nil {
-				return fmt.Errorf("failed to encode session data: %w", err)
-			}
-			blob = buf.Bytes()
-		}
+	blob, err := s.encode(session, buf)
+	if err != nil {
+		return err
 	}
 
-	if s.maxSessionBytes > 0 && len(blob) > s.maxSessionBytes {
-		return ErrSessionTooLarge
+	if err := s.queueWrite(ctx, session, blob); err != nil {
+		return err
+	}
+	if s.optimistic {
+		session.Version++
+	}
+	return nil
+}
+
+// SaveMulti saves several sessions in one transaction: either all of them
+// are saved or none is. With optimistic locking, a single stale session
+// fails the whole batch with ErrConcurrentModification.
+func (s *SQLiteStore) SaveMulti(ctx context.Context, sessions []*Session) error {
+	if len(sessions) == 0 {
+		return nil
 	}
 
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer PutBuffer(buf)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	_, err := s.saveStmt.ExecContext(ctx, session.ID, blob, session.CreatedAt, session.ExpiresAt)
 
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to save session: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmts := s.saveStmts().bind(ctx, tx)
+	for _, session := range sessions {
+		// buf is reused: the previous blob has been written by now.
+		blob, err := s.encode(session, buf)
+		if err != nil {
+			return err
+		}
+		if err := stmts.exec(ctx, s.optimistic, session, blob); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if s.optimistic {
+		for _, session := range sessions {
+			session.Version++
+		}
 	}
 	return nil
 }
 
+// encode returns the data stored for session, or nil for an empty session.
+// buf is used as scratch space unless the Manager already encoded the values,
+// so the result is only valid until buf is reused.
+func (s *SQLiteStore) encode(session *Session, buf *bytes.Buffer) ([]byte, error) {
+	// Optimize for empty sessions: store NULL instead of Gob encoded empty map.
+	// This saves allocations and CPU cycles for sessions that are just created but not populated.
+	if len(session.Values) == 0 {
+		return nil, nil
+	}
+
+	blob, err := encodeValues(s.codec, session, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.maxSessionBytes > 0 && len(blob) > s.maxSessionBytes {
+		return nil, &SessionTooLargeError{Size: len(blob), Limit: s.maxSessionBytes}
+	}
+	return blob, nil
+}
+
+// saveStmts returns the statements used to write a session.
+func (s *SQLiteStore) saveStmts() sqlSaveStmts {
+	return sqlSaveStmts{save: s.saveStmt, insert: s.insertStmt, update: s.updateStmt}
+}
+
 func (s *SQLiteStore) Delete(ctx context.Context, id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -235,17 +644,108 @@ func (s *SQLiteStore) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
+// DeleteMulti removes the sessions with the given IDs in one transaction,
+// see MultiDeleter.
+func (s *SQLiteStore) DeleteMulti(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for len(ids) > 0 {
+		n := min(len(ids), sqliteMaxBatch)
+		query := fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", s.table, strings.Repeat("?, ", n-1)+"?")
+		args := make([]any, n)
+		for i, id := range ids[:n] {
+			args[i] = id
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to delete sessions: %w", err)
+		}
+		ids = ids[n:]
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 func (s *SQLiteStore) Cleanup(ctx context.Context) error {
+	_, err := s.CleanupCount(ctx)
+	return err
+}
+
+// CleanupCount removes expired sessions in batches of CleanupBatchSize and
+// returns how many were removed. The write lock is held for one batch at a
+// time. If ctx is canceled between batches, the rows removed so far are
+// reported along with the context error.
+func (s *SQLiteStore) CleanupCount(ctx context.Context) (int64, error) {
+	now := s.expiryCutoff(s.clock.Now())
+	var total int64
+	for {
+		n, err := s.cleanupBatchOnce(ctx, now)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < int64(s.cleanupBatch) {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// cleanupBatchOnce deletes at most one batch of sessions expired before now.
+func (s *SQLiteStore) cleanupBatchOnce(ctx context.Context, now time.Time) (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	_, err := s.cleanupStmt.ExecContext(ctx, time.Now())
+	res, err := s.cleanupStmt.ExecContext(ctx, now, s.cleanupBatch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cleanup expired sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to cleanup expired sessions: %w", err)
+		return 0, fmt.Errorf("failed to cleanup expired sessions: %w", err)
+	}
+	return n, nil
+}
+
+// Ping checks that the database is reachable.
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
 	}
 	return nil
 }
 
+// Stats returns the connection pool statistics of the underlying database,
+// e.g. to graph open and in-use connections or wait counts.
+func (s *SQLiteStore) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// Codec returns the codec session values are encoded with, see CodecStore.
+func (s *SQLiteStore) Codec() Codec {
+	return s.codec
+}
+
 func (s *SQLiteStore) Close() error {
+	s.stopWriter()
+	if s.stopMaintenance != nil {
+		s.stopMaintenance()
+		<-s.maintenanceDone
+	}
 	if s.saveStmt != nil {
 		s.saveStmt.Close()
 	}
@@ -258,9 +758,36 @@ func (s *SQLiteStore) Close() error {
 	if s.cleanupStmt != nil {
 		s.cleanupStmt.Close()
 	}
+	if s.insertStmt != nil {
+		s.insertStmt.Close()
+	}
+	if s.updateStmt != nil {
+		s.updateStmt.Close()
+	}
+	if s.memoryConn != nil {
+		s.memoryConn.Close()
+	}
+	if !s.ownsDB {
+		return nil
+	}
 	return s.db.Close()
 }
 
+// sqliteAddColumn adds a column to table if it does not exist yet, so that
+// tables created by earlier versions of this package keep working.
+func sqliteAddColumn(db *sql.DB, table, column, definition string) error {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 func init() {
 	gob.Register(map[string]any{})
 }
@@ -0,0 +1,66 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Checkpoint copies the WAL file into the database and truncates it, e.g.
+// after a mass deletion. It runs in the background when
+// SQLiteConfig.CheckpointInterval is set.
+func (s *SQLiteStore) Checkpoint(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// busy is 1 if a reader kept the checkpoint from completing.
+	var busy, logFrames, checkpointed int
+	err := s.db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed)
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint database: %w", err)
+	}
+	if busy != 0 {
+		return errors.New("failed to checkpoint database: blocked by a concurrent reader")
+	}
+	return nil
+}
+
+// Vacuum rebuilds the database file, returning the space freed by deleted
+// sessions to the file system. It blocks writes while it runs, which can
+// take a while on a large database.
+func (s *SQLiteStore) Vacuum(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.db.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return nil
+}
+
+// startMaintenance runs Checkpoint, preceded by Vacuum if vacuum is set, at
+// every interval until Close.
+func (s *SQLiteStore) startMaintenance(interval time.Duration, vacuum bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopMaintenance = cancel
+	s.maintenanceDone = make(chan struct{})
+
+	go func() {
+		defer close(s.maintenanceDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Errors are ignored like those of the cleanup worker;
+				// the next run tries again.
+				if vacuum {
+					_ = s.Vacuum(ctx)
+				}
+				_ = s.Checkpoint(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
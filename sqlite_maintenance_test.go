@@ -0,0 +1,95 @@
+package dbsession
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fillSQLiteStore saves n sessions with some payload, so that the WAL grows.
+func fillSQLiteStore(t *testing.T, store *SQLiteStore, n int) {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		s := &Session{
+			ID:        fmt.Sprintf("session-%d", i),
+			Values:    map[string]any{"blob": strings.Repeat("x", 1024)},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		if err := store.Save(ctx, s); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", path, err)
+	}
+	return info.Size()
+}
+
+func TestSQLiteStore_CheckpointAndVacuum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	fillSQLiteStore(t, store, 200)
+	ctx := context.Background()
+	if err := store.Checkpoint(ctx); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	if size := fileSize(t, path+"-wal"); size != 0 {
+		t.Errorf("expected the WAL to be truncated, got %d bytes", size)
+	}
+
+	before := fileSize(t, path)
+	for i := 0; i < 200; i++ {
+		if err := store.Delete(ctx, fmt.Sprintf("session-%d", i)); err != nil {
+			t.Fatalf("failed to delete session: %v", err)
+		}
+	}
+	if err := store.Vacuum(ctx); err != nil {
+		t.Fatalf("failed to vacuum: %v", err)
+	}
+	if err := store.Checkpoint(ctx); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	if after := fileSize(t, path); after >= before {
+		t.Errorf("expected the database to shrink, got %d bytes from %d", after, before)
+	}
+}
+
+func TestSQLiteStore_CheckpointInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	store, err := NewSQLiteStoreWithConfig(SQLiteConfig{
+		DSN:                path,
+		CheckpointInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	fillSQLiteStore(t, store, 50)
+	deadline := time.Now().Add(2 * time.Second)
+	for fileSize(t, path+"-wal") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the background checkpoint to truncate the WAL")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Errorf("failed to close store: %v", err)
+	}
+}
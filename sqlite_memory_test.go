@@ -0,0 +1,51 @@
+package dbsession
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStore_InMemoryShared(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	s := &Session{ID: "shared", Values: map[string]any{"user": "alice"}, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	// Each connection held at once is a separate one from the pool.
+	for i := range 3 {
+		conn, err := store.db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("failed to get connection: %v", err)
+		}
+		defer conn.Close()
+		var n int
+		if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sessions WHERE id = ?", s.ID).Scan(&n); err != nil || n != 1 {
+			t.Errorf("connection %d: expected the saved session, got %d, %v", i, n, err)
+		}
+	}
+
+	// The database outlives the pool's idle connections.
+	store.db.SetMaxIdleConns(0)
+	store.db.SetMaxIdleConns(16)
+	if got, err := store.Get(ctx, s.ID); err != nil || got == nil {
+		t.Errorf("expected the session after idle connections closed, got %v, %v", got, err)
+	}
+
+	// Each store has a database of its own.
+	other, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer other.Close()
+	if got, err := other.Get(ctx, s.ID); err != nil || got != nil {
+		t.Errorf("expected another store not to see the session, got %v, %v", got, err)
+	}
+}
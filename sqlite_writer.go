@@ -0,0 +1,159 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	// sqliteWriteQueue bounds the number of saves waiting for the writer.
+	sqliteWriteQueue = 256
+	// sqliteMaxWriteBatch bounds the number of saves committed together.
+	sqliteMaxWriteBatch = 64
+)
+
+// errSQLiteClosed is returned by Save after Close.
+var errSQLiteClosed = errors.New("sqlite store is closed")
+
+// sqliteWrite is a save queued for the writer goroutine.
+type sqliteWrite struct {
+	ctx     context.Context
+	session *Session
+	blob    []byte     // Encoded values; valid until done is signaled
+	done    chan error // Receives the result, buffered
+}
+
+// startWriter starts the goroutine performing saves. SQLite allows a single
+// writer at a time, so instead of each Save taking the write lock for its
+// own transaction, saves are queued and the writer commits all those
+// pending in one transaction.
+func (s *SQLiteStore) startWriter() {
+	s.writes = make(chan *sqliteWrite, sqliteWriteQueue)
+	s.writerDone = make(chan struct{})
+	go s.writeLoop()
+}
+
+// queueWrite hands a save to the writer and waits for its result.
+func (s *SQLiteStore) queueWrite(ctx context.Context, session *Session, blob []byte) error {
+	w := &sqliteWrite{ctx: ctx, session: session, blob: blob, done: make(chan error, 1)}
+
+	// Close waits for queued writes; it closes the queue only once no Save
+	// is sending to it.
+	s.closeMu.RLock()
+	if s.closed {
+		s.closeMu.RUnlock()
+		return fmt.Errorf("failed to save session: %w", errSQLiteClosed)
+	}
+	select {
+	case s.writes <- w:
+	case <-ctx.Done():
+		// Never queued, so the writer holds no reference to blob.
+		s.closeMu.RUnlock()
+		return ctx.Err()
+	}
+	s.closeMu.RUnlock()
+
+	// Once queued, the result is awaited even if ctx is canceled, as blob
+	// must stay valid until the writer is done with it.
+	return <-w.done
+}
+
+// stopWriter makes further saves fail and waits for the queued ones.
+func (s *SQLiteStore) stopWriter() {
+	s.closeMu.Lock()
+	if s.closed || s.writes == nil {
+		s.closeMu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.writes)
+	s.closeMu.Unlock()
+	<-s.writerDone
+}
+
+func (s *SQLiteStore) writeLoop() {
+	defer close(s.writerDone)
+	batch := make([]*sqliteWrite, 0, sqliteMaxWriteBatch)
+	for w := range s.writes {
+		batch = append(batch[:0], w)
+	drain:
+		for len(batch) < sqliteMaxWriteBatch {
+			select {
+			case w, ok := <-s.writes:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, w)
+			default:
+				break drain
+			}
+		}
+		s.writeBatch(batch)
+	}
+}
+
+// writeBatch commits the saves in batch and reports their results.
+func (s *SQLiteStore) writeBatch(batch []*sqliteWrite) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(batch) == 1 {
+		w := batch[0]
+		w.done <- s.saveStmts().exec(w.ctx, s.optimistic, w.session, w.blob)
+		return
+	}
+
+	// Without optimistic locking, only the latest of several saves of the
+	// same session needs writing: the others would be overwritten in the
+	// same transaction. Saves whose caller has given up are not written, so
+	// the latest live one is, and the earlier ones share its result. With
+	// optimistic locking, each save checks the version left by the last.
+	live := make([]bool, len(batch))
+	latest := make(map[string]int, len(batch))
+	for i, w := range batch {
+		live[i] = w.ctx.Err() == nil
+		if live[i] && !s.optimistic {
+			latest[w.session.ID] = i
+		}
+	}
+
+	errs := make([]error, len(batch))
+	tx, err := s.db.Begin()
+	if err != nil {
+		err = fmt.Errorf("failed to begin transaction: %w", err)
+		for _, w := range batch {
+			w.done <- err
+		}
+		return
+	}
+	stmts := s.saveStmts().bind(context.Background(), tx)
+	for i, w := range batch {
+		if !live[i] {
+			errs[i] = w.ctx.Err()
+			continue
+		}
+		if j, ok := latest[w.session.ID]; ok && j != i {
+			continue
+		}
+		// A cancellation from now on could abort the transaction of the
+		// whole batch.
+		errs[i] = stmts.exec(context.WithoutCancel(w.ctx), s.optimistic, w.session, w.blob)
+	}
+	if err := tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		err = fmt.Errorf("failed to commit transaction: %w", err)
+		for i := range errs {
+			if live[i] {
+				errs[i] = err
+			}
+		}
+	}
+
+	for i, w := range batch {
+		if j, ok := latest[w.session.ID]; ok && live[i] {
+			i = j
+		}
+		w.done <- errs[i]
+	}
+}
@@ -0,0 +1,144 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStore_ConcurrentSaves(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 5 {
+				// Saves of the same session may be coalesced; the last
+				// one must win.
+				s := &Session{ID: fmt.Sprintf("s%d", i), Values: map[string]any{"n": j}, ExpiresAt: time.Now().Add(time.Hour)}
+				if err := store.Save(ctx, s); err != nil {
+					t.Errorf("failed to save: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range 50 {
+		got, err := store.Get(ctx, fmt.Sprintf("s%d", i))
+		if err != nil || got == nil {
+			t.Fatalf("expected session s%d, got %v", i, err)
+		}
+		if got.Values["n"] != 4 {
+			t.Errorf("expected the last save of s%d to win, got %v", i, got.Values["n"])
+		}
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if err := store.Save(ctx, &Session{ID: "late", ExpiresAt: time.Now().Add(time.Hour)}); !errors.Is(err, errSQLiteClosed) {
+		t.Errorf("expected saves after Close to fail, got %v", err)
+	}
+}
+
+func TestSQLiteStore_ConcurrentOptimisticSaves(t *testing.T) {
+	store, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: filepath.Join(t.TempDir(), "sessions.db"), OptimisticLocking: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	s := &Session{ID: "shared", Values: map[string]any{}, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	// Copies at the same version race; exactly one may win.
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := &Session{ID: s.ID, Values: map[string]any{}, ExpiresAt: s.ExpiresAt, Version: s.Version}
+			errs[i] = store.Save(ctx, c)
+		}()
+	}
+	wg.Wait()
+	won := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, ErrConcurrentModification):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if won != 1 {
+		t.Errorf("expected exactly one save to win, got %d", won)
+	}
+}
+
+// TestSQLiteStore_WriteBatchCanceledLatest checks that when the latest save
+// of a session in a batch was given up by its caller, the earlier one is
+// written instead, and each caller gets its own result.
+func TestSQLiteStore_WriteBatchCanceledLatest(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	write := func(ctx context.Context, n int) *sqliteWrite {
+		s := &Session{ID: "coalesced", Values: map[string]any{"n": n}, ExpiresAt: time.Now().Add(time.Hour)}
+		blob, err := EncodeValues(s.Values)
+		if err != nil {
+			t.Fatalf("failed to encode: %v", err)
+		}
+		return &sqliteWrite{ctx: ctx, session: s, blob: blob, done: make(chan error, 1)}
+	}
+	first := write(context.Background(), 1)
+	last := write(canceled, 2)
+	store.writeBatch([]*sqliteWrite{first, last})
+
+	if err := <-first.done; err != nil {
+		t.Errorf("expected the live save to succeed, got %v", err)
+	}
+	if err := <-last.done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the canceled save to fail with its context, got %v", err)
+	}
+	got, err := store.Get(context.Background(), "coalesced")
+	if err != nil || got == nil {
+		t.Fatalf("expected the session to be written, got %v", err)
+	}
+	if got.Values["n"] != 1 {
+		t.Errorf("expected the live save's values, got %v", got.Values)
+	}
+}
+
+// TestSQLiteStore_QueueWriteDeadline checks that a Save waiting for room in
+// a full queue gives up at its context deadline.
+func TestSQLiteStore_QueueWriteDeadline(t *testing.T) {
+	store := &SQLiteStore{writes: make(chan *sqliteWrite)} // Never drained
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := store.queueWrite(ctx, &Session{ID: "queued"}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context deadline, got %v", err)
+	}
+}
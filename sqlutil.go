@@ -0,0 +1,230 @@
+package dbsession
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// defaultTableName is the table used by the SQL stores unless configured otherwise.
+const defaultTableName = "sessions"
+
+// identifierPattern restricts table names to plain SQL identifiers.
+// Identifiers cannot be passed as query parameters, so they are validated
+// against this allowlist before being interpolated into statements.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// validateIdentifier returns an error if name is not a safe SQL identifier.
+func validateIdentifier(kind, name string) error {
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("invalid %s %q: must match %s", kind, name, identifierPattern)
+	}
+	return nil
+}
+
+// expiresIndexName returns the name of the expires_at index for table.
+// The default table keeps its historical index name so existing databases
+// are not re-indexed.
+func expiresIndexName(table string) string {
+	if table == defaultTableName {
+		return "idx_expires_at"
+	}
+	return "idx_" + table + "_expires_at"
+}
+
+// ownerIndexName returns the name of the owner_id index for table.
+func ownerIndexName(table string) string {
+	return "idx_" + table + "_owner_id"
+}
+
+// sqlSaveStmts are the statements that write a session row, shared by the
+// SQL stores. Both stores use the same argument order.
+type sqlSaveStmts struct {
+	save   *sql.Stmt // Upsert
+	insert *sql.Stmt // Optimistic locking: insert of a new session
+	update *sql.Stmt // Optimistic locking: versioned update
+}
+
+// bind returns the statements bound to tx.
+func (st sqlSaveStmts) bind(ctx context.Context, tx *sql.Tx) sqlSaveStmts {
+	bound := sqlSaveStmts{save: tx.StmtContext(ctx, st.save)}
+	if st.insert != nil {
+		bound.insert = tx.StmtContext(ctx, st.insert)
+		bound.update = tx.StmtContext(ctx, st.update)
+	}
+	return bound
+}
+
+// exec writes one session row. With optimistic locking, the row is updated
+// only if its version still matches, and ErrConcurrentModification is
+// returned if it does not. A session at Version 0 is either new or was last
+// written with locking off, which never bumps the version, so the update is
+// tried first and the row is inserted only if there was none to update. The
+// caller increments session.Version once the write is durable.
+func (st sqlSaveStmts) exec(ctx context.Context, optimistic bool, session *Session, data any) error {
+	lastAccessed := nullTime(session.LastAccessedAt)
+	owner := nullString(session.OwnerID)
+	metadata, err := encodeMetadata(session.Metadata)
+	if err != nil {
+		return err
+	}
+	if !optimistic {
+		if _, err := st.save.ExecContext(ctx, session.ID, data, session.CreatedAt, session.ExpiresAt, lastAccessed, session.RegenerateCount, owner, metadata); err != nil {
+			return fmt.Errorf("failed to save session: %w", err)
+		}
+		return nil
+	}
+
+	n, err := rowsAffected(st.update.ExecContext(ctx, data, session.ExpiresAt, lastAccessed, session.RegenerateCount, owner, metadata, session.ID, session.Version))
+	if err == nil && n == 0 && session.Version == 0 {
+		n, err = rowsAffected(st.insert.ExecContext(ctx, session.ID, data, session.CreatedAt, session.ExpiresAt, lastAccessed, session.RegenerateCount, owner, metadata))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	if n == 0 {
+		return ErrConcurrentModification
+	}
+	return nil
+}
+
+// rowsAffected returns the number of rows affected by a statement.
+func rowsAffected(res sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// sqlSessionColumns are the columns read by the SQL stores' Get queries, in
+// the order expected by sqlRow.dest.
+const sqlSessionColumns = "data, created_at, expires_at, version, last_accessed_at, regenerate_count, owner_id, metadata"
+
+// sqlRow holds a session row scanned by one of the SQL stores.
+type sqlRow struct {
+	// data is only valid until the next Scan or Close of the rows.
+	data           sql.RawBytes
+	createdAt      time.Time
+	expiresAt      time.Time
+	version        int
+	lastAccessedAt sql.NullTime // NULL for rows written by earlier versions
+	regenerations  int
+	ownerID        sql.NullString
+	metadata       sql.NullString // JSON, see encodeMetadata
+}
+
+// dest returns the Scan destinations for sqlSessionColumns.
+func (r *sqlRow) dest() []any {
+	return []any{&r.data, &r.createdAt, &r.expiresAt, &r.version, &r.lastAccessedAt, &r.regenerations, &r.ownerID, &r.metadata}
+}
+
+// session builds a Session from the row and its decoded values.
+func (r *sqlRow) session(id string, values map[string]any) (*Session, error) {
+	metadata, err := decodeMetadata(r.metadata.String)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		ID:              id,
+		Values:          values,
+		CreatedAt:       r.createdAt,
+		ExpiresAt:       r.expiresAt,
+		LastAccessedAt:  r.lastAccessedAt.Time,
+		RegenerateCount: r.regenerations,
+		Version:         r.version,
+		OwnerID:         r.ownerID.String,
+		Metadata:        metadata,
+	}, nil
+}
+
+// encodeMetadata returns metadata as a query argument: a JSON object, or nil
+// if there is none. JSON keeps the column readable by other tools.
+func encodeMetadata(metadata map[string]string) (any, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode session metadata: %w", err)
+	}
+	return string(data), nil
+}
+
+// decodeMetadata decodes a metadata column; NULL decodes to nil.
+func decodeMetadata(data string) (map[string]string, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(data), &metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode session metadata: %w: %w", ErrCorruptSession, err)
+	}
+	return metadata, nil
+}
+
+// nullTime returns t as a query argument, or nil for the zero time.
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// nullString returns s as a query argument, or nil for the empty string.
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// querySessions runs query and passes each resulting session to fn. The
+// query must select id followed by sqlSessionColumns. A row whose data
+// cannot be decoded is passed as a nil session and an error naming its ID.
+func querySessions(ctx context.Context, db *sql.DB, decode func([]byte) (map[string]any, error), fn func(*Session, error) error, query string, args ...any) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var row sqlRow
+		if err := rows.Scan(append([]any{&id}, row.dest()...)...); err != nil {
+			return fmt.Errorf("failed to scan session: %w", err)
+		}
+		var session *Session
+		values, err := decode(row.data)
+		if err == nil {
+			session, err = row.session(id, values)
+		}
+		if err != nil {
+			err = fmt.Errorf("session %s: %w", id, err)
+		}
+		if err := fn(session, err); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return nil
+}
+
+// listSessions runs query and returns the resulting sessions, failing on
+// the first row that cannot be decoded.
+func listSessions(ctx context.Context, db *sql.DB, decode func([]byte) (map[string]any, error), query string, args ...any) ([]*Session, error) {
+	var sessions []*Session
+	err := querySessions(ctx, db, decode, func(s *Session, err error) error {
+		if err != nil {
+			return err
+		}
+		sessions = append(sessions, s)
+		return nil
+	}, query, args...)
+	return sessions, err
+}
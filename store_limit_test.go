@@ -73,6 +73,8 @@ func TestStore_MaxSessionBytes(t *testing.T) {
 		t.Fatal("expected error when saving too large session, got nil")
 	} else if !errors.Is(err, ErrSessionTooLarge) {
 		t.Errorf("expected ErrSessionTooLarge on Save, got: %v", err)
+	} else if tooLarge := (*SessionTooLargeError)(nil); !errors.As(err, &tooLarge) || tooLarge.Size <= tooLarge.Limit {
+		t.Errorf("expected the encoded size and limit, got: %v", err)
 	}
 }
 
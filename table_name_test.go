@@ -0,0 +1,134 @@
+package dbsession
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStore_TableName(t *testing.T) {
+	dbPath := "test_table_name.db"
+	defer os.Remove(dbPath)
+
+	a, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: dbPath, TableName: "app_a_sessions"})
+	if err != nil {
+		t.Fatalf("failed to create store a: %v", err)
+	}
+	defer a.Close()
+	b, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: dbPath, TableName: "app_b_sessions"})
+	if err != nil {
+		t.Fatalf("failed to create store b: %v", err)
+	}
+	defer b.Close()
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "table-name-session",
+		Values:    map[string]any{"k": "v"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := a.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	got, err := a.Get(ctx, s.ID)
+	if err != nil || got == nil {
+		t.Fatalf("expected session in store a, got %v, %v", got, err)
+	}
+	got, err = b.Get(ctx, s.ID)
+	if err != nil {
+		t.Fatalf("failed to get from store b: %v", err)
+	}
+	if got != nil {
+		t.Error("expected tables to be isolated, found session in store b")
+	}
+}
+
+func TestSQLStores_InvalidTableName(t *testing.T) {
+	names := []string{
+		"sessions; DROP TABLE users",
+		"1sessions",
+		"my-sessions",
+		`"sessions"`,
+		"public.sessions",
+	}
+	for _, name := range names {
+		if _, err := NewSQLiteStoreWithConfig(SQLiteConfig{DSN: ":memory:", TableName: name}); err == nil {
+			t.Errorf("SQLite: expected error for table name %q", name)
+		}
+		// Validation happens before connecting, so no server is needed.
+		if _, err := NewPostgreSQLStoreWithConfig(PostgreSQLConfig{DSN: "postgres://invalid", TableName: name}); err == nil {
+			t.Errorf("PostgreSQL: expected error for table name %q", name)
+		}
+	}
+}
+
+func TestPostgresTableName(t *testing.T) {
+	if got := postgresTableName("", "sessions"); got != "sessions" {
+		t.Errorf("expected unqualified name, got %q", got)
+	}
+	if got := postgresTableName("tenant_1", "sessions"); got != "tenant_1.sessions" {
+		t.Errorf("expected schema-qualified name, got %q", got)
+	}
+	// Neither part is quoted, so PostgreSQL folds both alike.
+	if got := postgresTableName("Tenant", "Sessions"); got != "Tenant.Sessions" {
+		t.Errorf("expected unquoted schema and table, got %q", got)
+	}
+}
+
+func TestPostgreSQLStore_InvalidSchema(t *testing.T) {
+	_, err := NewPostgreSQLStoreWithConfig(PostgreSQLConfig{DSN: "postgres://invalid", Schema: `x"; DROP SCHEMA public; --`})
+	if err == nil {
+		t.Error("expected error for invalid schema name")
+	}
+}
+
+func TestPostgreSQLStore_Schema(t *testing.T) {
+	db, err := sql.Open("postgres", getTestPostgreSQLDSN())
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Skipf("Skipping PostgreSQL test: %v (is PostgreSQL running?)", err)
+	}
+	if _, err := db.Exec(`CREATE SCHEMA IF NOT EXISTS "dbsession_test"`); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	defer db.Exec(`DROP SCHEMA "dbsession_test" CASCADE`)
+
+	store, err := NewPostgreSQLStoreWithConfig(PostgreSQLConfig{DB: db, Schema: "dbsession_test"})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "schema-session",
+		Values:    map[string]any{"k": "v"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM "dbsession_test".sessions WHERE id = $1`, s.ID).Scan(&n); err != nil {
+		t.Fatalf("failed to query schema table: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 row in schema table, got %d", n)
+	}
+	var indexes int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pg_indexes WHERE schemaname = 'dbsession_test' AND indexname = 'idx_expires_at'`).Scan(&indexes); err != nil {
+		t.Fatalf("failed to query indexes: %v", err)
+	}
+	if indexes != 1 {
+		t.Errorf("expected expires_at index in schema, got %d", indexes)
+	}
+}
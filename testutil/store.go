@@ -0,0 +1,206 @@
+// Package testutil provides test doubles for code built on dbsession.
+//
+// FakeStore is an in-memory Store that records the calls made to it, so
+// that tests of handlers using a Manager can check which sessions were
+// saved or deleted, and make any store method fail on demand:
+//
+//	store := testutil.NewFakeStore()
+//	mgr := dbsession.NewManager(dbsession.Config{Store: store})
+//	// ... exercise the handler ...
+//	if ids := store.IDs("Delete"); len(ids) != 1 {
+//		t.Errorf("expected one session to be deleted, got %v", ids)
+//	}
+package testutil
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/Morditux/dbsession"
+)
+
+// Names of the Store methods, as recorded in Call.Method and accepted by
+// FakeStore.IDs and FakeStore.SetError.
+const (
+	MethodGet     = "Get"
+	MethodSave    = "Save"
+	MethodDelete  = "Delete"
+	MethodCleanup = "Cleanup"
+	MethodPing    = "Ping"
+	MethodClose   = "Close"
+)
+
+// Call is a call made to a FakeStore.
+type Call struct {
+	Method string // One of the Method constants
+	ID     string // Session ID, empty for Cleanup, Ping and Close
+	Err    error  // Error returned by the call
+}
+
+// FakeStore is an in-memory dbsession.Store recording the calls made to it.
+// Sessions are copied on Save and Get, as a real store serializes them.
+// It is safe for concurrent use; the zero value is not, use NewFakeStore.
+type FakeStore struct {
+	mu       sync.Mutex
+	sessions map[string]*dbsession.Session
+	calls    []Call
+	errs     map[string]error // Injected errors by method
+}
+
+var _ dbsession.Store = (*FakeStore)(nil)
+
+// NewFakeStore returns an empty FakeStore.
+func NewFakeStore() *FakeStore {
+	return &FakeStore{
+		sessions: make(map[string]*dbsession.Session),
+		errs:     make(map[string]error),
+	}
+}
+
+// SetError makes every later call to method fail with err, without
+// affecting the stored sessions. A nil err restores normal operation.
+func (f *FakeStore) SetError(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errs, method)
+		return
+	}
+	f.errs[method] = err
+}
+
+// Calls returns the calls made so far, oldest first.
+func (f *FakeStore) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// IDs returns the session IDs passed to method so far, oldest first, e.g.
+// IDs(MethodDelete) for the deleted sessions.
+func (f *FakeStore) IDs(method string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, c := range f.calls {
+		if c.Method == method {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids
+}
+
+// Reset forgets the recorded calls, keeping the stored sessions and the
+// injected errors, e.g. once a test's setup is done.
+func (f *FakeStore) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+// Session returns a copy of the stored session with the given ID, or nil.
+// Unlike Get, it is not recorded.
+func (f *FakeStore) Session(id string) *dbsession.Session {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return clone(f.sessions[id])
+}
+
+// Len returns the number of stored sessions, expired ones included.
+func (f *FakeStore) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.sessions)
+}
+
+// record appends a call and returns the error injected for its method.
+// f.mu must be held.
+func (f *FakeStore) record(method, id string) error {
+	err := f.errs[method]
+	f.calls = append(f.calls, Call{Method: method, ID: id, Err: err})
+	return err
+}
+
+func (f *FakeStore) Get(ctx context.Context, id string) (*dbsession.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(MethodGet, id); err != nil {
+		return nil, err
+	}
+	return clone(f.sessions[id]), nil
+}
+
+func (f *FakeStore) Save(ctx context.Context, s *dbsession.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(MethodSave, s.ID); err != nil {
+		return err
+	}
+	f.sessions[s.ID] = clone(s)
+	return nil
+}
+
+func (f *FakeStore) Delete(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(MethodDelete, id); err != nil {
+		return err
+	}
+	delete(f.sessions, id)
+	return nil
+}
+
+// Cleanup removes the sessions whose ExpiresAt has passed.
+func (f *FakeStore) Cleanup(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(MethodCleanup, ""); err != nil {
+		return err
+	}
+	now := time.Now()
+	for id, s := range f.sessions {
+		if now.After(s.ExpiresAt) {
+			delete(f.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (f *FakeStore) Ping(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.record(MethodPing, "")
+}
+
+// Close is recorded but leaves the store usable, so that it can be
+// inspected after the Manager using it was closed.
+func (f *FakeStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.record(MethodClose, "")
+}
+
+// clone copies the stored fields of s, or returns nil if s is nil. Values
+// is never nil, as with the stores of dbsession.
+func clone(s *dbsession.Session) *dbsession.Session {
+	if s == nil {
+		return nil
+	}
+	values := maps.Clone(s.Values)
+	if values == nil {
+		values = make(map[string]any)
+	}
+	return &dbsession.Session{
+		ID:              s.ID,
+		Values:          values,
+		CreatedAt:       s.CreatedAt,
+		ExpiresAt:       s.ExpiresAt,
+		LastAccessedAt:  s.LastAccessedAt,
+		RegenerateCount: s.RegenerateCount,
+		Version:         s.Version,
+		OwnerID:         s.OwnerID,
+		Metadata:        maps.Clone(s.Metadata),
+	}
+}
@@ -0,0 +1,81 @@
+package testutil_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/Morditux/dbsession"
+	"github.com/Morditux/dbsession/testutil"
+)
+
+func TestFakeStore(t *testing.T) {
+	store := testutil.NewFakeStore()
+	mgr := dbsession.NewManager(dbsession.Config{Store: store})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s, err := mgr.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.Set("user", "alice")
+	if err := mgr.PersistSession(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if got := store.IDs(testutil.MethodSave); !slices.Equal(got, []string{s.ID, s.ID}) {
+		t.Errorf("expected two saves of %s, got %v", s.ID, got)
+	}
+
+	// Stored sessions are copies.
+	s.Values["user"] = "mallory"
+	if stored := store.Session(s.ID); stored == nil || stored.Values["user"] != "alice" {
+		t.Errorf("expected the stored copy to keep alice, got %v", stored)
+	}
+
+	store.Reset()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := mgr.Destroy(w, r, s); err != nil {
+		t.Fatalf("failed to destroy session: %v", err)
+	}
+	calls := store.Calls()
+	if len(calls) != 1 || calls[0].Method != testutil.MethodDelete || calls[0].ID != s.ID {
+		t.Errorf("expected a single Delete of %s, got %+v", s.ID, calls)
+	}
+	if store.Len() != 0 {
+		t.Errorf("expected no stored sessions, got %d", store.Len())
+	}
+}
+
+func TestFakeStore_SetError(t *testing.T) {
+	store := testutil.NewFakeStore()
+	mgr := dbsession.NewManager(dbsession.Config{Store: store})
+	defer mgr.Close()
+
+	ctx := context.Background()
+	s, err := mgr.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	boom := errors.New("boom")
+	store.SetError(testutil.MethodDelete, boom)
+	if err := mgr.DeleteSession(ctx, s.ID); !errors.Is(err, boom) {
+		t.Errorf("expected the injected error, got %v", err)
+	}
+	calls := store.Calls()
+	if last := calls[len(calls)-1]; last.Method != testutil.MethodDelete || !errors.Is(last.Err, boom) {
+		t.Errorf("expected the failed Delete to be recorded, got %+v", last)
+	}
+	if _, err := mgr.LoadSession(ctx, s.ID); err != nil {
+		t.Errorf("expected the session to survive the failed delete, got %v", err)
+	}
+
+	store.SetError(testutil.MethodDelete, nil)
+	if err := mgr.DeleteSession(ctx, s.ID); err != nil {
+		t.Errorf("expected Delete to succeed again, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// TieredStore layers a fast cache store (L1, e.g. Memcached) in front of a
+// durable store (L2, e.g. PostgreSQL). Reads are served from L1 when
+// possible and fall back to L2, back-filling L1; writes go to both.
+//
+// L1 failures on Get and Save are tolerated: the store degrades to L2 only
+// rather than failing the request. Delete is the exception, see Delete.
+type TieredStore struct {
+	l1           Store
+	l2           Store
+	writeL1First bool
+}
+
+// TieredConfig holds configuration for the tiered store.
+type TieredConfig struct {
+	L1 Store // Cache
+	L2 Store // Source of truth
+	// WriteL1First makes Save write L1 before L2. By default L2 is written
+	// first, so a failed durable write never leaves a session that exists
+	// only in the cache.
+	WriteL1First bool
+}
+
+// NewTieredStore creates a TieredStore with l1 in front of l2.
+func NewTieredStore(l1, l2 Store) *TieredStore {
+	return NewTieredStoreWithConfig(TieredConfig{L1: l1, L2: l2})
+}
+
+// NewTieredStoreWithConfig creates a TieredStore with custom configuration.
+func NewTieredStoreWithConfig(cfg TieredConfig) *TieredStore {
+	return &TieredStore{
+		l1:           cfg.L1,
+		l2:           cfg.L2,
+		writeL1First: cfg.WriteL1First,
+	}
+}
+
+// Get returns the session from L1, or from L2 on an L1 miss or failure.
+// Sessions found in L2 are written back to L1.
+func (t *TieredStore) Get(ctx context.Context, id string) (*Session, error) {
+	if s, err := t.l1.Get(ctx, id); err == nil && s != nil {
+		return s, nil
+	}
+
+	s, err := t.l2.Get(ctx, id)
+	if err != nil || s == nil {
+		return s, err
+	}
+	_ = t.l1.Save(ctx, s) // Best effort: a cold cache only costs a slower read.
+	return s, nil
+}
+
+// Save writes the session to both stores. Only L2 errors are returned. If the
+// L1 write fails, the cached copy is evicted so that it cannot be served
+// stale.
+func (t *TieredStore) Save(ctx context.Context, s *Session) error {
+	if t.writeL1First {
+		l1Err := t.l1.Save(ctx, s)
+		if err := t.l2.Save(ctx, s); err != nil {
+			// Do not leave a cached session that was never persisted.
+			_ = t.l1.Delete(ctx, s.ID)
+			return err
+		}
+		if l1Err != nil {
+			_ = t.l1.Delete(ctx, s.ID)
+		}
+		return nil
+	}
+
+	if err := t.l2.Save(ctx, s); err != nil {
+		return err
+	}
+	if err := t.l1.Save(ctx, s); err != nil {
+		_ = t.l1.Delete(ctx, s.ID)
+	}
+	return nil
+}
+
+// Delete removes the session from both stores. Unlike Get and Save, it does
+// not degrade when L1 fails: a cached copy that survives would keep a
+// destroyed session usable, so L1 errors are returned along with L2 errors.
+func (t *TieredStore) Delete(ctx context.Context, id string) error {
+	return errors.Join(t.l1.Delete(ctx, id), t.l2.Delete(ctx, id))
+}
+
+// Evict removes the session from L1 only, e.g. when an InvalidationListener
+// reports that another node deleted it.
+func (t *TieredStore) Evict(ctx context.Context, id string) error {
+	return t.l1.Delete(ctx, id)
+}
+
+// SetExpiryLeeway passes d to both tiers, see ExpiryLeewaySetter.
+func (t *TieredStore) SetExpiryLeeway(d time.Duration) {
+	setExpiryLeeway(t.l1, d)
+	setExpiryLeeway(t.l2, d)
+}
+
+// Cleanup removes expired sessions from L2. L1 is expected to expire
+// entries on its own.
+func (t *TieredStore) Cleanup(ctx context.Context) error {
+	return t.l2.Cleanup(ctx)
+}
+
+// Ping checks L2 only: with L1 down the store still serves requests, so
+// an unreachable cache does not make it unhealthy.
+func (t *TieredStore) Ping(ctx context.Context) error {
+	return t.l2.Ping(ctx)
+}
+
+// Close closes both stores.
+func (t *TieredStore) Close() error {
+	return errors.Join(t.l1.Close(), t.l2.Close())
+}
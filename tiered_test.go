@@ -0,0 +1,158 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapStore is an in-memory Store standing in for a cache tier.
+type mapStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	err      error // Returned by every operation when set
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{sessions: make(map[string]*Session)}
+}
+
+func (m *mapStore) Get(ctx context.Context, id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return cloneSession(s), nil
+}
+
+func (m *mapStore) Save(ctx context.Context, s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.sessions[s.ID] = cloneSession(s)
+	return nil
+}
+
+func (m *mapStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *mapStore) Cleanup(ctx context.Context) error { return m.err }
+func (m *mapStore) Ping(ctx context.Context) error    { return m.err }
+func (m *mapStore) Close() error                      { return nil }
+
+func (m *mapStore) has(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.sessions[id]
+	return ok
+}
+
+func TestTieredStore(t *testing.T) {
+	l2, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	l1 := newMapStore()
+	store := NewTieredStore(l1, l2)
+	defer store.Close()
+
+	ctx := context.Background()
+	s := &Session{
+		ID:        "tiered",
+		Values:    map[string]any{"user": "alice"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Save(ctx, s); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if !l1.has(s.ID) {
+		t.Error("expected session in L1 after save")
+	}
+
+	// An L1 miss is served from L2 and back-filled.
+	l1.Delete(ctx, s.ID)
+	got, err := store.Get(ctx, s.ID)
+	if err != nil || got == nil {
+		t.Fatalf("failed to get session from L2: %v", err)
+	}
+	if got.Values["user"] != "alice" {
+		t.Errorf("expected alice, got %v", got.Values["user"])
+	}
+	if !l1.has(s.ID) {
+		t.Error("expected L1 to be back-filled")
+	}
+
+	if err := store.Delete(ctx, s.ID); err != nil {
+		t.Fatalf("failed to delete session: %v", err)
+	}
+	if l1.has(s.ID) {
+		t.Error("expected session removed from L1")
+	}
+	if got, _ := l2.Get(ctx, s.ID); got != nil {
+		t.Error("expected session removed from L2")
+	}
+}
+
+func TestTieredStore_L1Down(t *testing.T) {
+	l2, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	l1 := newMapStore()
+	l1.err = errors.New("cache unavailable")
+
+	for _, l1First := range []bool{false, true} {
+		store := NewTieredStoreWithConfig(TieredConfig{L1: l1, L2: l2, WriteL1First: l1First})
+		ctx := context.Background()
+		s := &Session{
+			ID:        "degraded",
+			Values:    map[string]any{"n": 1},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		if err := store.Save(ctx, s); err != nil {
+			t.Fatalf("WriteL1First=%v: expected save to degrade to L2, got %v", l1First, err)
+		}
+		got, err := store.Get(ctx, s.ID)
+		if err != nil || got == nil {
+			t.Fatalf("WriteL1First=%v: expected get to degrade to L2, got %v", l1First, err)
+		}
+		// A failed cache delete must not be hidden.
+		if err := store.Delete(ctx, s.ID); err == nil {
+			t.Errorf("WriteL1First=%v: expected delete to report the L1 failure", l1First)
+		}
+	}
+	l2.Close()
+}
+
+func TestTieredStore_L2FailureEvictsL1(t *testing.T) {
+	l1 := newMapStore()
+	l2 := newMapStore()
+	l2.err = errors.New("database unavailable")
+	store := NewTieredStoreWithConfig(TieredConfig{L1: l1, L2: l2, WriteL1First: true})
+
+	s := &Session{ID: "unpersisted", Values: map[string]any{}, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(context.Background(), s); err == nil {
+		t.Fatal("expected L2 error to be returned")
+	}
+	if l1.has(s.ID) {
+		t.Error("expected L1 write to be rolled back after L2 failure")
+	}
+}
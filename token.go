@@ -0,0 +1,49 @@
+package dbsession
+
+import (
+	"net/http"
+	"strings"
+)
+
+// BearerToken extracts the session ID from an "Authorization: Bearer <id>"
+// header. It is meant for Config.TokenExtractor.
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// requestID returns the session ID presented by the request: from the
+// configured TokenExtractor, or else the session cookie. If the ID was read
+// from one of Config.FallbackCookieNames, fallback is that cookie's name.
+func (m *Manager) requestID(r *http.Request) (id, fallback string) {
+	if m.tokenExtractor != nil {
+		return m.tokenExtractor(r), ""
+	}
+	if cookie, err := r.Cookie(m.cookie); err == nil {
+		return cookie.Value, ""
+	}
+	for _, name := range m.fallbackCookies {
+		if cookie, err := r.Cookie(name); err == nil {
+			return cookie.Value, name
+		}
+	}
+	return "", ""
+}
+
+// SaveToken saves the session like Save but sets no cookie; it returns the
+// client token for the caller to send to the client, e.g. in a response
+// header or JSON body: the session ID, or with Config.TokenKey the sealed
+// token. Remember-me tokens are cookie-based and are not issued.
+func (m *Manager) SaveToken(r *http.Request, s *Session) (string, error) {
+	token, err := m.clientToken(s)
+	if err != nil {
+		return "", err
+	}
+	if err := m.persist(r, s); err != nil {
+		return "", err
+	}
+	return token, nil
+}
@@ -0,0 +1,108 @@
+package dbsession
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestManager_BearerToken(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store, TokenExtractor: BearerToken})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.Set("user", "alice")
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	token, err := mgr.SaveToken(r, s)
+	if err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if token != s.ID {
+		t.Errorf("expected token %q, got %q", s.ID, token)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	loaded, err := mgr.Load(r)
+	if err != nil {
+		t.Fatalf("failed to load session from bearer token: %v", err)
+	}
+	if v, _ := loaded.Get("user"); v != "alice" {
+		t.Errorf("expected alice, got %v", v)
+	}
+
+	// The cookie is ignored once a TokenExtractor is configured.
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Cookie", "session_id="+token)
+	if _, err := mgr.Load(r); err != ErrSessionNotFound {
+		t.Errorf("expected ErrSessionNotFound for cookie, got %v", err)
+	}
+}
+
+func TestManager_RequireSession(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	mgr := NewManager(Config{Store: store, TokenExtractor: BearerToken, RequireSession: true})
+	defer mgr.Close()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := mgr.Get(r); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound without a token, got %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer unknown")
+	if _, err := mgr.Get(r); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound for an unknown token, got %v", err)
+	}
+
+	var called bool
+	h := mgr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusUnauthorized || called {
+		t.Errorf("expected a 401 without calling the handler, got %d (called %v)", rec.Code, called)
+	}
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	token, err := mgr.SaveToken(r, s)
+	if err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	if got, err := mgr.Get(r); err != nil || got.ID != s.ID {
+		t.Errorf("expected the saved session, got %v, %v", got, err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	cases := map[string]string{
+		"Bearer abc":  "abc",
+		"bearer abc":  "abc",
+		"Basic abc":   "",
+		"Bearerabc":   "",
+		"":            "",
+		"Bearer  abc": "abc",
+	}
+	for header, want := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if got := BearerToken(r); got != want {
+			t.Errorf("BearerToken(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Tracer starts spans around store operations.
+//
+// It is intentionally minimal so that dbsession does not depend on the
+// OpenTelemetry module. Adapting an otel trace.Tracer takes a few lines:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string) (context.Context, dbsession.Span) {
+//		ctx, span := o.t.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+// where otelSpan forwards SetAttribute, RecordError and End to the
+// underlying trace.Span.
+type Tracer interface {
+	// Start creates a span named name as a child of any span carried by ctx.
+	// The returned context carries the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation started by a Tracer.
+type Span interface {
+	// SetAttribute records a key/value attribute on the span.
+	SetAttribute(key string, value any)
+	// RecordError marks the span as failed with err.
+	RecordError(err error)
+	// End completes the span.
+	End()
+}
+
+// Span attribute keys set by the Manager.
+const (
+	// AttrStoreBackend identifies the store implementation (e.g. "sqlite").
+	AttrStoreBackend = "session.store"
+	// AttrStoreHit reports whether a Get found the session.
+	AttrStoreHit = "session.hit"
+	// AttrCleanupRemoved reports how many expired sessions a Cleanup removed,
+	// for stores implementing CleanupCounter.
+	AttrCleanupRemoved = "session.removed"
+	// AttrCleanupSkipped reports that a Cleanup was skipped because another
+	// process held the cleanup lock (see Config.SingleInstanceCleanup).
+	AttrCleanupSkipped = "session.cleanup_skipped"
+)
+
+// storeBackend returns a short name describing the store implementation,
+// used as a span attribute.
+func storeBackend(s Store) string {
+	switch s := s.(type) {
+	case *SQLiteStore:
+		return "sqlite"
+	case *PostgreSQLStore:
+		return "postgresql"
+	case *MemcachedStore:
+		return "memcached"
+	case *TieredStore:
+		return "tiered"
+	case *OverflowStore:
+		return "overflow"
+	case *RetryStore:
+		return storeBackend(s.store)
+	case *CachingStore:
+		return storeBackend(s.store)
+	default:
+		return fmt.Sprintf("%T", s)
+	}
+}
+
+// startSpan starts a span for a store operation if tracing is enabled.
+// The returned Span is nil when no Tracer is configured.
+func (m *Manager) startSpan(ctx context.Context, op string) (context.Context, Span) {
+	if m.tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := m.tracer.Start(ctx, "dbsession."+op)
+	span.SetAttribute(AttrStoreBackend, m.backend)
+	return ctx, span
+}
+
+// endSpan records err (if any) and ends span. It is a no-op for a nil span.
+func endSpan(span Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// storeContext bounds a store call by Config.StoreTimeout.
+func (m *Manager) storeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.storeTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, m.storeTimeout)
+}
+
+// storeGet loads a session from the store with GetOK, tracing the call.
+func (m *Manager) storeGet(ctx context.Context, id string) (*Session, bool, error) {
+	ctx, cancel := m.storeContext(ctx)
+	defer cancel()
+	ctx, span := m.startSpan(ctx, "Get")
+	start := time.Now()
+	s, found, err := GetOK(ctx, m.store, id)
+	m.observeStore("Get", start, err)
+	if span != nil {
+		span.SetAttribute(AttrStoreHit, found)
+	}
+	endSpan(span, err)
+	return s, found, err
+}
+
+// storeSave persists a session to the store, tracing the call.
+func (m *Manager) storeSave(ctx context.Context, s *Session) error {
+	// Forgotten once the save is done, so that a lookup which missed while
+	// it was under way cannot remember the ID afterwards.
+	defer m.misses.forget(s.ID)
+	ctx, cancel := m.storeContext(ctx)
+	defer cancel()
+	ctx, span := m.startSpan(ctx, "Save")
+	start := time.Now()
+	err := m.store.Save(ctx, s)
+	m.observeStore("Save", start, err)
+	endSpan(span, err)
+	return err
+}
+
+// storeDelete removes a session from the store, tracing the call.
+func (m *Manager) storeDelete(ctx context.Context, id string) error {
+	ctx, cancel := m.storeContext(ctx)
+	defer cancel()
+	ctx, span := m.startSpan(ctx, "Delete")
+	start := time.Now()
+	err := m.store.Delete(ctx, id)
+	m.observeStore("Delete", start, err)
+	endSpan(span, err)
+	return err
+}
+
+// storeDeleteMulti removes sessions from the store, tracing the call. Stores
+// without MultiDeleter delete them one by one, attempting each.
+func (m *Manager) storeDeleteMulti(ctx context.Context, ids []string) error {
+	ctx, cancel := m.storeContext(ctx)
+	defer cancel()
+	ctx, span := m.startSpan(ctx, "DeleteMulti")
+	start := time.Now()
+	var err error
+	if deleter, ok := m.store.(MultiDeleter); ok {
+		err = deleter.DeleteMulti(ctx, ids)
+	} else {
+		var errs []error
+		for _, id := range ids {
+			if err := m.store.Delete(ctx, id); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		err = errors.Join(errs...)
+	}
+	m.observeStore("DeleteMulti", start, err)
+	endSpan(span, err)
+	return err
+}
+
+// storePing checks the store backend, tracing the call.
+func (m *Manager) storePing(ctx context.Context) error {
+	ctx, span := m.startSpan(ctx, "Ping")
+	start := time.Now()
+	err := m.store.Ping(ctx)
+	m.observeStore("Ping", start, err)
+	endSpan(span, err)
+	return err
+}
+
+// storeCleanup removes expired sessions from store, tracing the call.
+func (m *Manager) storeCleanup(ctx context.Context, store Store) error {
+	ctx, span := m.startSpan(ctx, "Cleanup")
+	start := time.Now()
+	if exclusive, ok := store.(ExclusiveCleaner); ok && m.singleCleanup {
+		ran, err := exclusive.CleanupExclusive(ctx)
+		m.observeStore("Cleanup", start, err)
+		if span != nil {
+			span.SetAttribute(AttrCleanupSkipped, !ran)
+		}
+		endSpan(span, err)
+		return err
+	}
+	counter, ok := store.(CleanupCounter)
+	if !ok {
+		err := store.Cleanup(ctx)
+		m.observeStore("Cleanup", start, err)
+		endSpan(span, err)
+		return err
+	}
+	n, err := counter.CleanupCount(ctx)
+	m.observeStore("Cleanup", start, err)
+	if span != nil {
+		span.SetAttribute(AttrCleanupRemoved, n)
+	}
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,128 @@
+package dbsession
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]any
+	err   error
+	ended bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+func (s *recordedSpan) RecordError(err error)              { s.err = err }
+func (s *recordedSpan) End()                               { s.ended = true }
+
+type spanKey struct{}
+
+// recordingTracer records every span it starts.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordedSpan{name: name, attrs: make(map[string]any)}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func TestManager_Tracing(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	tracer := &recordingTracer{}
+	mgr := NewManager(Config{Store: store, Tracer: tracer})
+	defer mgr.Close()
+
+	s, err := mgr.New()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.Set("user", "alice")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := mgr.Save(w, r, s); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	r2 := httptest.NewRequest("GET", "/", nil)
+	r2.AddCookie(&http.Cookie{Name: "session_id", Value: s.ID})
+	if _, err := mgr.Get(r2); err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+
+	r3 := httptest.NewRequest("GET", "/", nil)
+	r3.AddCookie(&http.Cookie{Name: "session_id", Value: "0123456789abcdef0123456789abcdef"})
+	if _, err := mgr.Get(r3); err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+
+	if err := mgr.Destroy(httptest.NewRecorder(), r2, s); err != nil {
+		t.Fatalf("failed to destroy: %v", err)
+	}
+
+	want := []struct {
+		name string
+		hit  any
+	}{
+		{"dbsession.Save", nil},
+		{"dbsession.Get", true},
+		{"dbsession.Get", false},
+		{"dbsession.Delete", nil},
+	}
+	if len(tracer.spans) != len(want) {
+		t.Fatalf("expected %d spans, got %d", len(want), len(tracer.spans))
+	}
+	for i, w := range want {
+		span := tracer.spans[i]
+		if span.name != w.name {
+			t.Errorf("span %d: expected name %s, got %s", i, w.name, span.name)
+		}
+		if !span.ended {
+			t.Errorf("span %d (%s) was not ended", i, span.name)
+		}
+		if span.attrs[AttrStoreBackend] != "sqlite" {
+			t.Errorf("span %d: expected backend sqlite, got %v", i, span.attrs[AttrStoreBackend])
+		}
+		if span.attrs[AttrStoreHit] != w.hit {
+			t.Errorf("span %d: expected hit=%v, got %v", i, w.hit, span.attrs[AttrStoreHit])
+		}
+	}
+}
+
+// ctxCheckStore verifies that the tracer's context reaches the store.
+type ctxCheckStore struct {
+	MockStore
+	sawSpan bool
+}
+
+func (c *ctxCheckStore) Get(ctx context.Context, id string) (*Session, error) {
+	_, c.sawSpan = ctx.Value(spanKey{}).(*recordedSpan)
+	return nil, nil
+}
+
+func TestManager_TracingPropagatesContext(t *testing.T) {
+	store := &ctxCheckStore{}
+	mgr := NewManager(Config{Store: store, Tracer: &recordingTracer{}})
+	defer mgr.Close()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session_id", Value: "0123456789abcdef0123456789abcdef"})
+	if _, err := mgr.Get(r); err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if !store.sawSpan {
+		t.Error("store did not receive the span context")
+	}
+}